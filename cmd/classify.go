@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sunkay11/gmail-automation/internal/config"
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"github.com/sunkay11/gmail-automation/internal/ml"
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// classifyPage is how many emails the classify command reads at a time.
+const classifyPage = 100
+
+// classifyOptions are the flags of the classify command.
+type classifyOptions struct {
+	query string
+	since string
+	// max stops after that many emails; 0 classifies all.
+	max         int
+	concurrency int
+	// rpm caps the requests started per minute; 0 leaves only the
+	// classifier's backoff on rate-limit errors.
+	rpm        int
+	promptName string
+	noCache    bool
+	// classifier is llm for the configured chat model, or local for the
+	// model in modelFile.
+	classifier string
+	modelFile  string
+}
+
+// localPrompt names the ml.Document rendering that the local model reads
+// in its predictions, where the chat models name their prompt template.
+var localPrompt = openai.Prompt{Name: "document", Version: "1"}
+
+// classifyStats are the totals of a classify run.
+type classifyStats struct {
+	classified, cached, skipped, failed int
+	promptTokens, completionTokens      int
+}
+
+// runClassifyCommand handles `classify`, running the stored emails that
+// match the options through the classifier and storing a prediction for
+// each. Emails already predicted by the same model and prompt version are
+// skipped, so an interrupted run picks up where it stopped.
+func runClassifyCommand(ctx context.Context, cfg *config.Config, emailDB db.EmailDB, opts classifyOptions) error {
+	if opts.concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1, got %d", opts.concurrency)
+	}
+	filter, err := storedFilter(opts.query, opts.since)
+	if err != nil {
+		return err
+	}
+
+	var (
+		classifier openai.GPT
+		prompt     *openai.Prompt
+		render     func(db.Email) (string, error)
+	)
+	switch opts.classifier {
+	case "local":
+		model, err := ml.Load(opts.modelFile)
+		if err != nil {
+			return err
+		}
+		classifier, prompt = model, &localPrompt
+		render = func(email db.Email) (string, error) { return ml.Document(email), nil }
+	case "llm":
+		if err := cfg.ValidateLLM(); err != nil {
+			return err
+		}
+		gpt, closeCache, err := newClassifier(cfg, emailDB, "classify", opts.noCache)
+		if err != nil {
+			return err
+		}
+		defer closeCache()
+		if prompt, err = loadPrompt(cfg, opts.promptName); err != nil {
+			return err
+		}
+		classifier = gpt
+		render = func(email db.Email) (string, error) {
+			return renderPrompt(ctx, emailDB, prompt, email, cfg.Prompts.Examples, nil)
+		}
+	default:
+		return fmt.Errorf("unknown classifier %q: expected llm or local", opts.classifier)
+	}
+	done, err := emailDB.PredictedEmailsContext(ctx, classifier.Model(), prompt.Version)
+	if err != nil {
+		return err
+	}
+	log.Printf("Prompt: %s (version %s)", prompt.Name, prompt.Version)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// A failure other than an invalid answer, such as a bad API key or an
+	// exhausted rate limit, would fail every email: it stops the run.
+	var (
+		mu       sync.Mutex
+		stats    classifyStats
+		runErr   error
+		stopOnce sync.Once
+	)
+	stop := func(err error) {
+		stopOnce.Do(func() {
+			runErr = err
+			cancel()
+		})
+	}
+
+	var limiter <-chan time.Time
+	if opts.rpm > 0 {
+		ticker := time.NewTicker(time.Minute / time.Duration(opts.rpm))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	emails := make(chan db.Email)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for email := range emails {
+				if limiter != nil {
+					select {
+					case <-limiter:
+					case <-ctx.Done():
+						return
+					}
+				}
+				p, cached, err := classifyStored(ctx, emailDB, classifier, prompt, render, email)
+				mu.Lock()
+				switch {
+				case errors.Is(err, openai.ErrInvalidClassification):
+					stats.failed++
+					fmt.Printf("[%d] not classified: %v\n", email.Id, err)
+				case err != nil:
+					stop(fmt.Errorf("email %d: %w", email.Id, err))
+				default:
+					stats.classified++
+					if cached {
+						stats.cached++
+					}
+					stats.promptTokens += p.PromptTokens
+					stats.completionTokens += p.CompletionTokens
+					fmt.Printf("[%d] %s (%s, %.0f%%): %s\n", email.Id, p.Label, p.Category, p.Confidence*100, email.Subject)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// Pages are read whole rather than through an iterator, so that no
+	// read stays open while the workers write predictions.
+	started := time.Now()
+	sent := 0
+	cursor := ""
+read:
+	for {
+		page, err := emailDB.ListEmailsContext(ctx, filter, db.ListOptions{Limit: classifyPage, Cursor: cursor})
+		if err != nil {
+			stop(err)
+			break
+		}
+		for _, email := range page.Emails {
+			if done[email.Id] {
+				stats.skipped++
+				continue
+			}
+			if opts.max > 0 && sent == opts.max {
+				break read
+			}
+			select {
+			case emails <- email:
+				sent++
+			case <-ctx.Done():
+				break read
+			}
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	close(emails)
+	wg.Wait()
+
+	fmt.Printf("Classified %d emails with %s in %s (%d from the cache): %d already done, %d failed; %d prompt and %d completion tokens\n",
+		stats.classified, classifier.Model(), time.Since(started).Round(time.Second), stats.cached, stats.skipped, stats.failed,
+		stats.promptTokens, stats.completionTokens)
+	if runErr != nil {
+		return runErr
+	}
+	return ctx.Err()
+}
+
+// storedFilter matches the stored emails, trashed or not, that match a
+// Gmail-style query and were sent since a date or age; both may be empty.
+func storedFilter(query, since string) (db.EmailFilter, error) {
+	filter := db.EmailFilter{State: db.StateAll}
+	if query != "" {
+		q, err := db.ParseQuery(query)
+		if err != nil {
+			return filter, err
+		}
+		filter.Query = q
+	}
+	if since != "" {
+		after, err := db.ParseSince(since, time.Now())
+		if err != nil {
+			return filter, err
+		}
+		filter.After = after
+	}
+	return filter, nil
+}
+
+// classifyStored classifies one stored email as render renders it for
+// prompt and stores the prediction, reporting whether the answer came from
+// the cache.
+func classifyStored(ctx context.Context, emailDB db.EmailDB, classifier openai.GPT, prompt *openai.Prompt, render func(db.Email) (string, error), email db.Email) (db.Prediction, bool, error) {
+	text, err := render(email)
+	if err != nil {
+		return db.Prediction{}, false, err
+	}
+	start := time.Now()
+	c, err := classifier.ClassifyEmailContext(ctx, text)
+	if err != nil {
+		return db.Prediction{}, false, err
+	}
+	p := db.Prediction{
+		EmailID:          email.Id,
+		Model:            classifier.Model(),
+		PromptName:       prompt.Name,
+		PromptVersion:    prompt.Version,
+		Label:            string(c.Action),
+		Category:         c.Category,
+		Confidence:       c.Confidence,
+		Reason:           c.Reason,
+		RawResponse:      c.Raw,
+		Latency:          time.Since(start),
+		PromptTokens:     c.Usage.PromptTokens,
+		CompletionTokens: c.Usage.CompletionTokens,
+	}
+	// Not ctx: an interrupted run still keeps what it has paid for.
+	return p, c.Cached, emailDB.StorePredictionContext(context.Background(), &p)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+)
+
+// runContactsCommand handles `contacts [<match>] [--sort emails|recent|trashed]
+// [--limit N] [--rebuild]`. --rebuild first re-parses the addresses of every
+// stored email, for mail stored before contacts were tracked.
+func runContactsCommand(ctx context.Context, emailDB db.EmailDB, args []string, sort string, limit int, rebuild bool) error {
+	if rebuild {
+		indexed, err := emailDB.RebuildContactsContext(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Indexed the addresses of %d emails\n", indexed)
+	}
+
+	contacts, err := emailDB.ListContactsContext(ctx, db.ContactOptions{
+		Sort:  db.ContactSort(sort),
+		Limit: limit,
+		Match: strings.Join(args, " "),
+	})
+	if err != nil {
+		return err
+	}
+	if len(contacts) == 0 {
+		fmt.Println("No contacts")
+		return nil
+	}
+
+	fmt.Printf("%-40s %6s %6s %6s %8s  %s\n", "CONTACT", "FROM", "TO", "READ", "TRASHED", "LAST CONTACT")
+	for _, c := range contacts {
+		name := c.Address
+		if c.Name != "" {
+			name = fmt.Sprintf("%s <%s>", c.Name, c.Address)
+		}
+		last := ""
+		if !c.LastContact.IsZero() {
+			last = c.LastContact.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%-40s %6d %6d %6s %8s  %s\n", name, c.Received, c.Addressed,
+			percent(c.Read, c.Received), percent(c.Trashed, c.Received), last)
+	}
+	return nil
+}
+
+// percent formats part as a share of total, or "-" when there is none.
+func percent(part, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d%%", part*100/total)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+)
+
+// runDBCommand handles "db migrate [--to N]" and "db status".
+func runDBCommand(emailDB db.EmailDB, args []string, toVersion int) error {
+	sqliteDB, ok := emailDB.(*db.SQLiteDB)
+	if !ok {
+		return fmt.Errorf("schema migrations are only supported for the sqlite driver")
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("usage: db migrate [--to <version>] | db status")
+	}
+
+	switch args[0] {
+	case "migrate":
+		var backup string
+		var err error
+		if toVersion < 0 {
+			backup, err = sqliteDB.Migrate()
+		} else {
+			backup, err = sqliteDB.MigrateTo(toVersion)
+		}
+		if backup != "" {
+			fmt.Println("Backup:", backup)
+		}
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case "status":
+		statuses, err := sqliteDB.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = "applied " + status.AppliedAt
+			}
+			fmt.Printf("%04d %-40s %s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown db command %q", args[0])
+	}
+}
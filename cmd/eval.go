@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sunkay11/gmail-automation/internal/config"
+	"github.com/sunkay11/gmail-automation/internal/dataset"
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"github.com/sunkay11/gmail-automation/internal/eval"
+	"github.com/sunkay11/gmail-automation/internal/ml"
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// calibrationBins is how many bins of confidence eval reports.
+const calibrationBins = 10
+
+// evalOptions are the flags of the eval command.
+type evalOptions struct {
+	// classifier is llm for the configured chat model, or local for the
+	// model in modelFile.
+	classifier  string
+	modelFile   string
+	promptName  string
+	max         int
+	concurrency int
+	noCache     bool
+	// jsonOut is where the report is written as JSON; empty writes none.
+	jsonOut string
+}
+
+// runEvalCommand handles `eval <split.csv>`, running a classifier over a
+// labelled split and reporting how its answers compare with the labels.
+// Rows with the ID of a stored email are classified as that email, with
+// what gives its outcome away hidden; other rows as what the split holds.
+func runEvalCommand(ctx context.Context, cfg *config.Config, emailDB db.EmailDB, args []string, opts evalOptions) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: eval <split.csv>")
+	}
+	if opts.concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1, got %d", opts.concurrency)
+	}
+	examples, err := dataset.Read(args[0])
+	if err != nil {
+		return err
+	}
+	if opts.max > 0 && len(examples) > opts.max {
+		examples = examples[:opts.max]
+	}
+	if len(examples) == 0 {
+		return fmt.Errorf("%s: no examples", args[0])
+	}
+	emails, err := splitEmails(ctx, emailDB, examples)
+	if err != nil {
+		return err
+	}
+
+	var (
+		classifier openai.GPT
+		render     func(db.Email) (string, error)
+		promptName string
+	)
+	switch opts.classifier {
+	case "local":
+		model, err := ml.Load(opts.modelFile)
+		if err != nil {
+			return err
+		}
+		classifier = model
+		render = func(email db.Email) (string, error) { return ml.Document(email), nil }
+	case "llm":
+		if err := cfg.ValidateLLM(); err != nil {
+			return err
+		}
+		gpt, closeCache, err := newClassifier(cfg, emailDB, "eval", opts.noCache)
+		if err != nil {
+			return err
+		}
+		defer closeCache()
+		prompt, err := loadPrompt(cfg, opts.promptName)
+		if err != nil {
+			return err
+		}
+		// The few-shot examples may not give away the answers of the
+		// split's other rows.
+		held := map[int64]bool{}
+		for _, ex := range examples {
+			held[ex.Id] = true
+		}
+		skip := func(email db.Email) bool { return held[email.Id] }
+		classifier = gpt
+		promptName = prompt.Name + "@" + prompt.Version
+		render = func(email db.Email) (string, error) {
+			return renderPrompt(ctx, emailDB, prompt, email, cfg.Prompts.Examples, skip)
+		}
+	default:
+		return fmt.Errorf("unknown classifier %q: expected llm or local", opts.classifier)
+	}
+	price, _ := modelPrices(cfg).Lookup(classifier.Model())
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// As in classify, a failure other than an invalid answer stops the run.
+	var (
+		mu       sync.Mutex
+		runErr   error
+		stopOnce sync.Once
+	)
+	stop := func(err error) {
+		stopOnce.Do(func() {
+			runErr = err
+			cancel()
+		})
+	}
+
+	results := make([]eval.Result, len(examples))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				res := eval.Result{EmailID: examples[i].Id, Label: examples[i].Outcome}
+				text, err := render(emails[i])
+				if err != nil {
+					stop(fmt.Errorf("row %d: %w", i+1, err))
+					continue
+				}
+				start := time.Now()
+				c, err := classifier.ClassifyEmailContext(ctx, text)
+				res.Latency = time.Since(start)
+				switch {
+				case errors.Is(err, openai.ErrInvalidClassification):
+					res.Failed = true
+					mu.Lock()
+					fmt.Printf("[row %d] not classified: %v\n", i+1, err)
+					mu.Unlock()
+				case err != nil:
+					stop(fmt.Errorf("row %d: %w", i+1, err))
+					continue
+				default:
+					res.Predicted, res.Confidence, res.Cached = c.Action, c.Confidence, c.Cached
+					res.Usage = c.Usage
+					if !c.Cached {
+						res.Cost = price.Cost(c.Usage)
+					}
+				}
+				results[i] = res
+			}
+		}()
+	}
+send:
+	for i := range examples {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if runErr != nil {
+		return runErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	report := eval.Evaluate(results, calibrationBins)
+	report.Model = classifier.Model()
+	report.Prompt = promptName
+	report.Split = args[0]
+	report.CreatedAt = time.Now().UTC()
+	printReport(report)
+
+	if opts.jsonOut == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(opts.jsonOut, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote the report to %s\n", opts.jsonOut)
+	return nil
+}
+
+// splitEmails returns the email of each example of a split: the stored
+// email when the example names one, else the example's own, with what
+// gives the outcome away hidden either way.
+func splitEmails(ctx context.Context, emailDB db.EmailDB, examples []openai.Example) ([]db.Email, error) {
+	var ids []int64
+	for _, ex := range examples {
+		if ex.Id != 0 {
+			ids = append(ids, ex.Id)
+		}
+	}
+	// The emails are fetched by id a page at a time, which keeps each
+	// query within the database's limit on bound parameters.
+	stored := map[int64]db.Email{}
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > classifyPage {
+			n = classifyPage
+		}
+		page, err := emailDB.ListEmailsContext(ctx, db.EmailFilter{IDs: ids[:n], State: db.StateAll}, db.ListOptions{Limit: n})
+		if err != nil {
+			return nil, err
+		}
+		for _, email := range page.Emails {
+			stored[email.Id] = email
+		}
+		ids = ids[n:]
+	}
+
+	emails := make([]db.Email, len(examples))
+	for i, ex := range examples {
+		email, ok := stored[ex.Id]
+		if !ok {
+			email = ex.Email
+		}
+		email = openai.Examples([]db.Email{email})[0].Email
+		email.Read, email.Deleted, email.State, email.TrashedAt = false, false, "", ""
+		emails[i] = email
+	}
+	return emails, nil
+}
+
+// printReport prints the metrics of a report as tables.
+func printReport(r eval.Report) {
+	fmt.Printf("Evaluated %s on %s: %d examples, %d answered, %d failed\n", r.Model, r.Split, r.Examples, r.Answered, r.Failed)
+	if r.Prompt != "" {
+		fmt.Printf("Prompt: %s\n", r.Prompt)
+	}
+	fmt.Printf("Accuracy %.1f%%, macro F1 %.3f\n\n", 100*r.Accuracy, r.MacroF1)
+
+	fmt.Printf("%-8s %9s %7s %7s %8s\n", "LABEL", "PRECISION", "RECALL", "F1", "SUPPORT")
+	for _, action := range openai.Actions {
+		c := r.Classes[action]
+		fmt.Printf("%-8s %9.3f %7.3f %7.3f %8d\n", action, c.Precision, c.Recall, c.F1, c.Support)
+	}
+
+	fmt.Printf("\n%-16s", "LABEL\\PREDICTED")
+	for _, predicted := range openai.Actions {
+		fmt.Printf(" %7s", predicted)
+	}
+	fmt.Println()
+	for _, label := range openai.Actions {
+		fmt.Printf("%-16s", label)
+		for _, predicted := range openai.Actions {
+			fmt.Printf(" %7d", r.Confusion[label][predicted])
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("\n%-11s %6s %10s %9s\n", "CONFIDENCE", "COUNT", "MEAN", "ACCURACY")
+	for _, b := range r.Calibration.Bins {
+		if b.Count == 0 {
+			continue
+		}
+		fmt.Printf("%4.2f-%-6.2f %6d %10.3f %9.3f\n", b.Lower, b.Upper, b.Count, b.Confidence, b.Accuracy)
+	}
+	fmt.Printf("ECE %.3f, Brier score %.3f\n\n", r.Calibration.ECE, r.Calibration.Brier)
+
+	fmt.Printf("Cost $%.4f for %d prompt and %d completion tokens, %d answers from the cache\n",
+		r.Cost.USD, r.Cost.PromptTokens, r.Cost.CompletionTokens, r.Cost.Cached)
+	fmt.Printf("Latency mean %.0fms, p50 %.0fms, p95 %.0fms, max %.0fms\n", r.Latency.Mean, r.Latency.P50, r.Latency.P95, r.Latency.Max)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sunkay11/gmail-automation/internal/config"
+	"github.com/sunkay11/gmail-automation/internal/dataset"
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// exportOptions are the flags of the export-dataset command.
+type exportOptions struct {
+	query, since string
+	format       string
+	out          string
+	split        string
+	// ratios are the comma-separated shares of train, valid and test.
+	ratios   string
+	stratify bool
+	columns  string
+	seed     int64
+	// promptName is the template jsonl examples are rendered with, as
+	// classify renders it; empty renders the columns.
+	promptName string
+}
+
+// runExportDatasetCommand handles `export-dataset`, writing the stored
+// emails that match the query, labelled with what was done with them, to
+// train, valid and test files and a manifest in the output directory.
+func runExportDatasetCommand(ctx context.Context, cfg *config.Config, emailDB db.EmailDB, opts exportOptions) error {
+	columns, err := dataset.ParseColumns(opts.columns)
+	if err != nil {
+		return err
+	}
+	ratios, err := parseRatios(opts.ratios)
+	if err != nil {
+		return err
+	}
+	filter, err := storedFilter(opts.query, opts.since)
+	if err != nil {
+		return err
+	}
+
+	var emails []db.Email
+	err = eachStored(ctx, emailDB, filter, func(email db.Email) error {
+		emails = append(emails, email)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	exportOpts := dataset.Options{
+		Format:  opts.format,
+		Columns: columns,
+		Split:   dataset.SplitOptions{Mode: opts.split, Ratios: ratios, Stratify: opts.stratify, Seed: opts.seed},
+	}
+	// Fine-tuning examples rendered with --prompt ask as classify does,
+	// with the same template and few-shot examples, so that the model is
+	// asked as it learned. The examples come from the example's own split
+	// only, so that none of valid or test is learned from.
+	if opts.format == dataset.FormatJSONL && opts.promptName != "" {
+		prompt, err := loadPrompt(cfg, opts.promptName)
+		if err != nil {
+			return err
+		}
+		exportOpts.PromptName = prompt.Name + "@" + prompt.Version
+		exportOpts.Render = func(ex openai.Example, skip func(db.Email) bool) (string, error) {
+			return renderPrompt(ctx, emailDB, prompt, ex.Email, cfg.Prompts.Examples, skip)
+		}
+	}
+	m, err := dataset.Export(opts.out, openai.Examples(emails), exportOpts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-6s %6s", "SPLIT", "ROWS")
+	for _, action := range openai.Actions {
+		fmt.Printf(" %6s", strings.ToUpper(string(action)))
+	}
+	fmt.Println("  FILE")
+	for _, s := range m.Splits {
+		fmt.Printf("%-6s %6d", s.Name, s.Rows)
+		for _, action := range openai.Actions {
+			fmt.Printf(" %6d", s.Classes[action])
+		}
+		fmt.Printf("  %s/%s\n", opts.out, s.File)
+	}
+	fmt.Printf("Manifest: %s/%s\n", opts.out, dataset.ManifestFile)
+	return nil
+}
+
+// parseRatios parses the three comma-separated shares of --ratios.
+func parseRatios(list string) ([3]float64, error) {
+	var ratios [3]float64
+	parts := strings.Split(list, ",")
+	if len(parts) != len(ratios) {
+		return ratios, fmt.Errorf("--ratios needs the shares of train, valid and test, got %q", list)
+	}
+	for i, part := range parts {
+		r, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return ratios, fmt.Errorf("--ratios: %v", err)
+		}
+		ratios[i] = r
+	}
+	return ratios, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sunkay11/gmail-automation/internal/config"
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// finetuneOptions are the flags of the finetune create command.
+type finetuneOptions struct {
+	baseModel  string
+	validation string
+	suffix     string
+	epochs     int
+	watch      bool
+}
+
+// runFinetuneCommand handles `finetune upload|create|status|list|cancel`,
+// managing fine-tuning jobs of the configured provider. Every job is
+// recorded in the models table, with the model it produced once it
+// succeeds, so that --model can name the job to classify with its model.
+func runFinetuneCommand(ctx context.Context, cfg *config.Config, emailDB db.EmailDB, args []string, opts finetuneOptions) error {
+	usage := fmt.Errorf("usage: finetune upload <file.jsonl> | create <file ID or .jsonl> | status <job ID> | list | cancel <job ID>")
+	if len(args) == 0 {
+		return usage
+	}
+	if err := cfg.ValidateLLM(); err != nil {
+		return err
+	}
+	llm := cfg.ChatLLM()
+	apiKey, err := llm.APIKey.Value()
+	if err != nil {
+		return err
+	}
+	tuner, err := openai.NewFineTuner(openai.Provider{
+		Name:       llm.Provider,
+		BaseURL:    llm.BaseURL,
+		Model:      llm.Model,
+		APIKey:     apiKey,
+		APIVersion: llm.APIVersion,
+		Deployment: llm.Deployment,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "upload" && len(args) == 2:
+		id, err := tuner.Upload(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Uploaded %s as %s\n", args[1], id)
+		return nil
+
+	case args[0] == "create" && len(args) == 2:
+		training, err := uploadedFile(ctx, tuner, args[1])
+		if err != nil {
+			return err
+		}
+		validation := ""
+		if opts.validation != "" {
+			if validation, err = uploadedFile(ctx, tuner, opts.validation); err != nil {
+				return err
+			}
+		}
+		base := opts.baseModel
+		if base == "" {
+			base = llm.Model
+		}
+		job, err := tuner.Create(ctx, openai.JobRequest{
+			Model:          base,
+			TrainingFile:   training,
+			ValidationFile: validation,
+			Suffix:         opts.suffix,
+			Epochs:         opts.epochs,
+		})
+		if err != nil {
+			return err
+		}
+		if err := recordJob(emailDB, job, opts.suffix); err != nil {
+			return err
+		}
+		fmt.Printf("Started %s fine-tuning %s on %s (%s)\n", job.ID, job.BaseModel, training, job.Status)
+		if !opts.watch {
+			fmt.Printf("Follow it with: finetune status %s\n", job.ID)
+			return nil
+		}
+		return watchJob(ctx, tuner, emailDB, job.ID)
+
+	case args[0] == "status" && len(args) == 2:
+		return watchJob(ctx, tuner, emailDB, args[1])
+
+	case args[0] == "cancel" && len(args) == 2:
+		job, err := tuner.Cancel(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s is %s\n", job.ID, job.Status)
+		return recordJob(emailDB, job, "")
+
+	case args[0] == "list" && len(args) == 1:
+		models, err := emailDB.ListModelsContext(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-28s %-20s %-16s %-10s %-19s %s\n", "JOB", "BASE", "STATUS", "TOKENS", "CREATED", "MODEL")
+		for _, m := range models {
+			// Jobs still running are looked up again.
+			if !(openai.Job{Status: m.Status}).Done() {
+				job, err := tuner.Job(ctx, m.JobID)
+				if err != nil {
+					return err
+				}
+				if err := recordJob(emailDB, job, ""); err != nil {
+					return err
+				}
+				m.Status, m.Name, m.TrainedTokens = job.Status, job.FineTunedModel, job.TrainedTokens
+			}
+			fmt.Printf("%-28s %-20s %-16s %-10d %-19s %s\n", m.JobID, m.BaseModel, m.Status, m.TrainedTokens,
+				m.CreatedAt.Local().Format("2006-01-02 15:04:05"), m.Name)
+		}
+		return nil
+	}
+	return usage
+}
+
+// uploadedFile returns the ID of a file for fine-tuning: a local .jsonl
+// file is uploaded first, anything else is taken to be an uploaded file's
+// ID.
+func uploadedFile(ctx context.Context, tuner *openai.FineTuner, file string) (string, error) {
+	if !strings.HasSuffix(file, ".jsonl") {
+		return file, nil
+	}
+	if _, err := os.Stat(file); err != nil {
+		return "", err
+	}
+	id, err := tuner.Upload(ctx, file)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Uploaded %s as %s\n", file, id)
+	return id, nil
+}
+
+// watchJob prints the events of a job as they arrive, recording each new
+// status, until it is done. Interrupting stops watching, not the job.
+func watchJob(ctx context.Context, tuner *openai.FineTuner, emailDB db.EmailDB, id string) error {
+	status := ""
+	job, err := tuner.Watch(ctx, id, func(e openai.JobEvent) {
+		fmt.Printf("%s %-5s %s\n", e.CreatedAt.Local().Format("2006-01-02 15:04:05"), e.Level, e.Message)
+	}, func(job openai.Job) {
+		if job.Status == status {
+			return
+		}
+		status = job.Status
+		fmt.Printf("%s is %s\n", job.ID, job.Status)
+		if err := recordJob(emailDB, job, ""); err != nil {
+			fmt.Printf("Could not record %s: %v\n", job.ID, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if job.FineTunedModel != "" {
+		fmt.Printf("Fine-tuned model %s after %d tokens; classify with --model %s\n", job.FineTunedModel, job.TrainedTokens, job.ID)
+	}
+	return nil
+}
+
+// recordJob saves the state of a job to the models table. suffix is only
+// kept for jobs not recorded before.
+func recordJob(emailDB db.EmailDB, job openai.Job, suffix string) error {
+	// Not ctx: an interrupted watch still records what it saw.
+	return emailDB.SaveModelContext(context.Background(), &db.ModelRecord{
+		JobID:          job.ID,
+		BaseModel:      job.BaseModel,
+		Name:           job.FineTunedModel,
+		Status:         job.Status,
+		TrainingFile:   job.TrainingFile,
+		ValidationFile: job.ValidationFile,
+		Suffix:         suffix,
+		TrainedTokens:  job.TrainedTokens,
+		CreatedAt:      job.CreatedAt,
+		FinishedAt:     job.FinishedAt,
+	})
+}
+
+// resolveModel returns the model --model names: the model a recorded
+// fine-tuning job produced when it names the job, or the name itself.
+func resolveModel(ctx context.Context, emailDB db.EmailDB, name string) (string, error) {
+	models, err := emailDB.ListModelsContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, m := range models {
+		if m.JobID != name {
+			continue
+		}
+		if m.Name == "" {
+			return "", fmt.Errorf("fine-tuning job %s has no model yet: it is %s", name, m.Status)
+		}
+		return m.Name, nil
+	}
+	return name, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/sunkay11/gmail-automation/internal/config"
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// newClassifier connects to the chat model selected by the llm section of
+// the config, resolving the API key only now that it is needed. Requests
+// are recorded to the usage ledger as operation and refused beyond the
+// usage budgets. Unless the cache is disabled, answers are cached; noCache
+// asks the model anyway and caches the fresh answers. The returned func
+// closes the cache.
+func newClassifier(cfg *config.Config, emailDB db.EmailDB, operation string, noCache bool) (openai.GPT, func(), error) {
+	llm := cfg.ChatLLM()
+	apiKey, err := llm.APIKey.Value()
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("Model: %s (%s)", llm.Model, llm.Provider)
+	classifier, err := openai.NewClassifier(openai.Provider{
+		Name:       llm.Provider,
+		BaseURL:    llm.BaseURL,
+		Model:      llm.Model,
+		APIKey:     apiKey,
+		APIVersion: llm.APIVersion,
+		Deployment: llm.Deployment,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prices := modelPrices(cfg)
+	if _, ok := prices.Lookup(llm.Model); !ok && llm.Provider != "compatible" {
+		if cfg.Usage.DailyBudget > 0 || cfg.Usage.MonthlyBudget > 0 {
+			return nil, nil, fmt.Errorf("no price for %s in usage.prices: add one for the budgets to apply", llm.Model)
+		}
+		log.Printf("No price for %s in usage.prices: its usage is recorded at no cost", llm.Model)
+	}
+	var gpt openai.GPT = openai.NewMeteredGPT(classifier, emailDB, prices, openai.Budget{
+		Daily:   cfg.Usage.DailyBudget,
+		Monthly: cfg.Usage.MonthlyBudget,
+	}, operation)
+	if !cfg.Cache.Enabled {
+		return gpt, func() {}, nil
+	}
+
+	cache, err := openai.OpenCache(cfg.Cache.Path, cfg.CacheTTL(), cfg.Cache.MaxEntries)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cache %s: %w", cfg.Cache.Path, err)
+	}
+	cached := cache.Wrap(gpt)
+	cached.Refresh = noCache
+	return cached, func() { cache.Close() }, nil
+}
+
+// modelPrices returns the configured prices of the models.
+func modelPrices(cfg *config.Config) openai.Prices {
+	prices := openai.Prices{}
+	for model, price := range cfg.ModelPrices() {
+		prices[model] = openai.Price{Prompt: price.Prompt, Completion: price.Completion}
+	}
+	return prices
+}
+
+// loadPrompt returns the named prompt template, or the configured default
+// for an empty name.
+func loadPrompt(cfg *config.Config, name string) (*openai.Prompt, error) {
+	templates := map[string]string{}
+	for _, p := range cfg.Prompts.Templates {
+		templates[p.Name] = p.Template
+	}
+	prompts, err := openai.LoadPrompts(cfg.Prompts.Dir, templates)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = cfg.Prompts.Default
+	}
+	return prompts.Get(name)
+}
+
+// renderPrompt renders prompt for email with up to examples similar stored
+// emails, other than those skip reports, as few-shot examples.
+func renderPrompt(ctx context.Context, emailDB db.EmailDB, prompt *openai.Prompt, email db.Email, examples int, skip func(db.Email) bool) (string, error) {
+	similar, err := db.SimilarEmails(ctx, emailDB, email, examples, skip)
+	if err != nil {
+		return "", err
+	}
+	return prompt.Render(email, openai.Examples(similar))
+}
@@ -1,28 +1,44 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/sunkay11/gmail-automation/internal/config"
 	"github.com/sunkay11/gmail-automation/internal/db"
 	"github.com/sunkay11/gmail-automation/internal/gmailapi"
-	"github.com/sunkay11/gmail-automation/internal/openai"
 )
 
+// setFlags collects repeated --set key=value overrides.
+type setFlags map[string]string
+
+func (s setFlags) String() string {
+	return fmt.Sprint(map[string]string(s))
+}
+
+func (s setFlags) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	s[parts[0]] = parts[1]
+	return nil
+}
+
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Println("storeInbox --numEmails <number of emails to store> storeDeleted getStored")
+		fmt.Println("storeInbox --numEmails <number of emails to store> storeDeleted getStored classifyEmail classify usage train [split.csv] predict eval <split.csv> export-dataset finetune upload|create|status|list|cancel config show db migrate|status search <query> report contacts reparse")
+		fmt.Println("common flags: --config <path> --set <key>=<value> --account <name>")
 		os.Exit(1)
 	}
 
-	cfg, err := config.LoadConfig("config.yaml")
-	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
-	}
-
 	// Extract the command from os.Args
 	command := os.Args[1]
 
@@ -31,59 +47,345 @@ func main() {
 
 	// Add the flag definition here
 	numEmails := cmdFlags.Int("numEmails", 50, "Number of emails to store")
+	query := cmdFlags.String("query", "", `Gmail-style filter over the local database, e.g. "from:alice is:unread newer_than:7d"`)
+	limit := cmdFlags.Int("limit", 20, "Number of results per page")
+	page := cmdFlags.Int("page", 1, "Page of results to show")
+	cursor := cmdFlags.String("cursor", "", "Continue getStored from a previous page")
+	by := cmdFlags.String("by", "day", "Bucket for report: hour, day, weekday or month; for usage: day or month")
+	tz := cmdFlags.String("tz", "", "Time zone for report buckets, e.g. Europe/Berlin; defaults to local time")
+	sortBy := cmdFlags.String("sort", "emails", "Order of contacts: emails, recent or trashed")
+	rebuild := cmdFlags.Bool("rebuild", false, "Re-parse the addresses of every stored email before listing contacts")
+	promptName := cmdFlags.String("prompt", "", "Prompt template to classify with, defaulting to prompts.default, or to render jsonl datasets with instead of --columns")
+	since := cmdFlags.String("since", "", "Only classify, train on, predict or export emails sent, or report usage, since a date (YYYY-MM-DD) or an age such as 30d")
+	concurrency := cmdFlags.Int("concurrency", 4, "Number of emails classified at once")
+	rpm := cmdFlags.Int("rpm", 0, "Maximum classification requests per minute; 0 for no limit")
+	maxEmails := cmdFlags.Int("max", 0, "Stop classify after this many emails, or eval after this many rows; 0 for all")
+	noCache := cmdFlags.Bool("no-cache", false, "Ask the model even when its answer is cached")
+	algorithm := cmdFlags.String("algorithm", "nb", "Local model to train: nb (naive Bayes) or logreg (logistic regression)")
+	modelFile := cmdFlags.String("model-file", "model.json", "File the local model is saved to by train and read from by predict, and by classify and eval with --classifier local")
+	holdout := cmdFlags.Float64("holdout", 0.2, "Fraction of emails train keeps out to measure the model on")
+	format := cmdFlags.String("format", "csv", "Format of export-dataset: csv, jsonl (chat fine-tuning) or parquet")
+	outDir := cmdFlags.String("out", "dataset", "Directory export-dataset writes the splits and manifest to")
+	split := cmdFlags.String("split", "shuffle", "How export-dataset splits: shuffle, or time for the oldest emails in train and the newest in test")
+	ratios := cmdFlags.String("ratios", "0.7,0.15,0.15", "Shares of train, valid and test for export-dataset")
+	stratify := cmdFlags.Bool("stratify", false, "Keep the labels in the same proportions in every split")
+	columns := cmdFlags.String("columns", "subject,sender,domain,body,headers", "Feature columns export-dataset writes")
+	seed := cmdFlags.Int64("seed", 1, "Seed of the shuffled split")
+	classifierName := cmdFlags.String("classifier", "llm", "What classify and eval run: llm for the chat model, or local for the model in --model-file")
+	jsonOut := cmdFlags.String("json", "", "File eval writes its report to as JSON, to compare runs")
+	modelName := cmdFlags.String("model", "", "Chat model to classify with, or the ID of a fine-tuning job to use its model; defaults to llm.model")
+	baseModel := cmdFlags.String("base-model", "", "Model finetune create fine-tunes; defaults to llm.model")
+	validation := cmdFlags.String("validation", "", "Validation file ID or .jsonl for finetune create")
+	suffix := cmdFlags.String("suffix", "", "Suffix of the fine-tuned model's name")
+	epochs := cmdFlags.Int("epochs", 0, "Epochs of finetune create; 0 leaves them to the server")
+	watch := cmdFlags.Bool("watch", false, "Follow the job finetune create starts until it is done")
+	toVersion := cmdFlags.Int("to", -1, "Schema version to migrate to (db migrate); defaults to the latest")
+	accountName := cmdFlags.String("account", "", "Name of the gmail account to use")
+	configPath := cmdFlags.String("config", "config.yaml", "Path to the config file")
+	overrides := setFlags{}
+	cmdFlags.Var(overrides, "set", "Override a config key, e.g. --set openai.model=gpt-4 (repeatable)")
 
 	// Parse the flags
-	err = cmdFlags.Parse(os.Args[2:])
+	args, err := parseInterspersed(cmdFlags, os.Args[2:])
 	if err != nil {
 		fmt.Println("Error parsing flags:", err)
 		os.Exit(1)
 	}
-	emailDB := db.NewSQLiteDB(cfg.DB.Path)
+
+	cfg, err := config.Load(*configPath, overrides)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	if command == "config" {
+		if len(args) == 0 || args[0] != "show" {
+			fmt.Println("Usage: config show")
+			os.Exit(1)
+		}
+		fmt.Print(cfg)
+		return
+	}
+
+	emailDB, err := openEmailDB(cfg, command == "db")
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+
+	account, err := cfg.Account(*accountName)
+	if err != nil {
+		log.Fatalf("Failed to select account: %v", err)
+	}
+
+	// Ctrl-C cancels ctx so that a long sync stops between requests and
+	// rolls back instead of being killed mid-write.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *modelName != "" {
+		if cfg.LLM.Model, err = resolveModel(ctx, emailDB, *modelName); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Create a new GmailClient instance
-	gmailClient := gmailapi.NewGmailClient(emailDB, cfg.Gmail.Labels)
+	gmailClient := gmailapi.NewGmailClientForAccount(emailDB, cfg.Gmail.Labels, account)
 
 	switch command {
+	case "db":
+		if err := runDBCommand(emailDB, args, *toVersion); err != nil {
+			log.Fatal(err)
+		}
+	case "search":
+		if err := runSearchCommand(emailDB, args, *limit, *page); err != nil {
+			log.Fatal(err)
+		}
+	case "report":
+		err := runReportCommand(ctx, emailDB, *query, *by, *tz)
+		if errors.Is(err, context.Canceled) {
+			exitInterrupted()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "contacts":
+		err := runContactsCommand(ctx, emailDB, args, *sortBy, *limit, *rebuild)
+		if errors.Is(err, context.Canceled) {
+			exitInterrupted()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "reparse":
+		err := runReparseCommand(ctx, gmailClient)
+		if errors.Is(err, context.Canceled) {
+			exitInterrupted()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
 	case "storeInbox":
-		err := gmailClient.GetInboxEmailsAndStore(*numEmails)
+		if err := cfg.ValidateGmail(account); err != nil {
+			log.Fatal(err)
+		}
+		err := gmailClient.GetInboxEmailsAndStoreContext(ctx, *numEmails)
+		if errors.Is(err, context.Canceled) {
+			exitInterrupted()
+		}
 		if err != nil {
 			fmt.Println("Error:", err)
 			os.Exit(1)
 		}
 	case "storeDeleted":
-		err := gmailClient.GetDeletedEmailsAndStore(1)
+		if err := cfg.ValidateGmail(account); err != nil {
+			log.Fatal(err)
+		}
+		err := gmailClient.GetDeletedEmailsAndStoreContext(ctx, 1)
+		if errors.Is(err, context.Canceled) {
+			exitInterrupted()
+		}
 		if err != nil {
 			log.Fatal(err)
 			os.Exit(1)
 		}
 	case "getStored":
-		emails, err := emailDB.GetEmails("emails")
+		page, err := getStoredEmails(ctx, emailDB, *query, *limit, *cursor)
 		if err != nil {
 			log.Fatal(err)
 			os.Exit(1)
 		}
-		for i, email := range emails {
+		for i, email := range page.Emails {
 			fmt.Printf("[%d], [%s], [%s], [%s]\n", i, email.From, email.Subject, email.SentDate)
 		}
+		if page.NextCursor != "" {
+			fmt.Printf("More results: --cursor %s\n", page.NextCursor)
+		}
+	case "classify":
+		err := runClassifyCommand(ctx, cfg, emailDB, classifyOptions{
+			query:       *query,
+			since:       *since,
+			max:         *maxEmails,
+			concurrency: *concurrency,
+			rpm:         *rpm,
+			promptName:  *promptName,
+			noCache:     *noCache,
+			classifier:  *classifierName,
+			modelFile:   *modelFile,
+		})
+		if errors.Is(err, context.Canceled) {
+			fmt.Println("Interrupted; predictions so far are stored, run classify again to resume")
+			os.Exit(130)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "usage":
+		err := runUsageCommand(ctx, cfg, emailDB, *since, *by)
+		if errors.Is(err, context.Canceled) {
+			exitInterrupted()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "train":
+		err := runTrainCommand(ctx, emailDB, args, trainOptions{
+			query:     *query,
+			since:     *since,
+			algorithm: *algorithm,
+			modelFile: *modelFile,
+			holdout:   *holdout,
+		})
+		if errors.Is(err, context.Canceled) {
+			exitInterrupted()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "predict":
+		err := runPredictCommand(ctx, emailDB, *modelFile, *query, *since, *limit)
+		if errors.Is(err, context.Canceled) {
+			exitInterrupted()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "eval":
+		err := runEvalCommand(ctx, cfg, emailDB, args, evalOptions{
+			classifier:  *classifierName,
+			modelFile:   *modelFile,
+			promptName:  *promptName,
+			max:         *maxEmails,
+			concurrency: *concurrency,
+			noCache:     *noCache,
+			jsonOut:     *jsonOut,
+		})
+		if errors.Is(err, context.Canceled) {
+			exitInterrupted()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "export-dataset":
+		err := runExportDatasetCommand(ctx, cfg, emailDB, exportOptions{
+			query:      *query,
+			since:      *since,
+			format:     *format,
+			out:        *outDir,
+			split:      *split,
+			ratios:     *ratios,
+			stratify:   *stratify,
+			columns:    *columns,
+			seed:       *seed,
+			promptName: *promptName,
+		})
+		if errors.Is(err, context.Canceled) {
+			exitInterrupted()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "finetune":
+		err := runFinetuneCommand(ctx, cfg, emailDB, args, finetuneOptions{
+			baseModel:  *baseModel,
+			validation: *validation,
+			suffix:     *suffix,
+			epochs:     *epochs,
+			watch:      *watch,
+		})
+		if errors.Is(err, context.Canceled) {
+			fmt.Println("Stopped watching; the job goes on, follow it again with finetune status <job ID>")
+			os.Exit(130)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
 	case "classifyEmail":
+		if err := cfg.ValidateLLM(); err != nil {
+			log.Fatal(err)
+		}
 		testEmail := db.Email{
 			Subject: "Why don’t we screen healthy people to catch diseases early?",
 			From:    "sunkay.subscriptions@gmail.com",
 			To:      "Nikhil Krishnan from OutOfPocket <nikhil@outofpocket.health>",
 			Labels:  "UNREAD, CATEGORY_UPDATES, INBOX",
 		}
-		log.Println("Model:", cfg.OpenAI.Model)
-		gpt3 := openai.NewGPT3Classifier(cfg.OpenAI.APIKey, cfg.OpenAI.Model)
-		prompt := gpt3.GenerateContextualPrompt(testEmail)
+		gpt3, closeCache, err := newClassifier(cfg, emailDB, "classifyEmail", *noCache)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer closeCache()
+		tmpl, err := loadPrompt(cfg, *promptName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		prompt, err := renderPrompt(ctx, emailDB, tmpl, testEmail, cfg.Prompts.Examples, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
 		fmt.Println(prompt)
-		result, err := gpt3.ClassifyEmail(prompt)
+		result, err := gpt3.ClassifyEmailContext(ctx, prompt)
 		if err != nil {
 			log.Fatal(err)
 			os.Exit(1)
 		}
-		fmt.Println(result)
+		fmt.Printf("Action: %s\nCategory: %s\nConfidence: %.2f\nReason: %s\n", result.Action, result.Category, result.Confidence, result.Reason)
 	default:
 		fmt.Println("Unknown command:", os.Args[1])
 		os.Exit(1)
 	}
 }
+
+// exitInterrupted reports a cancelled command and exits with the
+// conventional status for SIGINT.
+func exitInterrupted() {
+	fmt.Println("Interrupted; nothing from this run was stored")
+	os.Exit(130)
+}
+
+// getStoredEmails lists one page of stored emails, newest first,
+// filtered by a Gmail-style query when one is given.
+func getStoredEmails(ctx context.Context, emailDB db.EmailDB, query string, limit int, cursor string) (db.EmailPage, error) {
+	filter := db.EmailFilter{State: db.StateAll}
+	if query != "" {
+		q, err := db.ParseQuery(query)
+		if err != nil {
+			return db.EmailPage{}, err
+		}
+		filter.Query = q
+	}
+	return emailDB.ListEmailsContext(ctx, filter, db.ListOptions{Limit: limit, Cursor: cursor})
+}
+
+// openEmailDB opens the store selected by db.driver. For managing the
+// schema a SQLite database is opened as it is, at whatever version.
+func openEmailDB(cfg *config.Config, managingSchema bool) (db.EmailDB, error) {
+	switch cfg.DB.Driver {
+	case "postgres":
+		dsn, err := cfg.DB.DSN.Value()
+		if err != nil {
+			return nil, err
+		}
+		return db.NewPostgresDB(dsn)
+	default:
+		if managingSchema {
+			return db.OpenSQLiteDB(cfg.DB.Path)
+		}
+		return db.NewSQLiteDB(cfg.DB.Path)
+	}
+}
+
+// parseInterspersed parses flags that may appear before, between or after
+// positional arguments, and returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
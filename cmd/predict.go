@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"github.com/sunkay11/gmail-automation/internal/ml"
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// predicted is a stored email and the local model's verdict on it.
+type predicted struct {
+	email      db.Email
+	prediction ml.Prediction
+}
+
+// runPredictCommand handles `predict`, listing the stored emails that match
+// the query, most likely to be trashed first, with the chances the local
+// model gives them of being trashed or read.
+func runPredictCommand(ctx context.Context, emailDB db.EmailDB, modelFile, query, since string, limit int) error {
+	model, err := ml.Load(modelFile)
+	if err != nil {
+		return err
+	}
+	filter, err := storedFilter(query, since)
+	if err != nil {
+		return err
+	}
+
+	var list []predicted
+	err = eachStored(ctx, emailDB, filter, func(email db.Email) error {
+		list = append(list, predicted{email, model.Predict(ml.Document(email))})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].prediction.Probabilities[openai.ActionTrash] > list[j].prediction.Probabilities[openai.ActionTrash]
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+
+	fmt.Printf("%-8s %8s %7s  %-7s %-30s %s\n", "ID", "P(TRASH)", "P(READ)", "ACTION", "FROM", "SUBJECT")
+	for _, p := range list {
+		fmt.Printf("%-8d %7.0f%% %6.0f%%  %-7s %-30.30s %s\n", p.email.Id,
+			100*p.prediction.Probabilities[openai.ActionTrash], 100*p.prediction.Probabilities[openai.ActionRead],
+			p.prediction.Action, p.email.From, p.email.Subject)
+	}
+	fmt.Printf("Model: %s\n", model.Model())
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sunkay11/gmail-automation/internal/gmailapi"
+)
+
+// runReparseCommand handles `reparse`, rebuilding the headers, body,
+// addresses and labels of every stored email from its raw source. Emails
+// stored before raw sources were kept are left alone.
+func runReparseCommand(ctx context.Context, gmailClient *gmailapi.GmailClient) error {
+	result, err := gmailClient.Reparse(ctx)
+	if err != nil {
+		return err
+	}
+	for _, failure := range result.Failures {
+		fmt.Printf("Email %d not reparsed: %v\n", failure.EmailID, failure.Err)
+	}
+	fmt.Printf("Reparsed %d emails, %d failed\n", result.Reparsed, len(result.Failures))
+	return nil
+}
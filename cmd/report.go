@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+)
+
+// reportBuckets maps --by to the function naming the bucket of a local
+// time. Bucket names sort in time order.
+var reportBuckets = map[string]func(t time.Time) string{
+	"hour":  func(t time.Time) string { return t.Format("15") },
+	"day":   func(t time.Time) string { return t.Format("2006-01-02") },
+	"month": func(t time.Time) string { return t.Format("2006-01") },
+	"weekday": func(t time.Time) string {
+		// Numbered from Monday so that Sunday sorts last.
+		return fmt.Sprintf("%d %s", (int(t.Weekday())+6)%7+1, t.Format("Mon"))
+	},
+}
+
+// runReportCommand handles `report [--by hour|day|weekday|month] [--tz
+// <zone>] [--query <query>]`, counting stored emails by when they were sent
+// in the given time zone, the local one by default.
+func runReportCommand(ctx context.Context, emailDB db.EmailDB, query, by, tz string) error {
+	bucketOf, ok := reportBuckets[by]
+	if !ok {
+		return fmt.Errorf("unknown report bucket %q: expected hour, day, weekday or month", by)
+	}
+	loc := time.Local
+	if tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return fmt.Errorf("invalid time zone %q: %v", tz, err)
+		}
+	}
+
+	filter := db.EmailFilter{State: db.StateAll}
+	if query != "" {
+		q, err := db.ParseQuery(query)
+		if err != nil {
+			return err
+		}
+		filter.Query = q
+	}
+
+	it, err := emailDB.IterateEmailsContext(ctx, filter, db.ListOptions{Sort: db.SortBySentDate, Ascending: true})
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	counts := map[string]int{}
+	for it.Next() {
+		email := it.Email()
+		if email.SentAt.IsZero() {
+			continue
+		}
+		counts[bucketOf(email.SentAt.In(loc))]++
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	buckets := make([]string, 0, len(counts))
+	for bucket := range counts {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	fmt.Printf("Emails by %s (%s)\n", by, loc)
+	for _, bucket := range buckets {
+		fmt.Printf("%-12s %d\n", bucket, counts[bucket])
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+)
+
+// runSearchCommand handles `search "<query>" [--limit N] [--page N]`.
+func runSearchCommand(emailDB db.EmailDB, args []string, limit int, page int) error {
+	sqliteDB, ok := emailDB.(*db.SQLiteDB)
+	if !ok {
+		return fmt.Errorf("search is only supported for the sqlite driver")
+	}
+	if len(args) == 0 {
+		return fmt.Errorf(`usage: search "<query>" [--limit N] [--page N]`)
+	}
+	if limit < 1 {
+		return fmt.Errorf("--limit must be at least 1, got %d", limit)
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	results, total, err := sqliteDB.Search(strings.Join(args, " "), db.SearchOptions{
+		Limit:          limit,
+		Offset:         (page - 1) * limit,
+		HighlightStart: "\033[1m",
+		HighlightEnd:   "\033[0m",
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, result := range results {
+		email := result.Email
+		fmt.Printf("[%d] %s | %s | %s", (page-1)*limit+i+1, email.SentDate, email.From, email.Subject)
+		if email.State == db.StateTrashed {
+			fmt.Print(" (trashed)")
+		}
+		fmt.Printf("\n    %s\n", result.Snippet)
+	}
+
+	if total == 0 {
+		fmt.Println("No matches")
+		return nil
+	}
+	pages := (total + limit - 1) / limit
+	fmt.Printf("%d matches, page %d of %d\n", total, page, pages)
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	"github.com/sunkay11/gmail-automation/internal/dataset"
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"github.com/sunkay11/gmail-automation/internal/ml"
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// trainSeed fixes the holdout split and the training order, so that the
+// same history trains the same model.
+const trainSeed = 1
+
+// trainOptions are the flags of the train command.
+type trainOptions struct {
+	query, since string
+	algorithm    string
+	modelFile    string
+	// holdout is the fraction of emails kept out of training to measure
+	// the model on.
+	holdout float64
+}
+
+// runTrainCommand handles `train [split.csv]`, fitting a local model to
+// what was done with the stored emails, trashed or not, and saving it to
+// the model file. Given a split that export-dataset wrote, it learns from
+// that split's emails and labels only, so that eval on the other splits
+// measures emails the model has not seen.
+func runTrainCommand(ctx context.Context, emailDB db.EmailDB, args []string, opts trainOptions) error {
+	algorithm, err := mlAlgorithm(opts.algorithm)
+	if err != nil {
+		return err
+	}
+	if opts.holdout < 0 || opts.holdout >= 1 {
+		return fmt.Errorf("--holdout must be at least 0 and below 1, got %g", opts.holdout)
+	}
+
+	var examples []ml.Example
+	switch len(args) {
+	case 0:
+		filter, err := storedFilter(opts.query, opts.since)
+		if err != nil {
+			return err
+		}
+		err = eachStored(ctx, emailDB, filter, func(email db.Email) error {
+			examples = append(examples, ml.Example{Text: ml.Document(email), Label: openai.OutcomeOf(email)})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	case 1:
+		if opts.query != "" || opts.since != "" {
+			return fmt.Errorf("--query and --since do not apply to a split, which is trained on as it is")
+		}
+		split, err := dataset.Read(args[0])
+		if err != nil {
+			return err
+		}
+		emails, err := splitEmails(ctx, emailDB, split)
+		if err != nil {
+			return err
+		}
+		for i, email := range emails {
+			examples = append(examples, ml.Example{Text: ml.Document(email), Label: split[i].Outcome})
+		}
+	default:
+		return fmt.Errorf("usage: train [split.csv]")
+	}
+	rng := rand.New(rand.NewSource(trainSeed))
+	rng.Shuffle(len(examples), func(i, j int) { examples[i], examples[j] = examples[j], examples[i] })
+	held := int(float64(len(examples)) * opts.holdout)
+	test, train := examples[:held], examples[held:]
+
+	model, err := ml.Train(algorithm, train, ml.TrainOptions{Seed: trainSeed})
+	if err != nil {
+		return err
+	}
+	counts := map[openai.Action]int{}
+	for _, ex := range train {
+		counts[ex.Label]++
+	}
+	fmt.Printf("Trained %s on %d emails with %d features:", model.Model(), len(train), len(model.Vocabulary))
+	for _, action := range openai.Actions {
+		fmt.Printf(" %d %s", counts[action], action)
+	}
+	fmt.Println()
+	if len(test) > 0 {
+		correct := 0
+		for _, ex := range test {
+			if model.Predict(ex.Text).Action == ex.Label {
+				correct++
+			}
+		}
+		fmt.Printf("Holdout accuracy: %.1f%% of %d emails\n", 100*float64(correct)/float64(len(test)), len(test))
+	}
+	if err := model.Save(opts.modelFile); err != nil {
+		return err
+	}
+	fmt.Printf("Saved to %s\n", opts.modelFile)
+	return nil
+}
+
+// mlAlgorithm resolves the --algorithm flag, which takes nb and logreg as
+// short names.
+func mlAlgorithm(name string) (string, error) {
+	switch name {
+	case "nb", ml.NaiveBayes:
+		return ml.NaiveBayes, nil
+	case "logreg", ml.LogisticRegression:
+		return ml.LogisticRegression, nil
+	}
+	return "", fmt.Errorf("unknown algorithm %q: expected nb or logreg", name)
+}
+
+// eachStored calls fn with every stored email that matches filter, newest
+// first, a page at a time.
+func eachStored(ctx context.Context, emailDB db.EmailDB, filter db.EmailFilter, fn func(db.Email) error) error {
+	cursor := ""
+	for {
+		page, err := emailDB.ListEmailsContext(ctx, filter, db.ListOptions{Limit: classifyPage, Cursor: cursor})
+		if err != nil {
+			return err
+		}
+		for _, email := range page.Emails {
+			if err := fn(email); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		cursor = page.NextCursor
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sunkay11/gmail-automation/internal/config"
+	"github.com/sunkay11/gmail-automation/internal/db"
+)
+
+// usageRow totals the ledger entries of one period and model.
+type usageRow struct {
+	period, model                  string
+	requests                       int
+	promptTokens, completionTokens int
+	cost                           float64
+}
+
+// runUsageCommand handles `usage [--since <date or age>] [--by day|month]`,
+// totalling the llm_usage ledger per period and model, and showing what is
+// left of the budgets. --since defaults to the start of the month.
+func runUsageCommand(ctx context.Context, cfg *config.Config, emailDB db.EmailDB, since, by string) error {
+	now := time.Now()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	start := month
+	if since != "" {
+		var err error
+		if start, err = db.ParseSince(since, now); err != nil {
+			return err
+		}
+	}
+	var layout string
+	switch by {
+	case "day":
+		layout = "2006-01-02"
+	case "month":
+		layout = "2006-01"
+	default:
+		return fmt.Errorf("unknown bucket %q for usage: expected day or month", by)
+	}
+
+	// The budgets need this month even when --since is later.
+	from := start
+	if month.Before(from) {
+		from = month
+	}
+	records, err := emailDB.ListUsageContext(ctx, from)
+	if err != nil {
+		return err
+	}
+
+	rows := map[[2]string]*usageRow{}
+	var total usageRow
+	var spentToday, spentMonth float64
+	for _, r := range records {
+		if !r.CreatedAt.Before(month) {
+			spentMonth += r.Cost
+		}
+		if !r.CreatedAt.Before(today) {
+			spentToday += r.Cost
+		}
+		if r.CreatedAt.Before(start) {
+			continue
+		}
+		key := [2]string{r.CreatedAt.Local().Format(layout), r.Model}
+		row, ok := rows[key]
+		if !ok {
+			row = &usageRow{period: key[0], model: key[1]}
+			rows[key] = row
+		}
+		for _, sum := range []*usageRow{row, &total} {
+			sum.requests++
+			sum.promptTokens += r.PromptTokens
+			sum.completionTokens += r.CompletionTokens
+			sum.cost += r.Cost
+		}
+	}
+
+	sorted := make([]*usageRow, 0, len(rows))
+	for _, row := range rows {
+		sorted = append(sorted, row)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].period != sorted[j].period {
+			return sorted[i].period < sorted[j].period
+		}
+		return sorted[i].model < sorted[j].model
+	})
+
+	fmt.Printf("%-10s  %-24s %8s %12s %12s %10s\n", "PERIOD", "MODEL", "REQUESTS", "PROMPT", "COMPLETION", "COST")
+	for _, row := range sorted {
+		fmt.Printf("%-10s  %-24s %8d %12d %12d %10s\n", row.period, row.model, row.requests,
+			row.promptTokens, row.completionTokens, dollars(row.cost))
+	}
+	fmt.Printf("%-10s  %-24s %8d %12d %12d %10s\n", "total", "", total.requests,
+		total.promptTokens, total.completionTokens, dollars(total.cost))
+
+	fmt.Printf("Today %s%s, this month %s%s\n", dollars(spentToday), ofBudget(cfg.Usage.DailyBudget),
+		dollars(spentMonth), ofBudget(cfg.Usage.MonthlyBudget))
+	return nil
+}
+
+func dollars(amount float64) string {
+	return fmt.Sprintf("$%.4f", amount)
+}
+
+// ofBudget describes a budget, or nothing when there is none.
+func ofBudget(budget float64) string {
+	if budget <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" of %s", dollars(budget))
+}
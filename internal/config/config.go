@@ -1,8 +1,9 @@
 package config
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"regexp"
 
@@ -12,45 +13,221 @@ import (
 
 type Config struct {
 	OpenAI struct {
-		APIKey string `yaml:"api_key"`
+		APIKey Secret `yaml:"api_key"`
 		Model  string `yaml:"model"`
-		Prompt string `yaml:"prompt"`
 	} `yaml:"openai"`
 
+	LLM LLM `yaml:"llm"`
+
+	// Prompts configures the classification prompt templates: the built-in
+	// "triage" one, every *.tmpl file in Dir and the Templates listed here,
+	// each a text/template over the email. Default names the one used and
+	// Examples is how many similar past emails are shown to the model.
+	Prompts struct {
+		Default   string   `yaml:"default"`
+		Dir       string   `yaml:"dir,omitempty"`
+		Examples  int      `yaml:"examples"`
+		Templates []Prompt `yaml:"templates,omitempty"`
+	} `yaml:"prompts"`
+
+	// Cache keeps chat model answers in a SQLite file at Path, keyed by
+	// model, prompt and request settings. TTL is a duration such as 720h,
+	// 0 for no expiry; MaxEntries bounds it, 0 for no bound.
+	Cache struct {
+		Enabled    bool   `yaml:"enabled"`
+		Path       string `yaml:"path"`
+		TTL        string `yaml:"ttl"`
+		MaxEntries int    `yaml:"max_entries"`
+	} `yaml:"cache"`
+
+	Usage Usage `yaml:"usage"`
+
 	Gmail struct {
-		ClientSecretPath string   `yaml:"client_secret_path"`
-		TokenPath        string   `yaml:"token_path"`
-		Labels           []string `yaml:"labels"`
+		ClientSecretPath string    `yaml:"client_secret_path"`
+		TokenPath        string    `yaml:"token_path"`
+		Labels           []string  `yaml:"labels"`
+		Accounts         []Account `yaml:"accounts,omitempty"`
 	} `yaml:"gmail"`
 
 	DB struct {
-		Path string `yaml:"path"`
+		Driver string `yaml:"driver"`
+		Path   string `yaml:"path"`
+		DSN    Secret `yaml:"dsn,omitempty"`
 	} `yaml:"db"`
 }
 
+// Prompt is a named inline prompt template.
+type Prompt struct {
+	Name     string `yaml:"name"`
+	Template string `yaml:"template"`
+}
+
+// Account is a mailbox the ingester can read. Auth is either "oauth" (the
+// default, interactive consent with a cached token) or "service_account"
+// (domain-wide delegation impersonating Subject).
+type Account struct {
+	Name               string `yaml:"name"`
+	Auth               string `yaml:"auth"`
+	ClientSecretPath   string `yaml:"client_secret_path"`
+	TokenPath          string `yaml:"token_path"`
+	ServiceAccountPath string `yaml:"service_account_path"`
+	Subject            string `yaml:"subject"`
+}
+
+// Account returns the named account. An empty name selects the first
+// configured account, or falls back to the top-level gmail OAuth settings
+// when no accounts are configured.
+func (c *Config) Account(name string) (Account, error) {
+	if len(c.Gmail.Accounts) == 0 {
+		if name != "" {
+			return Account{}, fmt.Errorf("unknown account %q: no gmail.accounts configured", name)
+		}
+		return Account{
+			Name:             "default",
+			Auth:             "oauth",
+			ClientSecretPath: c.Gmail.ClientSecretPath,
+			TokenPath:        c.Gmail.TokenPath,
+		}, nil
+	}
+
+	for _, account := range c.Gmail.Accounts {
+		if name == "" || account.Name == name {
+			if account.Auth == "" {
+				account.Auth = "oauth"
+			}
+			if account.ClientSecretPath == "" {
+				account.ClientSecretPath = c.Gmail.ClientSecretPath
+			}
+			if account.TokenPath == "" {
+				account.TokenPath = c.Gmail.TokenPath
+			}
+			return account, nil
+		}
+	}
+	return Account{}, fmt.Errorf("unknown account %q", name)
+}
+
+// Default returns the configuration used for anything the config file,
+// environment and command line leave unset.
+func Default() *Config {
+	var config Config
+	config.OpenAI.Model = "gpt-3.5-turbo"
+	config.Prompts.Default = "triage"
+	config.Prompts.Examples = 3
+	config.Cache.Enabled = true
+	config.Cache.Path = "./llm_cache.sqlite"
+	config.Cache.TTL = "720h"
+	config.Cache.MaxEntries = 100000
+	config.Gmail.ClientSecretPath = "./client_secret.json"
+	config.Gmail.TokenPath = "./token.json"
+	config.Gmail.Labels = []string{"INBOX", "TRASH", "IMPORTANT", "STARRED", "READ", "UNREAD"}
+	config.DB.Driver = "sqlite"
+	config.DB.Path = "./emails.sqlite"
+	return &config
+}
+
 func LoadConfig(filePath string) (*Config, error) {
+	return Load(filePath, nil)
+}
+
+// Load builds the effective configuration. Later layers win:
+// defaults < config file < GMAIL_AUTOMATION_* environment variables <
+// overrides (typically --set key=value flags). The result is validated.
+func Load(filePath string, overrides map[string]string) (*Config, error) {
+	config := Default()
+
 	content, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to read config file")
 	}
 
-	content = replaceEnvVars(content)
+	content, err = replaceEnvVars(content)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s", filePath)
+	}
 
-	var config Config
-	err = yaml.Unmarshal(content, &config)
+	err = yaml.Unmarshal(content, config)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to unmarshal config file")
 	}
 
-	log.Println("Config:", config)
-	return &config, nil
+	for _, key := range Keys() {
+		if value, ok := os.LookupEnv(EnvName(key)); ok {
+			if err := config.Set(key, value); err != nil {
+				return nil, errors.Wrapf(err, "environment variable %s", EnvName(key))
+			}
+		}
+	}
+
+	for key, value := range overrides {
+		if err := config.Set(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
 }
 
-func replaceEnvVars(content []byte) []byte {
-	envVarPattern := regexp.MustCompile(`\$\{(.+?)\}`)
-	return envVarPattern.ReplaceAllFunc(content, func(s []byte) []byte {
-		envVar := string(s[2 : len(s)-1])
-		envValue := os.Getenv(envVar)
-		return []byte(envValue)
-	})
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?:(:-|:\?)(.*?))?\}`)
+
+// replaceEnvVars expands ${VAR}, ${VAR:-default} and ${VAR:?message}
+// outside YAML comments. An unset or empty VAR is an error in the first
+// form, expands to default in the second and is an error carrying message
+// in the third; ${VAR:-} opts into an empty value.
+func replaceEnvVars(content []byte) ([]byte, error) {
+	var missing error
+	expand := func(s []byte) []byte {
+		match := envVarPattern.FindSubmatch(s)
+		name, op, arg := string(match[1]), string(match[2]), string(match[3])
+
+		value := os.Getenv(name)
+		if value != "" {
+			return []byte(value)
+		}
+		if op == ":-" {
+			return []byte(arg)
+		}
+		if missing == nil {
+			if op == "" || arg == "" {
+				arg = "required but not set"
+			}
+			missing = fmt.Errorf("environment variable %s: %s", name, arg)
+		}
+		return nil
+	}
+
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	for i, line := range lines {
+		code := commentStart(line)
+		lines[i] = append(envVarPattern.ReplaceAllFunc(line[:code:code], expand), line[code:]...)
+	}
+
+	if missing != nil {
+		return nil, missing
+	}
+	return bytes.Join(lines, nil), nil
+}
+
+// commentStart returns where the YAML comment of a line starts: at a #
+// that begins the line or follows a space, outside quotes; or the length
+// of the line when it has none.
+func commentStart(line []byte) int {
+	var quote byte
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return i
+		}
+	}
+	return len(line)
 }
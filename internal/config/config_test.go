@@ -0,0 +1,261 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestReplaceEnvVars(t *testing.T) {
+	t.Setenv("CONFIG_TEST_SET", "value")
+	os.Unsetenv("CONFIG_TEST_UNSET")
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "key: ${CONFIG_TEST_SET}", want: "key: value"},
+		{in: "key: ${CONFIG_TEST_UNSET}", wantErr: true},
+		{in: "key: ${CONFIG_TEST_UNSET:-}", want: "key: "},
+		{in: "key: ${CONFIG_TEST_UNSET:-fallback}", want: "key: fallback"},
+		{in: "key: ${CONFIG_TEST_SET:-fallback}", want: "key: value"},
+		{in: "key: ${CONFIG_TEST_SET:?must be set}", want: "key: value"},
+		{in: "key: ${CONFIG_TEST_UNSET:?must be set}", wantErr: true},
+		{in: "key: ${CONFIG_TEST_UNSET:?}", wantErr: true},
+		{in: "# key: ${CONFIG_TEST_UNSET}", want: "# key: ${CONFIG_TEST_UNSET}"},
+		{in: "key: ${CONFIG_TEST_SET} # was ${CONFIG_TEST_UNSET}", want: "key: value # was ${CONFIG_TEST_UNSET}"},
+		{in: "key: \"#${CONFIG_TEST_SET}\"\nother: ${CONFIG_TEST_SET}#x", want: "key: \"#value\"\nother: value#x"},
+		{in: "a: 1\n  # ${CONFIG_TEST_UNSET:?must be set}\nb: ${CONFIG_TEST_UNSET}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := replaceEnvVars([]byte(tt.in))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("replaceEnvVars(%q): expected an error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("replaceEnvVars(%q): unexpected error: %v", tt.in, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("replaceEnvVars(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+openai:
+  api_key: from-file
+  model: file-model
+gmail:
+  labels: ["INBOX", "TRASH"]
+db:
+  path: ./file.sqlite
+`)
+	t.Setenv(EnvName("openai.model"), "env-model")
+	t.Setenv(EnvName("db.path"), "./env.sqlite")
+
+	cfg, err := Load(path, map[string]string{"db.path": "./flag.sqlite"})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.OpenAI.APIKey != "from-file" {
+		t.Errorf("Expected api key from file, got %q", cfg.OpenAI.APIKey)
+	}
+	if cfg.OpenAI.Model != "env-model" {
+		t.Errorf("Expected model from env, got %q", cfg.OpenAI.Model)
+	}
+	if cfg.DB.Path != "./flag.sqlite" {
+		t.Errorf("Expected db path from flag, got %q", cfg.DB.Path)
+	}
+	if strings.Join(cfg.Gmail.Labels, ",") != "INBOX,TRASH" {
+		t.Errorf("Expected labels from file, got %v", cfg.Gmail.Labels)
+	}
+	if cfg.Gmail.TokenPath != "./token.json" {
+		t.Errorf("Expected default token path, got %q", cfg.Gmail.TokenPath)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	path := writeConfig(t, `
+gmail:
+  labels: ["INBOX", "INBXO"]
+  accounts:
+    - name: alice
+      auth: service_account
+db:
+  path: ./missing-dir/emails.sqlite
+prompts:
+  examples: -1
+  templates:
+    - name: short
+      template: "{{.Subject}}"
+    - name: short
+      template: "{{.From}}"
+cache:
+  ttl: 30d
+usage:
+  daily_budget: -1
+  prices:
+    - prompt: 0.01
+`)
+
+	_, err := Load(path, nil)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+
+	for _, want := range []string{"INBXO", "missing-dir", "service_account_path", "subject", "prompts.examples", `duplicate prompt name "short"`, "cache.ttl", "usage budgets", "usage.prices[0]: model"} {
+		if !strings.Contains(verr.Error(), want) {
+			t.Errorf("Expected validation error to mention %q, got:\n%s", want, verr)
+		}
+	}
+}
+
+func TestStringRedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.OpenAI.APIKey = "sk-secret"
+
+	out := cfg.String()
+	if strings.Contains(out, "sk-secret") {
+		t.Errorf("Expected api key to be redacted, got:\n%s", out)
+	}
+	if cfg.OpenAI.APIKey != "sk-secret" {
+		t.Errorf("Redaction must not modify the config")
+	}
+}
+
+func TestSecretReferences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "openai")
+	if err := os.WriteFile(path, []byte("sk-from-file\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+
+	tests := []struct {
+		secret Secret
+		want   string
+	}{
+		{secret: "sk-literal", want: "sk-literal"},
+		{secret: Secret("file:" + path), want: "sk-from-file"},
+		{secret: "exec:echo sk-from-exec", want: "sk-from-exec"},
+		{secret: `exec:printf '%s' "sk with  spaces"`, want: "sk with  spaces"},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.secret.Value()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.secret, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.secret, got, tt.want)
+		}
+	}
+
+	if _, err := Secret("exec:false").Value(); err == nil {
+		t.Errorf("Expected a failing command to return an error")
+	}
+
+	if _, err := Secret("exec:").Value(); err == nil {
+		t.Errorf("Expected an empty command to return an error")
+	}
+
+	if Secret("sk-literal").String() == "sk-literal" {
+		t.Errorf("Literal secrets must be redacted when printed")
+	}
+}
+
+func TestSecretLookupsDoNotWaitForOthers(t *testing.T) {
+	slow := Secret("exec:sleep 2; echo slow")
+	started := make(chan struct{})
+	go func() {
+		close(started)
+		slow.Value()
+	}()
+	<-started
+	time.Sleep(100 * time.Millisecond)
+
+	begin := time.Now()
+	if got, err := Secret("exec:echo fast").Value(); err != nil || got != "fast" {
+		t.Fatalf("Expected fast, got %q, %v", got, err)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("Expected a lookup not to wait for another command, took %s", elapsed)
+	}
+}
+
+func TestChatLLM(t *testing.T) {
+	cfg := Default()
+	cfg.OpenAI.APIKey = "sk-openai"
+
+	llm := cfg.ChatLLM()
+	if llm.Provider != "openai" || llm.Model != "gpt-3.5-turbo" || llm.APIKey != "sk-openai" {
+		t.Errorf("Expected the openai section as fallback, got %+v", llm)
+	}
+	if err := cfg.ValidateLLM(); err != nil {
+		t.Errorf("Expected the defaults to validate, got %v", err)
+	}
+
+	cfg.LLM.Provider = "ollama"
+	cfg.LLM.Model = "llama3"
+	llm = cfg.ChatLLM()
+	if llm.Provider != "compatible" || llm.BaseURL != "http://localhost:11434/v1" || llm.APIKey != "" {
+		t.Errorf("Expected ollama's local endpoint without the OpenAI key, got %+v", llm)
+	}
+	if err := cfg.ValidateLLM(); err != nil {
+		t.Errorf("Expected a local provider to need no key, got %v", err)
+	}
+
+	cfg.LLM.Provider = "azure"
+	err := cfg.ValidateLLM()
+	if err == nil || !strings.Contains(err.Error(), "llm.base_url") {
+		t.Errorf("Expected azure to require a base URL, got %v", err)
+	}
+	cfg.LLM.Provider = "bedrock"
+	if err := cfg.ValidateLLM(); err == nil || !strings.Contains(err.Error(), "bedrock") {
+		t.Errorf("Expected an unknown provider error, got %v", err)
+	}
+}
+
+func TestModelPrices(t *testing.T) {
+	path := writeConfig(t, `
+usage:
+  prices:
+    - model: gpt-4o
+      prompt: 0.0025
+      completion: 0.01
+    - model: llama3
+`)
+	cfg, err := Load(path, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	prices := cfg.ModelPrices()
+	if p := prices["gpt-4o"]; p.Prompt != 0.0025 || p.Completion != 0.01 {
+		t.Errorf("Expected the configured gpt-4o price, got %+v", p)
+	}
+	if _, ok := prices["llama3"]; !ok {
+		t.Error("Expected llama3 to be added")
+	}
+	if p := prices["gpt-3.5-turbo"]; p.Prompt == 0 {
+		t.Error("Expected the built-in gpt-3.5-turbo price to remain")
+	}
+}
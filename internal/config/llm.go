@@ -0,0 +1,48 @@
+package config
+
+// LLM selects the chat model the classifier talks to. Provider is openai
+// (the default), azure, or compatible for any server speaking the OpenAI
+// API; ollama, llamacpp and vllm are compatible with their usual local
+// base URL. Model and, for openai and azure, APIKey fall back to the
+// openai section.
+type LLM struct {
+	Provider string `yaml:"provider"`
+	BaseURL  string `yaml:"base_url"`
+	Model    string `yaml:"model"`
+	APIKey   Secret `yaml:"api_key,omitempty"`
+	// APIVersion and Deployment apply to azure only. Deployment defaults to
+	// the model name without dots.
+	APIVersion string `yaml:"api_version,omitempty"`
+	Deployment string `yaml:"deployment,omitempty"`
+}
+
+// localBaseURLs are the default endpoints of the local servers that can be
+// named as providers.
+var localBaseURLs = map[string]string{
+	"ollama":   "http://localhost:11434/v1",
+	"llamacpp": "http://localhost:8080/v1",
+	"vllm":     "http://localhost:8000/v1",
+}
+
+// ChatLLM returns the llm settings with the local provider names resolved
+// and the openai fallbacks applied.
+func (c *Config) ChatLLM() LLM {
+	llm := c.LLM
+	if llm.Provider == "" {
+		llm.Provider = "openai"
+	}
+	if url, ok := localBaseURLs[llm.Provider]; ok {
+		llm.Provider = "compatible"
+		if llm.BaseURL == "" {
+			llm.BaseURL = url
+		}
+	}
+	if llm.Model == "" {
+		llm.Model = c.OpenAI.Model
+	}
+	// A local server must not receive the OpenAI key.
+	if llm.APIKey == "" && llm.Provider != "compatible" {
+		llm.APIKey = c.OpenAI.APIKey
+	}
+	return llm
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const envPrefix = "GMAIL_AUTOMATION_"
+
+const redacted = "********"
+
+// Keys lists the dotted keys (e.g. "openai.model") that can be overridden
+// from the environment or the command line.
+func Keys() []string {
+	var keys []string
+	walkFields(reflect.ValueOf(&Config{}).Elem(), "", func(key string, _ reflect.Value, _ reflect.StructField) {
+		keys = append(keys, key)
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// EnvName returns the environment variable that overrides key, e.g.
+// GMAIL_AUTOMATION_OPENAI_MODEL for "openai.model".
+func EnvName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Set assigns value to the field addressed by a dotted key. List fields take
+// a comma separated value.
+func (c *Config) Set(key string, value string) error {
+	found := false
+	var setErr error
+	walkFields(reflect.ValueOf(c).Elem(), "", func(k string, field reflect.Value, _ reflect.StructField) {
+		if k != key {
+			return
+		}
+		found = true
+		setErr = setValue(field, value)
+	})
+
+	if !found {
+		return fmt.Errorf("unknown config key %q", key)
+	}
+	if setErr != nil {
+		return fmt.Errorf("config key %s: %v", key, setErr)
+	}
+	return nil
+}
+
+// Redacted returns a copy of the config with every secret replaced by a
+// placeholder, safe for printing and logging.
+func (c *Config) Redacted() *Config {
+	copied := *c
+	copied.Gmail.Labels = append([]string(nil), c.Gmail.Labels...)
+	copied.Gmail.Accounts = append([]Account(nil), c.Gmail.Accounts...)
+
+	walkFields(reflect.ValueOf(&copied).Elem(), "", func(_ string, field reflect.Value, _ reflect.StructField) {
+		if secret, ok := field.Interface().(Secret); ok {
+			field.SetString(secret.String())
+		}
+	})
+	return &copied
+}
+
+// String renders the config as YAML with secrets redacted.
+func (c *Config) String() string {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return fmt.Sprintf("<unable to render config: %v>", err)
+	}
+	return string(out)
+}
+
+// walkFields calls fn for every settable leaf field of v, keyed by the
+// dotted path of yaml tags. Lists of structs are not addressable by key and
+// are skipped.
+func walkFields(v reflect.Value, prefix string, fn func(key string, field reflect.Value, sf reflect.StructField)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Struct:
+			walkFields(field, key, fn)
+		case reflect.Slice:
+			if field.Type().Elem().Kind() == reflect.String {
+				fn(key, field, sf)
+			}
+		default:
+			fn(key, field, sf)
+		}
+	}
+}
+
+func setValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretTimeout bounds how long an exec: secret reference may run.
+var SecretTimeout = 10 * time.Second
+
+// Secret is a config value that must never be printed. It holds either the
+// secret itself or a reference to it:
+//
+//	api_key: file:/run/secrets/openai    # contents of the file, trimmed
+//	api_key: exec:pass show openai       # stdout of the command, trimmed
+//
+// exec: commands are run by sh -c, so arguments may be quoted as in a
+// shell. References are resolved on first use by Value and cached for the
+// life of the process, so commands that never need the secret never run the
+// command.
+type Secret string
+
+// resolvedSecret is the cached value of one reference. Its lock is held
+// while the reference is resolved, so that concurrent lookups of it wait
+// for one resolution while other references resolve alongside.
+type resolvedSecret struct {
+	sync.Mutex
+	done  bool
+	value string
+}
+
+var secretCache = struct {
+	sync.Mutex
+	values map[Secret]*resolvedSecret
+}{values: map[Secret]*resolvedSecret{}}
+
+// IsReference reports whether the secret points at a file or command rather
+// than holding the value itself.
+func (s Secret) IsReference() bool {
+	return strings.HasPrefix(string(s), "file:") || strings.HasPrefix(string(s), "exec:")
+}
+
+// Value returns the secret, resolving and caching references.
+func (s Secret) Value() (string, error) {
+	if !s.IsReference() {
+		return string(s), nil
+	}
+
+	secretCache.Lock()
+	cached, ok := secretCache.values[s]
+	if !ok {
+		cached = &resolvedSecret{}
+		secretCache.values[s] = cached
+	}
+	secretCache.Unlock()
+
+	// A failed resolution is not cached: the next lookup tries again.
+	cached.Lock()
+	defer cached.Unlock()
+	if !cached.done {
+		value, err := s.resolve()
+		if err != nil {
+			return "", err
+		}
+		cached.value, cached.done = value, true
+	}
+	return cached.value, nil
+}
+
+func (s Secret) resolve() (string, error) {
+	ref := string(s)
+	switch {
+	case strings.HasPrefix(ref, "file:"):
+		path := strings.TrimPrefix(ref, "file:")
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %v", ref, err)
+		}
+		return strings.TrimSpace(string(content)), nil
+
+	case strings.HasPrefix(ref, "exec:"):
+		command := strings.TrimSpace(strings.TrimPrefix(ref, "exec:"))
+		if command == "" {
+			return "", fmt.Errorf("secret %s: no command given", ref)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), SecretTimeout)
+		defer cancel()
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return "", fmt.Errorf("secret %s: timed out after %s", ref, SecretTimeout)
+			}
+			return "", fmt.Errorf("secret %s: %v: %s", ref, err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimSpace(stdout.String()), nil
+	}
+
+	return ref, nil
+}
+
+// String never reveals a literal secret; references are shown as written.
+func (s Secret) String() string {
+	if s == "" || s.IsReference() {
+		return string(s)
+	}
+	return redacted
+}
+
+// MarshalYAML renders the secret the same way as String.
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
@@ -0,0 +1,39 @@
+package config
+
+// Usage sets the spending caps of chat requests, in USD per calendar day
+// and month (0 for none), and the prices used to cost them. Prices listed
+// here add to or replace the built-in ones by model name.
+type Usage struct {
+	DailyBudget   float64      `yaml:"daily_budget"`
+	MonthlyBudget float64      `yaml:"monthly_budget"`
+	Prices        []ModelPrice `yaml:"prices,omitempty"`
+}
+
+// ModelPrice is what a model charges in USD per 1000 prompt and completion
+// tokens. Model also prices the models whose names start with it.
+type ModelPrice struct {
+	Model      string  `yaml:"model"`
+	Prompt     float64 `yaml:"prompt"`
+	Completion float64 `yaml:"completion"`
+}
+
+// defaultPrices are OpenAI's list prices for its chat models.
+var defaultPrices = []ModelPrice{
+	{Model: "gpt-3.5-turbo", Prompt: 0.0005, Completion: 0.0015},
+	{Model: "gpt-4", Prompt: 0.03, Completion: 0.06},
+	{Model: "gpt-4-turbo", Prompt: 0.01, Completion: 0.03},
+	{Model: "gpt-4o", Prompt: 0.005, Completion: 0.015},
+	{Model: "gpt-4o-mini", Prompt: 0.00015, Completion: 0.0006},
+}
+
+// ModelPrices returns the built-in prices overridden by usage.prices, by
+// model name.
+func (c *Config) ModelPrices() map[string]ModelPrice {
+	prices := map[string]ModelPrice{}
+	for _, list := range [][]ModelPrice{defaultPrices, c.Usage.Prices} {
+		for _, price := range list {
+			prices[price.Model] = price
+		}
+	}
+	return prices
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// knownLabels are the Gmail system labels plus the READ and ARCHIVED
+// pseudo-labels the ingester derives itself. User labels ("Label_...") and
+// CATEGORY_* labels are always accepted.
+var knownLabels = []string{
+	"INBOX", "TRASH", "SPAM", "SENT", "DRAFT", "IMPORTANT", "STARRED",
+	"UNREAD", "CHAT", "READ", "ARCHIVED",
+}
+
+// ValidationError lists every problem found in a config, so that all of them
+// can be fixed in one go.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+func (e *ValidationError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+func (e *ValidationError) err() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks the settings every command relies on. Settings needed only
+// by some commands are checked by ValidateGmail and ValidateLLM.
+func (c *Config) Validate() error {
+	verr := &ValidationError{}
+
+	switch c.DB.Driver {
+	case "sqlite":
+		if c.DB.Path == "" {
+			verr.add("db.path is required")
+		} else if dir := filepath.Dir(c.DB.Path); !isDir(dir) {
+			verr.add("db.path %s: directory %s does not exist", c.DB.Path, dir)
+		}
+	case "postgres":
+		if c.DB.DSN == "" {
+			verr.add("db.dsn is required for driver postgres")
+		}
+	default:
+		verr.add("db.driver: unknown driver %q (expected sqlite or postgres)", c.DB.Driver)
+	}
+
+	if len(c.Gmail.Labels) == 0 {
+		verr.add("gmail.labels must list at least one label")
+	}
+	for _, label := range c.Gmail.Labels {
+		if !isKnownLabel(label) {
+			verr.add("gmail.labels: unknown label %q (expected one of %s, CATEGORY_* or Label_*)",
+				label, strings.Join(knownLabels, ", "))
+		}
+	}
+
+	names := map[string]bool{}
+	for i, account := range c.Gmail.Accounts {
+		where := fmt.Sprintf("gmail.accounts[%d]", i)
+		if account.Name == "" {
+			verr.add("%s: name is required", where)
+		} else if names[account.Name] {
+			verr.add("%s: duplicate account name %q", where, account.Name)
+		}
+		names[account.Name] = true
+
+		switch account.Auth {
+		case "", "oauth":
+		case "service_account":
+			if account.ServiceAccountPath == "" {
+				verr.add("%s: service_account_path is required for auth service_account", where)
+			}
+			if account.Subject == "" {
+				verr.add("%s: subject is required for auth service_account", where)
+			}
+		default:
+			verr.add("%s: unknown auth %q (expected oauth or service_account)", where, account.Auth)
+		}
+	}
+
+	if c.Prompts.Examples < 0 {
+		verr.add("prompts.examples must not be negative")
+	}
+	prompts := map[string]bool{}
+	for i, prompt := range c.Prompts.Templates {
+		if prompt.Name == "" {
+			verr.add("prompts.templates[%d]: name is required", i)
+		} else if prompts[prompt.Name] {
+			verr.add("prompts.templates[%d]: duplicate prompt name %q", i, prompt.Name)
+		}
+		prompts[prompt.Name] = true
+	}
+
+	if c.Cache.Enabled {
+		if c.Cache.Path == "" {
+			verr.add("cache.path is required when the cache is enabled")
+		} else if dir := filepath.Dir(c.Cache.Path); !isDir(dir) {
+			verr.add("cache.path %s: directory %s does not exist", c.Cache.Path, dir)
+		}
+	}
+	if ttl, err := time.ParseDuration(c.Cache.TTL); err != nil || ttl < 0 {
+		verr.add("cache.ttl: %q is not a duration such as 720h", c.Cache.TTL)
+	}
+	if c.Cache.MaxEntries < 0 {
+		verr.add("cache.max_entries must not be negative")
+	}
+
+	if c.Usage.DailyBudget < 0 || c.Usage.MonthlyBudget < 0 {
+		verr.add("usage budgets must not be negative")
+	}
+	for i, price := range c.Usage.Prices {
+		if price.Model == "" {
+			verr.add("usage.prices[%d]: model is required", i)
+		}
+		if price.Prompt < 0 || price.Completion < 0 {
+			verr.add("usage.prices[%d]: prices must not be negative", i)
+		}
+	}
+
+	return verr.err()
+}
+
+// CacheTTL returns cache.ttl, which Validate has checked.
+func (c *Config) CacheTTL() time.Duration {
+	ttl, _ := time.ParseDuration(c.Cache.TTL)
+	return ttl
+}
+
+// ValidateGmail checks that the credential files for account exist.
+func (c *Config) ValidateGmail(account Account) error {
+	verr := &ValidationError{}
+
+	switch account.Auth {
+	case "service_account":
+		if !isFile(account.ServiceAccountPath) {
+			verr.add("account %s: service account key %s not found", account.Name, account.ServiceAccountPath)
+		}
+	default:
+		if !isFile(account.ClientSecretPath) {
+			verr.add("account %s: client secret %s not found (download it from the Google Cloud console)",
+				account.Name, account.ClientSecretPath)
+		}
+		if dir := filepath.Dir(account.TokenPath); !isDir(dir) {
+			verr.add("account %s: token path %s: directory %s does not exist", account.Name, account.TokenPath, dir)
+		}
+	}
+
+	return verr.err()
+}
+
+// ValidateLLM checks the settings needed to call the chat model, see LLM.
+func (c *Config) ValidateLLM() error {
+	verr := &ValidationError{}
+	llm := c.ChatLLM()
+
+	switch llm.Provider {
+	case "openai":
+	case "azure":
+		if llm.BaseURL == "" {
+			verr.add("llm.base_url is required for provider azure (https://<resource>.openai.azure.com)")
+		}
+	case "compatible":
+		if llm.BaseURL == "" {
+			verr.add("llm.base_url is required for provider compatible")
+		}
+	default:
+		verr.add("llm.provider: unknown provider %q (expected openai, azure, compatible, ollama, llamacpp or vllm)", c.LLM.Provider)
+	}
+
+	if llm.Provider != "compatible" {
+		key := string(llm.APIKey)
+		if key == "" {
+			verr.add("llm.api_key or openai.api_key is required (set it in the config or via %s)", EnvName("openai.api_key"))
+		} else if strings.HasPrefix(key, "file:") && !isFile(strings.TrimPrefix(key, "file:")) {
+			verr.add("api key: secret file %s not found", strings.TrimPrefix(key, "file:"))
+		}
+	}
+	if llm.Model == "" {
+		verr.add("llm.model is required")
+	}
+
+	return verr.err()
+}
+
+func isKnownLabel(label string) bool {
+	if strings.HasPrefix(label, "CATEGORY_") || strings.HasPrefix(label, "Label_") {
+		return true
+	}
+	for _, known := range knownLabels {
+		if label == known {
+			return true
+		}
+	}
+	return false
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -1,22 +1,60 @@
 package credentials
 
 import (
+	"fmt"
 	"io/ioutil"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+)
+
+const gmailReadonlyScope = "https://www.googleapis.com/auth/gmail.readonly"
+
+// Auth modes an account can be configured with.
+const (
+	AuthOAuth          = "oauth"
+	AuthServiceAccount = "service_account"
 )
 
 func GetGmailCredentials() (*oauth2.Config, error) {
-	b, err := ioutil.ReadFile("./client_secret.json")
+	return GetGmailCredentialsFromFile("./client_secret.json")
+}
+
+// GetGmailCredentialsFromFile loads an installed-app OAuth client secret.
+func GetGmailCredentialsFromFile(path string) (*oauth2.Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := google.ConfigFromJSON(b, gmailReadonlyScope)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// GetServiceAccountCredentials loads a service account key and impersonates
+// subject through Google Workspace domain-wide delegation. The service
+// account's client ID must be granted the Gmail read-only scope in the
+// Workspace admin console.
+func GetServiceAccountCredentials(path string, subject string) (*jwt.Config, error) {
+	if subject == "" {
+		return nil, fmt.Errorf("service account %s: subject to impersonate is required", path)
+	}
+
+	b, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	config, err := google.ConfigFromJSON(b, "https://www.googleapis.com/auth/gmail.readonly")
+	config, err := google.JWTConfigFromJSON(b, gmailReadonlyScope)
 	if err != nil {
 		return nil, err
 	}
+	config.Subject = subject
 
 	return config, nil
 }
@@ -0,0 +1,98 @@
+package credentials
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeServiceAccountKey writes a service account key file, as the Google
+// Cloud console downloads it, with a freshly generated private key.
+func writeServiceAccountKey(t *testing.T, tokenURI string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey failed: %v", err)
+	}
+	data, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "gmail-automation-test",
+		"private_key_id": "key-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "sync@gmail-automation-test.iam.gserviceaccount.com",
+		"client_id":      "1234567890",
+		"token_uri":      tokenURI,
+	})
+	path := filepath.Join(t.TempDir(), "service_account.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGetServiceAccountCredentials(t *testing.T) {
+	// The token endpoint hands out a token for any assertion, keeping the
+	// claims for the test to check.
+	var claims map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		parts := strings.Split(r.Form.Get("assertion"), ".")
+		if len(parts) == 3 {
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			json.Unmarshal(payload, &claims)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "ya29.test", "token_type": "Bearer", "expires_in": 3600}`)
+	}))
+	defer srv.Close()
+	path := writeServiceAccountKey(t, srv.URL)
+
+	config, err := GetServiceAccountCredentials(path, "alice@example.com")
+	if err != nil {
+		t.Fatalf("GetServiceAccountCredentials failed: %v", err)
+	}
+	if config.Subject != "alice@example.com" || config.Email != "sync@gmail-automation-test.iam.gserviceaccount.com" {
+		t.Errorf("Expected to impersonate alice as the service account, got subject %q and email %q", config.Subject, config.Email)
+	}
+	if len(config.Scopes) != 1 || config.Scopes[0] != gmailReadonlyScope {
+		t.Errorf("Expected only the Gmail read-only scope, got %v", config.Scopes)
+	}
+
+	token, err := config.TokenSource(context.Background()).Token()
+	if err != nil || token.AccessToken != "ya29.test" {
+		t.Fatalf("Expected a token, got %v, %v", token, err)
+	}
+	if claims["sub"] != "alice@example.com" || claims["scope"] != gmailReadonlyScope || claims["iss"] != config.Email {
+		t.Errorf("Expected the assertion to carry the subject and scope, got %v", claims)
+	}
+}
+
+func TestGetServiceAccountCredentialsErrors(t *testing.T) {
+	path := writeServiceAccountKey(t, "https://oauth2.googleapis.com/token")
+	if _, err := GetServiceAccountCredentials(path, ""); err == nil || !strings.Contains(err.Error(), "subject") {
+		t.Errorf("Expected a missing subject to be refused, got %v", err)
+	}
+	if _, err := GetServiceAccountCredentials(filepath.Join(t.TempDir(), "missing.json"), "alice@example.com"); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing key file to be reported, got %v", err)
+	}
+
+	secret := filepath.Join(t.TempDir(), "client_secret.json")
+	os.WriteFile(secret, []byte(`{"installed": {"client_id": "x", "client_secret": "y"}}`), 0600)
+	if _, err := GetServiceAccountCredentials(secret, "alice@example.com"); err == nil {
+		t.Error("Expected an OAuth client secret to be refused as a service account key")
+	}
+}
@@ -0,0 +1,130 @@
+// Package dataset exports the stored emails, labelled with what was done
+// with them, as training data: train, valid and test splits in CSV, chat
+// fine-tuning JSONL or Parquet, with a manifest describing them. Read loads
+// a CSV split back for evaluation.
+package dataset
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// Columns lists the feature columns that can be exported, in the order
+// they are written.
+var Columns = []string{"subject", "sender", "domain", "body", "headers"}
+
+// maxBodyRunes bounds the body exported per email.
+const maxBodyRunes = 2000
+
+// ParseColumns parses a comma-separated list of feature columns and
+// returns them in Columns order.
+func ParseColumns(list string) ([]string, error) {
+	wanted := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !containsString(Columns, name) {
+			return nil, fmt.Errorf("unknown column %q: expected %s", name, strings.Join(Columns, ", "))
+		}
+		wanted[name] = true
+	}
+	var columns []string
+	for _, name := range Columns {
+		if wanted[name] {
+			columns = append(columns, name)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns in %q", list)
+	}
+	return columns, nil
+}
+
+// Value returns a feature column of an example. The labels in the headers
+// column leave out those that give the outcome away.
+func Value(column string, example openai.Example) string {
+	switch column {
+	case "subject":
+		return example.Subject
+	case "sender":
+		return sender(example.From)
+	case "domain":
+		address := sender(example.From)
+		if at := strings.LastIndex(address, "@"); at >= 0 {
+			return address[at+1:]
+		}
+		return ""
+	case "body":
+		body := []rune(strings.TrimSpace(example.Body))
+		if len(body) > maxBodyRunes {
+			body = body[:maxBodyRunes]
+		}
+		return string(body)
+	case "headers":
+		var lines []string
+		for _, h := range []struct{ name, value string }{
+			{"To", example.To},
+			{"Cc", example.Cc},
+			{"Reply-To", example.ReplyTo},
+			{"Labels", example.Labels},
+		} {
+			if h.value != "" {
+				lines = append(lines, h.name+": "+h.value)
+			}
+		}
+		if example.HasAttachment {
+			lines = append(lines, "Attachment: yes")
+		}
+		return strings.Join(lines, "\n")
+	}
+	return ""
+}
+
+// Prompt renders the columns of an example as the user message of a chat
+// fine-tuning example: a line per column, the headers as they are, and the
+// body last after a blank line.
+func Prompt(example openai.Example, columns []string) string {
+	var lines []string
+	body := ""
+	for _, column := range columns {
+		value := Value(column, example)
+		switch {
+		case column == "body":
+			body = value
+		case column == "headers":
+			if value != "" {
+				lines = append(lines, value)
+			}
+		default:
+			lines = append(lines, strings.ToUpper(column[:1])+column[1:]+": "+value)
+		}
+	}
+	prompt := strings.Join(lines, "\n")
+	if body != "" {
+		prompt += "\n\n" + body
+	}
+	return prompt
+}
+
+// sender returns the lower-cased address of a From header, or the header
+// itself when it does not parse.
+func sender(from string) string {
+	if list := db.ParseAddressList(from); len(list) > 0 {
+		return strings.ToLower(list[0].Address)
+	}
+	return strings.TrimSpace(from)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
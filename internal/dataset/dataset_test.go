@@ -0,0 +1,284 @@
+package dataset
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// testExamples are 60 trashed and 40 starred emails, one a day from 2023.
+func testExamples() []openai.Example {
+	var emails []db.Email
+	start := time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		email := db.Email{
+			Id:      int64(i + 1),
+			Subject: fmt.Sprintf("Email %d", i),
+			From:    "Shop <Deals@Shop.example>",
+			To:      "me@example.com",
+			Labels:  "INBOX, TRASH",
+			Body:    "Sale",
+			SentAt:  start.AddDate(0, 0, i),
+		}
+		if i%5 >= 3 {
+			email.From = "boss@work.example"
+			email.Labels = "INBOX, STARRED"
+		}
+		emails = append(emails, email)
+	}
+	return openai.Examples(emails)
+}
+
+func countOutcomes(examples []openai.Example) map[openai.Action]int {
+	counts := map[openai.Action]int{}
+	for _, ex := range examples {
+		counts[ex.Outcome]++
+	}
+	return counts
+}
+
+func TestSplit(t *testing.T) {
+	examples := testExamples()
+	ratios := [3]float64{0.7, 0.15, 0.15}
+
+	shuffled, err := Split(examples, SplitOptions{Mode: SplitShuffle, Ratios: ratios, Stratify: true, Seed: 1})
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	for i, want := range []map[openai.Action]int{
+		{openai.ActionTrash: 42, openai.ActionStar: 28},
+		{openai.ActionTrash: 9, openai.ActionStar: 6},
+		{openai.ActionTrash: 9, openai.ActionStar: 6},
+	} {
+		if got := countOutcomes(shuffled[i]); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected %s to hold %v, got %v", Splits[i], want, got)
+		}
+	}
+	again, _ := Split(examples, SplitOptions{Mode: SplitShuffle, Ratios: ratios, Stratify: true, Seed: 1})
+	if fmt.Sprint(again) != fmt.Sprint(shuffled) {
+		t.Error("Expected the same seed to split the same way")
+	}
+
+	byTime, err := Split(examples, SplitOptions{Mode: SplitTime, Ratios: ratios})
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	if len(byTime[0]) != 70 || len(byTime[1]) != 15 || len(byTime[2]) != 15 {
+		t.Fatalf("Expected 70/15/15 examples, got %d/%d/%d", len(byTime[0]), len(byTime[1]), len(byTime[2]))
+	}
+	if last, first := byTime[0][69].SentAt, byTime[1][0].SentAt; !last.Before(first) {
+		t.Errorf("Expected train to end before valid starts, got %s and %s", last, first)
+	}
+	if last, first := byTime[1][14].SentAt, byTime[2][0].SentAt; !last.Before(first) {
+		t.Errorf("Expected valid to end before test starts, got %s and %s", last, first)
+	}
+
+	if _, err := Split(examples, SplitOptions{Mode: "sequential", Ratios: ratios}); err == nil {
+		t.Error("Expected an unknown split to fail")
+	}
+	if _, err := Split(examples, SplitOptions{Mode: SplitTime}); err == nil {
+		t.Error("Expected zero ratios to fail")
+	}
+	for _, r := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := Split(examples, SplitOptions{Mode: SplitTime, Ratios: [3]float64{0.8, r, 0.1}}); err == nil {
+			t.Errorf("Expected a ratio of %g to fail", r)
+		}
+	}
+}
+
+func TestColumns(t *testing.T) {
+	columns, err := ParseColumns("body, Subject,domain")
+	if err != nil || strings.Join(columns, ",") != "subject,domain,body" {
+		t.Errorf("Expected subject,domain,body, got %v, %v", columns, err)
+	}
+	if _, err := ParseColumns("subject,attachments"); err == nil {
+		t.Error("Expected an unknown column to fail")
+	}
+
+	ex := testExamples()[0]
+	if got := Value("sender", ex); got != "deals@shop.example" {
+		t.Errorf("Expected the sender address, got %q", got)
+	}
+	if got := Value("headers", ex); got != "To: me@example.com\nLabels: INBOX" {
+		t.Errorf("Expected headers without the outcome label, got %q", got)
+	}
+	want := "Subject: Email 0\nDomain: shop.example\nTo: me@example.com\nLabels: INBOX\n\nSale"
+	if got := Prompt(ex, []string{"subject", "domain", "body", "headers"}); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestExport(t *testing.T) {
+	opts := Options{
+		Columns: []string{"subject", "sender"},
+		Split:   SplitOptions{Mode: SplitTime, Ratios: [3]float64{0.8, 0.1, 0.1}},
+	}
+	for _, format := range []string{FormatCSV, FormatJSONL, FormatParquet} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			opts.Format = format
+			m, err := Export(dir, testExamples(), opts)
+			if err != nil {
+				t.Fatalf("Export failed: %v", err)
+			}
+			if m.Classes[openai.ActionTrash] != 60 || m.Classes[openai.ActionStar] != 40 || m.Labels[openai.ActionStar] != 1 {
+				t.Errorf("Expected 60 trash and 40 star with star as 1, got %v and %v", m.Classes, m.Labels)
+			}
+			if s := m.Splits[2]; s.File != "test."+format || s.Rows != 10 || !s.First.Equal(time.Date(2023, 4, 1, 9, 0, 0, 0, time.UTC)) {
+				t.Errorf("Expected 10 test rows from 2023-04-01, got %+v", s)
+			}
+			var saved Manifest
+			data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+			if err != nil || json.Unmarshal(data, &saved) != nil || saved.Splits[0].Rows != 80 {
+				t.Errorf("Expected the manifest to be saved, got %s, %v", data, err)
+			}
+
+			data, err = os.ReadFile(filepath.Join(dir, "train."+format))
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch format {
+			case FormatCSV:
+				records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+				if err != nil || len(records) != 81 {
+					t.Fatalf("Expected a header and 80 rows, got %d, %v", len(records), err)
+				}
+				if got := strings.Join(records[0], ","); got != "id,sent_at,subject,sender,label,label_id" {
+					t.Errorf("Unexpected header %s", got)
+				}
+				if got := strings.Join(records[1], ","); got != "1,2023-01-01T09:00:00Z,Email 0,deals@shop.example,trash,0" {
+					t.Errorf("Unexpected first row %s", got)
+				}
+			case FormatJSONL:
+				lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+				var example struct {
+					Messages []struct {
+						Role      string
+						Content   string
+						ToolCalls []struct {
+							Function struct{ Name, Arguments string }
+						} `json:"tool_calls"`
+					}
+					Tools []json.RawMessage
+				}
+				if err := json.Unmarshal([]byte(lines[3]), &example); err != nil || len(lines) != 80 {
+					t.Fatalf("Expected 80 JSON lines, got %d, %v", len(lines), err)
+				}
+				if len(example.Messages) != 3 || example.Messages[1].Content != "Subject: Email 3\nSender: boss@work.example" || len(example.Tools) != 1 {
+					t.Fatalf("Unexpected example %+v", example)
+				}
+				call := example.Messages[2].ToolCalls[0].Function
+				if call.Name != "classify_email" || !strings.Contains(call.Arguments, `"action":"star"`) {
+					t.Errorf("Expected a classify_email call starring, got %+v", call)
+				}
+				// All 32 of the boss's emails in train were starred.
+				var args openai.Classification
+				json.Unmarshal([]byte(call.Arguments), &args)
+				if want := 33.0 / 36; args.Confidence != want {
+					t.Errorf("Expected a confidence of %v from the sender's share, got %v", want, args.Confidence)
+				}
+			case FormatParquet:
+				meta := parquetFooter(t, data)
+				if got := strings.Join(schemaNames(meta), ","); got != "schema,id,sent_at,subject,sender,label,label_id" || meta[3] != int64(80) {
+					t.Errorf("Expected 80 rows of the exported columns, got %s and %v rows", got, meta[3])
+				}
+				if !bytes.Contains(data, []byte("deals@shop.example")) {
+					t.Error("Expected the sender values in the file")
+				}
+			}
+		})
+	}
+}
+
+func TestExportRendersPrompt(t *testing.T) {
+	dir := t.TempDir()
+	examples := testExamples()
+	// Emails 1 to 80 are in train, 81 to 90 in valid and 91 to 100 in test,
+	// where email 95 may draw few-shot examples from 91 to 94 only.
+	var skipped []int64
+	m, err := Export(dir, examples, Options{
+		Format:  FormatJSONL,
+		Columns: []string{"subject"},
+		Split:   SplitOptions{Mode: SplitTime, Ratios: [3]float64{0.8, 0.1, 0.1}},
+		Render: func(ex openai.Example, skip func(db.Email) bool) (string, error) {
+			if ex.Id == 95 {
+				for _, e := range examples {
+					if skip(e.Email) {
+						skipped = append(skipped, e.Id)
+					}
+				}
+			}
+			return "Classify: " + ex.Subject, nil
+		},
+		PromptName: "triage@1234",
+	})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if m.Prompt != "triage@1234" || m.Columns != nil {
+		t.Errorf("Expected the prompt and no columns in the manifest, got %q and %v", m.Prompt, m.Columns)
+	}
+	if len(skipped) != 96 || skipped[89] != 90 || skipped[90] != 95 {
+		t.Errorf("Expected all but emails 91 to 94 to be skipped, got %v", skipped)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "test.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.SplitN(string(data), "\n", 2)[0], `"content":"Classify: Email 90"`) {
+		t.Errorf("Expected the rendered prompt as the user message, got %s", data)
+	}
+}
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	examples := testExamples()
+	examples[3].Cc = "team@work.example"
+	examples[3].HasAttachment = true
+	if _, err := Export(dir, examples, Options{Format: FormatCSV, Columns: Columns, Split: SplitOptions{Mode: SplitTime, Ratios: [3]float64{0.8, 0.1, 0.1}}}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	read, err := Read(filepath.Join(dir, "train.csv"))
+	if err != nil || len(read) != 80 {
+		t.Fatalf("Expected 80 examples, got %d, %v", len(read), err)
+	}
+	ex := read[3]
+	if ex.Id != 4 || ex.Outcome != openai.ActionStar || ex.Subject != "Email 3" || ex.From != "boss@work.example" ||
+		ex.Cc != "team@work.example" || !ex.HasAttachment || ex.Labels != "INBOX" || !ex.SentAt.Equal(examples[3].SentAt) {
+		t.Errorf("Unexpected example %+v", ex)
+	}
+
+	oneHot := filepath.Join(dir, "legacy.csv")
+	os.WriteFile(oneHot, []byte("text,INBOX,STARRED,TRASH,UNREAD\n"+
+		"Sale ends today,1,0,1,0\n"+
+		"\"Standup, notes\",1,1,0,1\n"+
+		"Newsletter,1,0,0,1\n"), 0644)
+	read, err = Read(oneHot)
+	if err != nil || len(read) != 3 {
+		t.Fatalf("Expected 3 examples, got %d, %v", len(read), err)
+	}
+	for i, want := range []openai.Action{openai.ActionTrash, openai.ActionStar, openai.ActionIgnore} {
+		if read[i].Outcome != want || read[i].Labels != "INBOX" || read[i].Id != 0 {
+			t.Errorf("Row %d: expected %s and the INBOX label, got %+v", i+1, want, read[i])
+		}
+	}
+	if read[1].Subject != "Standup, notes" {
+		t.Errorf("Expected the text as the subject, got %q", read[1].Subject)
+	}
+
+	bad := filepath.Join(dir, "bad.csv")
+	os.WriteFile(bad, []byte("id,subject,label\n1,Hi,archive\n"), 0644)
+	if _, err := Read(bad); err == nil || !strings.Contains(err.Error(), "bad.csv:2") {
+		t.Errorf("Expected the unknown label to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package dataset
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// The output formats.
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// ManifestFile is the name of the manifest written next to the splits.
+const ManifestFile = "manifest.json"
+
+// Options say what Export writes.
+type Options struct {
+	Format  string
+	Columns []string
+	Split   SplitOptions
+	// Render renders the user message of each jsonl example. Give it the
+	// prompt template classify renders, so that a fine-tuned model is asked
+	// as it learned; nil renders the columns with Prompt. skip reports the
+	// emails that must not be few-shot examples of ex: those outside its
+	// split and, split by time, those not sent before it.
+	Render func(ex openai.Example, skip func(db.Email) bool) (string, error)
+	// PromptName is the name and version of the template Render renders,
+	// recorded in the manifest.
+	PromptName string
+}
+
+// Manifest describes an exported dataset.
+type Manifest struct {
+	CreatedAt  time.Time  `json:"created_at"`
+	Format     string     `json:"format"`
+	Columns    []string   `json:"columns"`
+	Split      string     `json:"split"`
+	Ratios     [3]float64 `json:"ratios"`
+	Stratified bool       `json:"stratified"`
+	Seed       int64      `json:"seed"`
+	// Labels maps each label to the label_id it is written with.
+	Labels map[openai.Action]int `json:"labels"`
+	// Classes counts the examples of each label over all splits.
+	Classes map[openai.Action]int `json:"classes"`
+	Splits  []SplitManifest       `json:"splits"`
+	// Prompt is the template the jsonl examples are rendered with; empty
+	// when they render the columns.
+	Prompt string `json:"prompt,omitempty"`
+
+	render func(openai.Example, func(db.Email) bool) (string, error)
+}
+
+// SplitManifest describes the file of one split.
+type SplitManifest struct {
+	Name    string                `json:"name"`
+	File    string                `json:"file"`
+	Rows    int                   `json:"rows"`
+	Classes map[openai.Action]int `json:"classes"`
+	// First and Last are when the oldest and newest emails were sent.
+	First *time.Time `json:"first,omitempty"`
+	Last  *time.Time `json:"last,omitempty"`
+}
+
+// Export splits examples and writes a file per split and the manifest to
+// dir, creating it if need be.
+func Export(dir string, examples []openai.Example, opts Options) (*Manifest, error) {
+	if opts.Format != FormatCSV && opts.Format != FormatJSONL && opts.Format != FormatParquet {
+		return nil, fmt.Errorf("unknown format %q: expected %s, %s or %s", opts.Format, FormatCSV, FormatJSONL, FormatParquet)
+	}
+	splits, err := Split(examples, opts.Split)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{
+		CreatedAt:  time.Now().UTC(),
+		Format:     opts.Format,
+		Columns:    opts.Columns,
+		Split:      opts.Split.Mode,
+		Ratios:     opts.Split.Ratios,
+		Stratified: opts.Split.Stratify,
+		Seed:       opts.Split.Seed,
+		Labels:     map[openai.Action]int{},
+		Classes:    map[openai.Action]int{},
+		render:     opts.Render,
+	}
+	if opts.Render != nil && opts.Format == FormatJSONL {
+		// The template, not the columns, makes the examples.
+		m.Prompt, m.Columns = opts.PromptName, nil
+	}
+	for i, action := range openai.Actions {
+		m.Labels[action] = i
+		m.Classes[action] = 0
+	}
+	for i, name := range Splits {
+		s := SplitManifest{Name: name, File: name + "." + opts.Format, Rows: len(splits[i]), Classes: map[openai.Action]int{}}
+		for _, action := range openai.Actions {
+			s.Classes[action] = 0
+		}
+		for _, ex := range splits[i] {
+			s.Classes[ex.Outcome]++
+			m.Classes[ex.Outcome]++
+			if sent := ex.SentAt.UTC(); !ex.SentAt.IsZero() {
+				if s.First == nil || sent.Before(*s.First) {
+					s.First = &sent
+				}
+				if s.Last == nil || sent.After(*s.Last) {
+					s.Last = &sent
+				}
+			}
+		}
+		if err := writeSplit(filepath.Join(dir, s.File), splits[i], m); err != nil {
+			return nil, err
+		}
+		m.Splits = append(m.Splits, s)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return m, os.WriteFile(filepath.Join(dir, ManifestFile), append(data, '\n'), 0644)
+}
+
+func writeSplit(path string, examples []openai.Example, m *Manifest) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	switch m.Format {
+	case FormatCSV:
+		err = writeCSV(w, examples, m)
+	case FormatJSONL:
+		err = writeJSONL(w, examples, m)
+	case FormatParquet:
+		err = writeParquet(w, examples, m)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// writeCSV writes the id, the send time, the columns, the label and its
+// label_id per example, under a header row.
+func writeCSV(w io.Writer, examples []openai.Example, m *Manifest) error {
+	out := csv.NewWriter(w)
+	header := append(append([]string{"id", "sent_at"}, m.Columns...), "label", "label_id")
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	for _, ex := range examples {
+		record := []string{strconv.FormatInt(ex.Id, 10), sentAt(ex)}
+		for _, column := range m.Columns {
+			record = append(record, Value(column, ex))
+		}
+		record = append(record, string(ex.Outcome), strconv.Itoa(m.Labels[ex.Outcome]))
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeJSONL writes a chat fine-tuning example per line, whose confidence
+// is the share of the split's emails from the same sender that had the
+// example's outcome.
+func writeJSONL(w io.Writer, examples []openai.Example, m *Manifest) error {
+	confidences := outcomeShares(examples)
+	split := map[int64]bool{}
+	for _, ex := range examples {
+		split[ex.Id] = true
+	}
+	for i, ex := range examples {
+		prompt := Prompt(ex, m.Columns)
+		if m.render != nil {
+			skip := func(e db.Email) bool {
+				return !split[e.Id] || (m.Split == SplitTime && !e.SentAt.Before(ex.SentAt))
+			}
+			var err error
+			if prompt, err = m.render(ex, skip); err != nil {
+				return err
+			}
+		}
+		line, err := openai.FineTuneExample(prompt, ex.Outcome, confidences[i])
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outcomeShares returns for each example the share of the examples from
+// its sender that had its outcome, smoothed towards an even split between
+// the actions so that a sender seen once is not a certainty.
+func outcomeShares(examples []openai.Example) []float64 {
+	type key struct {
+		sender  string
+		outcome openai.Action
+	}
+	senders, outcomes := map[string]int{}, map[key]int{}
+	for _, ex := range examples {
+		sender := Value("sender", ex)
+		senders[sender]++
+		outcomes[key{sender, ex.Outcome}]++
+	}
+	shares := make([]float64, len(examples))
+	for i, ex := range examples {
+		sender := Value("sender", ex)
+		shares[i] = float64(outcomes[key{sender, ex.Outcome}]+1) / float64(senders[sender]+len(openai.Actions))
+	}
+	return shares
+}
+
+// writeParquet writes the same columns as writeCSV.
+func writeParquet(w io.Writer, examples []openai.Example, m *Manifest) error {
+	columns := []parquetColumn{{"id", parquetInt64}, {"sent_at", parquetByteArray}}
+	for _, column := range m.Columns {
+		columns = append(columns, parquetColumn{column, parquetByteArray})
+	}
+	columns = append(columns, parquetColumn{"label", parquetByteArray}, parquetColumn{"label_id", parquetInt32})
+
+	out := newParquetWriter(w, columns)
+	for _, ex := range examples {
+		row := []interface{}{ex.Id, sentAt(ex)}
+		for _, column := range m.Columns {
+			row = append(row, Value(column, ex))
+		}
+		row = append(row, string(ex.Outcome), int32(m.Labels[ex.Outcome]))
+		if err := out.write(row); err != nil {
+			return err
+		}
+	}
+	return out.close()
+}
+
+// sentAt formats when an example was sent, or nothing when it is unknown.
+func sentAt(ex openai.Example) string {
+	if ex.SentAt.IsZero() {
+		return ""
+	}
+	return ex.SentAt.Format(time.RFC3339)
+}
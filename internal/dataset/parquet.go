@@ -0,0 +1,246 @@
+package dataset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// parquetType is the physical type of a Parquet column. Only what the
+// dataset needs is supported: required INT32, INT64 and UTF-8 BYTE_ARRAY
+// columns, written PLAIN and uncompressed in a single row group.
+type parquetType int32
+
+const (
+	parquetInt32     parquetType = 1
+	parquetInt64     parquetType = 2
+	parquetByteArray parquetType = 6
+)
+
+// Values of the Parquet format's Thrift enums.
+const (
+	parquetRequired     = 0
+	parquetUTF8         = 0
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+)
+
+var parquetMagic = []byte("PAR1")
+
+type parquetColumn struct {
+	name string
+	typ  parquetType
+}
+
+// parquetWriter buffers rows and writes them as a Parquet file on close.
+type parquetWriter struct {
+	w       io.Writer
+	columns []parquetColumn
+	// values holds the PLAIN encoding of each column's values.
+	values []bytes.Buffer
+	rows   int
+}
+
+func newParquetWriter(w io.Writer, columns []parquetColumn) *parquetWriter {
+	return &parquetWriter{w: w, columns: columns, values: make([]bytes.Buffer, len(columns))}
+}
+
+// write adds a row, an int32, int64 or string per column.
+func (p *parquetWriter) write(row []interface{}) error {
+	if len(row) != len(p.columns) {
+		return fmt.Errorf("parquet: %d values for %d columns", len(row), len(p.columns))
+	}
+	for i, v := range row {
+		buf := &p.values[i]
+		switch v := v.(type) {
+		case int32:
+			binary.Write(buf, binary.LittleEndian, v)
+		case int64:
+			binary.Write(buf, binary.LittleEndian, v)
+		case string:
+			binary.Write(buf, binary.LittleEndian, uint32(len(v)))
+			buf.WriteString(v)
+		default:
+			return fmt.Errorf("parquet: column %s: unsupported value %T", p.columns[i].name, v)
+		}
+	}
+	p.rows++
+	return nil
+}
+
+// close writes the file: a data page per column, then the footer.
+func (p *parquetWriter) close() error {
+	var file bytes.Buffer
+	file.Write(parquetMagic)
+
+	type chunk struct{ offset, size int64 }
+	chunks := make([]chunk, len(p.columns))
+	if p.rows > 0 {
+		for i := range p.columns {
+			data := p.values[i].Bytes()
+			var header thriftWriter
+			header.begin()
+			header.i32(1, parquetDataPage)
+			header.i32(2, int32(len(data)))
+			header.i32(3, int32(len(data)))
+			header.structField(5)
+			header.i32(1, int32(p.rows))
+			header.i32(2, parquetPlain)
+			header.i32(3, parquetRLE)
+			header.i32(4, parquetRLE)
+			header.end()
+			header.end()
+
+			chunks[i] = chunk{int64(file.Len()), int64(header.Len() + len(data))}
+			file.Write(header.Bytes())
+			file.Write(data)
+		}
+	}
+
+	var meta thriftWriter
+	meta.begin()
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(p.columns)+1)
+	meta.begin()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(p.columns)))
+	meta.end()
+	for _, c := range p.columns {
+		meta.begin()
+		meta.i32(1, int32(c.typ))
+		meta.i32(3, parquetRequired)
+		meta.binary(4, c.name)
+		if c.typ == parquetByteArray {
+			meta.i32(6, parquetUTF8)
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(p.rows))
+	if p.rows == 0 {
+		meta.list(4, thriftStruct, 0)
+	} else {
+		var total int64
+		for _, c := range chunks {
+			total += c.size
+		}
+		meta.list(4, thriftStruct, 1)
+		meta.begin()
+		meta.list(1, thriftStruct, len(p.columns))
+		for i, c := range p.columns {
+			meta.begin()
+			meta.i64(2, chunks[i].offset)
+			meta.structField(3)
+			meta.i32(1, int32(c.typ))
+			meta.list(2, thriftI32, 2)
+			meta.varint(parquetPlain)
+			meta.varint(parquetRLE)
+			meta.list(3, thriftBinary, 1)
+			meta.bytes(c.name)
+			meta.i32(4, parquetUncompressed)
+			meta.i64(5, int64(p.rows))
+			meta.i64(6, chunks[i].size)
+			meta.i64(7, chunks[i].size)
+			meta.i64(9, chunks[i].offset)
+			meta.end()
+			meta.end()
+		}
+		meta.i64(2, total)
+		meta.i64(3, int64(p.rows))
+		meta.end()
+	}
+	meta.binary(6, "gmail-automation")
+	meta.end()
+
+	file.Write(meta.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(meta.Len()))
+	file.Write(parquetMagic)
+	_, err := p.w.Write(file.Bytes())
+	return err
+}
+
+// Types of the Thrift compact protocol.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Thrift compact protocol structs of the Parquet
+// metadata. Structs are opened with begin, or structField for a field,
+// and closed with end; fields must be written in ascending order.
+type thriftWriter struct {
+	bytes.Buffer
+	// last holds the id of the last field written in each open struct.
+	last []int16
+}
+
+func (t *thriftWriter) begin() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) end() {
+	t.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.bytes(s)
+}
+
+// list starts a list field of n elements, which follow as bare values.
+func (t *thriftWriter) list(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | elem)
+	} else {
+		t.WriteByte(0xf0 | elem)
+		t.uvarint(uint64(n))
+	}
+}
+
+func (t *thriftWriter) bytes(s string) {
+	t.uvarint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+// varint writes a zigzag-encoded integer.
+func (t *thriftWriter) varint(v int64) {
+	t.uvarint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	t.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
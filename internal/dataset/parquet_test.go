@@ -0,0 +1,215 @@
+package dataset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// thriftReader decodes the Thrift compact protocol that thriftWriter
+// writes: a struct becomes a map from field id to value, a list a slice,
+// an integer an int64 and binary a string.
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		panic("thrift: unexpected end of data")
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		panic("thrift: bad varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		n := int(r.uvarint())
+		s := string(r.data[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case thriftList:
+		head := r.byte()
+		n, elem := int(head>>4), head&0x0f
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case thriftStruct:
+		fields := map[int16]interface{}{}
+		var id int16
+		for {
+			head := r.byte()
+			if head == 0 {
+				return fields
+			}
+			if delta := int16(head >> 4); delta != 0 {
+				id += delta
+			} else {
+				id = int16(r.varint())
+			}
+			fields[id] = r.value(head & 0x0f)
+		}
+	}
+	panic(fmt.Sprintf("thrift: unsupported type %d", typ))
+}
+
+// readStruct decodes the struct at offset in data, returning it and where
+// it ends.
+func readStruct(data []byte, offset int) (map[int16]interface{}, int) {
+	r := &thriftReader{data: data, pos: offset}
+	return r.value(thriftStruct).(map[int16]interface{}), r.pos
+}
+
+// parquetFooter checks the ends of a Parquet file and decodes its FileMetaData.
+func parquetFooter(t *testing.T, data []byte) map[int16]interface{} {
+	t.Helper()
+	if len(data) < 12 || !bytes.HasPrefix(data, parquetMagic) || !bytes.HasSuffix(data, parquetMagic) {
+		t.Fatal("Expected the Parquet magic at both ends")
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	start := len(data) - 8 - size
+	meta, end := readStruct(data, start)
+	if end != len(data)-8 {
+		t.Fatalf("Expected the footer to take its %d bytes, it took %d", size, end-start)
+	}
+	return meta
+}
+
+// schemaNames returns the names of the schema elements of a footer.
+func schemaNames(meta map[int16]interface{}) []string {
+	var names []string
+	for _, e := range meta[2].([]interface{}) {
+		names = append(names, e.(map[int16]interface{})[4].(string))
+	}
+	return names
+}
+
+func TestParquetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newParquetWriter(&buf, []parquetColumn{{"id", parquetInt64}, {"subject", parquetByteArray}, {"label_id", parquetInt32}})
+	rows := [][]interface{}{
+		{int64(1), "Weekly deals", int32(0)},
+		{int64(2), "Quarterly plan", int32(1)},
+		{int64(3), "", int32(0)},
+	}
+	for _, row := range rows {
+		if err := w.write(row); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	if err := w.write([]interface{}{int64(4)}); err == nil {
+		t.Error("Expected a short row to fail")
+	}
+	if err := w.close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	meta := parquetFooter(t, data)
+	if meta[1] != int64(1) || meta[3] != int64(3) || meta[6] != "gmail-automation" {
+		t.Errorf("Expected version 1, 3 rows and the writer, got %v, %v and %v", meta[1], meta[3], meta[6])
+	}
+	if got := fmt.Sprint(schemaNames(meta)); got != "[schema id subject label_id]" {
+		t.Errorf("Unexpected schema %s", got)
+	}
+	schema := meta[2].([]interface{})
+	if root := schema[0].(map[int16]interface{}); root[5] != int64(3) {
+		t.Errorf("Expected the root to have 3 children, got %v", root)
+	}
+	for i, want := range []int64{int64(parquetInt64), int64(parquetByteArray), int64(parquetInt32)} {
+		e := schema[i+1].(map[int16]interface{})
+		if e[1] != want || e[3] != int64(parquetRequired) {
+			t.Errorf("Expected %s to be a required column of type %d, got %v", e[4], want, e)
+		}
+	}
+
+	groups := meta[4].([]interface{})
+	if len(groups) != 1 {
+		t.Fatalf("Expected one row group, got %d", len(groups))
+	}
+	group := groups[0].(map[int16]interface{})
+	chunks := group[1].([]interface{})
+	if len(chunks) != 3 || group[3] != int64(3) {
+		t.Fatalf("Expected 3 column chunks of 3 rows, got %v", group)
+	}
+
+	// The chunks follow one another from the magic to the footer, each a
+	// data page header and the PLAIN values it describes.
+	offset, total := int64(len(parquetMagic)), int64(0)
+	var values [][]byte
+	for i, c := range chunks {
+		chunk := c.(map[int16]interface{})
+		cm := chunk[3].(map[int16]interface{})
+		if chunk[2] != offset || cm[9] != offset || cm[5] != int64(3) || cm[4] != int64(parquetUncompressed) {
+			t.Fatalf("Unexpected chunk %d at %d: %v", i, offset, chunk)
+		}
+		if path := cm[3].([]interface{}); len(path) != 1 || path[0] != schemaNames(meta)[i+1] {
+			t.Errorf("Expected chunk %d to be %s, got %v", i, schemaNames(meta)[i+1], path)
+		}
+		size := cm[7].(int64)
+		header, end := readStruct(data, int(offset))
+		page := header[5].(map[int16]interface{})
+		if header[1] != int64(parquetDataPage) || page[1] != int64(3) || page[2] != int64(parquetPlain) {
+			t.Errorf("Unexpected page header %v", header)
+		}
+		if n := header[2].(int64); int64(end)+n != offset+size || header[3] != n {
+			t.Errorf("Expected the page of chunk %d to fill its %d bytes, got %v", i, size, header)
+		}
+		values = append(values, data[end:offset+size])
+		offset += size
+		total += size
+	}
+	if group[2] != total || offset != int64(len(data)-8-int(binary.LittleEndian.Uint32(data[len(data)-8:]))) {
+		t.Errorf("Expected the chunks to end at the footer and total %d bytes, got %v", total, group[2])
+	}
+
+	var ids [3]int64
+	binary.Read(bytes.NewReader(values[0]), binary.LittleEndian, &ids)
+	var labels [3]int32
+	binary.Read(bytes.NewReader(values[2]), binary.LittleEndian, &labels)
+	var subjects []string
+	for rest := values[1]; len(rest) >= 4; {
+		n := binary.LittleEndian.Uint32(rest)
+		subjects = append(subjects, string(rest[4:4+n]))
+		rest = rest[4+n:]
+	}
+	if ids != [3]int64{1, 2, 3} || labels != [3]int32{0, 1, 0} || fmt.Sprintf("%q", subjects) != `["Weekly deals" "Quarterly plan" ""]` {
+		t.Errorf("Unexpected values %v, %q and %v", ids, subjects, labels)
+	}
+}
+
+func TestParquetWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w := newParquetWriter(&buf, []parquetColumn{{"subject", parquetByteArray}})
+	if err := w.close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	meta := parquetFooter(t, buf.Bytes())
+	if meta[3] != int64(0) || len(meta[4].([]interface{})) != 0 || fmt.Sprint(schemaNames(meta)) != "[schema subject]" {
+		t.Errorf("Expected no rows and no row groups, got %v", meta)
+	}
+}
@@ -0,0 +1,135 @@
+package dataset
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// Read reads a labelled split back as examples, in file order. It reads
+// the CSV files Export writes, rebuilding as much of each email as the
+// columns hold, and the older CSV files with a text column and a 0 or 1
+// column per Gmail label, whose text is taken as the subject and whose
+// labels give the outcome.
+func Read(path string) ([]openai.Example, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s: no header row", path)
+	}
+
+	header := map[string]int{}
+	for i, name := range records[0] {
+		header[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	read := readExported
+	if _, ok := header["label"]; !ok {
+		if _, ok := header["text"]; !ok {
+			return nil, fmt.Errorf("%s: expected a label or a text column", path)
+		}
+		read = readOneHot
+	}
+
+	examples := make([]openai.Example, 0, len(records)-1)
+	for i, record := range records[1:] {
+		ex, err := read(records[0], header, record)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+2, err)
+		}
+		examples = append(examples, ex)
+	}
+	return examples, nil
+}
+
+// readExported reads a row written by writeCSV.
+func readExported(names []string, header map[string]int, record []string) (openai.Example, error) {
+	field := func(name string) string {
+		if i, ok := header[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	ex := openai.Example{Outcome: openai.Action(field("label"))}
+	if !containsAction(ex.Outcome) {
+		return ex, fmt.Errorf("unknown label %q", ex.Outcome)
+	}
+	if id := field("id"); id != "" {
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return ex, fmt.Errorf("bad id %q", id)
+		}
+		ex.Id = n
+	}
+	if sent := field("sent_at"); sent != "" {
+		t, err := time.Parse(time.RFC3339, sent)
+		if err != nil {
+			return ex, fmt.Errorf("bad sent_at %q", sent)
+		}
+		ex.SentAt = t
+	}
+	ex.Subject = field("subject")
+	ex.Body = field("body")
+	ex.From = field("sender")
+	if ex.From == "" && field("domain") != "" {
+		ex.From = "@" + field("domain")
+	}
+	for _, line := range strings.Split(field("headers"), "\n") {
+		name, value, _ := strings.Cut(line, ": ")
+		switch name {
+		case "To":
+			ex.To = value
+		case "Cc":
+			ex.Cc = value
+		case "Reply-To":
+			ex.ReplyTo = value
+		case "Labels":
+			ex.Labels = value
+		case "Attachment":
+			ex.HasAttachment = value == "yes"
+		}
+	}
+	return ex, nil
+}
+
+// readOneHot reads a row of the older format: the text, and a column per
+// label set to 1 when the email had it.
+func readOneHot(names []string, header map[string]int, record []string) (openai.Example, error) {
+	var labels []string
+	text := ""
+	for i, value := range record {
+		if i >= len(names) {
+			break
+		}
+		switch {
+		case i == header["text"]:
+			text = value
+		case strings.TrimSpace(value) == "1":
+			labels = append(labels, strings.TrimSpace(names[i]))
+		case strings.TrimSpace(value) != "0" && strings.TrimSpace(value) != "":
+			return openai.Example{}, fmt.Errorf("column %s: expected 0 or 1, got %q", names[i], value)
+		}
+	}
+	return openai.Examples([]db.Email{{Subject: text, Labels: strings.Join(labels, ", ")}})[0], nil
+}
+
+func containsAction(action openai.Action) bool {
+	for _, a := range openai.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
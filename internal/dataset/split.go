@@ -0,0 +1,103 @@
+package dataset
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// Splits names the splits, in the order of SplitOptions.Ratios.
+var Splits = []string{"train", "valid", "test"}
+
+// The ways of splitting.
+const (
+	// SplitShuffle deals the examples out at random.
+	SplitShuffle = "shuffle"
+	// SplitTime puts the oldest examples in train and the newest in test,
+	// so that no split learns from emails newer than those it is tested on.
+	SplitTime = "time"
+)
+
+// SplitOptions say how to split examples.
+type SplitOptions struct {
+	Mode string
+	// Ratios are the shares of train, valid and test; they need not add
+	// up to one.
+	Ratios [3]float64
+	// Stratify splits each class on its own, so that every split has the
+	// classes in the same proportions. With SplitTime it cuts each class
+	// by time rather than all of them at the same dates.
+	Stratify bool
+	Seed     int64
+}
+
+// Split deals examples into the Splits. Each split is in random order for
+// SplitShuffle and oldest first for SplitTime.
+func Split(examples []openai.Example, opts SplitOptions) ([3][]openai.Example, error) {
+	var splits [3][]openai.Example
+	if opts.Mode != SplitShuffle && opts.Mode != SplitTime {
+		return splits, fmt.Errorf("unknown split %q: expected %s or %s", opts.Mode, SplitShuffle, SplitTime)
+	}
+	var sum float64
+	for _, r := range opts.Ratios {
+		if math.IsNaN(r) || math.IsInf(r, 0) {
+			return splits, fmt.Errorf("split ratio %g is not a finite number", r)
+		}
+		if r < 0 {
+			return splits, fmt.Errorf("negative split ratio %g", r)
+		}
+		sum += r
+	}
+	if sum == 0 {
+		return splits, fmt.Errorf("split ratios add up to zero")
+	}
+
+	groups := [][]openai.Example{examples}
+	if opts.Stratify {
+		byClass := map[openai.Action][]openai.Example{}
+		for _, ex := range examples {
+			byClass[ex.Outcome] = append(byClass[ex.Outcome], ex)
+		}
+		groups = nil
+		for _, action := range openai.Actions {
+			groups = append(groups, byClass[action])
+		}
+	}
+
+	rng := rand.New(rand.NewSource(opts.Seed))
+	for _, group := range groups {
+		group = append([]openai.Example(nil), group...)
+		if opts.Mode == SplitShuffle {
+			rng.Shuffle(len(group), func(i, j int) { group[i], group[j] = group[j], group[i] })
+		} else {
+			sortByTime(group)
+		}
+		train := int(math.Round(float64(len(group)) * opts.Ratios[0] / sum))
+		valid := int(math.Round(float64(len(group)) * (opts.Ratios[0] + opts.Ratios[1]) / sum))
+		splits[0] = append(splits[0], group[:train]...)
+		splits[1] = append(splits[1], group[train:valid]...)
+		splits[2] = append(splits[2], group[valid:]...)
+	}
+
+	for i := range splits {
+		if opts.Mode == SplitShuffle {
+			rng.Shuffle(len(splits[i]), func(a, b int) { splits[i][a], splits[i][b] = splits[i][b], splits[i][a] })
+		} else {
+			sortByTime(splits[i])
+		}
+	}
+	return splits, nil
+}
+
+// sortByTime orders examples oldest first, by id for equal times.
+func sortByTime(examples []openai.Example) {
+	sort.SliceStable(examples, func(i, j int) bool {
+		if !examples[i].SentAt.Equal(examples[j].SentAt) {
+			return examples[i].SentAt.Before(examples[j].SentAt)
+		}
+		return examples[i].Id < examples[j].Id
+	})
+}
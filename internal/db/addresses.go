@@ -0,0 +1,160 @@
+package db
+
+import (
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// AddressRole is the header an address appeared in.
+type AddressRole string
+
+const (
+	RoleFrom    AddressRole = "from"
+	RoleTo      AddressRole = "to"
+	RoleCc      AddressRole = "cc"
+	RoleBcc     AddressRole = "bcc"
+	RoleReplyTo AddressRole = "reply-to"
+)
+
+// EmailAddress is one parsed address of an email. Address is lower-cased
+// so that it identifies a contact; Name is the decoded display name.
+type EmailAddress struct {
+	Role    AddressRole
+	Name    string
+	Address string
+}
+
+// wordDecoder decodes RFC 2047 encoded words in any charset x/text knows,
+// not only the UTF-8 and Latin-1 that mime handles itself.
+var wordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	},
+}
+
+var addressParser = &mail.AddressParser{WordDecoder: wordDecoder}
+
+// ParseAddressList parses an address header such as From or To, including
+// groups and encoded words. Headers that are not valid RFC 5322 are split on
+// commas and each part parsed on its own; parts without an address are
+// dropped.
+func ParseAddressList(header string) []*mail.Address {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return nil
+	}
+	if list, err := addressParser.ParseList(header); err == nil {
+		return list
+	}
+
+	var list []*mail.Address
+	for _, part := range splitAddresses(header) {
+		if addr, err := addressParser.Parse(part); err == nil {
+			list = append(list, addr)
+		} else if addr := looseAddress(part); addr != nil {
+			list = append(list, addr)
+		}
+	}
+	return list
+}
+
+// splitAddresses splits a header on the commas that are outside quotes
+// and angle brackets.
+func splitAddresses(header string) []string {
+	var parts []string
+	var quoted bool
+	var depth, start int
+	for i, r := range header {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '<':
+			depth++
+		case r == '>' && depth > 0:
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, header[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, header[start:])
+
+	trimmed := parts[:0]
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			trimmed = append(trimmed, part)
+		}
+	}
+	return trimmed
+}
+
+// looseAddress salvages "Name <user@host>" or a bare user@host from text
+// net/mail rejects, such as unquoted specials in the display name.
+func looseAddress(part string) *mail.Address {
+	name := ""
+	address := part
+	if open := strings.LastIndex(part, "<"); open >= 0 {
+		end := strings.Index(part[open:], ">")
+		if end < 0 {
+			return nil
+		}
+		name = strings.Trim(strings.TrimSpace(part[:open]), `"`)
+		address = part[open+1 : open+end]
+	}
+	address = strings.TrimSpace(address)
+	if at := strings.LastIndex(address, "@"); at <= 0 || at == len(address)-1 || strings.ContainsAny(address, " <>,") {
+		return nil
+	}
+	if decoded, err := wordDecoder.DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	return &mail.Address{Name: name, Address: address}
+}
+
+// addresses returns the parsed addresses of every address header of e,
+// each address once per role.
+func (e *Email) addresses() []EmailAddress {
+	headers := []struct {
+		role   AddressRole
+		header string
+	}{
+		{RoleFrom, e.From},
+		{RoleTo, e.To},
+		{RoleCc, e.Cc},
+		{RoleBcc, e.Bcc},
+		{RoleReplyTo, e.ReplyTo},
+	}
+
+	var addresses []EmailAddress
+	seen := map[EmailAddress]bool{}
+	for _, h := range headers {
+		for _, addr := range ParseAddressList(h.header) {
+			key := EmailAddress{Role: h.role, Address: strings.ToLower(addr.Address)}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			addresses = append(addresses, EmailAddress{Role: h.role, Name: strings.TrimSpace(addr.Name), Address: key.Address})
+		}
+	}
+	return addresses
+}
+
+// DecodeHeader decodes the RFC 2047 encoded words in a header value. A
+// value that does not decode is returned as it is.
+func DecodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseAddressList(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{``, ``},
+		{`alice@example.com`, `"" <alice@example.com>`},
+		{`Nikhil Krishnan from OutOfPocket <nikhil@outofpocket.health>`, `"Nikhil Krishnan from OutOfPocket" <nikhil@outofpocket.health>`},
+		{`"Smith, John" <john@example.com>, bob@example.com`, `"Smith, John" <john@example.com>|"" <bob@example.com>`},
+		{`=?UTF-8?B?w4lsb2TDrWU=?= <elodie@example.com>`, `"Élodíe" <elodie@example.com>`},
+		{`=?ISO-8859-1?Q?J=F6rg?= <jorg@example.com>`, `"Jörg" <jorg@example.com>`},
+		{`=?ISO-2022-JP?B?GyRCJUYlOSVIGyhC?= <jp@example.com>`, `"テスト" <jp@example.com>`},
+		{`undisclosed-recipients:;`, ``},
+		{`Team: a@example.com, b@example.com;, c@example.com`, `"" <a@example.com>|"" <b@example.com>|"" <c@example.com>`},
+		{`John Smith <js@example.com> (work)`, `"John Smith" <js@example.com>`},
+		// Not RFC 5322, but seen in the wild.
+		{`Acme [Billing] <billing@acme.com>, not an address`, `"Acme [Billing]" <billing@acme.com>`},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, addr := range ParseAddressList(tt.header) {
+			got = append(got, fmt.Sprintf("%q <%s>", addr.Name, addr.Address))
+		}
+		if strings.Join(got, "|") != tt.want {
+			t.Errorf("ParseAddressList(%q) = %s, want %s", tt.header, strings.Join(got, "|"), tt.want)
+		}
+	}
+}
+
+func TestEmailAddresses(t *testing.T) {
+	email := Email{
+		From:    "Alice <ALICE@example.com>",
+		To:      "bob@example.com, Alice <alice@example.com>, bob@example.com",
+		Cc:      "carol@example.com",
+		ReplyTo: "noreply@example.com",
+	}
+	var got []string
+	for _, addr := range email.addresses() {
+		got = append(got, fmt.Sprintf("%s:%s", addr.Role, addr.Address))
+	}
+	want := "from:alice@example.com|to:bob@example.com|to:alice@example.com|cc:carol@example.com|reply-to:noreply@example.com"
+	if strings.Join(got, "|") != want {
+		t.Errorf("addresses() = %s, want %s", strings.Join(got, "|"), want)
+	}
+}
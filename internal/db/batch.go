@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// RowStatus is what StoreEmails did with one email.
+type RowStatus string
+
+const (
+	RowInserted RowStatus = "inserted"
+	RowUpdated  RowStatus = "updated"
+	RowSkipped  RowStatus = "skipped"
+)
+
+// RowResult is the outcome for one email passed to StoreEmails.
+type RowResult struct {
+	// Index is the position of the email in the input.
+	Index   int
+	Subject string
+	Status  RowStatus
+	// Err is why the row was skipped.
+	Err error
+}
+
+// BatchResult reports the outcome of StoreEmails, row by row in input
+// order, with totals.
+type BatchResult struct {
+	Rows     []RowResult
+	Inserted int
+	Updated  int
+	Skipped  int
+}
+
+// Stored is the number of rows inserted or updated.
+func (r BatchResult) Stored() int64 {
+	return int64(r.Inserted + r.Updated)
+}
+
+func (r *BatchResult) add(row RowResult) {
+	r.Rows = append(r.Rows, row)
+	switch row.Status {
+	case RowInserted:
+		r.Inserted++
+	case RowUpdated:
+		r.Updated++
+	case RowSkipped:
+		r.Skipped++
+	}
+}
+
+// pendingRow is an email whose sent date parsed, waiting to be written.
+type pendingRow struct {
+	index    int
+	email    Email
+	sentDate time.Time
+}
+
+// emailKey is the unique key of the emails tables, with the sent date as
+// SQLite stores it.
+type emailKey struct {
+	subject, from, to, sentDate string
+}
+
+func (r pendingRow) key() emailKey {
+	return emailKey{r.email.Subject, r.email.From, r.email.To, r.sentDate.Format(sentDateLayout)}
+}
+
+// trashedEmails returns copies of emails marked as trashed.
+func trashedEmails(emails []Email) []Email {
+	trashed := make([]Email, len(emails))
+	for i, email := range emails {
+		email.State = StateTrashed
+		email.Deleted = true
+		trashed[i] = email
+	}
+	return trashed
+}
+
+// emailChan returns a closed channel holding emails, for feeding a slice
+// to StoreEmails.
+func emailChan(emails []Email) <-chan Email {
+	ch := make(chan Email, len(emails))
+	for _, email := range emails {
+		ch <- email
+	}
+	close(ch)
+	return ch
+}
+
+// readBatches drains emails, skipping those whose sent date does not parse
+// and handing the rest to write in chunks of up to chunkRows. It stops with
+// ctx.Err() if ctx is cancelled first.
+func readBatches(ctx context.Context, emails <-chan Email, chunkRows int, result *BatchResult, write func([]pendingRow) error) error {
+	var chunk []pendingRow
+	for index := 0; ; index++ {
+		var email Email
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case email, ok = <-emails:
+		}
+		if !ok {
+			break
+		}
+
+		sentDate, err := email.sentTime()
+		if err != nil {
+			result.add(RowResult{Index: index, Subject: email.Subject, Status: RowSkipped, Err: err})
+			continue
+		}
+
+		chunk = append(chunk, pendingRow{index: index, email: email, sentDate: sentDate})
+		if len(chunk) == chunkRows {
+			if err := write(chunk); err != nil {
+				return err
+			}
+			chunk = chunk[:0]
+		}
+	}
+
+	if len(chunk) > 0 {
+		if err := write(chunk); err != nil {
+			return err
+		}
+	}
+
+	sort.SliceStable(result.Rows, func(i, j int) bool { return result.Rows[i].Index < result.Rows[j].Index })
+	return nil
+}
+
+// logSkipped logs the emails a batch skipped, for callers that only
+// report a count.
+func logSkipped(result BatchResult) {
+	for _, row := range result.Rows {
+		if row.Status == RowSkipped {
+			log.Printf("Skipped email %d (%q): %v", row.Index, row.Subject, row.Err)
+		}
+	}
+}
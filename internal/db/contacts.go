@@ -0,0 +1,231 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Contact is a correspondent with totals over the emails they appear in.
+type Contact struct {
+	ID      int64
+	Address string
+	Name    string
+
+	// Received counts emails from the contact, Addressed those to, cc or
+	// bcc them.
+	Received  int
+	Addressed int
+	// Read and Trashed count the emails from the contact that were read
+	// or are in the trash.
+	Read    int
+	Trashed int
+	// LastContact is when the latest email from or to the contact was sent.
+	LastContact time.Time
+}
+
+// ContactSort orders ListContacts.
+type ContactSort string
+
+const (
+	ContactsByEmails  ContactSort = "emails"
+	ContactsByRecent  ContactSort = "recent"
+	ContactsByTrashed ContactSort = "trashed"
+)
+
+// ContactOptions controls ListContacts. A zero Limit returns up to 50
+// contacts; Match keeps those whose address or name contains it.
+type ContactOptions struct {
+	Sort  ContactSort
+	Limit int
+	Match string
+}
+
+const (
+	clearAddressesQuery = `DELETE FROM email_addresses WHERE email_id = $1`
+	upsertContactQuery  = `INSERT INTO contacts (address, "name") VALUES ($1, $2)
+		ON CONFLICT (address) DO UPDATE SET "name" = CASE WHEN excluded."name" <> '' THEN excluded."name" ELSE contacts."name" END
+		RETURNING id`
+	linkAddressQuery = `INSERT INTO email_addresses (email_id, contact_id, "role") VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`
+)
+
+// addressWriter replaces the email_addresses rows of stored emails. Its
+// queries are valid in both SQLite and PostgreSQL.
+type addressWriter struct {
+	clear, contact, link *sql.Stmt
+}
+
+func newAddressWriter(ctx context.Context, tx *sql.Tx) (*addressWriter, error) {
+	w := &addressWriter{}
+	for _, s := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&w.clear, clearAddressesQuery},
+		{&w.contact, upsertContactQuery},
+		{&w.link, linkAddressQuery},
+	} {
+		stmt, err := tx.PrepareContext(ctx, s.query)
+		if err != nil {
+			w.close()
+			return nil, err
+		}
+		*s.stmt = stmt
+	}
+	return w, nil
+}
+
+func (w *addressWriter) close() {
+	for _, stmt := range []*sql.Stmt{w.clear, w.contact, w.link} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// write replaces the addresses of the email stored as emailID with those
+// parsed from email's headers.
+func (w *addressWriter) write(ctx context.Context, emailID int64, email *Email) error {
+	if _, err := w.clear.ExecContext(ctx, emailID); err != nil {
+		return err
+	}
+	for _, addr := range email.addresses() {
+		var contactID int64
+		if err := w.contact.QueryRowContext(ctx, addr.Address, addr.Name).Scan(&contactID); err != nil {
+			return err
+		}
+		if _, err := w.link.ExecContext(ctx, emailID, contactID, string(addr.Role)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildAddresses re-parses the address headers of every stored email, for
+// emails stored before contacts were tracked. It returns the number of
+// emails indexed.
+func rebuildAddresses(ctx context.Context, db *sql.DB) (int, error) {
+	// Read everything first: PostgreSQL cannot run statements on a
+	// connection while a result set is open.
+	rows, err := db.QueryContext(ctx, `SELECT id, "from", "to", cc, bcc, reply_to FROM emails`)
+	if err != nil {
+		return 0, err
+	}
+	type headers struct {
+		id    int64
+		email Email
+	}
+	var all []headers
+	for rows.Next() {
+		var h headers
+		var from, to, cc, bcc sql.NullString
+		if err := rows.Scan(&h.id, &from, &to, &cc, &bcc, &h.email.ReplyTo); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		h.email.From, h.email.To, h.email.Cc, h.email.Bcc = from.String, to.String, cc.String, bcc.String
+		all = append(all, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	w, err := newAddressWriter(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	defer w.close()
+
+	for _, h := range all {
+		if err := w.write(ctx, h.id, &h.email); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(all), nil
+}
+
+// contactsDialect holds the SQL that differs between drivers in
+// contactsQuery.
+type contactsDialect struct {
+	// lastContact is the latest sent time of e as seconds since the epoch.
+	lastContact string
+	like        string
+}
+
+// contactsQuery lists contacts with their totals. Placeholders are $N,
+// which both drivers accept.
+func contactsQuery(d contactsDialect, opts ContactOptions) (string, []interface{}, error) {
+	order := map[ContactSort]string{
+		"":                "received + addressed DESC, last_contact DESC",
+		ContactsByEmails:  "received + addressed DESC, last_contact DESC",
+		ContactsByRecent:  "last_contact DESC",
+		ContactsByTrashed: "trashed DESC, received DESC",
+	}[opts.Sort]
+	if order == "" {
+		return "", nil, fmt.Errorf("unknown contact sort %q: expected emails, recent or trashed", opts.Sort)
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 50
+	}
+
+	var args postgresArgs
+	where := "TRUE"
+	if opts.Match != "" {
+		pattern := args.add("%" + escapeLike(strings.ToLower(opts.Match)) + "%")
+		where = fmt.Sprintf(`(c.address %[1]s %[2]s ESCAPE '\' OR c."name" %[1]s %[2]s ESCAPE '\')`, d.like, pattern)
+	}
+
+	query := fmt.Sprintf(`SELECT c.id, c.address, c."name",
+			COUNT(DISTINCT CASE WHEN a."role" = 'from' THEN e.id END) AS received,
+			COUNT(DISTINCT CASE WHEN a."role" IN ('to', 'cc', 'bcc') THEN e.id END) AS addressed,
+			COUNT(DISTINCT CASE WHEN a."role" = 'from' AND e."read" THEN e.id END) AS "read",
+			COUNT(DISTINCT CASE WHEN a."role" = 'from' AND e."state" = 'trashed' THEN e.id END) AS trashed,
+			MAX(%[1]s) AS last_contact
+		FROM contacts AS c
+		JOIN email_addresses AS a ON a.contact_id = c.id
+		JOIN emails AS e ON e.id = a.email_id
+		WHERE %[2]s
+		GROUP BY c.id, c.address, c."name"
+		ORDER BY %[3]s, c.address
+		LIMIT %[4]s`, d.lastContact, where, order, args.add(opts.Limit))
+	return query, args, nil
+}
+
+func listContacts(ctx context.Context, db *sql.DB, d contactsDialect, opts ContactOptions) ([]Contact, error) {
+	query, args, err := contactsQuery(d, opts)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []Contact
+	for rows.Next() {
+		var c Contact
+		var last sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.Address, &c.Name, &c.Received, &c.Addressed, &c.Read, &c.Trashed, &last); err != nil {
+			return nil, err
+		}
+		if last.Valid {
+			c.LastContact = time.Unix(last.Int64, 0).UTC()
+		}
+		contacts = append(contacts, c)
+	}
+	return contacts, rows.Err()
+}
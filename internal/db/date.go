@@ -0,0 +1,290 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sentDateLayout is how the "sentDate" key column stores the sender's wall
+// clock time. The instant itself is kept in sent_at, with the sender's
+// offset in sent_offset.
+const sentDateLayout = "2006-01-02 15:04:05"
+
+// isoLayouts are accepted besides RFC 5322 so that values already
+// normalized, or written by other tools, parse too.
+var isoLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	sentDateLayout,
+	"2006-01-02",
+}
+
+// zoneOffsets are the named zones of RFC 5322's obsolete syntax plus a few
+// that real mailers still send, in seconds east of UTC.
+var zoneOffsets = map[string]int{
+	"UT": 0, "UTC": 0, "GMT": 0, "Z": 0,
+	"EST": -5 * 3600, "EDT": -4 * 3600,
+	"CST": -6 * 3600, "CDT": -5 * 3600,
+	"MST": -7 * 3600, "MDT": -6 * 3600,
+	"PST": -8 * 3600, "PDT": -7 * 3600,
+	"BST": 1 * 3600, "CET": 1 * 3600, "CEST": 2 * 3600,
+}
+
+var monthNames = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April,
+	"may": time.May, "jun": time.June, "jul": time.July, "aug": time.August,
+	"sep": time.September, "oct": time.October, "nov": time.November, "dec": time.December,
+}
+
+var dayNames = map[string]bool{
+	"mon": true, "tue": true, "wed": true, "thu": true, "fri": true, "sat": true, "sun": true,
+}
+
+func parseSentDate(dateStr string) (string, error) {
+	t, err := parseSentTime(dateStr)
+	if err != nil {
+		return "", err
+	}
+
+	return t.Format(sentDateLayout), nil
+}
+
+// parseSentTime parses a Date header. It accepts RFC 5322 dates including
+// the obsolete syntax (comments, two-digit years, named and military zones,
+// missing seconds or day of week) as well as ISO 8601. The result keeps the
+// sender's offset.
+func parseSentTime(dateStr string) (time.Time, error) {
+	value := strings.TrimSpace(dateStr)
+	for _, layout := range isoLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	t, ok := parseRFC5322Date(value)
+	if !ok {
+		return time.Time{}, &DateParseError{Value: dateStr}
+	}
+	return t, nil
+}
+
+// sentTime is when email was sent: its Date header, or Gmail's
+// internalDate if the header is missing or unparsable.
+func (e *Email) sentTime() (time.Time, error) {
+	t, err := parseSentTime(e.SentDate)
+	if err != nil && e.InternalDate > 0 {
+		return time.UnixMilli(e.InternalDate).UTC(), nil
+	}
+	return t, err
+}
+
+// sentOffset is t's offset from UTC in minutes.
+func sentOffset(t time.Time) int {
+	_, offset := t.Zone()
+	return offset / 60
+}
+
+// sentAt rebuilds a sent time from the stored epoch and offset.
+func sentAt(epoch int64, offset int) time.Time {
+	if offset == 0 {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Unix(epoch, 0).In(time.FixedZone("", offset*60))
+}
+
+// parseRFC5322Date parses the date-time of RFC 5322 section 3.3 and the
+// obsolete forms of section 4.3. Fields are recognized by shape rather than
+// position, which also covers asctime-style dates ("Mon Jan  2 15:04:05
+// 2006"). A missing or unknown zone is read as UTC, as RFC 5322 says to do
+// for "-0000" and military zones.
+func parseRFC5322Date(value string) (time.Time, bool) {
+	var (
+		day, year, hour, min, sec = -1, -1, -1, -1, 0
+		month                     time.Month
+		offset                    int
+		haveZone                  bool
+	)
+
+	for _, field := range strings.Fields(strings.ReplaceAll(stripComments(value), ",", " ")) {
+		lower := strings.ToLower(field)
+		switch {
+		case strings.Contains(field, ":") && hour < 0 && !isZone(field):
+			var ok bool
+			if hour, min, sec, ok = parseClock(field); !ok {
+				return time.Time{}, false
+			}
+
+		case isZone(field):
+			if haveZone {
+				// "-0400 (EDT)" style; the first zone wins.
+				continue
+			}
+			zone, ok := parseZone(field)
+			if !ok {
+				return time.Time{}, false
+			}
+			offset, haveZone = zone, true
+
+		case len(lower) >= 3 && monthNames[lower[:3]] != 0 && month == 0 && isLetters(lower):
+			month = monthNames[lower[:3]]
+
+		case len(lower) >= 3 && dayNames[lower[:3]] && isLetters(lower):
+			// The day of week is redundant.
+
+		case isDigits(field):
+			n, _ := strconv.Atoi(field)
+			switch {
+			case len(field) <= 2 && day < 0:
+				day = n
+			case year < 0:
+				year = obsoleteYear(n, len(field))
+			default:
+				return time.Time{}, false
+			}
+
+		case isLetters(field) && hour >= 0 && len(field) <= 5:
+			// A military or unknown zone name. RFC 5322 says to read these
+			// as -0000; a numeric zone next to it still applies.
+
+		default:
+			return time.Time{}, false
+		}
+	}
+
+	if day < 1 || month == 0 || year < 0 || hour < 0 {
+		return time.Time{}, false
+	}
+	loc := time.UTC
+	if offset != 0 {
+		loc = time.FixedZone("", offset)
+	}
+	t := time.Date(year, month, day, hour, min, sec, 0, loc)
+	if t.Day() != day || t.Month() != month {
+		// time.Date normalizes 31 Apr to 1 May; reject it instead.
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// stripComments removes RFC 5322 comments, which may nest.
+func stripComments(value string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range value {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+			b.WriteByte(' ')
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// parseClock parses hh:mm[:ss[.fraction]]. A leap second reads as :59.
+func parseClock(field string) (hour, min, sec int, ok bool) {
+	parts := strings.Split(field, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, 0, 0, false
+	}
+	if len(parts) == 3 {
+		parts[2], _, _ = strings.Cut(parts[2], ".")
+	}
+	var values [3]int
+	for i, part := range parts {
+		if part == "" || len(part) > 2 || !isDigits(part) {
+			return 0, 0, 0, false
+		}
+		values[i], _ = strconv.Atoi(part)
+	}
+	hour, min, sec = values[0], values[1], values[2]
+	if hour > 23 || min > 59 || sec > 60 {
+		return 0, 0, 0, false
+	}
+	if sec == 60 {
+		sec = 59
+	}
+	return hour, min, sec, true
+}
+
+// isZone reports whether field is a numeric zone (+0200, -04:00) or a
+// named one, optionally followed by an offset as in "GMT+0100".
+func isZone(field string) bool {
+	if field[0] == '+' || field[0] == '-' {
+		return true
+	}
+	name := strings.ToUpper(strings.TrimLeft(field, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"))
+	prefix := strings.ToUpper(field[:len(field)-len(name)])
+	_, known := zoneOffsets[prefix]
+	return known && (name == "" || name[0] == '+' || name[0] == '-')
+}
+
+// parseZone returns the offset of a zone accepted by isZone, in seconds.
+func parseZone(field string) (int, bool) {
+	rest := strings.TrimLeft(field, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz")
+	base := zoneOffsets[strings.ToUpper(field[:len(field)-len(rest)])]
+	if rest == "" {
+		return base, true
+	}
+
+	sign := 1
+	if rest[0] == '-' {
+		sign = -1
+	}
+	digits := strings.ReplaceAll(rest[1:], ":", "")
+	if !isDigits(digits) {
+		return 0, false
+	}
+	switch len(digits) {
+	case 1:
+		digits = "0" + digits + "00"
+	case 2:
+		digits += "00"
+	case 4:
+	default:
+		return 0, false
+	}
+	hours, _ := strconv.Atoi(digits[:2])
+	minutes, _ := strconv.Atoi(digits[2:])
+	if hours > 23 || minutes > 59 {
+		return 0, false
+	}
+	return base + sign*(hours*3600+minutes*60), true
+}
+
+// obsoleteYear expands the two- and three-digit years RFC 5322 section
+// 4.3 allows.
+func obsoleteYear(year, digits int) int {
+	switch {
+	case digits <= 2 && year < 50:
+		return year + 2000
+	case digits <= 3:
+		return year + 1900
+	}
+	return year
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isLetters(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
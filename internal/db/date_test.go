@@ -0,0 +1,82 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseSentTime(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   string // RFC 3339 in the sender's offset
+		offset int    // minutes east of UTC
+	}{
+		{"Mon, 03 Apr 2023 18:15:16 +0000", "2023-04-03T18:15:16Z", 0},
+		{"Sat, 1 Apr 2023 12:37:18 +0000", "2023-04-01T12:37:18Z", 0},
+		{"Mon, 3 Apr 2023 12:17:07 -0400 (EDT)", "2023-04-03T12:17:07-04:00", -240},
+		{"Tue, 4 Apr 2023 00:17:49 +0000 (UTC)", "2023-04-04T00:17:49Z", 0},
+		{"3 Apr 2023 01:14:34 -0500", "2023-04-03T01:14:34-05:00", -300},
+		{"Thu, 6 Apr 2023 09:05 +0530", "2023-04-06T09:05:00+05:30", 330},
+		{"Thu,6 Apr 2023 09:05:00 +0530", "2023-04-06T09:05:00+05:30", 330},
+		{"  Fri,  07  April  2023  23:59:60  +0100  ", "2023-04-07T23:59:59+01:00", 60},
+		{"Mon, 03 Apr 23 18:15:16 GMT", "2023-04-03T18:15:16Z", 0},
+		{"Mon, 03 Apr 99 18:15:16 EST", "1999-04-03T18:15:16-05:00", -300},
+		{"Mon, 03 Apr 123 18:15:16 PDT", "2023-04-03T18:15:16-07:00", -420},
+		{"Mon, 03 Apr 2023 18:15:16 UT", "2023-04-03T18:15:16Z", 0},
+		{"Mon, 03 Apr 2023 18:15:16 Z", "2023-04-03T18:15:16Z", 0},
+		{"Mon, 03 Apr 2023 18:15:16 A", "2023-04-03T18:15:16Z", 0},
+		{"Mon, 03 Apr 2023 18:15:16 +0900 (JST)", "2023-04-03T18:15:16+09:00", 540},
+		{"Mon, 03 Apr 2023 18:15:16 GMT+02:00", "2023-04-03T18:15:16+02:00", 120},
+		{"Mon, 03 Apr 2023 18:15:16 (a (nested) comment) -0700", "2023-04-03T18:15:16-07:00", -420},
+		{"Mon, 03 Apr 2023 18:15:16", "2023-04-03T18:15:16Z", 0},
+		{"Mon Apr  3 18:15:16 2023", "2023-04-03T18:15:16Z", 0},
+		{"2023-04-03T08:58:29-04:00", "2023-04-03T08:58:29-04:00", -240},
+		{"2023-04-03T08:00:00Z", "2023-04-03T08:00:00Z", 0},
+		{"2023-04-03 18:15:16", "2023-04-03T18:15:16Z", 0},
+	}
+	for _, tt := range tests {
+		got, err := parseSentTime(tt.value)
+		if err != nil {
+			t.Errorf("parseSentTime(%q): %v", tt.value, err)
+			continue
+		}
+		if got.Format(time.RFC3339) != tt.want || sentOffset(got) != tt.offset {
+			t.Errorf("parseSentTime(%q) = %s (offset %d), want %s (offset %d)",
+				tt.value, got.Format(time.RFC3339), sentOffset(got), tt.want, tt.offset)
+		}
+	}
+
+	for _, value := range []string{
+		"",
+		"not a date",
+		"Mon, 31 Apr 2023 18:15:16 +0000",
+		"Mon, 03 Apr 2023 25:15:16 +0000",
+		"Mon, 03 Apr 2023 18:15:16 +2500",
+		"Mon, 03 Foo 2023 18:15:16 +0000",
+		"Mon, 03 Apr 2023",
+	} {
+		if got, err := parseSentTime(value); !errors.Is(err, ErrDateParse) {
+			t.Errorf("parseSentTime(%q) = %v, %v; want ErrDateParse", value, got, err)
+		}
+	}
+}
+
+func TestSentTimeFallsBackToInternalDate(t *testing.T) {
+	internal := time.Date(2023, 4, 3, 18, 15, 16, 0, time.UTC)
+	email := Email{SentDate: "garbage", InternalDate: internal.UnixMilli()}
+	got, err := email.sentTime()
+	if err != nil || !got.Equal(internal) {
+		t.Errorf("Expected internalDate %s, got %s, %v", internal, got, err)
+	}
+
+	// A parsable header wins.
+	email.SentDate = "Mon, 03 Apr 2023 12:00:00 -0400"
+	if got, err := email.sentTime(); err != nil || got.Hour() != 12 || sentOffset(got) != -240 {
+		t.Errorf("Expected the Date header to be used, got %s, %v", got, err)
+	}
+
+	if _, err := (&Email{SentDate: "garbage"}).sentTime(); !errors.Is(err, ErrDateParse) {
+		t.Errorf("Expected ErrDateParse without internalDate, got %v", err)
+	}
+}
@@ -1,5 +1,11 @@
 package db
 
+import (
+	"context"
+	"strings"
+	"time"
+)
+
 type Email struct {
 	Id        int64
 	Subject   string
@@ -7,6 +13,7 @@ type Email struct {
 	To        string
 	Cc        string
 	Bcc       string
+	ReplyTo   string
 	SentDate  string
 	Body      string
 	Sender    string
@@ -14,21 +21,114 @@ type Email struct {
 	Read      bool
 	Deleted   bool
 	CreatedAt string
+
+	// Size is Gmail's size estimate in bytes.
+	Size          int64
+	HasAttachment bool
+
+	// State is derived from Deleted and Labels when stored empty.
+	State       EmailState
+	TrashedAt   string
+	FirstSeenAt string
+
+	// InternalDate is Gmail's receive time in milliseconds since the
+	// epoch, used when SentDate does not parse.
+	InternalDate int64
+	// SentAt is set when reading: the instant the email was sent, in the
+	// sender's offset.
+	SentAt time.Time
+
+	// Raw is the source the email was parsed from. When set it is stored
+	// with the email; it is not read back by the query methods.
+	Raw *RawMessage
+}
+
+// splitLabels turns the "A, B, C" label string used by Email into a list.
+func splitLabels(labels string) []string {
+	list := []string{}
+	for _, label := range strings.Split(labels, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			list = append(list, label)
+		}
+	}
+	return list
 }
 
+// EmailDB stores emails. Every method has a Context variant that stops
+// when ctx is cancelled; the plain methods use context.Background().
+// Errors can be checked with errors.Is against ErrDuplicate, ErrNotFound
+// and ErrDateParse.
 type EmailDB interface {
+	// InsertEmail stores a new email and returns its id. An email already
+	// stored with the same subject, from, to and sent date is left as it is
+	// and reported as ErrDuplicate; InsertEmails updates it instead.
 	InsertEmail(email *Email) (id int64, err error)
-	GetEmails(tableName string) ([]Email, error)
+	InsertEmailContext(ctx context.Context, email *Email) (id int64, err error)
 	UpdateEmailReadStatus(id int64, read bool) error
+	UpdateEmailReadStatusContext(ctx context.Context, id int64, read bool) error
 	UpdateEmailLabels(id int64, labels string) error
-	GetEmail(tableName string, subject string, from string, to string, sentDate string) (Email, error)
+	UpdateEmailLabelsContext(ctx context.Context, id int64, labels string) error
+
+	// query methods, see EmailFilter
+	ListEmails(filter EmailFilter, opts ListOptions) (EmailPage, error)
+	ListEmailsContext(ctx context.Context, filter EmailFilter, opts ListOptions) (EmailPage, error)
+	IterateEmails(filter EmailFilter, opts ListOptions) (*EmailIterator, error)
+	IterateEmailsContext(ctx context.Context, filter EmailFilter, opts ListOptions) (*EmailIterator, error)
+	FindEmail(filter EmailFilter) (Email, error)
+	FindEmailContext(ctx context.Context, filter EmailFilter) (Email, error)
+	EmailEvents(emailID int64) ([]EmailEvent, error)
+	EmailEventsContext(ctx context.Context, emailID int64) ([]EmailEvent, error)
+	ListContacts(opts ContactOptions) ([]Contact, error)
+	ListContactsContext(ctx context.Context, opts ContactOptions) ([]Contact, error)
+
+	// RebuildContacts re-parses the address headers of every stored email.
+	RebuildContacts() (int, error)
+	RebuildContactsContext(ctx context.Context) (int, error)
+
+	// RawMessage returns the raw source stored with an email.
+	RawMessage(emailID int64) (RawMessage, error)
+	RawMessageContext(ctx context.Context, emailID int64) (RawMessage, error)
+	// ReparseEmails rewrites every email stored with its raw source from
+	// what parse derives from it, in one transaction.
+	ReparseEmails(ctx context.Context, parse ParseFunc) (ReparseResult, error)
+
+	// StorePrediction records a classifier's verdict, replacing an earlier
+	// one by the same model and prompt version.
+	StorePrediction(p *Prediction) error
+	StorePredictionContext(ctx context.Context, p *Prediction) error
+	// PredictedEmails returns the ids of the emails already classified by
+	// model with promptVersion.
+	PredictedEmails(model, promptVersion string) (map[int64]bool, error)
+	PredictedEmailsContext(ctx context.Context, model, promptVersion string) (map[int64]bool, error)
+
+	// RecordUsage adds a paid chat request to the ledger.
+	RecordUsage(u *UsageRecord) error
+	RecordUsageContext(ctx context.Context, u *UsageRecord) error
+	// ListUsage returns the ledger entries since a time, oldest first.
+	ListUsage(since time.Time) ([]UsageRecord, error)
+	ListUsageContext(ctx context.Context, since time.Time) ([]UsageRecord, error)
+
+	// SaveModel records a fine-tuning job, updating the status, model name
+	// and finish time of one already recorded.
+	SaveModel(m *ModelRecord) error
+	SaveModelContext(ctx context.Context, m *ModelRecord) error
+	// ListModels returns the recorded fine-tuning jobs, newest first.
+	ListModels() ([]ModelRecord, error)
+	ListModelsContext(ctx context.Context) ([]ModelRecord, error)
 
 	// batch update methods
 	InsertEmails(emails []Email) (int64, error)
+	InsertEmailsContext(ctx context.Context, emails []Email) (int64, error)
 	//UpdateEmailReadStatuses(ids []int64, read bool) error
 	//UpdateEmailLabelses(ids []int64, labels string) error
 
+	// InsertDeletedEmails stores emails as trashed.
 	InsertDeletedEmails(emails []Email) (int64, error)
+	InsertDeletedEmailsContext(ctx context.Context, emails []Email) (int64, error)
+
+	// StoreEmails writes emails as they arrive on the channel, in one
+	// transaction, and reports the outcome of every row. See BatchResult.
+	StoreEmails(ctx context.Context, emails <-chan Email) (BatchResult, error)
 	//GetDeletedEmails() ([]Email, error)
 	//GetDeletedEmail(subject string, from string, to string, sentDate string) (Email, error)
 }
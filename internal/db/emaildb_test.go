@@ -0,0 +1,1100 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// emailDBSuite is the conformance suite every EmailDB implementation must
+// pass. Each test gets a freshly created, empty database.
+var emailDBSuite = []struct {
+	name string
+	test func(t *testing.T, db EmailDB)
+}{
+	{"InsertEmails", testInsertEmails},
+	{"InsertDuplicateEmails", testInsertDuplicateEmails},
+	{"ListEmails", testListEmails},
+	{"InsertEmailAndCheckForDuplicates", testInsertEmailAndCheckForDuplicates},
+	{"InsertDeletedEmails", testInsertDeletedEmails},
+	{"FindEmail", testFindEmail},
+	{"ListEmailsFilters", testListEmailsFilters},
+	{"ListEmailsPagination", testListEmailsPagination},
+	{"Errors", testErrors},
+	{"StoreEmails", testStoreEmails},
+	{"UpsertKeepsIDAndRecordsEvents", testUpsertKeepsIDAndRecordsEvents},
+	{"TrashKeepsOneRow", testTrashKeepsOneRow},
+	{"SentAt", testSentAt},
+	{"Contacts", testContacts},
+	{"RawMessagesAndReparse", testRawMessagesAndReparse},
+	{"SimilarEmails", testSimilarEmails},
+	{"Predictions", testPredictions},
+	{"Usage", testUsage},
+	{"Models", testModels},
+}
+
+// listAll returns every email in state, newest id first.
+func listAll(t *testing.T, db EmailDB, state EmailState) ([]Email, error) {
+	it, err := db.IterateEmails(EmailFilter{State: state}, ListOptions{Sort: SortByID})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var emails []Email
+	for it.Next() {
+		emails = append(emails, it.Email())
+	}
+	return emails, it.Err()
+}
+
+// runEmailDBSuite runs the conformance suite, calling newDB for each test.
+func runEmailDBSuite(t *testing.T, newDB func(t *testing.T) EmailDB) {
+	for _, tc := range emailDBSuite {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			tc.test(t, newDB(t))
+		})
+	}
+}
+
+func testInsertEmails(t *testing.T, db EmailDB) {
+
+	// Test data for the emails
+	emails := []Email{
+		{
+			Subject:  "Test Email 1",
+			Body:     "Hello, this is a test email 1.",
+			From:     "test1@example.com",
+			To:       "recipient1@example.com",
+			Cc:       "",
+			Bcc:      "",
+			SentDate: time.Now().Format(time.RFC1123Z),
+		},
+		{
+			Subject:  "Test Email 2",
+			Body:     "Hello, this is a test email 2.",
+			From:     "test2@example.com",
+			To:       "recipient2@example.com",
+			Cc:       "",
+			Bcc:      "",
+			SentDate: time.Now().Add(-1 * time.Hour).Format(time.RFC1123Z),
+		},
+	}
+
+	// Call the InsertEmails function
+	rowsAffected, err := db.InsertEmails(emails)
+	if err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+
+	// Check if the correct number of emails was inserted
+	if int(rowsAffected) != len(emails) {
+		t.Fatalf("Expected %d inserted emails, got %d", len(emails), rowsAffected)
+	}
+
+	// Retrieve emails from the database and check if they match the test data
+	storedEmails, err := listAll(t, db, StateAll)
+	if err != nil {
+		t.Fatalf("IterateEmails failed: %v", err)
+	}
+
+	// Check if the number of retrieved emails matches the number of inserted emails
+	if len(storedEmails) != len(emails) {
+		t.Fatalf("Expected %d stored emails, got %d", len(emails), len(storedEmails))
+	}
+
+	// Compare the stored emails with the test data
+	for i, email := range emails {
+		storedEmail := storedEmails[len(storedEmails)-1-i] // Get the email in reverse order
+
+		if email.Subject != storedEmail.Subject || email.Body != storedEmail.Body || email.From != storedEmail.From || email.To != storedEmail.To || email.Cc != storedEmail.Cc || email.Bcc != storedEmail.Bcc || email.SentDate != storedEmail.SentDate {
+			t.Errorf("Email mismatch. Expected: %+v, Got: %+v", email, storedEmail)
+		}
+	}
+}
+
+// This is to test INSERT AND REPLACE functionality
+// Insert 3 emails but only 2 are unique
+func testInsertDuplicateEmails(t *testing.T, db EmailDB) {
+
+	// Test data for the emails
+	emails := []Email{
+		{
+			Subject:  "Test Email 1",
+			Body:     "Hello, this is a test email 1.",
+			From:     "test1@example.com",
+			To:       "recipient1@example.com",
+			Cc:       "",
+			Bcc:      "",
+			Labels:   "X, Y, Z",
+			SentDate: time.Now().Format(time.RFC1123Z),
+		},
+		{
+			Subject:  "Test Email 2",
+			Body:     "Hello, this is a test email 2.",
+			From:     "test2@example.com",
+			To:       "recipient2@example.com",
+			Cc:       "",
+			Bcc:      "",
+			Labels:   "X, Y, Z",
+			SentDate: time.Now().Add(-1 * time.Hour).Format(time.RFC1123Z),
+		},
+		{
+			Subject:  "Test Email 2",
+			Body:     "Hello, this is a test email 2.",
+			From:     "test2@example.com",
+			To:       "recipient2@example.com",
+			Cc:       "",
+			Bcc:      "",
+			Labels:   "A, B, C",
+			SentDate: time.Now().Add(-1 * time.Hour).Format(time.RFC1123Z),
+		},
+	}
+
+	// Call the InsertEmails function
+	rowsAffected, err := db.InsertEmails(emails)
+	if err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+
+	// Check if the correct number of emails was inserted
+	if int(rowsAffected) != len(emails) {
+		t.Fatalf("Expected %d inserted emails, got %d", len(emails)-1, rowsAffected)
+	}
+
+	// Retrieve emails from the database and check if they match the test data
+	storedEmails, err := listAll(t, db, StateAll)
+	if err != nil {
+		t.Fatalf("IterateEmails failed: %v", err)
+	}
+
+	// Check if the number of retrieved emails matches the number of inserted emails
+	if len(storedEmails) != len(emails)-1 {
+		t.Fatalf("Expected %d stored emails, got %d", len(emails)-1, len(storedEmails))
+	}
+
+	// Compare the stored emails with the test data
+	for i, email := range emails {
+		// break at len(emails)-1 because the last email is a duplicate
+		if i == len(emails)-1 {
+			break
+		}
+
+		storedEmail := storedEmails[len(storedEmails)-1-i] // Get the email in reverse order
+		if email.Subject != storedEmail.Subject || email.Body != storedEmail.Body || email.From != storedEmail.From || email.To != storedEmail.To || email.Cc != storedEmail.Cc || email.Bcc != storedEmail.Bcc || email.SentDate != storedEmail.SentDate {
+			t.Errorf("Email mismatch. Expected: %+v, Got: %+v", email, storedEmail)
+		}
+	}
+
+	// Lets insert the duplicate email again
+	emails = []Email{
+		{
+			Subject:  "Test Email 1",
+			Body:     "Hello, this is a test email 1.",
+			From:     "test1@example.com",
+			To:       "recipient1@example.com",
+			Cc:       "",
+			Bcc:      "",
+			Labels:   "D, E, F",
+			SentDate: time.Now().Format(time.RFC1123Z),
+		},
+		{
+			Subject:  "Test Email 2",
+			Body:     "Hello, this is a test email 2.",
+			From:     "test2@example.com",
+			To:       "recipient2@example.com",
+			Cc:       "",
+			Bcc:      "",
+			Labels:   "X, Y, Z",
+			SentDate: time.Now().Add(-1 * time.Hour).Format(time.RFC1123Z),
+		},
+		{
+			Subject:  "Test Email 5",
+			Body:     "Hello, this is a test email 5.",
+			From:     "test5@example.com",
+			To:       "recipient5@example.com",
+			Cc:       "",
+			Bcc:      "",
+			Labels:   "X, Y, Z",
+			SentDate: time.Now().Add(-1 * time.Hour).Format(time.RFC1123Z),
+		},
+	}
+	rowsAffected, err = db.InsertEmails(emails)
+	if err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+
+	// Check if the correct number of emails was inserted
+	if int(rowsAffected) != len(emails) {
+		t.Fatalf("Expected %d inserted emails, got %d", len(emails)-1, rowsAffected)
+	}
+
+	// Retrieve emails from the database and check if they match the test data
+	storedEmails, err = listAll(t, db, StateAll)
+	if err != nil {
+		t.Fatalf("IterateEmails failed: %v", err)
+	}
+
+	// Compare the stored emails with the test data
+	for i, email := range emails {
+
+		storedEmail := storedEmails[len(storedEmails)-1-i] // Get the email in reverse order
+
+		if email.Subject != storedEmail.Subject || email.Body != storedEmail.Body || email.From != storedEmail.From || email.To != storedEmail.To || email.Cc != storedEmail.Cc || email.Bcc != storedEmail.Bcc || email.SentDate != storedEmail.SentDate {
+			t.Errorf("Email mismatch. Expected: %+v, Got: %+v", email, storedEmail)
+		}
+	}
+
+}
+
+func testListEmails(t *testing.T, db EmailDB) {
+
+	// Test data for the emails
+	emails := []Email{
+		{
+			Subject:  "Test Email 1",
+			Body:     "Hello, this is a test email 1.",
+			From:     "test1@example.com",
+			To:       "recipient1@example.com",
+			Cc:       "",
+			Bcc:      "",
+			SentDate: time.Now().Format(time.RFC1123Z),
+		},
+		{
+			Subject:  "Test Email 2",
+			Body:     "Hello, this is a test email 2.",
+			From:     "test2@example.com",
+			To:       "recipient2@example.com",
+			Cc:       "",
+			Bcc:      "",
+			SentDate: time.Now().Add(-1 * time.Hour).Format(time.RFC1123Z),
+		},
+	}
+
+	// Call the InsertEmails function
+	_, err := db.InsertEmails(emails)
+	if err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+
+	// Get the emails from the database
+	resultEmails, err := listAll(t, db, StateAll)
+	if err != nil {
+		t.Errorf("Error getting emails: %v", err)
+	}
+
+	// Verify that the correct number of emails were retrieved
+	if len(resultEmails) != len(emails) {
+		t.Errorf("Expected %d emails, but got %d", len(emails), len(resultEmails))
+	}
+
+	// Verify that the retrieved emails are the same as the inserted emails
+	expectedEmails := make(map[string]Email)
+	for _, email := range emails {
+		key := fmt.Sprintf("%s:%s:%s:%s", email.Subject, email.From, email.To, email.SentDate)
+		expectedEmails[key] = email
+	}
+
+	for _, email := range resultEmails {
+		key := fmt.Sprintf("%s:%s:%s:%s", email.Subject, email.From, email.To, email.SentDate)
+		_, ok := expectedEmails[key]
+		if !ok {
+			t.Errorf("Unexpected email %+v", email)
+			continue
+		}
+	}
+
+}
+
+func testInsertEmailAndCheckForDuplicates(t *testing.T, testDB EmailDB) {
+
+	// create a new email
+	email := &Email{
+		Subject:   "Test Subject",
+		Body:      "Test Body",
+		From:      "test@example.com",
+		To:        "recipient@example.com",
+		Cc:        "",
+		Bcc:       "",
+		SentDate:  "2023-04-03T08:00:00Z",
+		Sender:    "",
+		Read:      false,
+		Deleted:   false,
+		Labels:    "",
+		CreatedAt: "",
+	}
+
+	// insert the email
+	id, err := testDB.InsertEmail(email)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// check that the email was inserted with the correct id
+	if id != 1 {
+		t.Errorf("Expected id 1, but got %d", id)
+	}
+
+	// insert the same email again, which should result in an error and
+	// leave the stored copy as it was
+	changed := *email
+	changed.Read = true
+	_, err = testDB.InsertEmail(&changed)
+	if !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("Expected ErrDuplicate, got %v", err)
+	}
+
+	// Get the emails from the database
+	resultEmails, _ := listAll(t, testDB, StateAll)
+	if len(resultEmails) != 1 {
+		t.Errorf("Expected 1 but got: %d", len(resultEmails))
+	} else if resultEmails[0].Read {
+		t.Error("Expected the duplicate not to update the stored email")
+	}
+}
+
+func testInsertDeletedEmails(t *testing.T, db EmailDB) {
+
+	// Test data for the emails
+	emails := []Email{
+		{
+			Subject:  "Test Email 1",
+			Body:     "Hello, this is a test email 1.",
+			From:     "test1@example.com",
+			To:       "recipient1@example.com",
+			Cc:       "",
+			Bcc:      "",
+			SentDate: time.Now().Format(time.RFC1123Z),
+		},
+		{
+			Subject:  "Test Email 2",
+			Body:     "Hello, this is a test email 2.",
+			From:     "test2@example.com",
+			To:       "recipient2@example.com",
+			Cc:       "",
+			Bcc:      "",
+			SentDate: time.Now().Add(-1 * time.Hour).Format(time.RFC1123Z),
+		},
+	}
+
+	// Call the InsertEmails function
+	rowsAffected, err := db.InsertDeletedEmails(emails)
+	if err != nil {
+		t.Fatalf("InsertDeletedEmails failed: %v", err)
+	}
+
+	// Check if the correct number of emails was inserted
+	if int(rowsAffected) != len(emails) {
+		t.Fatalf("Expected %d inserted emails, got %d", len(emails), rowsAffected)
+	}
+
+	// Retrieve emails from the database and check if they match the test data
+	storedEmails, err := listAll(t, db, StateTrashed)
+	if err != nil {
+		t.Fatalf("IterateEmails failed: %v", err)
+	}
+
+	// Check if the number of retrieved emails matches the number of inserted emails
+	if len(storedEmails) != len(emails) {
+		t.Fatalf("Expected %d stored emails, got %d", len(emails), len(storedEmails))
+	}
+
+	// Compare the stored emails with the test data
+	for i, email := range emails {
+		storedEmail := storedEmails[len(storedEmails)-1-i] // Get the email in reverse order
+
+		if email.Subject != storedEmail.Subject || email.Body != storedEmail.Body || email.From != storedEmail.From || email.To != storedEmail.To || email.Cc != storedEmail.Cc || email.Bcc != storedEmail.Bcc || email.SentDate != storedEmail.SentDate {
+			t.Errorf("Email mismatch. Expected: %+v, Got: %+v", email, storedEmail)
+		}
+	}
+}
+
+// testFindEmail given a subject, from, to, and sent date, FindEmail should return the corresponding email
+func testFindEmail(t *testing.T, db EmailDB) {
+
+	// Insert a test email into the database
+	testEmail := Email{
+		Subject:  "Test Email",
+		From:     "test@example.com",
+		To:       "recipient@example.com",
+		SentDate: "2023-04-03T08:58:29-04:00",
+		Labels:   "inbox",
+	}
+	_, err := db.InsertEmail(&testEmail)
+	if err != nil {
+		t.Fatalf("Failed to insert test email: %v", err)
+	}
+
+	// Test that the inserted email can be retrieved
+	resultEmail, err := db.FindEmail(EmailFilter{
+		Subject:  testEmail.Subject,
+		From:     testEmail.From,
+		To:       testEmail.To,
+		SentDate: testEmail.SentDate,
+	})
+	if err != nil {
+		t.Fatalf("Failed to retrieve test email: %v", err)
+	}
+
+	// Verify that the retrieved email matches the inserted email
+	if resultEmail.Subject != testEmail.Subject || resultEmail.From != testEmail.From || resultEmail.To != testEmail.To || resultEmail.SentDate != testEmail.SentDate {
+		t.Errorf("Expected email %+v, but got %+v", testEmail, resultEmail)
+	}
+
+	// The same email is not in the trash
+	if _, err := db.FindEmail(EmailFilter{State: StateTrashed, Subject: testEmail.Subject}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound from the trash, got %v", err)
+	}
+}
+
+func testListEmailsFilters(t *testing.T, db EmailDB) {
+	_, err := db.InsertEmails([]Email{
+		{Subject: "April invoice", Body: "Amount due", From: "Billing <billing@example.com>", Labels: "INBOX, UNREAD", SentDate: "Mon, 03 Apr 2023 18:15:16 +0000"},
+		{Subject: "Team lunch", Body: "Pizza on Friday", From: "alice@example.com", Labels: "INBOX", Read: true, SentDate: "Tue, 04 Apr 2023 12:00:00 +0000"},
+	})
+	if err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+	_, err = db.InsertDeletedEmails([]Email{
+		{Subject: "Old invoice", From: "billing@example.com", Labels: "TRASH", Deleted: true, SentDate: "Sun, 02 Apr 2023 18:15:16 +0000"},
+	})
+	if err != nil {
+		t.Fatalf("InsertDeletedEmails failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter EmailFilter
+		want   []string
+	}{
+		{"all", EmailFilter{}, []string{"Team lunch", "April invoice", "Old invoice"}},
+		{"inbox", EmailFilter{State: StateInbox}, []string{"Team lunch", "April invoice"}},
+		{"trashed", EmailFilter{State: StateTrashed}, []string{"Old invoice"}},
+		{"archived", EmailFilter{State: StateArchived}, nil},
+		{"labels", EmailFilter{Labels: []string{"inbox", "unread"}}, []string{"April invoice"}},
+		{"sender", EmailFilter{Sender: "BILLING"}, []string{"April invoice", "Old invoice"}},
+		{"date range", EmailFilter{
+			After:  time.Date(2023, 4, 3, 0, 0, 0, 0, time.UTC),
+			Before: time.Date(2023, 4, 4, 0, 0, 0, 0, time.UTC),
+		}, []string{"April invoice"}},
+		{"read", EmailFilter{Read: Bool(true)}, []string{"Team lunch"}},
+		{"deleted flag", EmailFilter{Deleted: Bool(false)}, []string{"Team lunch", "April invoice"}},
+		{"text", EmailFilter{Text: "pizza"}, []string{"Team lunch"}},
+		{"injection", EmailFilter{Sender: "' OR 1=1 --"}, nil},
+	}
+
+	for _, tt := range tests {
+		page, err := db.ListEmails(tt.filter, ListOptions{})
+		if err != nil {
+			t.Errorf("%s: ListEmails failed: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, email := range page.Emails {
+			got = append(got, email.Subject)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	all, err := db.ListEmails(EmailFilter{}, ListOptions{})
+	if err != nil || len(all.Emails) != 3 {
+		t.Fatalf("Expected 3 emails, got %v, %v", all.Emails, err)
+	}
+	ids := []int64{all.Emails[0].Id, all.Emails[2].Id, all.Emails[2].Id + 100}
+	for _, tt := range []struct {
+		name   string
+		filter EmailFilter
+		want   string
+	}{
+		{"ids", EmailFilter{IDs: ids}, "Team lunch|Old invoice"},
+		{"ids and state", EmailFilter{IDs: ids, State: StateInbox}, "Team lunch"},
+	} {
+		page, err := db.ListEmails(tt.filter, ListOptions{})
+		if err != nil {
+			t.Errorf("%s: ListEmails failed: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, email := range page.Emails {
+			got = append(got, email.Subject)
+		}
+		if strings.Join(got, "|") != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func testListEmailsPagination(t *testing.T, db EmailDB) {
+	sent := time.Date(2023, 4, 3, 18, 0, 0, 0, time.UTC)
+	var emails []Email
+	for i := 0; i < 5; i++ {
+		emails = append(emails, Email{
+			Subject:  fmt.Sprintf("Email %d", i),
+			SentDate: sent.Add(time.Duration(i) * time.Hour).Format(time.RFC1123Z),
+		})
+	}
+	// Two emails share a sent date so that the cursor has to break the tie.
+	emails[1].SentDate = emails[0].SentDate
+	if _, err := db.InsertEmails(emails[:3]); err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+	if _, err := db.InsertDeletedEmails(emails[3:]); err != nil {
+		t.Fatalf("InsertDeletedEmails failed: %v", err)
+	}
+
+	for _, ascending := range []bool{false, true} {
+		var got []string
+		opts := ListOptions{Ascending: ascending, Limit: 2}
+		for pages := 0; ; pages++ {
+			if pages > len(emails) {
+				t.Fatalf("Pagination did not terminate")
+			}
+			page, err := db.ListEmails(EmailFilter{}, opts)
+			if err != nil {
+				t.Fatalf("ListEmails failed: %v", err)
+			}
+			for _, email := range page.Emails {
+				got = append(got, email.Subject)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			opts.Cursor = page.NextCursor
+		}
+
+		want := "Email 4|Email 3|Email 2|Email 1|Email 0"
+		if ascending {
+			want = "Email 0|Email 1|Email 2|Email 3|Email 4"
+		}
+		if strings.Join(got, "|") != want {
+			t.Errorf("Ascending=%v: got %v, want %s", ascending, got, want)
+		}
+	}
+
+	if _, err := db.ListEmails(EmailFilter{}, ListOptions{Cursor: "not a cursor"}); err == nil {
+		t.Errorf("Expected an error for an invalid cursor")
+	}
+}
+
+func testErrors(t *testing.T, db EmailDB) {
+	if _, err := db.InsertEmail(&Email{Subject: "Bad date", SentDate: "yesterday"}); !errors.Is(err, ErrDateParse) {
+		t.Errorf("InsertEmail with a bad date: expected ErrDateParse, got %v", err)
+	}
+	var dateErr *DateParseError
+	if _, err := db.InsertEmail(&Email{SentDate: "yesterday"}); !errors.As(err, &dateErr) || dateErr.Value != "yesterday" {
+		t.Errorf("InsertEmail with a bad date: expected a DateParseError for %q, got %v", "yesterday", err)
+	}
+
+	if err := db.UpdateEmailReadStatus(12345, true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateEmailReadStatus of a missing id: expected ErrNotFound, got %v", err)
+	}
+	if err := db.UpdateEmailLabels(12345, "INBOX"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateEmailLabels of a missing id: expected ErrNotFound, got %v", err)
+	}
+	if _, err := db.FindEmail(EmailFilter{Subject: "Nothing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindEmail with no match: expected ErrNotFound, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	emails := []Email{{Subject: "Cancelled", SentDate: "Mon, 03 Apr 2023 18:15:16 +0000"}}
+	if _, err := db.InsertEmailsContext(ctx, emails); !errors.Is(err, context.Canceled) {
+		t.Errorf("InsertEmailsContext with a cancelled context: expected context.Canceled, got %v", err)
+	}
+	if _, err := db.ListEmailsContext(ctx, EmailFilter{}, ListOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListEmailsContext with a cancelled context: expected context.Canceled, got %v", err)
+	}
+	if page, err := db.ListEmails(EmailFilter{}, ListOptions{}); err != nil || len(page.Emails) != 0 {
+		t.Errorf("Expected nothing stored after cancelled inserts, got %+v, %v", page.Emails, err)
+	}
+}
+
+func testStoreEmails(t *testing.T, db EmailDB) {
+	// Enough rows to need several statements under SQLite's parameter limit.
+	const n = 500
+	sent := time.Date(2023, 4, 3, 18, 0, 0, 0, time.UTC)
+
+	emails := make(chan Email)
+	go func() {
+		defer close(emails)
+		for i := 0; i < n; i++ {
+			emails <- Email{Subject: fmt.Sprintf("Email %d", i), SentDate: sent.Add(time.Duration(i) * time.Minute).Format(time.RFC1123Z)}
+			if i == 10 {
+				emails <- Email{Subject: "Bad date", SentDate: "not a date"}
+				emails <- Email{Subject: "Email 3", SentDate: sent.Add(3 * time.Minute).Format(time.RFC1123Z)}
+			}
+		}
+	}()
+
+	result, err := db.StoreEmails(context.Background(), emails)
+	if err != nil {
+		t.Fatalf("StoreEmails failed: %v", err)
+	}
+	if result.Inserted != n || result.Updated != 1 || result.Skipped != 1 || len(result.Rows) != n+2 {
+		t.Fatalf("Expected %d inserted, 1 updated, 1 skipped, got %d, %d, %d over %d rows",
+			n, result.Inserted, result.Updated, result.Skipped, len(result.Rows))
+	}
+	for i, row := range result.Rows {
+		if row.Index != i {
+			t.Fatalf("Expected rows in input order, row %d has index %d", i, row.Index)
+		}
+	}
+	if row := result.Rows[11]; row.Status != RowSkipped || !errors.Is(row.Err, ErrDateParse) {
+		t.Errorf("Expected the bad date to be skipped with ErrDateParse, got %+v", row)
+	}
+	if row := result.Rows[12]; row.Status != RowUpdated || row.Subject != "Email 3" {
+		t.Errorf("Expected the repeated email to be updated, got %+v", row)
+	}
+
+	stored, err := listAll(t, db, StateAll)
+	if err != nil || len(stored) != n {
+		t.Fatalf("Expected %d stored emails, got %d, %v", n, len(stored), err)
+	}
+
+	// A second run only updates.
+	again := make([]Email, 3)
+	for i := range again {
+		again[i] = Email{Subject: fmt.Sprintf("Email %d", i), SentDate: sent.Add(time.Duration(i) * time.Minute).Format(time.RFC1123Z)}
+	}
+	result, err = db.StoreEmails(context.Background(), emailChan(again))
+	if err != nil || result.Updated != 3 || result.Inserted != 0 {
+		t.Errorf("Expected 3 updates, got %+v, %v", result, err)
+	}
+
+	// A batch with nothing valid stores nothing and is not an error.
+	rowsAffected, err := db.InsertDeletedEmails([]Email{{Subject: "Bad date", SentDate: "not a date"}})
+	if err != nil || rowsAffected != 0 {
+		t.Errorf("Expected an all-skipped batch to succeed with 0 rows, got %d, %v", rowsAffected, err)
+	}
+}
+
+func testUpsertKeepsIDAndRecordsEvents(t *testing.T, db EmailDB) {
+	email := Email{Subject: "Tracked", From: "a@example.com", Labels: "INBOX, UNREAD", SentDate: "Mon, 03 Apr 2023 18:15:16 +0000"}
+	if _, err := db.InsertEmails([]Email{email}); err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+	before, err := db.FindEmail(EmailFilter{Subject: "Tracked"})
+	if err != nil {
+		t.Fatalf("FindEmail failed: %v", err)
+	}
+
+	// Re-syncing the same email unchanged records nothing.
+	if _, err := db.InsertEmails([]Email{email}); err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+	events, err := db.EmailEvents(before.Id)
+	if err != nil || len(events) != 0 {
+		t.Fatalf("Expected no events for an unchanged email, got %+v, %v", events, err)
+	}
+
+	email.Labels = "INBOX"
+	email.Read = true
+	if _, err := db.InsertEmails([]Email{email}); err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+	if err := db.UpdateEmailReadStatus(before.Id, false); err != nil {
+		t.Fatalf("UpdateEmailReadStatus failed: %v", err)
+	}
+
+	after, err := db.FindEmail(EmailFilter{Subject: "Tracked"})
+	if err != nil {
+		t.Fatalf("FindEmail failed: %v", err)
+	}
+	if after.Id != before.Id || after.CreatedAt != before.CreatedAt {
+		t.Errorf("Expected the upsert to keep id %d and created_at %s, got %d and %s", before.Id, before.CreatedAt, after.Id, after.CreatedAt)
+	}
+	if after.Labels != "INBOX" || after.Read {
+		t.Errorf("Expected the update to apply, got %+v", after)
+	}
+
+	events, err = db.EmailEvents(before.Id)
+	if err != nil {
+		t.Fatalf("EmailEvents failed: %v", err)
+	}
+	var got []string
+	for _, event := range events {
+		got = append(got, fmt.Sprintf("%s:%s->%s", event.Field, event.OldValue, event.NewValue))
+		if event.EmailID != before.Id || event.ObservedAt.IsZero() {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	}
+	want := []string{"labels:INBOX, UNREAD->INBOX", "read:false->true", "read:true->false"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected events %v, got %v", want, got)
+	}
+}
+
+func testTrashKeepsOneRow(t *testing.T, db EmailDB) {
+	email := Email{Subject: "Moving", From: "a@example.com", Labels: "INBOX", SentDate: "Mon, 03 Apr 2023 18:15:16 +0000"}
+	if _, err := db.InsertEmails([]Email{email}); err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+	inbox, err := db.FindEmail(EmailFilter{State: StateInbox, Subject: "Moving"})
+	if err != nil {
+		t.Fatalf("FindEmail failed: %v", err)
+	}
+	if inbox.TrashedAt != "" || inbox.FirstSeenAt == "" {
+		t.Errorf("Expected an inbox email with first_seen_at and no trashed_at, got %+v", inbox)
+	}
+
+	// The deleted sync finds the same email in the trash.
+	email.Labels = "TRASH"
+	if _, err := db.InsertDeletedEmails([]Email{email}); err != nil {
+		t.Fatalf("InsertDeletedEmails failed: %v", err)
+	}
+
+	all, err := listAll(t, db, StateAll)
+	if err != nil || len(all) != 1 {
+		t.Fatalf("Expected one stored email, got %+v, %v", all, err)
+	}
+	trashed := all[0]
+	if trashed.Id != inbox.Id || trashed.State != StateTrashed || !trashed.Deleted || trashed.TrashedAt == "" {
+		t.Errorf("Expected email %d to move to the trash, got %+v", inbox.Id, trashed)
+	}
+	if trashed.FirstSeenAt != inbox.FirstSeenAt {
+		t.Errorf("Expected first_seen_at %s to be kept, got %s", inbox.FirstSeenAt, trashed.FirstSeenAt)
+	}
+
+	// Seeing it in the trash again keeps the time it was trashed.
+	if _, err := db.InsertDeletedEmails([]Email{email}); err != nil {
+		t.Fatalf("InsertDeletedEmails failed: %v", err)
+	}
+	again, err := db.FindEmail(EmailFilter{State: StateTrashed, Subject: "Moving"})
+	if err != nil || again.TrashedAt != trashed.TrashedAt {
+		t.Errorf("Expected trashed_at %s to be kept, got %+v, %v", trashed.TrashedAt, again, err)
+	}
+
+	events, err := db.EmailEvents(inbox.Id)
+	if err != nil {
+		t.Fatalf("EmailEvents failed: %v", err)
+	}
+	var got []string
+	for _, event := range events {
+		got = append(got, fmt.Sprintf("%s:%s->%s", event.Field, event.OldValue, event.NewValue))
+	}
+	want := []string{"state:inbox->trashed", "labels:INBOX->TRASH", "deleted:false->true"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected events %v, got %v", want, got)
+	}
+}
+
+func testSentAt(t *testing.T, db EmailDB) {
+	_, err := db.InsertEmails([]Email{
+		// 02:30 UTC on the 4th, though the sender's clock says the 3rd.
+		{Subject: "Late in New York", SentDate: "Mon, 3 Apr 2023 22:30:00 -0400 (EDT)"},
+		{Subject: "Noon in London", SentDate: "Mon, 03 Apr 2023 12:00:00 +0100"},
+		{Subject: "No header", SentDate: "", InternalDate: time.Date(2023, 4, 2, 9, 0, 0, 0, time.UTC).UnixMilli()},
+	})
+	if err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+
+	late, err := db.FindEmail(EmailFilter{Subject: "Late in New York"})
+	if err != nil {
+		t.Fatalf("FindEmail failed: %v", err)
+	}
+	if late.SentDate != "Mon, 3 Apr 2023 22:30:00 -0400 (EDT)" {
+		t.Errorf("Expected the original Date header back, got %q", late.SentDate)
+	}
+	if want := time.Date(2023, 4, 4, 2, 30, 0, 0, time.UTC); !late.SentAt.Equal(want) || sentOffset(late.SentAt) != -240 {
+		t.Errorf("Expected SentAt %s at -0400, got %s", want, late.SentAt)
+	}
+
+	noHeader, err := db.FindEmail(EmailFilter{Subject: "No header"})
+	if err != nil || noHeader.SentAt.Format(time.RFC3339) != "2023-04-02T09:00:00Z" {
+		t.Errorf("Expected SentAt from internalDate, got %+v, %v", noHeader, err)
+	}
+
+	// Ranges compare instants, not the senders' wall clocks.
+	page, err := db.ListEmails(EmailFilter{After: time.Date(2023, 4, 4, 0, 0, 0, 0, time.UTC)}, ListOptions{})
+	if err != nil || len(page.Emails) != 1 || page.Emails[0].Subject != "Late in New York" {
+		t.Errorf("Expected only the New York email after the 4th UTC, got %+v, %v", page.Emails, err)
+	}
+
+	var order []string
+	page, err = db.ListEmails(EmailFilter{}, ListOptions{Sort: SortBySentDate, Ascending: true})
+	if err != nil {
+		t.Fatalf("ListEmails failed: %v", err)
+	}
+	for _, email := range page.Emails {
+		order = append(order, email.Subject)
+	}
+	if want := []string{"No header", "Noon in London", "Late in New York"}; strings.Join(order, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected sent order %v, got %v", want, order)
+	}
+}
+
+func testContacts(t *testing.T, db EmailDB) {
+	_, err := db.InsertEmails([]Email{
+		{Subject: "Invoice", From: "Billing <billing@example.com>", To: "me@example.com", Labels: "INBOX, UNREAD", SentDate: "Mon, 03 Apr 2023 18:15:16 +0000"},
+		{Subject: "Receipt", From: "billing@example.com", To: "Me <me@example.com>", Labels: "INBOX, READ", Read: true, SentDate: "Tue, 04 Apr 2023 18:15:16 +0000"},
+		{Subject: "Lunch?", From: "Me <me@example.com>", To: "alice@example.com", Cc: "Billing <billing@example.com>", SentDate: "Wed, 05 Apr 2023 12:00:00 +0000"},
+	})
+	if err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+	if _, err := db.InsertDeletedEmails([]Email{
+		{Subject: "Reminder", From: "=?UTF-8?Q?Billing_=E2=9C=93?= <BILLING@example.com>", To: "me@example.com", Labels: "TRASH, READ", Read: true, SentDate: "Thu, 06 Apr 2023 18:15:16 +0000"},
+	}); err != nil {
+		t.Fatalf("InsertDeletedEmails failed: %v", err)
+	}
+
+	contacts, err := db.ListContacts(ContactOptions{Match: "billing"})
+	if err != nil || len(contacts) != 1 {
+		t.Fatalf("Expected one billing contact, got %+v, %v", contacts, err)
+	}
+	billing := contacts[0]
+	if billing.Address != "billing@example.com" || billing.Name != "Billing ✓" {
+		t.Errorf("Expected the latest decoded name, got %+v", billing)
+	}
+	if billing.Received != 3 || billing.Addressed != 1 || billing.Read != 2 || billing.Trashed != 1 {
+		t.Errorf("Expected 3 received, 1 addressed, 2 read, 1 trashed, got %+v", billing)
+	}
+	if want := time.Date(2023, 4, 6, 18, 15, 16, 0, time.UTC); !billing.LastContact.Equal(want) {
+		t.Errorf("Expected last contact %s, got %s", want, billing.LastContact)
+	}
+
+	// Read counts come from the read flag, not from which labels were kept.
+	if _, err := db.InsertEmails([]Email{
+		{Subject: "Digest", From: "news@example.com", To: "me@example.com", Labels: "INBOX", Read: true, SentDate: "Mon, 03 Apr 2023 08:00:00 +0000"},
+		{Subject: "Digest", From: "news@example.com", To: "me@example.com", Labels: "INBOX", SentDate: "Tue, 04 Apr 2023 08:00:00 +0000"},
+	}); err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+	if contacts, err := db.ListContacts(ContactOptions{Match: "news"}); err != nil || len(contacts) != 1 || contacts[0].Received != 2 || contacts[0].Read != 1 {
+		t.Errorf("Expected news to have 1 of 2 emails read, got %+v, %v", contacts, err)
+	}
+
+	contacts, err = db.ListContacts(ContactOptions{Sort: ContactsByRecent, Limit: 2})
+	if err != nil || len(contacts) != 2 || contacts[0].Address != "billing@example.com" {
+		t.Errorf("Expected billing to be the most recent of 2 contacts, got %+v, %v", contacts, err)
+	}
+	if _, err := db.ListContacts(ContactOptions{Sort: "bogus"}); err == nil {
+		t.Errorf("Expected an error for an unknown sort")
+	}
+
+	// Re-syncing with a different Cc replaces the email's addresses.
+	if _, err := db.InsertEmails([]Email{
+		{Subject: "Lunch?", From: "Me <me@example.com>", To: "alice@example.com", Cc: "dave@example.com", SentDate: "Wed, 05 Apr 2023 12:00:00 +0000"},
+	}); err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+	if contacts, err := db.ListContacts(ContactOptions{Match: "billing"}); err != nil || len(contacts) != 1 || contacts[0].Addressed != 0 {
+		t.Errorf("Expected billing to be dropped from the Cc, got %+v, %v", contacts, err)
+	}
+
+	indexed, err := db.RebuildContacts()
+	if err != nil || indexed != 6 {
+		t.Errorf("Expected RebuildContacts to index 6 emails, got %d, %v", indexed, err)
+	}
+	if contacts, err := db.ListContacts(ContactOptions{Match: "dave"}); err != nil || len(contacts) != 1 || contacts[0].Addressed != 1 {
+		t.Errorf("Expected the rebuild to keep dave's totals, got %+v, %v", contacts, err)
+	}
+}
+
+func testRawMessagesAndReparse(t *testing.T, db EmailDB) {
+	raw := func(gmailID, source string) *RawMessage {
+		return &RawMessage{GmailID: gmailID, LabelIDs: []string{"INBOX", "UNREAD"}, InternalDate: 1680516916000, SizeEstimate: 2048, Data: []byte(source)}
+	}
+	_, err := db.InsertEmails([]Email{
+		{Subject: "Old subject", From: "a@example.com", To: "me@example.com", SentDate: "Mon, 03 Apr 2023 10:00:00 +0000", Raw: raw("m1", "Subject: New subject\r\nFrom: Alice <a@example.com>")},
+		{Subject: "Taken", From: "b@example.com", To: "me@example.com", SentDate: "Mon, 03 Apr 2023 11:00:00 +0000", Raw: raw("m2", "Subject: Kept")},
+		{Subject: "No source", From: "c@example.com", To: "me@example.com", SentDate: "Mon, 03 Apr 2023 12:00:00 +0000"},
+	})
+	if err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+	id, err := db.InsertEmail(&Email{Subject: "Single", From: "d@example.com", To: "me@example.com", SentDate: "Mon, 03 Apr 2023 13:00:00 +0000", Raw: raw("m4", "Subject: Single")})
+	if err != nil {
+		t.Fatalf("InsertEmail failed: %v", err)
+	}
+
+	stored, err := db.RawMessage(id)
+	if err != nil || stored.GmailID != "m4" || string(stored.Data) != "Subject: Single" || strings.Join(stored.LabelIDs, ",") != "INBOX,UNREAD" || stored.SizeEstimate != 2048 {
+		t.Errorf("Expected the raw message back, got %+v, %v", stored, err)
+	}
+	noSource, err := db.FindEmail(EmailFilter{Subject: "No source"})
+	if err != nil {
+		t.Fatalf("FindEmail failed: %v", err)
+	}
+	if _, err := db.RawMessage(noSource.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an email without a source, got %v", err)
+	}
+
+	// m1 is parsed from its source and dated by internalDate; m2 and m4
+	// parse to m4's key, so m2 collides and must be left as it was.
+	result, err := db.ReparseEmails(context.Background(), func(raw RawMessage) (Email, error) {
+		email := Email{Subject: "Single", From: "d@example.com", To: "me@example.com", SentDate: "Mon, 03 Apr 2023 13:00:00 +0000"}
+		if raw.GmailID == "m1" {
+			email.SentDate = time.UnixMilli(raw.InternalDate).UTC().Format(time.RFC1123Z)
+			for _, line := range strings.Split(string(raw.Data), "\r\n") {
+				name, value, _ := strings.Cut(line, ": ")
+				switch name {
+				case "Subject":
+					email.Subject = value
+				case "From":
+					email.From = value
+				}
+			}
+		}
+		return email, nil
+	})
+	if err != nil {
+		t.Fatalf("ReparseEmails failed: %v", err)
+	}
+	if result.Reparsed != 2 || len(result.Failures) != 1 {
+		t.Errorf("Expected 2 reparsed and 1 failure, got %+v", result)
+	}
+
+	reparsed, err := db.FindEmail(EmailFilter{Subject: "New subject"})
+	if err != nil {
+		t.Fatalf("Expected the reparsed subject: %v", err)
+	}
+	if reparsed.From != "Alice <a@example.com>" || reparsed.SentAt.Format(time.RFC3339) != "2023-04-03T10:15:16Z" {
+		t.Errorf("Expected the reparsed columns, got %+v", reparsed)
+	}
+	if _, err := db.FindEmail(EmailFilter{Subject: "Taken"}); err != nil {
+		t.Errorf("Expected the colliding email to be unchanged: %v", err)
+	}
+	contacts, err := db.ListContacts(ContactOptions{Match: "alice"})
+	if err != nil || len(contacts) != 1 || contacts[0].Name != "Alice" || contacts[0].Received != 1 {
+		t.Errorf("Expected the reparsed From in contacts, got %+v, %v", contacts, err)
+	}
+}
+
+func testSimilarEmails(t *testing.T, db EmailDB) {
+	_, err := db.InsertEmails([]Email{
+		{Subject: "Your invoice for March", From: "Billing <billing@shop.example>", To: "me@example.com", SentDate: "Mon, 03 Apr 2023 10:00:00 +0000"},
+		{Subject: "Shipping update", From: "orders@shop.example", To: "me@example.com", SentDate: "Tue, 04 Apr 2023 10:00:00 +0000"},
+		{Subject: "Invoice overdue", From: "accounts@other.example", To: "me@example.com", SentDate: "Wed, 05 Apr 2023 10:00:00 +0000"},
+		{Subject: "Lunch on Friday", From: "alice@example.com", To: "me@example.com", SentDate: "Thu, 06 Apr 2023 10:00:00 +0000"},
+		{Subject: "Your invoice for April", From: "billing@shop.example", To: "me@example.com", SentDate: "Mon, 01 May 2023 10:00:00 +0000"},
+	})
+	if err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+
+	// The April invoice itself is left out.
+	email := Email{Subject: "Your invoice for April", From: "billing@shop.example", To: "me@example.com", SentDate: "Mon, 01 May 2023 10:00:00 +0000"}
+	similar, err := SimilarEmails(context.Background(), db, email, 3, nil)
+	if err != nil {
+		t.Fatalf("SimilarEmails failed: %v", err)
+	}
+	var subjects []string
+	for _, e := range similar {
+		subjects = append(subjects, e.Subject)
+	}
+	if want := "Your invoice for March|Shipping update|Invoice overdue"; strings.Join(subjects, "|") != want {
+		t.Errorf("Expected sender, then domain, then subject matches %q, got %q", want, strings.Join(subjects, "|"))
+	}
+
+	if similar, err := SimilarEmails(context.Background(), db, email, 0, nil); err != nil || len(similar) != 0 {
+		t.Errorf("Expected no examples for n = 0, got %v, %v", similar, err)
+	}
+
+	skipBilling := func(e Email) bool { return strings.HasPrefix(e.From, "Billing") }
+	if similar, err := SimilarEmails(context.Background(), db, email, 3, skipBilling); err != nil || len(similar) != 3 || similar[0].Subject != "Shipping update" {
+		t.Errorf("Expected the skipped March invoice to be left out, got %v, %v", similar, err)
+	}
+}
+
+func testPredictions(t *testing.T, db EmailDB) {
+	if _, err := db.InsertEmails([]Email{
+		{Subject: "Sale", From: "shop@example.com", To: "me@example.com", SentDate: "Mon, 03 Apr 2023 10:00:00 +0000"},
+		{Subject: "Hello", From: "alice@example.com", To: "me@example.com", SentDate: "Tue, 04 Apr 2023 10:00:00 +0000"},
+	}); err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+	emails, err := listAll(t, db, StateAll)
+	if err != nil || len(emails) != 2 {
+		t.Fatalf("Expected 2 emails, got %d, %v", len(emails), err)
+	}
+
+	p := Prediction{EmailID: emails[0].Id, Model: "gpt-test", PromptName: "triage", PromptVersion: "v1",
+		Label: "ignore", Category: "personal", Confidence: 0.5, Reason: "Unsure.", Latency: 1500 * time.Millisecond,
+		PromptTokens: 120, CompletionTokens: 30}
+	if err := db.StorePrediction(&p); err != nil || p.ID == 0 {
+		t.Fatalf("StorePrediction failed: %v, id %d", err, p.ID)
+	}
+	// Classifying the same email again replaces the prediction.
+	again := p
+	again.Label, again.Confidence = "star", 0.9
+	if err := db.StorePrediction(&again); err != nil || again.ID != p.ID {
+		t.Errorf("Expected the prediction to be replaced in place, got id %d (was %d), %v", again.ID, p.ID, err)
+	}
+	other := Prediction{EmailID: emails[1].Id, Model: "gpt-test", PromptVersion: "v2", Label: "trash"}
+	if err := db.StorePrediction(&other); err != nil {
+		t.Fatalf("StorePrediction failed: %v", err)
+	}
+
+	done, err := db.PredictedEmails("gpt-test", "v1")
+	if err != nil || len(done) != 1 || !done[emails[0].Id] {
+		t.Errorf("Expected only email %d predicted by gpt-test v1, got %v, %v", emails[0].Id, done, err)
+	}
+	if done, err := db.PredictedEmails("other-model", "v1"); err != nil || len(done) != 0 {
+		t.Errorf("Expected nothing predicted by another model, got %v, %v", done, err)
+	}
+}
+
+func testUsage(t *testing.T, db EmailDB) {
+	yesterday := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	for _, u := range []UsageRecord{
+		{Model: "gpt-test", Operation: "classify", PromptTokens: 500, CompletionTokens: 50, Cost: 0.002, CreatedAt: yesterday.Add(-time.Hour)},
+		{Model: "gpt-test", Operation: "classify", PromptTokens: 400, CompletionTokens: 40, Cost: 0.0015, CreatedAt: yesterday},
+		{Model: "gpt-other", Operation: "classifyEmail", PromptTokens: 300, CompletionTokens: 30, Cost: 0.01},
+	} {
+		u := u
+		if err := db.RecordUsage(&u); err != nil || u.ID == 0 {
+			t.Fatalf("RecordUsage failed: %v, id %d", err, u.ID)
+		}
+	}
+
+	records, err := db.ListUsage(yesterday)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Expected 2 records since yesterday, got %d, %v", len(records), err)
+	}
+	if r := records[0]; r.Model != "gpt-test" || r.PromptTokens != 400 || r.Cost != 0.0015 || !r.CreatedAt.Equal(yesterday) {
+		t.Errorf("Expected the oldest record first, got %+v", r)
+	}
+	if records[1].Model != "gpt-other" || records[1].CreatedAt.IsZero() {
+		t.Errorf("Expected the record without a time to be stamped now, got %+v", records[1])
+	}
+}
+
+func testModels(t *testing.T, db EmailDB) {
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	first := ModelRecord{JobID: "ftjob-1", BaseModel: "gpt-test", Status: "failed", TrainingFile: "file-1", CreatedAt: created.Add(-time.Hour)}
+	job := ModelRecord{JobID: "ftjob-2", BaseModel: "gpt-test", Status: "running", TrainingFile: "file-2", ValidationFile: "file-3",
+		Suffix: "triage", CreatedAt: created}
+	for _, m := range []*ModelRecord{&first, &job} {
+		if err := db.SaveModel(m); err != nil || m.ID == 0 {
+			t.Fatalf("SaveModel failed: %v, id %d", err, m.ID)
+		}
+	}
+
+	finished := created.Add(30 * time.Minute)
+	update := ModelRecord{JobID: "ftjob-2", BaseModel: "gpt-test", Name: "ft:gpt-test:triage", Status: "succeeded",
+		TrainingFile: "file-2", TrainedTokens: 12000, FinishedAt: finished}
+	if err := db.SaveModel(&update); err != nil || update.ID != job.ID {
+		t.Fatalf("Expected SaveModel to update job %d, got %d, %v", job.ID, update.ID, err)
+	}
+
+	models, err := db.ListModels()
+	if err != nil || len(models) != 2 {
+		t.Fatalf("Expected 2 models, got %d, %v", len(models), err)
+	}
+	m := models[0]
+	if m.JobID != "ftjob-2" || m.Name != "ft:gpt-test:triage" || m.Status != "succeeded" || m.TrainedTokens != 12000 ||
+		m.ValidationFile != "file-3" || m.Suffix != "triage" || !m.CreatedAt.Equal(created) || !m.FinishedAt.Equal(finished) {
+		t.Errorf("Expected the updated job first, got %+v", m)
+	}
+	if models[1].JobID != "ftjob-1" || !models[1].FinishedAt.IsZero() {
+		t.Errorf("Expected the older job unfinished, got %+v", models[1])
+	}
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Errors returned by EmailDB implementations. Check them with errors.Is;
+// they are usually wrapped with more detail.
+var (
+	// ErrDuplicate means an insert matched an email that is already stored.
+	ErrDuplicate = errors.New("duplicate email")
+	// ErrNotFound means no stored email matched.
+	ErrNotFound = errors.New("email not found")
+	// ErrDateParse means an email's sent date is in no known format.
+	ErrDateParse = errors.New("unable to parse date")
+)
+
+// DateParseError reports the sent date that could not be parsed. It
+// matches ErrDateParse.
+type DateParseError struct {
+	Value string
+}
+
+func (e *DateParseError) Error() string {
+	return fmt.Sprintf("unable to parse date string: %s", e.Value)
+}
+
+func (e *DateParseError) Unwrap() error {
+	return ErrDateParse
+}
+
+// updateResult turns the result of an UPDATE by id into ErrNotFound when no
+// row matched.
+func updateResult(result sql.Result, err error, id int64) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: id %d", ErrNotFound, id)
+	}
+	return nil
+}
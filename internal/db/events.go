@@ -0,0 +1,49 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Fields recorded in email_events.
+const (
+	EventState   = "state"
+	EventLabels  = "labels"
+	EventRead    = "read"
+	EventDeleted = "deleted"
+)
+
+// EmailEvent is one observed change to a stored email. Triggers record an
+// event whenever an upsert or update changes an email's state, labels, read
+// or deleted flag, so the history shows when a mail was read or trashed.
+// Read and deleted values are "true" or "false".
+type EmailEvent struct {
+	ID         int64
+	EmailID    int64
+	Field      string
+	OldValue   string
+	NewValue   string
+	ObservedAt time.Time
+}
+
+// emailEventsQuery lists an email's events, oldest first.
+const emailEventsQuery = `SELECT id, email_id, "field", old_value, new_value, observed_at
+	FROM email_events WHERE email_id = $1 ORDER BY observed_at, id`
+
+func scanEmailEvents(rows *sql.Rows) ([]EmailEvent, error) {
+	defer rows.Close()
+
+	events := []EmailEvent{}
+	for rows.Next() {
+		var event EmailEvent
+		var oldValue, newValue sql.NullString
+		err := rows.Scan(&event.ID, &event.EmailID, &event.Field, &oldValue, &newValue, &event.ObservedAt)
+		if err != nil {
+			return nil, err
+		}
+		event.OldValue, event.NewValue = oldValue.String, newValue.String
+		event.ObservedAt = event.ObservedAt.UTC()
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
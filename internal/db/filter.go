@@ -0,0 +1,211 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EmailState is where an email is in the mailbox.
+type EmailState string
+
+const (
+	// StateAll matches every state in an EmailFilter.
+	StateAll      EmailState = ""
+	StateInbox    EmailState = "inbox"
+	StateArchived EmailState = "archived"
+	StateTrashed  EmailState = "trashed"
+	StateSpam     EmailState = "spam"
+)
+
+// StateFromLabels derives the state of an email from its Gmail labels.
+func StateFromLabels(labels []string) EmailState {
+	has := map[string]bool{}
+	for _, label := range labels {
+		has[strings.ToUpper(strings.TrimSpace(label))] = true
+	}
+	switch {
+	case has["TRASH"]:
+		return StateTrashed
+	case has["SPAM"]:
+		return StateSpam
+	case has["INBOX"]:
+		return StateInbox
+	}
+	return StateArchived
+}
+
+// state returns the email's State, or derives it from Deleted and Labels.
+func (e *Email) state() EmailState {
+	switch {
+	case e.State != "":
+		return e.State
+	case e.Deleted:
+		return StateTrashed
+	}
+	return StateFromLabels(splitLabels(e.Labels))
+}
+
+// EmailFilter narrows the emails returned by ListEmails, IterateEmails and
+// FindEmail. Zero fields do not filter. All set fields must match.
+type EmailFilter struct {
+	// IDs, if any, are the ids of the emails to match.
+	IDs   []int64
+	State EmailState
+	// Labels must all be present, compared case-insensitively.
+	Labels []string
+	// Sender is a case-insensitive substring of the From header.
+	Sender string
+	// After and Before bound the sent date: After <= sent < Before.
+	After  time.Time
+	Before time.Time
+	Read   *bool
+	// Deleted matches the deleted flag, independent of State.
+	Deleted *bool
+	// Text is a case-insensitive substring of the subject or body.
+	Text string
+	// Subject, From, To and SentDate match exactly; together they identify
+	// an email.
+	Subject  string
+	From     string
+	To       string
+	SentDate string
+	// Query is a Gmail-style query.
+	Query *Query
+}
+
+// SortField is the column results are ordered by.
+type SortField string
+
+const (
+	SortBySentDate  SortField = "sent_date"
+	SortByCreatedAt SortField = "created_at"
+	SortByID        SortField = "id"
+)
+
+// ListOptions controls ordering and pagination. Results are newest first
+// (descending) unless Ascending is set. Cursor is the NextCursor of the
+// previous page.
+type ListOptions struct {
+	Sort      SortField
+	Ascending bool
+	Limit     int
+	Cursor    string
+}
+
+// EmailPage is one page of results. NextCursor is empty on the last page.
+type EmailPage struct {
+	Emails     []Email
+	NextCursor string
+}
+
+// Bool returns a pointer to b, for EmailFilter.Read and Deleted.
+func Bool(b bool) *bool {
+	return &b
+}
+
+// cursor is the keyset position of the last row of a page: its sort key
+// and id.
+type cursor struct {
+	Key string `json:"k"`
+	ID  int64  `json:"i"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor %q", s)
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("invalid cursor %q", s)
+	}
+	return &c, nil
+}
+
+func (o ListOptions) sortField() (SortField, error) {
+	switch o.Sort {
+	case "":
+		return SortBySentDate, nil
+	case SortBySentDate, SortByCreatedAt, SortByID:
+		return o.Sort, nil
+	}
+	return "", fmt.Errorf("unknown sort field %q", o.Sort)
+}
+
+// EmailIterator streams emails row by row, like sql.Rows:
+//
+//	it, err := db.IterateEmails(filter, opts)
+//	...
+//	defer it.Close()
+//	for it.Next() {
+//		email := it.Email()
+//	}
+//	if err := it.Err(); err != nil { ... }
+type EmailIterator struct {
+	rows   *sql.Rows
+	scan   func(rows *sql.Rows) (Email, cursor, error)
+	email  Email
+	cursor cursor
+	err    error
+}
+
+func (it *EmailIterator) Next() bool {
+	if it.err != nil || !it.rows.Next() {
+		return false
+	}
+	it.email, it.cursor, it.err = it.scan(it.rows)
+	return it.err == nil
+}
+
+func (it *EmailIterator) Email() Email {
+	return it.email
+}
+
+func (it *EmailIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.rows.Err()
+}
+
+func (it *EmailIterator) Close() error {
+	return it.rows.Close()
+}
+
+// listPage reads one page from an iterator opened with a limit one larger
+// than wanted, so it knows whether another page follows.
+func listPage(it *EmailIterator, limit int) (EmailPage, error) {
+	defer it.Close()
+
+	page := EmailPage{Emails: []Email{}}
+	var last cursor
+	for it.Next() {
+		if limit > 0 && len(page.Emails) == limit {
+			page.NextCursor = last.encode()
+			break
+		}
+		page.Emails = append(page.Emails, it.Email())
+		last = it.cursor
+	}
+	return page, it.Err()
+}
+
+// pageLimit is the number of rows to fetch for a page of limit emails.
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return 0
+	}
+	return limit + 1
+}
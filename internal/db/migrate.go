@@ -0,0 +1,243 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is one numbered schema change. Files are named
+// NNNN_description.up.sql and NNNN_description.down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus describes a migration and whether it has been applied.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt string
+}
+
+// loadMigrations reads the migrations embedded for dialect, sorted by version.
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimSuffix(name, "."+direction+".sql"), "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("migration %s: expected NNNN_description.%s.sql", name, direction)
+		}
+
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up step", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// migrator applies migrations to a database, recording them in
+// schema_migrations. Every step runs in its own transaction.
+type migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func newMigrator(db *sql.DB, dialect string) (*migrator, error) {
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	return &migrator{db: db, migrations: migrations}, nil
+}
+
+func (m *migrator) latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *migrator) applied() (map[int]string, error) {
+	rows, err := m.db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]string{}
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func (m *migrator) version() (int, error) {
+	var version sql.NullInt64
+	err := m.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+	return int(version.Int64), err
+}
+
+func (m *migrator) status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+	}
+	return statuses, nil
+}
+
+// plan returns the steps needed to reach target, in the order to run them,
+// and whether they are down steps.
+func (m *migrator) plan(target int) ([]Migration, bool, error) {
+	if target < 0 || target > m.latest() {
+		return nil, false, fmt.Errorf("unknown schema version %d (latest is %d)", target, m.latest())
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var steps []Migration
+	current, err := m.version()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if target >= current {
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; !ok && migration.Version <= target {
+				steps = append(steps, migration)
+			}
+		}
+		return steps, false, nil
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; ok && migration.Version > target {
+			steps = append(steps, migration)
+		}
+	}
+	return steps, true, nil
+}
+
+func (m *migrator) run(steps []Migration, down bool) error {
+	for _, migration := range steps {
+		if err := m.step(migration, down); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *migrator) step(migration Migration, down bool) error {
+	direction, script := "up", migration.Up
+	if down {
+		direction, script = "down", migration.Down
+		if script == "" {
+			return fmt.Errorf("migration %04d_%s cannot be reverted: no down step", migration.Version, migration.Name)
+		}
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(script); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %04d_%s %s: %v", migration.Version, migration.Name, direction, err)
+	}
+
+	if down {
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+	} else {
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+			migration.Version, migration.Name, time.Now().UTC().Format(time.RFC3339))
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("Migrated %s %04d_%s", direction, migration.Version, migration.Name)
+	return nil
+}
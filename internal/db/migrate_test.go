@@ -0,0 +1,259 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrateUpAndDown(t *testing.T) {
+	db := newTestSQLiteDB(t)
+
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus failed: %v", err)
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Errorf("Expected migration %04d_%s to be applied", status.Version, status.Name)
+		}
+	}
+
+	if _, err := db.InsertEmails([]Email{{Subject: "Keep me", SentDate: "Mon, 03 Apr 2023 18:15:16 +0000"}}); err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+
+	backup, err := db.MigrateTo(0)
+	if err != nil {
+		t.Fatalf("MigrateTo(0) failed: %v", err)
+	}
+	if backup == "" {
+		t.Fatalf("Expected a backup before migrating a database with data")
+	}
+
+	// The backup holds the data from before the down migration.
+	backupDB, err := sql.Open("sqlite3", backup)
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer backupDB.Close()
+	var subject string
+	if err := backupDB.QueryRow(`SELECT subject FROM emails`).Scan(&subject); err != nil || subject != "Keep me" {
+		t.Errorf("Expected backup to contain the email, got %q, %v", subject, err)
+	}
+
+	if _, err := db.DB.Exec(`SELECT 1 FROM emails`); err == nil {
+		t.Errorf("Expected emails table to be dropped at version 0")
+	}
+
+	if _, err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if _, err := db.ListEmails(EmailFilter{}, ListOptions{}); err != nil {
+		t.Errorf("Expected emails table after migrating up: %v", err)
+	}
+}
+
+// A database created before migrations existed must be adopted without
+// losing data, and backed up first.
+func TestMigrateLegacyDatabase(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "legacy.sqlite")
+
+	legacy, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = legacy.Exec(`CREATE TABLE emails (
+		id INTEGER PRIMARY KEY, "subject" TEXT, "body" TEXT, "from" TEXT, "to" TEXT,
+		"Cc" TEXT, "Bcc" TEXT, "sentDate" TEXT, "sender" TEXT,
+		"read" BOOLEAN DEFAULT 0, "deleted" BOOLEAN DEFAULT 0, "labels" TEXT, created_at DATETIME,
+		UNIQUE(subject, "from", "to", "sentDate"));
+		INSERT INTO emails (subject, body, "from", "to", Cc, Bcc, sentDate, sender, labels, created_at)
+		VALUES ('Old mail', '', '', '', '', '', '2023-04-03 18:15:16', '', '', datetime('now'));`)
+	legacy.Close()
+	if err != nil {
+		t.Fatalf("Failed to create legacy schema: %v", err)
+	}
+
+	db, err := OpenSQLiteDB(path)
+	if err != nil {
+		t.Fatalf("OpenSQLiteDB failed: %v", err)
+	}
+	defer db.DB.Close()
+
+	// Opening it for use does not migrate it behind the user's back.
+	if err := db.checkSchema(); err == nil || !strings.Contains(err.Error(), "db migrate") {
+		t.Fatalf("Expected the legacy schema to be refused, got %v", err)
+	}
+	if backups, _ := filepath.Glob(path + ".v0-*.bak"); len(backups) != 0 {
+		t.Fatalf("Expected no backup before migrating, found %v", backups)
+	}
+	if _, err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if err := db.checkSchema(); err != nil {
+		t.Errorf("Expected the migrated schema to be accepted, got %v", err)
+	}
+
+	page, err := db.ListEmails(EmailFilter{}, ListOptions{})
+	if err != nil || len(page.Emails) != 1 || page.Emails[0].Subject != "Old mail" {
+		t.Errorf("Expected legacy email to survive migration, got %+v, %v", page.Emails, err)
+	}
+
+	backups, _ := filepath.Glob(path + ".v0-*.bak")
+	if len(backups) != 1 {
+		t.Errorf("Expected one pre-migration backup, found %v", backups)
+	}
+	for _, backup := range backups {
+		if info, err := os.Stat(backup); err != nil || info.Size() == 0 {
+			t.Errorf("Expected non-empty backup %s", backup)
+		}
+	}
+}
+
+// A database left at an older version by `db migrate --to` stays there,
+// with its pending migrations listed, until it is migrated again.
+func TestOpenKeepsMigratedDownSchema(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	if _, err := db.MigrateTo(5); err != nil {
+		t.Fatalf("MigrateTo(5) failed: %v", err)
+	}
+
+	if err := db.checkSchema(); err == nil || !strings.Contains(err.Error(), "schema version 5") {
+		t.Errorf("Expected version 5 to be refused, got %v", err)
+	}
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus failed: %v", err)
+	}
+	for _, status := range statuses {
+		if status.Applied != (status.Version <= 5) {
+			t.Errorf("Expected only migrations up to 5 applied, got %04d applied=%v", status.Version, status.Applied)
+		}
+	}
+
+	if _, err := db.DB.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (999, 'future', '')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.checkSchema(); err == nil || !strings.Contains(err.Error(), "newer than this build") {
+		t.Errorf("Expected a newer schema to be refused, got %v", err)
+	}
+}
+
+// Emails in both tables before 0004 are merged into one trashed row that
+// keeps the emails id, and the history of deleted_emails rows follows them.
+func TestMigrateMergesDeletedEmails(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	if _, err := db.MigrateTo(3); err != nil {
+		t.Fatalf("MigrateTo(3) failed: %v", err)
+	}
+
+	_, err := db.DB.Exec(`
+		INSERT INTO emails (id, subject, body, "from", "to", Cc, Bcc, sentDate, sender, labels, created_at) VALUES
+			(1, 'Moved', '', 'a@example.com', '', '', '', '2023-04-03 18:15:16', '', 'INBOX', '2023-04-04 00:00:00'),
+			(2, 'Kept', '', 'b@example.com', '', '', '', '2023-04-03 18:15:16', '', 'CATEGORY_UPDATES', '2023-04-04 00:00:00');
+		INSERT INTO deleted_emails (id, subject, body, "from", "to", Cc, Bcc, sentDate, sender, labels, deleted, created_at) VALUES
+			(1, 'Only trashed', '', 'c@example.com', '', '', '', '2023-04-02 18:15:16', '', 'TRASH', 1, '2023-04-05 00:00:00'),
+			(2, 'Moved', '', 'a@example.com', '', '', '', '2023-04-03 18:15:16', '', 'TRASH', 1, '2023-04-05 00:00:00');
+		UPDATE deleted_emails SET "read" = 1 WHERE id = 1;`)
+	if err != nil {
+		t.Fatalf("Failed to seed version 3: %v", err)
+	}
+
+	if _, err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	emails, err := listAll(t, db, StateAll)
+	if err != nil || len(emails) != 3 {
+		t.Fatalf("Expected 3 merged emails, got %+v, %v", emails, err)
+	}
+	states := map[string]Email{}
+	for _, email := range emails {
+		states[email.Subject] = email
+	}
+	if moved := states["Moved"]; moved.Id != 1 || moved.State != StateTrashed || moved.Labels != "TRASH" || moved.FirstSeenAt == "" {
+		t.Errorf("Expected Moved to keep id 1 and be trashed, got %+v", moved)
+	}
+	if kept := states["Kept"]; kept.State != StateArchived || kept.TrashedAt != "" {
+		t.Errorf("Expected Kept to be archived, got %+v", kept)
+	}
+	only := states["Only trashed"]
+	if only.State != StateTrashed || !only.Deleted || only.TrashedAt == "" {
+		t.Errorf("Expected Only trashed to be trashed, got %+v", only)
+	}
+
+	events, err := db.EmailEvents(only.Id)
+	if err != nil || len(events) != 1 || events[0].Field != EventRead {
+		t.Errorf("Expected the read event to follow Only trashed to id %d, got %+v, %v", only.Id, events, err)
+	}
+
+	var trashed int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM deleted_emails`).Scan(&trashed); err != nil || trashed != 2 {
+		t.Errorf("Expected the deleted_emails view to list 2 emails, got %d, %v", trashed, err)
+	}
+}
+
+// Rows from before 0005 get sent_at from their stored wall clock, read as UTC.
+func TestMigrateBackfillsSentAt(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	if _, err := db.MigrateTo(4); err != nil {
+		t.Fatalf("MigrateTo(4) failed: %v", err)
+	}
+	_, err := db.DB.Exec(`INSERT INTO emails (subject, body, "from", "to", Cc, Bcc, sentDate, sender, labels, created_at)
+		VALUES ('Old mail', '', '', '', '', '', '2023-04-03 18:15:16', '', '', datetime('now'))`)
+	if err != nil {
+		t.Fatalf("Failed to seed version 4: %v", err)
+	}
+	if _, err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	email, err := db.FindEmail(EmailFilter{Subject: "Old mail"})
+	if err != nil || email.SentAt.Format(time.RFC3339) != "2023-04-03T18:15:16Z" || email.SentDate != "2023-04-03 18:15:16" {
+		t.Errorf("Expected sent_at backfilled from sentDate, got %+v, %v", email, err)
+	}
+}
+
+// Rows from before 0011 had the read flag inverted; it is set from the
+// READ label again without recording events.
+func TestMigrateFixesReadFlag(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	if _, err := db.MigrateTo(10); err != nil {
+		t.Fatalf("MigrateTo(10) failed: %v", err)
+	}
+	_, err := db.DB.Exec(`INSERT INTO emails (subject, body, "from", "to", Cc, Bcc, sentDate, sender, labels, "read", created_at) VALUES
+		('Read mail', '', '', '', '', '', '2023-04-03 18:15:16', '', 'INBOX, READ', 0, datetime('now')),
+		('Unread mail', '', '', '', '', '', '2023-04-04 18:15:16', '', 'INBOX, UNREAD', 1, datetime('now')),
+		('Unlabelled mail', '', '', '', '', '', '2023-04-05 18:15:16', '', '', 1, datetime('now'))`)
+	if err != nil {
+		t.Fatalf("Failed to seed version 10: %v", err)
+	}
+	if _, err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	for subject, want := range map[string]bool{"Read mail": true, "Unread mail": false, "Unlabelled mail": false} {
+		email, err := db.FindEmail(EmailFilter{Subject: subject})
+		if err != nil || email.Read != want {
+			t.Errorf("Expected %s to be read=%v, got %+v, %v", subject, want, email, err)
+		}
+	}
+	var events int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM email_events`).Scan(&events); err != nil || events != 0 {
+		t.Errorf("Expected no events from the migration, got %d, %v", events, err)
+	}
+
+	// Later changes are still recorded.
+	email, _ := db.FindEmail(EmailFilter{Subject: "Unread mail"})
+	if err := db.UpdateEmailReadStatus(email.Id, true); err != nil {
+		t.Fatalf("UpdateEmailReadStatus failed: %v", err)
+	}
+	if history, err := db.EmailEvents(email.Id); err != nil || len(history) != 1 || history[0].Field != "read" {
+		t.Errorf("Expected the read change to be recorded, got %+v, %v", history, err)
+	}
+}
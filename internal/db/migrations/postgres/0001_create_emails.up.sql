@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS emails (
+	id BIGSERIAL PRIMARY KEY,
+	"subject" TEXT NOT NULL DEFAULT '',
+	"body" TEXT NOT NULL DEFAULT '',
+	"from" TEXT NOT NULL DEFAULT '',
+	"to" TEXT NOT NULL DEFAULT '',
+	"cc" TEXT NOT NULL DEFAULT '',
+	"bcc" TEXT NOT NULL DEFAULT '',
+	"sent_date" TIMESTAMPTZ NOT NULL,
+	"sender" TEXT NOT NULL DEFAULT '',
+	"read" BOOLEAN NOT NULL DEFAULT FALSE,
+	"deleted" BOOLEAN NOT NULL DEFAULT FALSE,
+	"labels" TEXT[] NOT NULL DEFAULT '{}',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	UNIQUE("subject", "from", "to", "sent_date")
+);
+
+CREATE TABLE IF NOT EXISTS deleted_emails (
+	id BIGSERIAL PRIMARY KEY,
+	"subject" TEXT NOT NULL DEFAULT '',
+	"body" TEXT NOT NULL DEFAULT '',
+	"from" TEXT NOT NULL DEFAULT '',
+	"to" TEXT NOT NULL DEFAULT '',
+	"cc" TEXT NOT NULL DEFAULT '',
+	"bcc" TEXT NOT NULL DEFAULT '',
+	"sent_date" TIMESTAMPTZ NOT NULL,
+	"sender" TEXT NOT NULL DEFAULT '',
+	"read" BOOLEAN NOT NULL DEFAULT FALSE,
+	"deleted" BOOLEAN NOT NULL DEFAULT FALSE,
+	"labels" TEXT[] NOT NULL DEFAULT '{}',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	UNIQUE("subject", "from", "to", "sent_date")
+);
@@ -0,0 +1,4 @@
+ALTER TABLE emails DROP COLUMN "size";
+ALTER TABLE emails DROP COLUMN "has_attachment";
+ALTER TABLE deleted_emails DROP COLUMN "size";
+ALTER TABLE deleted_emails DROP COLUMN "has_attachment";
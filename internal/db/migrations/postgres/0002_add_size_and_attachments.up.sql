@@ -0,0 +1,4 @@
+ALTER TABLE emails ADD COLUMN "size" BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE emails ADD COLUMN "has_attachment" BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE deleted_emails ADD COLUMN "size" BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE deleted_emails ADD COLUMN "has_attachment" BOOLEAN NOT NULL DEFAULT FALSE;
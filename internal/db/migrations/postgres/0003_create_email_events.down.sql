@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS emails_events_update ON emails;
+DROP TRIGGER IF EXISTS deleted_emails_events_update ON deleted_emails;
+DROP FUNCTION IF EXISTS record_email_events();
+DROP TABLE IF EXISTS email_events;
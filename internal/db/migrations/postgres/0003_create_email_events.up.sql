@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS email_events (
+	id BIGSERIAL PRIMARY KEY,
+	"source" TEXT NOT NULL,
+	email_id BIGINT NOT NULL,
+	"field" TEXT NOT NULL,
+	old_value TEXT,
+	new_value TEXT,
+	observed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS email_events_email ON email_events ("source", email_id, observed_at);
+
+CREATE OR REPLACE FUNCTION record_email_events() RETURNS trigger AS $$
+BEGIN
+	IF OLD."labels" IS DISTINCT FROM NEW."labels" THEN
+		INSERT INTO email_events ("source", email_id, "field", old_value, new_value)
+		VALUES (TG_TABLE_NAME, NEW.id, 'labels', array_to_string(OLD."labels", ', '), array_to_string(NEW."labels", ', '));
+	END IF;
+	IF OLD."read" IS DISTINCT FROM NEW."read" THEN
+		INSERT INTO email_events ("source", email_id, "field", old_value, new_value)
+		VALUES (TG_TABLE_NAME, NEW.id, 'read', OLD."read"::text, NEW."read"::text);
+	END IF;
+	IF OLD."deleted" IS DISTINCT FROM NEW."deleted" THEN
+		INSERT INTO email_events ("source", email_id, "field", old_value, new_value)
+		VALUES (TG_TABLE_NAME, NEW.id, 'deleted', OLD."deleted"::text, NEW."deleted"::text);
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER emails_events_update AFTER UPDATE ON emails
+	FOR EACH ROW EXECUTE FUNCTION record_email_events();
+CREATE TRIGGER deleted_emails_events_update AFTER UPDATE ON deleted_emails
+	FOR EACH ROW EXECUTE FUNCTION record_email_events();
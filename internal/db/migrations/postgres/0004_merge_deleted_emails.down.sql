@@ -0,0 +1,64 @@
+-- Split trashed emails back out into a deleted_emails table. Trashed rows
+-- stay in emails too, as they could before the merge.
+
+DROP TRIGGER IF EXISTS emails_events_update ON emails;
+DROP VIEW IF EXISTS deleted_emails;
+
+CREATE TABLE deleted_emails (
+	id BIGSERIAL PRIMARY KEY,
+	"subject" TEXT NOT NULL DEFAULT '',
+	"body" TEXT NOT NULL DEFAULT '',
+	"from" TEXT NOT NULL DEFAULT '',
+	"to" TEXT NOT NULL DEFAULT '',
+	"cc" TEXT NOT NULL DEFAULT '',
+	"bcc" TEXT NOT NULL DEFAULT '',
+	"sent_date" TIMESTAMPTZ NOT NULL,
+	"sender" TEXT NOT NULL DEFAULT '',
+	"read" BOOLEAN NOT NULL DEFAULT FALSE,
+	"deleted" BOOLEAN NOT NULL DEFAULT FALSE,
+	"labels" TEXT[] NOT NULL DEFAULT '{}',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	"size" BIGINT NOT NULL DEFAULT 0,
+	"has_attachment" BOOLEAN NOT NULL DEFAULT FALSE,
+	UNIQUE("subject", "from", "to", "sent_date")
+);
+
+INSERT INTO deleted_emails ("subject", "body", "from", "to", "cc", "bcc", "sent_date", "sender", "read", "deleted",
+	"labels", "size", "has_attachment", created_at)
+SELECT "subject", "body", "from", "to", "cc", "bcc", "sent_date", "sender", "read", "deleted",
+	"labels", "size", "has_attachment", COALESCE(trashed_at, created_at)
+FROM emails WHERE "state" = 'trashed';
+
+DROP INDEX IF EXISTS email_events_email;
+ALTER TABLE email_events ADD COLUMN "source" TEXT NOT NULL DEFAULT 'emails';
+DELETE FROM email_events WHERE "field" = 'state';
+CREATE INDEX IF NOT EXISTS email_events_email ON email_events ("source", email_id, observed_at);
+
+DROP INDEX IF EXISTS emails_state;
+ALTER TABLE emails
+	DROP COLUMN "state",
+	DROP COLUMN trashed_at,
+	DROP COLUMN first_seen_at;
+
+CREATE OR REPLACE FUNCTION record_email_events() RETURNS trigger AS $$
+BEGIN
+	IF OLD."labels" IS DISTINCT FROM NEW."labels" THEN
+		INSERT INTO email_events ("source", email_id, "field", old_value, new_value)
+		VALUES (TG_TABLE_NAME, NEW.id, 'labels', array_to_string(OLD."labels", ', '), array_to_string(NEW."labels", ', '));
+	END IF;
+	IF OLD."read" IS DISTINCT FROM NEW."read" THEN
+		INSERT INTO email_events ("source", email_id, "field", old_value, new_value)
+		VALUES (TG_TABLE_NAME, NEW.id, 'read', OLD."read"::text, NEW."read"::text);
+	END IF;
+	IF OLD."deleted" IS DISTINCT FROM NEW."deleted" THEN
+		INSERT INTO email_events ("source", email_id, "field", old_value, new_value)
+		VALUES (TG_TABLE_NAME, NEW.id, 'deleted', OLD."deleted"::text, NEW."deleted"::text);
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER emails_events_update AFTER UPDATE ON emails
+	FOR EACH ROW EXECUTE FUNCTION record_email_events();
+CREATE TRIGGER deleted_emails_events_update AFTER UPDATE ON deleted_emails
+	FOR EACH ROW EXECUTE FUNCTION record_email_events();
@@ -0,0 +1,82 @@
+-- Merge deleted_emails into emails. Each email is stored once, with its
+-- mailbox state; deleted_emails becomes a view over the trashed ones.
+
+DROP TRIGGER IF EXISTS emails_events_update ON emails;
+DROP TRIGGER IF EXISTS deleted_emails_events_update ON deleted_emails;
+
+ALTER TABLE emails
+	ADD COLUMN "state" TEXT NOT NULL DEFAULT 'inbox',
+	ADD COLUMN trashed_at TIMESTAMPTZ,
+	ADD COLUMN first_seen_at TIMESTAMPTZ;
+
+UPDATE emails SET
+	first_seen_at = created_at,
+	"state" = CASE
+		WHEN "deleted" OR 'TRASH' = ANY("labels") THEN 'trashed'
+		WHEN 'SPAM' = ANY("labels") THEN 'spam'
+		WHEN 'INBOX' = ANY("labels") THEN 'inbox'
+		ELSE 'archived'
+	END;
+UPDATE emails SET trashed_at = created_at WHERE "state" = 'trashed';
+
+-- An email in both tables was seen in the trash: keep the emails row and
+-- its id, taking the trashed copy's labels.
+UPDATE emails AS e SET
+	"state" = 'trashed',
+	"deleted" = TRUE,
+	"labels" = d."labels",
+	trashed_at = d.created_at,
+	first_seen_at = LEAST(e.first_seen_at, d.created_at)
+FROM deleted_emails AS d
+WHERE d."subject" = e."subject" AND d."from" = e."from" AND d."to" = e."to" AND d."sent_date" = e."sent_date";
+
+INSERT INTO emails ("subject", "body", "from", "to", "cc", "bcc", "sent_date", "sender", "read", "deleted",
+	"labels", "size", "has_attachment", created_at, "state", trashed_at, first_seen_at)
+SELECT d."subject", d."body", d."from", d."to", d."cc", d."bcc", d."sent_date", d."sender", d."read", TRUE,
+	d."labels", d."size", d."has_attachment", d.created_at, 'trashed', d.created_at, d.created_at
+FROM deleted_emails AS d
+ON CONFLICT ("subject", "from", "to", "sent_date") DO NOTHING;
+
+-- Point the history of deleted_emails rows at their merged row.
+UPDATE email_events AS ev SET email_id = e.id
+FROM deleted_emails AS d
+JOIN emails AS e ON e."subject" = d."subject" AND e."from" = d."from" AND e."to" = d."to" AND e."sent_date" = d."sent_date"
+WHERE ev."source" = 'deleted_emails' AND ev.email_id = d.id;
+
+DROP INDEX IF EXISTS email_events_email;
+ALTER TABLE email_events DROP COLUMN "source";
+CREATE INDEX IF NOT EXISTS email_events_email ON email_events (email_id, observed_at);
+
+DROP TABLE deleted_emails;
+
+CREATE VIEW deleted_emails AS
+	SELECT id, "subject", "body", "from", "to", "cc", "bcc", "sent_date", "sender", "read", "deleted",
+		"labels", "size", "has_attachment", created_at
+	FROM emails WHERE "state" = 'trashed';
+
+CREATE INDEX IF NOT EXISTS emails_state ON emails ("state");
+
+CREATE OR REPLACE FUNCTION record_email_events() RETURNS trigger AS $$
+BEGIN
+	IF OLD."state" IS DISTINCT FROM NEW."state" THEN
+		INSERT INTO email_events (email_id, "field", old_value, new_value)
+		VALUES (NEW.id, 'state', OLD."state", NEW."state");
+	END IF;
+	IF OLD."labels" IS DISTINCT FROM NEW."labels" THEN
+		INSERT INTO email_events (email_id, "field", old_value, new_value)
+		VALUES (NEW.id, 'labels', array_to_string(OLD."labels", ', '), array_to_string(NEW."labels", ', '));
+	END IF;
+	IF OLD."read" IS DISTINCT FROM NEW."read" THEN
+		INSERT INTO email_events (email_id, "field", old_value, new_value)
+		VALUES (NEW.id, 'read', OLD."read"::text, NEW."read"::text);
+	END IF;
+	IF OLD."deleted" IS DISTINCT FROM NEW."deleted" THEN
+		INSERT INTO email_events (email_id, "field", old_value, new_value)
+		VALUES (NEW.id, 'deleted', OLD."deleted"::text, NEW."deleted"::text);
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER emails_events_update AFTER UPDATE ON emails
+	FOR EACH ROW EXECUTE FUNCTION record_email_events();
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS emails_sent_date;
+ALTER TABLE emails DROP COLUMN date_header;
+ALTER TABLE emails DROP COLUMN sent_offset;
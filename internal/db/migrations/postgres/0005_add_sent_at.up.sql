@@ -0,0 +1,8 @@
+-- sent_date already holds the instant; sent_offset keeps the sender's
+-- offset from UTC in minutes, and date_header the original Date header.
+-- Rows stored before this migration lost the offset and read as UTC until
+-- the email is synced again.
+ALTER TABLE emails ADD COLUMN sent_offset INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE emails ADD COLUMN date_header TEXT;
+
+CREATE INDEX IF NOT EXISTS emails_sent_date ON emails ("sent_date");
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS email_addresses;
+DROP TABLE IF EXISTS contacts;
+ALTER TABLE emails DROP COLUMN reply_to;
@@ -0,0 +1,9 @@
+-- Syncs before this migration stored the read flag inverted: "read" was
+-- set when Gmail had the email UNREAD. Every sync stored the READ label
+-- exactly when it did not, so the flag is set from the labels again. The
+-- change records no email_events: nothing happened to the emails.
+ALTER TABLE emails DISABLE TRIGGER emails_events_update;
+
+UPDATE emails SET "read" = EXISTS (SELECT 1 FROM unnest("labels") AS l WHERE upper(l) = 'READ');
+
+ALTER TABLE emails ENABLE TRIGGER emails_events_update;
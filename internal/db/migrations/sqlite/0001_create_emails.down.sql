@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS deleted_emails;
+DROP TABLE IF EXISTS emails;
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS emails (
+	id INTEGER PRIMARY KEY,
+	"subject" TEXT,
+	"body" TEXT,
+	"from" TEXT,
+	"to"	TEXT,
+	"Cc" TEXT,
+	"Bcc" TEXT,
+	"sentDate" TEXT,
+	"sender" TEXT,
+	"read" BOOLEAN DEFAULT 0,
+	"deleted" BOOLEAN DEFAULT 0,
+	"labels" TEXT,
+	created_at DATETIME,
+	UNIQUE(subject, "from", "to", "sentDate")
+);
+
+CREATE TABLE IF NOT EXISTS deleted_emails (
+	id INTEGER PRIMARY KEY,
+	"subject" TEXT,
+	"body" TEXT,
+	"from" TEXT,
+	"to"	TEXT,
+	"Cc" TEXT,
+	"Bcc" TEXT,
+	"sentDate" TEXT,
+	"sender" TEXT,
+	"read" BOOLEAN DEFAULT 0,
+	"deleted" BOOLEAN DEFAULT 0,
+	"labels" TEXT,
+	created_at DATETIME,
+	UNIQUE(subject, "from", "to", "sentDate")
+);
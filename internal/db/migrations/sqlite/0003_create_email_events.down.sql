@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS emails_events_update;
+DROP TRIGGER IF EXISTS deleted_emails_events_update;
+DROP TABLE IF EXISTS email_events;
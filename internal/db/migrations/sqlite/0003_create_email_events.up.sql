@@ -0,0 +1,47 @@
+CREATE TABLE IF NOT EXISTS email_events (
+	id INTEGER PRIMARY KEY,
+	"source" TEXT NOT NULL,
+	email_id INTEGER NOT NULL,
+	"field" TEXT NOT NULL,
+	old_value TEXT,
+	new_value TEXT,
+	observed_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS email_events_email ON email_events ("source", email_id, observed_at);
+
+CREATE TRIGGER emails_events_update AFTER UPDATE ON emails BEGIN
+	INSERT INTO email_events ("source", email_id, "field", old_value, new_value, observed_at)
+	SELECT 'emails', new.id, 'labels', old."labels", new."labels", datetime('now')
+	WHERE old."labels" IS NOT new."labels";
+	INSERT INTO email_events ("source", email_id, "field", old_value, new_value, observed_at)
+	SELECT 'emails', new.id, 'read',
+		CASE WHEN old."read" THEN 'true' ELSE 'false' END,
+		CASE WHEN new."read" THEN 'true' ELSE 'false' END,
+		datetime('now')
+	WHERE old."read" IS NOT new."read";
+	INSERT INTO email_events ("source", email_id, "field", old_value, new_value, observed_at)
+	SELECT 'emails', new.id, 'deleted',
+		CASE WHEN old."deleted" THEN 'true' ELSE 'false' END,
+		CASE WHEN new."deleted" THEN 'true' ELSE 'false' END,
+		datetime('now')
+	WHERE old."deleted" IS NOT new."deleted";
+END;
+
+CREATE TRIGGER deleted_emails_events_update AFTER UPDATE ON deleted_emails BEGIN
+	INSERT INTO email_events ("source", email_id, "field", old_value, new_value, observed_at)
+	SELECT 'deleted_emails', new.id, 'labels', old."labels", new."labels", datetime('now')
+	WHERE old."labels" IS NOT new."labels";
+	INSERT INTO email_events ("source", email_id, "field", old_value, new_value, observed_at)
+	SELECT 'deleted_emails', new.id, 'read',
+		CASE WHEN old."read" THEN 'true' ELSE 'false' END,
+		CASE WHEN new."read" THEN 'true' ELSE 'false' END,
+		datetime('now')
+	WHERE old."read" IS NOT new."read";
+	INSERT INTO email_events ("source", email_id, "field", old_value, new_value, observed_at)
+	SELECT 'deleted_emails', new.id, 'deleted',
+		CASE WHEN old."deleted" THEN 'true' ELSE 'false' END,
+		CASE WHEN new."deleted" THEN 'true' ELSE 'false' END,
+		datetime('now')
+	WHERE old."deleted" IS NOT new."deleted";
+END;
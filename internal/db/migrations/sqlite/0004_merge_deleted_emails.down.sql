@@ -0,0 +1,79 @@
+-- Split trashed emails back out into a deleted_emails table. Trashed rows
+-- stay in emails too, as they could before the merge.
+
+DROP TRIGGER IF EXISTS emails_events_update;
+DROP TRIGGER IF EXISTS emails_search_insert;
+DROP TRIGGER IF EXISTS emails_search_delete;
+DROP TRIGGER IF EXISTS emails_search_update;
+DROP VIEW IF EXISTS deleted_emails;
+
+CREATE TABLE deleted_emails (
+	id INTEGER PRIMARY KEY,
+	"subject" TEXT,
+	"body" TEXT,
+	"from" TEXT,
+	"to"	TEXT,
+	"Cc" TEXT,
+	"Bcc" TEXT,
+	"sentDate" TEXT,
+	"sender" TEXT,
+	"read" BOOLEAN DEFAULT 0,
+	"deleted" BOOLEAN DEFAULT 0,
+	"labels" TEXT,
+	created_at DATETIME,
+	"size" INTEGER NOT NULL DEFAULT 0,
+	"has_attachment" BOOLEAN NOT NULL DEFAULT 0,
+	UNIQUE(subject, "from", "to", "sentDate")
+);
+
+INSERT INTO deleted_emails ("subject", "body", "from", "to", "Cc", "Bcc", "sentDate", "sender", "read", "deleted",
+	"labels", "size", "has_attachment", created_at)
+SELECT "subject", "body", "from", "to", "Cc", "Bcc", "sentDate", "sender", "read", "deleted",
+	"labels", "size", "has_attachment", COALESCE(trashed_at, created_at)
+FROM emails WHERE "state" = 'trashed';
+
+DROP INDEX IF EXISTS email_events_email;
+ALTER TABLE email_events ADD COLUMN "source" TEXT NOT NULL DEFAULT 'emails';
+DELETE FROM email_events WHERE "field" = 'state';
+CREATE INDEX IF NOT EXISTS email_events_email ON email_events ("source", email_id, observed_at);
+
+DROP INDEX IF EXISTS emails_state;
+ALTER TABLE emails DROP COLUMN "state";
+ALTER TABLE emails DROP COLUMN trashed_at;
+ALTER TABLE emails DROP COLUMN first_seen_at;
+
+CREATE TRIGGER emails_events_update AFTER UPDATE ON emails BEGIN
+	INSERT INTO email_events ("source", email_id, "field", old_value, new_value, observed_at)
+	SELECT 'emails', new.id, 'labels', old."labels", new."labels", datetime('now')
+	WHERE old."labels" IS NOT new."labels";
+	INSERT INTO email_events ("source", email_id, "field", old_value, new_value, observed_at)
+	SELECT 'emails', new.id, 'read',
+		CASE WHEN old."read" THEN 'true' ELSE 'false' END,
+		CASE WHEN new."read" THEN 'true' ELSE 'false' END,
+		datetime('now')
+	WHERE old."read" IS NOT new."read";
+	INSERT INTO email_events ("source", email_id, "field", old_value, new_value, observed_at)
+	SELECT 'emails', new.id, 'deleted',
+		CASE WHEN old."deleted" THEN 'true' ELSE 'false' END,
+		CASE WHEN new."deleted" THEN 'true' ELSE 'false' END,
+		datetime('now')
+	WHERE old."deleted" IS NOT new."deleted";
+END;
+
+CREATE TRIGGER deleted_emails_events_update AFTER UPDATE ON deleted_emails BEGIN
+	INSERT INTO email_events ("source", email_id, "field", old_value, new_value, observed_at)
+	SELECT 'deleted_emails', new.id, 'labels', old."labels", new."labels", datetime('now')
+	WHERE old."labels" IS NOT new."labels";
+	INSERT INTO email_events ("source", email_id, "field", old_value, new_value, observed_at)
+	SELECT 'deleted_emails', new.id, 'read',
+		CASE WHEN old."read" THEN 'true' ELSE 'false' END,
+		CASE WHEN new."read" THEN 'true' ELSE 'false' END,
+		datetime('now')
+	WHERE old."read" IS NOT new."read";
+	INSERT INTO email_events ("source", email_id, "field", old_value, new_value, observed_at)
+	SELECT 'deleted_emails', new.id, 'deleted',
+		CASE WHEN old."deleted" THEN 'true' ELSE 'false' END,
+		CASE WHEN new."deleted" THEN 'true' ELSE 'false' END,
+		datetime('now')
+	WHERE old."deleted" IS NOT new."deleted";
+END;
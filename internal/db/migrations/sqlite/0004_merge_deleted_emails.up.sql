@@ -0,0 +1,86 @@
+-- Merge deleted_emails into emails. Each email is stored once, with its
+-- mailbox state; deleted_emails becomes a view over the trashed ones.
+
+DROP TRIGGER IF EXISTS emails_events_update;
+DROP TRIGGER IF EXISTS deleted_emails_events_update;
+
+ALTER TABLE emails ADD COLUMN "state" TEXT NOT NULL DEFAULT 'inbox';
+ALTER TABLE emails ADD COLUMN trashed_at DATETIME;
+ALTER TABLE emails ADD COLUMN first_seen_at DATETIME;
+
+UPDATE emails SET
+	first_seen_at = created_at,
+	"state" = CASE
+		WHEN "deleted" OR (',' || REPLACE(UPPER(COALESCE("labels", '')), ' ', '') || ',') LIKE '%,TRASH,%' THEN 'trashed'
+		WHEN (',' || REPLACE(UPPER(COALESCE("labels", '')), ' ', '') || ',') LIKE '%,SPAM,%' THEN 'spam'
+		WHEN (',' || REPLACE(UPPER(COALESCE("labels", '')), ' ', '') || ',') LIKE '%,INBOX,%' THEN 'inbox'
+		ELSE 'archived'
+	END;
+UPDATE emails SET trashed_at = created_at WHERE "state" = 'trashed';
+
+-- An email in both tables was seen in the trash: keep the emails row and
+-- its id, taking the trashed copy's labels.
+UPDATE emails SET
+	"state" = 'trashed',
+	"deleted" = 1,
+	"labels" = d."labels",
+	trashed_at = d.created_at,
+	first_seen_at = MIN(COALESCE(emails.first_seen_at, d.created_at), d.created_at)
+FROM deleted_emails AS d
+WHERE d."subject" = emails."subject" AND d."from" = emails."from"
+	AND d."to" = emails."to" AND d."sentDate" = emails."sentDate";
+
+INSERT INTO emails ("subject", "body", "from", "to", "Cc", "Bcc", "sentDate", "sender", "read", "deleted",
+	"labels", "size", "has_attachment", created_at, "state", trashed_at, first_seen_at)
+SELECT d."subject", d."body", d."from", d."to", d."Cc", d."Bcc", d."sentDate", d."sender", d."read", 1,
+	d."labels", d."size", d."has_attachment", d.created_at, 'trashed', d.created_at, d.created_at
+FROM deleted_emails AS d
+WHERE NOT EXISTS (SELECT 1 FROM emails AS e
+	WHERE e."subject" = d."subject" AND e."from" = d."from" AND e."to" = d."to" AND e."sentDate" = d."sentDate");
+
+-- Point the history of deleted_emails rows at their merged row.
+UPDATE email_events SET email_id = (
+	SELECT e.id FROM deleted_emails AS d JOIN emails AS e
+		ON e."subject" = d."subject" AND e."from" = d."from" AND e."to" = d."to" AND e."sentDate" = d."sentDate"
+	WHERE d.id = email_events.email_id
+) WHERE "source" = 'deleted_emails';
+DELETE FROM email_events WHERE email_id IS NULL;
+
+DROP INDEX IF EXISTS email_events_email;
+ALTER TABLE email_events DROP COLUMN "source";
+CREATE INDEX IF NOT EXISTS email_events_email ON email_events (email_id, observed_at);
+
+DROP TABLE deleted_emails;
+
+CREATE VIEW deleted_emails AS
+	SELECT id, "subject", "body", "from", "to", "Cc", "Bcc", "sentDate", "sender", "read", "deleted",
+		"labels", "size", "has_attachment", created_at
+	FROM emails WHERE "state" = 'trashed';
+
+CREATE INDEX IF NOT EXISTS emails_state ON emails ("state");
+
+-- The full-text index is rebuilt for the merged table when next opened.
+DROP TRIGGER IF EXISTS emails_search_insert;
+DROP TRIGGER IF EXISTS emails_search_delete;
+DROP TRIGGER IF EXISTS emails_search_update;
+
+CREATE TRIGGER emails_events_update AFTER UPDATE ON emails BEGIN
+	INSERT INTO email_events (email_id, "field", old_value, new_value, observed_at)
+	SELECT new.id, 'state', old."state", new."state", datetime('now')
+	WHERE old."state" IS NOT new."state";
+	INSERT INTO email_events (email_id, "field", old_value, new_value, observed_at)
+	SELECT new.id, 'labels', old."labels", new."labels", datetime('now')
+	WHERE old."labels" IS NOT new."labels";
+	INSERT INTO email_events (email_id, "field", old_value, new_value, observed_at)
+	SELECT new.id, 'read',
+		CASE WHEN old."read" THEN 'true' ELSE 'false' END,
+		CASE WHEN new."read" THEN 'true' ELSE 'false' END,
+		datetime('now')
+	WHERE old."read" IS NOT new."read";
+	INSERT INTO email_events (email_id, "field", old_value, new_value, observed_at)
+	SELECT new.id, 'deleted',
+		CASE WHEN old."deleted" THEN 'true' ELSE 'false' END,
+		CASE WHEN new."deleted" THEN 'true' ELSE 'false' END,
+		datetime('now')
+	WHERE old."deleted" IS NOT new."deleted";
+END;
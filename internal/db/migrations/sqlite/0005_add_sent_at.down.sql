@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS emails_sent_at;
+ALTER TABLE emails DROP COLUMN date_header;
+ALTER TABLE emails DROP COLUMN sent_offset;
+ALTER TABLE emails DROP COLUMN sent_at;
@@ -0,0 +1,12 @@
+-- sent_at is the instant an email was sent, in seconds since the epoch,
+-- and sent_offset the sender's offset from UTC in minutes. Rows stored
+-- before this migration kept only the sender's wall clock time, so they
+-- are backfilled as if it were UTC; the next sync of each email corrects
+-- them from its Date header, which is now kept in date_header.
+ALTER TABLE emails ADD COLUMN sent_at INTEGER;
+ALTER TABLE emails ADD COLUMN sent_offset INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE emails ADD COLUMN date_header TEXT;
+
+UPDATE emails SET sent_at = CAST(strftime('%s', "sentDate") AS INTEGER);
+
+CREATE INDEX IF NOT EXISTS emails_sent_at ON emails (sent_at);
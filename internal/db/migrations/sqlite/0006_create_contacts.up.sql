@@ -0,0 +1,19 @@
+-- Parsed From/To/Cc/Bcc/Reply-To addresses. A contact is one address,
+-- lower-cased, with the latest display name seen for it.
+ALTER TABLE emails ADD COLUMN reply_to TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS contacts (
+	id INTEGER PRIMARY KEY,
+	address TEXT NOT NULL UNIQUE,
+	"name" TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE TABLE IF NOT EXISTS email_addresses (
+	email_id INTEGER NOT NULL REFERENCES emails (id) ON DELETE CASCADE,
+	contact_id INTEGER NOT NULL REFERENCES contacts (id),
+	"role" TEXT NOT NULL,
+	PRIMARY KEY (email_id, "role", contact_id)
+);
+
+CREATE INDEX IF NOT EXISTS email_addresses_contact ON email_addresses (contact_id, "role");
@@ -0,0 +1,13 @@
+-- The format=raw source of each fetched email, gzip-compressed, so that
+-- derived columns can be rebuilt without fetching it again. label_ids is
+-- Gmail's label list joined with commas; sha256 is of the uncompressed data.
+CREATE TABLE IF NOT EXISTS raw_messages (
+	email_id INTEGER PRIMARY KEY REFERENCES emails (id) ON DELETE CASCADE,
+	gmail_id TEXT NOT NULL DEFAULT '',
+	label_ids TEXT NOT NULL DEFAULT '',
+	internal_date INTEGER NOT NULL DEFAULT 0,
+	size_estimate INTEGER NOT NULL DEFAULT 0,
+	sha256 TEXT NOT NULL,
+	data BLOB NOT NULL,
+	fetched_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
@@ -0,0 +1,22 @@
+-- One classification of an email by a model and prompt version. The
+-- unique key lets a batch run skip emails it has already paid for.
+-- label is the suggested action; raw_response is the model's answer.
+CREATE TABLE IF NOT EXISTS predictions (
+	id INTEGER PRIMARY KEY,
+	email_id INTEGER NOT NULL REFERENCES emails (id) ON DELETE CASCADE,
+	model TEXT NOT NULL,
+	prompt_name TEXT NOT NULL DEFAULT '',
+	prompt_version TEXT NOT NULL,
+	label TEXT NOT NULL,
+	category TEXT NOT NULL DEFAULT '',
+	confidence REAL NOT NULL DEFAULT 0,
+	reason TEXT NOT NULL DEFAULT '',
+	raw_response TEXT NOT NULL DEFAULT '',
+	latency_ms INTEGER NOT NULL DEFAULT 0,
+	prompt_tokens INTEGER NOT NULL DEFAULT 0,
+	completion_tokens INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+	UNIQUE (email_id, model, prompt_version)
+);
+
+CREATE INDEX IF NOT EXISTS predictions_run ON predictions (model, prompt_version);
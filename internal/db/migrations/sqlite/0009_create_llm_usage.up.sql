@@ -0,0 +1,14 @@
+-- Every chat request that was paid for: the tokens the API reported and
+-- their cost in USD at the configured prices. created_at is in seconds
+-- since the epoch, so that budgets can sum any period.
+CREATE TABLE IF NOT EXISTS llm_usage (
+	id INTEGER PRIMARY KEY,
+	model TEXT NOT NULL,
+	operation TEXT NOT NULL DEFAULT '',
+	prompt_tokens INTEGER NOT NULL DEFAULT 0,
+	completion_tokens INTEGER NOT NULL DEFAULT 0,
+	cost REAL NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS llm_usage_created_at ON llm_usage (created_at);
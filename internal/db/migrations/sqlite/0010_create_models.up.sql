@@ -0,0 +1,16 @@
+-- Fine-tuning jobs and the models they produce. name is empty until the
+-- job succeeds. Times are in seconds since the epoch; finished_at is 0
+-- while the job runs.
+CREATE TABLE IF NOT EXISTS models (
+	id INTEGER PRIMARY KEY,
+	job_id TEXT NOT NULL UNIQUE,
+	base_model TEXT NOT NULL,
+	name TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL,
+	training_file TEXT NOT NULL,
+	validation_file TEXT NOT NULL DEFAULT '',
+	suffix TEXT NOT NULL DEFAULT '',
+	trained_tokens INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL,
+	finished_at INTEGER NOT NULL DEFAULT 0
+);
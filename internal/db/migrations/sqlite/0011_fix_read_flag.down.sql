@@ -0,0 +1,2 @@
+-- The corrected read flags are kept: the inverted ones carried nothing
+-- the labels do not.
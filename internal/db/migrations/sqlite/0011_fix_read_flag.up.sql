@@ -0,0 +1,28 @@
+-- Syncs before this migration stored the read flag inverted: "read" was
+-- set when Gmail had the email UNREAD. Every sync stored the READ label
+-- exactly when it did not, so the flag is set from the labels again. The
+-- change records no email_events: nothing happened to the emails.
+DROP TRIGGER IF EXISTS emails_events_update;
+
+UPDATE emails SET "read" = ((',' || REPLACE(upper(COALESCE("labels", '')), ' ', '') || ',') LIKE '%,READ,%');
+
+CREATE TRIGGER emails_events_update AFTER UPDATE ON emails BEGIN
+	INSERT INTO email_events (email_id, "field", old_value, new_value, observed_at)
+	SELECT new.id, 'state', old."state", new."state", datetime('now')
+	WHERE old."state" IS NOT new."state";
+	INSERT INTO email_events (email_id, "field", old_value, new_value, observed_at)
+	SELECT new.id, 'labels', old."labels", new."labels", datetime('now')
+	WHERE old."labels" IS NOT new."labels";
+	INSERT INTO email_events (email_id, "field", old_value, new_value, observed_at)
+	SELECT new.id, 'read',
+		CASE WHEN old."read" THEN 'true' ELSE 'false' END,
+		CASE WHEN new."read" THEN 'true' ELSE 'false' END,
+		datetime('now')
+	WHERE old."read" IS NOT new."read";
+	INSERT INTO email_events (email_id, "field", old_value, new_value, observed_at)
+	SELECT new.id, 'deleted',
+		CASE WHEN old."deleted" THEN 'true' ELSE 'false' END,
+		CASE WHEN new."deleted" THEN 'true' ELSE 'false' END,
+		datetime('now')
+	WHERE old."deleted" IS NOT new."deleted";
+END;
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ModelRecord is a fine-tuning job in the models table and the model it
+// produced.
+type ModelRecord struct {
+	ID        int64
+	JobID     string
+	BaseModel string
+	// Name is the fine-tuned model to classify with, empty until the job
+	// succeeds.
+	Name           string
+	Status         string
+	TrainingFile   string
+	ValidationFile string
+	Suffix         string
+	TrainedTokens  int
+	// CreatedAt defaults to now; FinishedAt is zero while the job runs.
+	// Both are kept to the second.
+	CreatedAt  time.Time
+	FinishedAt time.Time
+}
+
+func saveModel(ctx context.Context, db *sql.DB, m *ModelRecord) error {
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now()
+	}
+	var finishedAt int64
+	if !m.FinishedAt.IsZero() {
+		finishedAt = m.FinishedAt.Unix()
+	}
+	return db.QueryRowContext(ctx, `INSERT INTO models
+			(job_id, base_model, name, status, training_file, validation_file, suffix, trained_tokens, created_at, finished_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (job_id) DO UPDATE SET
+			name = excluded.name, status = excluded.status,
+			trained_tokens = excluded.trained_tokens, finished_at = excluded.finished_at
+		RETURNING id`,
+		m.JobID, m.BaseModel, m.Name, m.Status, m.TrainingFile, m.ValidationFile, m.Suffix, m.TrainedTokens,
+		m.CreatedAt.Unix(), finishedAt,
+	).Scan(&m.ID)
+}
+
+// listModels returns the models table, newest job first.
+func listModels(ctx context.Context, db *sql.DB) ([]ModelRecord, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, job_id, base_model, name, status, training_file, validation_file,
+			suffix, trained_tokens, created_at, finished_at
+		FROM models ORDER BY created_at DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var models []ModelRecord
+	for rows.Next() {
+		var m ModelRecord
+		var createdAt, finishedAt int64
+		if err := rows.Scan(&m.ID, &m.JobID, &m.BaseModel, &m.Name, &m.Status, &m.TrainingFile, &m.ValidationFile,
+			&m.Suffix, &m.TrainedTokens, &createdAt, &finishedAt); err != nil {
+			return nil, err
+		}
+		m.CreatedAt = time.Unix(createdAt, 0)
+		if finishedAt != 0 {
+			m.FinishedAt = time.Unix(finishedAt, 0)
+		}
+		models = append(models, m)
+	}
+	return models, rows.Err()
+}
@@ -0,0 +1,567 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresDB stores emails in PostgreSQL. Sent dates are TIMESTAMPTZ and
+// labels a TEXT[] column; Email values use the same string formats as
+// SQLiteDB, with sent dates rendered in UTC.
+type PostgresDB struct {
+	DB *sql.DB
+}
+
+// NewPostgresDB opens the database at dsn and migrates it to the latest
+// schema.
+func NewPostgresDB(dsn string) (*PostgresDB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	postgresDB := &PostgresDB{DB: db}
+	if err := postgresDB.Migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return postgresDB, nil
+}
+
+// Migrate brings the schema up to the latest version. Unlike SQLiteDB no
+// backup is taken; use pg_dump before upgrading a production database.
+func (p *PostgresDB) Migrate() error {
+	m, err := newMigrator(p.DB, "postgres")
+	if err != nil {
+		return err
+	}
+	steps, down, err := m.plan(m.latest())
+	if err != nil {
+		return err
+	}
+	return m.run(steps, down)
+}
+
+// insertValues inserts an email bound as upsertArgs binds it.
+const insertValues = `INSERT INTO emails
+		("subject", "body", "from", "to", "cc", "bcc", "sent_date", "sender", "read", "deleted", "labels", "size", "has_attachment",
+			"state", sent_offset, date_header, reply_to, trashed_at, first_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17,
+			CASE WHEN $14 = 'trashed' THEN now() END, now())`
+
+// insertQuery inserts an email, returning no row if it is already stored.
+const insertQuery = insertValues + `
+		ON CONFLICT ("subject", "from", "to", "sent_date") DO NOTHING
+		RETURNING id`
+
+// upsertQuery inserts an email, updating it in place if already stored.
+// trashed_at keeps the time an email was first seen in the trash and is
+// cleared when it leaves.
+func (p *PostgresDB) upsertQuery() string {
+	return insertValues + `
+		ON CONFLICT ("subject", "from", "to", "sent_date") DO UPDATE SET
+			"body" = EXCLUDED."body",
+			"cc" = EXCLUDED."cc",
+			"bcc" = EXCLUDED."bcc",
+			"sender" = EXCLUDED."sender",
+			"read" = EXCLUDED."read",
+			"deleted" = EXCLUDED."deleted",
+			"labels" = EXCLUDED."labels",
+			"size" = EXCLUDED."size",
+			"has_attachment" = EXCLUDED."has_attachment",
+			"state" = EXCLUDED."state",
+			sent_offset = EXCLUDED.sent_offset,
+			date_header = EXCLUDED.date_header,
+			reply_to = EXCLUDED.reply_to,
+			trashed_at = CASE WHEN EXCLUDED."state" = 'trashed' THEN COALESCE(emails.trashed_at, EXCLUDED.trashed_at) END
+		RETURNING id, (xmax = 0) AS inserted`
+}
+
+// updateQuery sets the columns of upsertQuery, bound the same way, on the
+// email whose id is $18.
+const updateQuery = `UPDATE emails SET
+		"subject" = $1, "body" = $2, "from" = $3, "to" = $4, "cc" = $5, "bcc" = $6, "sent_date" = $7,
+		"sender" = $8, "read" = $9, "deleted" = $10, "labels" = $11, "size" = $12, "has_attachment" = $13,
+		"state" = $14, sent_offset = $15, date_header = $16, reply_to = $17,
+		trashed_at = CASE WHEN $14 = 'trashed' THEN COALESCE(trashed_at, now()) END
+	WHERE id = $18`
+
+func upsertArgs(email *Email, sentDate time.Time) []interface{} {
+	return []interface{}{email.Subject, email.Body, email.From, email.To, email.Cc, email.Bcc,
+		sentDate, email.Sender, email.Read, email.Deleted, pq.Array(splitLabels(email.Labels)),
+		email.Size, email.HasAttachment, string(email.state()), sentOffset(sentDate), email.SentDate,
+		email.ReplyTo}
+}
+
+func (p *PostgresDB) InsertEmail(email *Email) (int64, error) {
+	return p.InsertEmailContext(context.Background(), email)
+}
+
+func (p *PostgresDB) InsertEmailContext(ctx context.Context, email *Email) (int64, error) {
+	sentDate, err := email.sentTime()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, insertQuery, upsertArgs(email, sentDate)...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %s", ErrDuplicate, email.Subject)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	derived, err := newDerivedWriter(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	defer derived.close()
+	if err := derived.write(ctx, id, email); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func (p *PostgresDB) InsertEmails(emails []Email) (int64, error) {
+	return p.InsertEmailsContext(context.Background(), emails)
+}
+
+func (p *PostgresDB) InsertEmailsContext(ctx context.Context, emails []Email) (int64, error) {
+	result, err := p.StoreEmails(ctx, emailChan(emails))
+	logSkipped(result)
+	return result.Stored(), err
+}
+
+func (p *PostgresDB) InsertDeletedEmails(emails []Email) (int64, error) {
+	return p.InsertDeletedEmailsContext(context.Background(), emails)
+}
+
+func (p *PostgresDB) InsertDeletedEmailsContext(ctx context.Context, emails []Email) (int64, error) {
+	result, err := p.StoreEmails(ctx, emailChan(trashedEmails(emails)))
+	logSkipped(result)
+	return result.Stored(), err
+}
+
+// postgresChunkRows is how many rows StoreEmails buffers between writes.
+// Rows are upserted one by one: a multi-row ON CONFLICT statement may not
+// touch the same row twice, and a sync can contain duplicates.
+const postgresChunkRows = 100
+
+// StoreEmails upserts the emails received on the channel until it is
+// closed, with one prepared statement inside a single transaction. Emails
+// whose sent date does not parse are skipped. If ctx is cancelled nothing
+// is written; the sender should stop too.
+func (p *PostgresDB) StoreEmails(ctx context.Context, emails <-chan Email) (BatchResult, error) {
+	var result BatchResult
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, p.upsertQuery())
+	if err != nil {
+		tx.Rollback()
+		return result, err
+	}
+	defer stmt.Close()
+
+	derived, err := newDerivedWriter(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		return result, err
+	}
+	defer derived.close()
+
+	err = readBatches(ctx, emails, postgresChunkRows, &result, func(rows []pendingRow) error {
+		for _, row := range rows {
+			var id int64
+			var inserted bool
+			if err := stmt.QueryRowContext(ctx, upsertArgs(&row.email, row.sentDate)...).Scan(&id, &inserted); err != nil {
+				return err
+			}
+			if err := derived.write(ctx, id, &row.email); err != nil {
+				return err
+			}
+			status := RowUpdated
+			if inserted {
+				status = RowInserted
+			}
+			result.add(RowResult{Index: row.index, Subject: row.email.Subject, Status: status})
+		}
+		return nil
+	})
+	if err != nil {
+		tx.Rollback()
+		return BatchResult{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return BatchResult{}, err
+	}
+	return result, nil
+}
+
+// postgresEmailColumns is the column list scanned by scanPostgresEmail.
+const postgresEmailColumns = `id, "subject", "body", "from", "to", "cc", "bcc", "sent_date",
+	"sender", "read", "deleted", "labels", "size", "has_attachment", created_at,
+	"state", trashed_at, first_seen_at, sent_offset, date_header, reply_to`
+
+func scanPostgresEmail(rows *sql.Rows) (Email, cursor, error) {
+	var email Email
+	var c cursor
+	var sentDate, createdAt time.Time
+	var trashedAt, firstSeenAt pq.NullTime
+	var offset int
+	var dateHeader sql.NullString
+	var labels []string
+	err := rows.Scan(&email.Id,
+		&email.Subject,
+		&email.Body,
+		&email.From,
+		&email.To,
+		&email.Cc,
+		&email.Bcc,
+		&sentDate,
+		&email.Sender,
+		&email.Read,
+		&email.Deleted,
+		pq.Array(&labels),
+		&email.Size,
+		&email.HasAttachment,
+		&createdAt,
+		&email.State,
+		&trashedAt,
+		&firstSeenAt,
+		&offset,
+		&dateHeader,
+		&email.ReplyTo,
+		&c.Key)
+	if err != nil {
+		return email, c, err
+	}
+
+	email.SentAt = sentAt(sentDate.Unix(), offset)
+	email.SentDate = dateHeader.String
+	if email.SentDate == "" {
+		email.SentDate = email.SentAt.Format(sentDateLayout)
+	}
+	email.Labels = strings.Join(labels, ", ")
+	email.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	if trashedAt.Valid {
+		email.TrashedAt = trashedAt.Time.UTC().Format(time.RFC3339)
+	}
+	if firstSeenAt.Valid {
+		email.FirstSeenAt = firstSeenAt.Time.UTC().Format(time.RFC3339)
+	}
+	c.ID = email.Id
+	return email, c, nil
+}
+
+// ListEmails returns one page of emails. A zero Limit returns up to 50.
+func (p *PostgresDB) ListEmails(filter EmailFilter, opts ListOptions) (EmailPage, error) {
+	return p.ListEmailsContext(context.Background(), filter, opts)
+}
+
+func (p *PostgresDB) ListEmailsContext(ctx context.Context, filter EmailFilter, opts ListOptions) (EmailPage, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 50
+	}
+	limit := opts.Limit
+	opts.Limit = pageLimit(limit)
+
+	it, err := p.IterateEmailsContext(ctx, filter, opts)
+	if err != nil {
+		return EmailPage{}, err
+	}
+	return listPage(it, limit)
+}
+
+// IterateEmails streams every email matching filter, up to opts.Limit if
+// set, without loading them all into memory.
+func (p *PostgresDB) IterateEmails(filter EmailFilter, opts ListOptions) (*EmailIterator, error) {
+	return p.IterateEmailsContext(context.Background(), filter, opts)
+}
+
+func (p *PostgresDB) IterateEmailsContext(ctx context.Context, filter EmailFilter, opts ListOptions) (*EmailIterator, error) {
+	query, args, err := postgresListQuery(filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &EmailIterator{rows: rows, scan: scanPostgresEmail}, nil
+}
+
+// FindEmail returns the most recently stored email matching filter, or
+// ErrNotFound.
+func (p *PostgresDB) FindEmail(filter EmailFilter) (Email, error) {
+	return p.FindEmailContext(context.Background(), filter)
+}
+
+func (p *PostgresDB) FindEmailContext(ctx context.Context, filter EmailFilter) (Email, error) {
+	page, err := p.ListEmailsContext(ctx, filter, ListOptions{Sort: SortByCreatedAt, Limit: 1})
+	if err != nil {
+		return Email{}, err
+	}
+	if len(page.Emails) == 0 {
+		return Email{}, ErrNotFound
+	}
+	return page.Emails[0], nil
+}
+
+// postgresArgs numbers placeholders as arguments are added.
+type postgresArgs []interface{}
+
+func (a *postgresArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+func postgresListQuery(filter EmailFilter, opts ListOptions) (string, []interface{}, error) {
+	sortField, err := opts.sortField()
+	if err != nil {
+		return "", nil, err
+	}
+	// Timestamps are compared as fixed-width UTC text so that cursors, which
+	// carry the key as a string, round-trip exactly.
+	sortKey := map[SortField]string{
+		SortBySentDate:  `to_char("sent_date" AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US')`,
+		SortByCreatedAt: `to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US')`,
+		SortByID:        `''`,
+	}[sortField]
+
+	var args postgresArgs
+	where, err := postgresFilterWhere(&args, filter)
+	if err != nil {
+		return "", nil, err
+	}
+	query := fmt.Sprintf(`SELECT %s, %s AS sort_key FROM emails WHERE %s`, postgresEmailColumns, sortKey, where)
+
+	cmp, dir := "<", "DESC"
+	if opts.Ascending {
+		cmp, dir = ">", "ASC"
+	}
+
+	after, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return "", nil, err
+	}
+	if after != nil {
+		query += fmt.Sprintf(` AND (%s, id) %s (%s, %s)`,
+			sortKey, cmp, args.add(after.Key), args.add(after.ID))
+	}
+
+	query += fmt.Sprintf(` ORDER BY sort_key %[1]s, id %[1]s`, dir)
+	if opts.Limit > 0 {
+		query += ` LIMIT ` + args.add(opts.Limit)
+	}
+
+	return query, args, nil
+}
+
+func postgresFilterWhere(args *postgresArgs, f EmailFilter) (string, error) {
+	conds := []string{"TRUE"}
+
+	if len(f.IDs) > 0 {
+		conds = append(conds, `id = ANY(`+args.add(pq.Array(f.IDs))+`)`)
+	}
+	if f.State != StateAll {
+		conds = append(conds, `"state" = `+args.add(string(f.State)))
+	}
+	for _, label := range f.Labels {
+		conds = append(conds, fmt.Sprintf(`EXISTS (SELECT 1 FROM unnest("labels") AS l WHERE upper(l) = upper(%s))`, args.add(label)))
+	}
+	if f.Sender != "" {
+		conds = append(conds, `"from" ILIKE `+args.add("%"+escapeLike(f.Sender)+"%"))
+	}
+	if !f.After.IsZero() {
+		conds = append(conds, `"sent_date" >= `+args.add(f.After))
+	}
+	if !f.Before.IsZero() {
+		conds = append(conds, `"sent_date" < `+args.add(f.Before))
+	}
+	if f.Read != nil {
+		conds = append(conds, `"read" = `+args.add(*f.Read))
+	}
+	if f.Deleted != nil {
+		conds = append(conds, `"deleted" = `+args.add(*f.Deleted))
+	}
+	if f.Text != "" {
+		pattern := "%" + escapeLike(f.Text) + "%"
+		conds = append(conds, fmt.Sprintf(`("subject" ILIKE %s OR "body" ILIKE %s)`, args.add(pattern), args.add(pattern)))
+	}
+	if f.Subject != "" {
+		conds = append(conds, `"subject" = `+args.add(f.Subject))
+	}
+	if f.From != "" {
+		conds = append(conds, `"from" = `+args.add(f.From))
+	}
+	if f.To != "" {
+		conds = append(conds, `"to" = `+args.add(f.To))
+	}
+	if f.SentDate != "" {
+		if sent, err := parseSentTime(f.SentDate); err == nil {
+			conds = append(conds, `"sent_date" = `+args.add(sent))
+		} else {
+			conds = append(conds, "FALSE")
+		}
+	}
+	if f.Query != nil {
+		c := &queryCompiler{now: time.Now(), dialect: postgresQueryDialect, args: *args}
+		where, err := c.node(f.Query.root)
+		if err != nil {
+			return "", err
+		}
+		*args = c.args
+		conds = append(conds, where)
+	}
+
+	return strings.Join(conds, " AND "), nil
+}
+
+// EmailEvents returns the recorded changes to an email, oldest first.
+func (p *PostgresDB) EmailEvents(emailID int64) ([]EmailEvent, error) {
+	return p.EmailEventsContext(context.Background(), emailID)
+}
+
+func (p *PostgresDB) EmailEventsContext(ctx context.Context, emailID int64) ([]EmailEvent, error) {
+	rows, err := p.DB.QueryContext(ctx, emailEventsQuery, emailID)
+	if err != nil {
+		return nil, err
+	}
+	return scanEmailEvents(rows)
+}
+
+func (p *PostgresDB) UpdateEmailReadStatus(id int64, read bool) error {
+	return p.UpdateEmailReadStatusContext(context.Background(), id, read)
+}
+
+func (p *PostgresDB) UpdateEmailReadStatusContext(ctx context.Context, id int64, read bool) error {
+	query := `UPDATE emails SET "read" = $1 WHERE id = $2`
+	result, err := p.DB.ExecContext(ctx, query, read, id)
+	return updateResult(result, err, id)
+}
+
+func (p *PostgresDB) UpdateEmailLabels(id int64, labels string) error {
+	return p.UpdateEmailLabelsContext(context.Background(), id, labels)
+}
+
+func (p *PostgresDB) UpdateEmailLabelsContext(ctx context.Context, id int64, labels string) error {
+	query := `UPDATE emails SET "labels" = $1 WHERE id = $2`
+	result, err := p.DB.ExecContext(ctx, query, pq.Array(splitLabels(labels)), id)
+	return updateResult(result, err, id)
+}
+
+// ListContacts returns correspondents with their totals, see Contact.
+func (p *PostgresDB) ListContacts(opts ContactOptions) ([]Contact, error) {
+	return p.ListContactsContext(context.Background(), opts)
+}
+
+func (p *PostgresDB) ListContactsContext(ctx context.Context, opts ContactOptions) ([]Contact, error) {
+	return listContacts(ctx, p.DB, contactsDialect{
+		lastContact: `EXTRACT(EPOCH FROM e."sent_date")::BIGINT`,
+		like:        "ILIKE",
+	}, opts)
+}
+
+// RebuildContacts re-parses the addresses of every stored email and
+// returns how many emails it indexed.
+func (p *PostgresDB) RebuildContacts() (int, error) {
+	return p.RebuildContactsContext(context.Background())
+}
+
+func (p *PostgresDB) RebuildContactsContext(ctx context.Context) (int, error) {
+	return rebuildAddresses(ctx, p.DB)
+}
+
+// RawMessage returns the stored raw source of an email, or ErrNotFound if
+// it has none.
+func (p *PostgresDB) RawMessage(emailID int64) (RawMessage, error) {
+	return p.RawMessageContext(context.Background(), emailID)
+}
+
+func (p *PostgresDB) RawMessageContext(ctx context.Context, emailID int64) (RawMessage, error) {
+	return rawMessage(ctx, p.DB, emailID)
+}
+
+// ReparseEmails rebuilds the columns and addresses of every email stored
+// with its raw source from parse's result, see ReparseResult.
+func (p *PostgresDB) ReparseEmails(ctx context.Context, parse ParseFunc) (ReparseResult, error) {
+	return reparseEmails(ctx, p.DB, parse, func(ctx context.Context, tx *sql.Tx, id int64, email *Email, sentDate time.Time) error {
+		result, err := tx.ExecContext(ctx, updateQuery, append(upsertArgs(email, sentDate), id)...)
+		return updateResult(result, err, id)
+	})
+}
+
+// StorePrediction records prediction and sets its ID, see Prediction.
+func (p *PostgresDB) StorePrediction(prediction *Prediction) error {
+	return p.StorePredictionContext(context.Background(), prediction)
+}
+
+func (p *PostgresDB) StorePredictionContext(ctx context.Context, prediction *Prediction) error {
+	return storePrediction(ctx, p.DB, prediction)
+}
+
+// PredictedEmails returns the ids of the emails with a prediction by model
+// and promptVersion.
+func (p *PostgresDB) PredictedEmails(model, promptVersion string) (map[int64]bool, error) {
+	return p.PredictedEmailsContext(context.Background(), model, promptVersion)
+}
+
+func (p *PostgresDB) PredictedEmailsContext(ctx context.Context, model, promptVersion string) (map[int64]bool, error) {
+	return predictedEmails(ctx, p.DB, model, promptVersion)
+}
+
+// RecordUsage adds u to the llm_usage ledger and sets its ID.
+func (p *PostgresDB) RecordUsage(u *UsageRecord) error {
+	return p.RecordUsageContext(context.Background(), u)
+}
+
+func (p *PostgresDB) RecordUsageContext(ctx context.Context, u *UsageRecord) error {
+	return recordUsage(ctx, p.DB, u)
+}
+
+// ListUsage returns the ledger entries recorded since since, oldest first.
+func (p *PostgresDB) ListUsage(since time.Time) ([]UsageRecord, error) {
+	return p.ListUsageContext(context.Background(), since)
+}
+
+func (p *PostgresDB) ListUsageContext(ctx context.Context, since time.Time) ([]UsageRecord, error) {
+	return listUsage(ctx, p.DB, since)
+}
+
+// SaveModel records a fine-tuning job in the models table, or updates the
+// one with the same job ID, and sets m's ID.
+func (p *PostgresDB) SaveModel(m *ModelRecord) error {
+	return p.SaveModelContext(context.Background(), m)
+}
+
+func (p *PostgresDB) SaveModelContext(ctx context.Context, m *ModelRecord) error {
+	return saveModel(ctx, p.DB, m)
+}
+
+// ListModels returns the models table, newest job first.
+func (p *PostgresDB) ListModels() ([]ModelRecord, error) {
+	return p.ListModelsContext(context.Background())
+}
+
+func (p *PostgresDB) ListModelsContext(ctx context.Context) ([]ModelRecord, error) {
+	return listModels(ctx, p.DB)
+}
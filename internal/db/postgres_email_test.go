@@ -0,0 +1,123 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// testPostgresDSN returns a DSN for an empty scratch Postgres database.
+// GMAIL_AUTOMATION_TEST_POSTGRES_DSN points at an existing server; otherwise
+// a throwaway cluster is started with the initdb and pg_ctl binaries found in
+// POSTGRES_BIN or on PATH. The test is skipped when neither is available.
+func testPostgresDSN(t *testing.T) string {
+	t.Helper()
+	if dsn := os.Getenv("GMAIL_AUTOMATION_TEST_POSTGRES_DSN"); dsn != "" {
+		return dsn
+	}
+
+	initdb, pgCtl := postgresBinary("initdb"), postgresBinary("pg_ctl")
+	if initdb == "" || pgCtl == "" {
+		t.Skip("initdb/pg_ctl not found; set POSTGRES_BIN or GMAIL_AUTOMATION_TEST_POSTGRES_DSN")
+	}
+
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "--auth=trust", "-E", "UTF8").CombinedOutput(); err != nil {
+		t.Skipf("initdb failed: %v\n%s", err, out)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to pick a port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -c fsync=off", port, dir)
+	if out, err := exec.Command(pgCtl, "-D", data, "-o", opts, "-l", filepath.Join(dir, "postgres.log"), "-w", "start").CombinedOutput(); err != nil {
+		t.Skipf("pg_ctl start failed: %v\n%s", err, out)
+	}
+	t.Cleanup(func() {
+		exec.Command(pgCtl, "-D", data, "-m", "immediate", "stop").Run()
+	})
+
+	return fmt.Sprintf("host=127.0.0.1 port=%d user=postgres dbname=postgres sslmode=disable", port)
+}
+
+func postgresBinary(name string) string {
+	if dir := os.Getenv("POSTGRES_BIN"); dir != "" {
+		return filepath.Join(dir, name)
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// scratchSchemas numbers the schemas created by scratchPostgres.
+var scratchSchemas int64
+
+// scratchPostgres creates an empty schema of its own for a test, dropped
+// when it ends, and returns dsn with that schema as the search_path, so
+// that each test starts with fresh id sequences and nothing else in the
+// database is touched.
+func scratchPostgres(t *testing.T, dsn string) string {
+	t.Helper()
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer conn.Close()
+
+	schema := fmt.Sprintf("gmail_automation_test_%d_%d_%d", os.Getpid(), time.Now().UnixNano(), atomic.AddInt64(&scratchSchemas, 1))
+	if _, err := conn.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("Failed to create schema %s: %v", schema, err)
+	}
+	t.Cleanup(func() {
+		conn, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Errorf("Failed to open database: %v", err)
+			return
+		}
+		defer conn.Close()
+		if _, err := conn.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("Failed to drop schema %s: %v", schema, err)
+		}
+	})
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		if dsn, err = pq.ParseURL(dsn); err != nil {
+			t.Fatalf("Bad DSN: %v", err)
+		}
+	}
+	// lib/pq passes parameters it does not know on to the server.
+	return dsn + " search_path=" + schema
+}
+
+func newTestPostgresDB(t *testing.T, dsn string) *PostgresDB {
+	t.Helper()
+	db, err := NewPostgresDB(scratchPostgres(t, dsn))
+	if err != nil {
+		t.Fatalf("NewPostgresDB failed: %v", err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+	return db
+}
+
+func TestPostgresDB(t *testing.T) {
+	dsn := testPostgresDSN(t)
+	runEmailDBSuite(t, func(t *testing.T) EmailDB {
+		return newTestPostgresDB(t, dsn)
+	})
+}
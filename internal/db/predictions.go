@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Prediction is a classifier's verdict on a stored email. Model and
+// PromptVersion identify the run: an email has at most one prediction per
+// pair, so a batch that stopped halfway can skip what it already did.
+type Prediction struct {
+	ID            int64
+	EmailID       int64
+	Model         string
+	PromptName    string
+	PromptVersion string
+	// Label is the suggested action, Category and Confidence as the model
+	// gave them.
+	Label      string
+	Category   string
+	Confidence float64
+	Reason     string
+	// RawResponse is the model's answer before parsing.
+	RawResponse string
+	Latency     time.Duration
+
+	PromptTokens     int
+	CompletionTokens int
+}
+
+const upsertPredictionQuery = `INSERT INTO predictions
+		(email_id, model, prompt_name, prompt_version, label, category, confidence, reason,
+		raw_response, latency_ms, prompt_tokens, completion_tokens)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	ON CONFLICT (email_id, model, prompt_version) DO UPDATE SET
+		prompt_name = excluded.prompt_name,
+		label = excluded.label,
+		category = excluded.category,
+		confidence = excluded.confidence,
+		reason = excluded.reason,
+		raw_response = excluded.raw_response,
+		latency_ms = excluded.latency_ms,
+		prompt_tokens = excluded.prompt_tokens,
+		completion_tokens = excluded.completion_tokens,
+		created_at = CURRENT_TIMESTAMP
+	RETURNING id`
+
+// storePrediction inserts p, or replaces the prediction of the same email,
+// model and prompt version, and sets p.ID.
+func storePrediction(ctx context.Context, db *sql.DB, p *Prediction) error {
+	return db.QueryRowContext(ctx, upsertPredictionQuery,
+		p.EmailID, p.Model, p.PromptName, p.PromptVersion, p.Label, p.Category, p.Confidence, p.Reason,
+		p.RawResponse, p.Latency.Milliseconds(), p.PromptTokens, p.CompletionTokens,
+	).Scan(&p.ID)
+}
+
+// predictedEmails returns the ids of the emails with a prediction by
+// model and promptVersion.
+func predictedEmails(ctx context.Context, db *sql.DB, model, promptVersion string) (map[int64]bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT email_id FROM predictions WHERE model = $1 AND prompt_version = $2`,
+		model, promptVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[int64]bool{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,486 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a parsed Gmail-style search such as
+//
+//	from:alice (subject:invoice OR has:attachment) -label:paid newer_than:30d
+//
+// Supported operators: from:, to:, cc:, bcc:, subject:, label:,
+// is:unread|read|starred|important, in:inbox|archive|trash|spam|sent|anywhere,
+// before:, after: (YYYY/MM/DD or YYYY-MM-DD, midnight in the local time
+// zone), older_than:, newer_than: (Nd, Nm or Ny), has:attachment, larger:,
+// smaller: (bytes, or with a K or M suffix), bare words and "quoted phrases" (matched against subject, body,
+// from and to), OR, AND (implicit between terms), - or NOT for negation, and
+// parentheses for grouping.
+type Query struct {
+	raw  string
+	root queryNode
+}
+
+type queryNode interface{}
+
+type andNode struct{ terms []queryNode }
+type orNode struct{ terms []queryNode }
+type notNode struct{ term queryNode }
+type termNode struct{ key, value string }
+
+// ParseQuery parses a Gmail-style search string. An empty string matches
+// every email.
+func ParseQuery(s string) (*Query, error) {
+	tokens, err := tokenizeQuery(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{tokens: tokens}
+	if len(tokens) == 0 {
+		return &Query{raw: s, root: andNode{}}, nil
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("query %q: unexpected %q", s, p.tokens[p.pos].text)
+	}
+
+	q := &Query{raw: s, root: root}
+	// Surface unknown operators at parse time rather than when compiling.
+	if _, _, err := q.compile(time.Now()); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *Query) String() string {
+	return q.raw
+}
+
+type queryToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeQuery(s string) ([]queryToken, error) {
+	var tokens []queryToken
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, queryToken{text: string(r)})
+			i++
+		case r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]):
+			tokens = append(tokens, queryToken{text: "-"})
+			i++
+		default:
+			// A word runs to the next space or parenthesis, but a quoted
+			// section (also after "key:") may contain anything.
+			var b strings.Builder
+			quoted := false
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' {
+				if runes[i] == '"' {
+					if b.Len() == 0 {
+						quoted = true
+					}
+					end := strings.IndexRune(string(runes[i+1:]), '"')
+					if end < 0 {
+						return nil, fmt.Errorf("query %q: unterminated quote", s)
+					}
+					quote := []rune(string(runes[i+1:])[:end])
+					b.WriteString(string(quote))
+					i += len(quote) + 2
+					continue
+				}
+				b.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, queryToken{text: b.String(), quoted: quoted})
+		}
+	}
+	return tokens, nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return queryToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *queryParser) isKeyword(tok queryToken, keyword string) bool {
+	return !tok.quoted && tok.text == keyword
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	terms := []queryNode{first}
+	for {
+		tok, ok := p.peek()
+		if !ok || !p.isKeyword(tok, "OR") {
+			break
+		}
+		p.pos++
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, next)
+	}
+
+	if len(terms) == 1 {
+		return first, nil
+	}
+	return orNode{terms: terms}, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	var terms []queryNode
+	for {
+		tok, ok := p.peek()
+		if !ok || p.isKeyword(tok, ")") || p.isKeyword(tok, "OR") {
+			break
+		}
+		if p.isKeyword(tok, "AND") {
+			p.pos++
+			continue
+		}
+		term, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+
+	switch len(terms) {
+	case 0:
+		return nil, fmt.Errorf("expected a search term")
+	case 1:
+		return terms[0], nil
+	}
+	return andNode{terms: terms}, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	tok, _ := p.peek()
+	if p.isKeyword(tok, "-") || p.isKeyword(tok, "NOT") {
+		p.pos++
+		term, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{term: term}, nil
+	}
+
+	if p.isKeyword(tok, "(") {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing, ok := p.peek()
+		if !ok || !p.isKeyword(closing, ")") {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return inner, nil
+	}
+
+	p.pos++
+	if !tok.quoted {
+		if i := strings.IndexRune(tok.text, ':'); i > 0 {
+			return termNode{key: strings.ToLower(tok.text[:i]), value: tok.text[i+1:]}, nil
+		}
+	}
+	return termNode{value: tok.text}, nil
+}
+
+// Where compiles the query into a parameterized SQLite WHERE clause over
+// the emails table.
+func (q *Query) Where() (string, []interface{}, error) {
+	return q.compile(time.Now())
+}
+
+func (q *Query) compile(now time.Time) (string, []interface{}, error) {
+	c := &queryCompiler{now: now, dialect: sqliteQueryDialect}
+	where, err := c.node(q.root)
+	return where, c.args, err
+}
+
+// queryDialect holds the SQL that differs between drivers in compiled
+// queries and filters.
+type queryDialect struct {
+	// placeholder binds the nth argument, counting from 1.
+	placeholder func(n int) string
+	// like matches a column, which may be NULL, against a pattern with
+	// backslash escapes, ignoring case; both are %s.
+	like string
+	// hasLabel matches the email's labels against a label argument, %s,
+	// made by labelArg from the label upper-cased without spaces.
+	hasLabel string
+	labelArg func(label string) interface{}
+	// sentAt is the send time compared with sentArg of a time.
+	sentAt  string
+	sentArg func(t time.Time) interface{}
+	// attachment is true when the email has an attachment, read when it
+	// was read.
+	attachment  string
+	read        string
+	true, false string
+}
+
+var sqliteQueryDialect = queryDialect{
+	placeholder: func(int) string { return "?" },
+	like:        `COALESCE(%s, '') LIKE %s ESCAPE '\'`,
+	hasLabel:    `(',' || REPLACE(UPPER(COALESCE("labels", '')), ' ', '') || ',') LIKE %s ESCAPE '\'`,
+	labelArg:    func(label string) interface{} { return "%," + escapeLike(label) + ",%" },
+	sentAt:      `sent_at`,
+	sentArg:     func(t time.Time) interface{} { return t.Unix() },
+	attachment:  `"has_attachment" = 1`,
+	read:        `"read" = 1`,
+	true:        "1",
+	false:       "0",
+}
+
+var postgresQueryDialect = queryDialect{
+	placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+	like:        `%s ILIKE %s`,
+	hasLabel:    `EXISTS (SELECT 1 FROM unnest("labels") AS l WHERE REPLACE(UPPER(l), ' ', '') = %s)`,
+	labelArg:    func(label string) interface{} { return label },
+	sentAt:      `"sent_date"`,
+	sentArg:     func(t time.Time) interface{} { return t },
+	attachment:  `"has_attachment"`,
+	read:        `"read"`,
+	true:        "TRUE",
+	false:       "FALSE",
+}
+
+type queryCompiler struct {
+	now     time.Time
+	dialect queryDialect
+	// args are bound in order; for Postgres they may start with arguments
+	// of the rest of the statement, which the placeholders count on from.
+	args []interface{}
+}
+
+func (c *queryCompiler) arg(value interface{}) string {
+	c.args = append(c.args, value)
+	return c.dialect.placeholder(len(c.args))
+}
+
+func (c *queryCompiler) node(n queryNode) (string, error) {
+	switch n := n.(type) {
+	case andNode:
+		return c.join(n.terms, " AND ", c.dialect.true)
+	case orNode:
+		return c.join(n.terms, " OR ", c.dialect.false)
+	case notNode:
+		inner, err := c.node(n.term)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + inner + ")", nil
+	case termNode:
+		return c.term(n)
+	}
+	return "", fmt.Errorf("unexpected query node %T", n)
+}
+
+func (c *queryCompiler) join(terms []queryNode, sep string, empty string) (string, error) {
+	if len(terms) == 0 {
+		return empty, nil
+	}
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		part, err := c.node(term)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, part)
+	}
+	return "(" + strings.Join(parts, sep) + ")", nil
+}
+
+func (c *queryCompiler) like(columns []string, value string) string {
+	pattern := "%" + escapeLike(value) + "%"
+	parts := make([]string, 0, len(columns))
+	for _, column := range columns {
+		parts = append(parts, fmt.Sprintf(c.dialect.like, column, c.arg(pattern)))
+	}
+	return "(" + strings.Join(parts, " OR ") + ")"
+}
+
+// hasLabel matches one of the email's labels, ignoring case and spacing.
+func (c *queryCompiler) hasLabel(label string) string {
+	label = strings.ToUpper(strings.ReplaceAll(label, " ", ""))
+	return fmt.Sprintf(c.dialect.hasLabel, c.arg(c.dialect.labelArg(label)))
+}
+
+func (c *queryCompiler) term(t termNode) (string, error) {
+	value := t.value
+	switch t.key {
+	case "":
+		return c.like([]string{`"subject"`, `"body"`, `"from"`, `"to"`}, value), nil
+	case "from":
+		return c.like([]string{`"from"`}, value), nil
+	case "to":
+		return c.like([]string{`"to"`, `"cc"`, `"bcc"`}, value), nil
+	case "cc":
+		return c.like([]string{`"cc"`}, value), nil
+	case "bcc":
+		return c.like([]string{`"bcc"`}, value), nil
+	case "subject":
+		return c.like([]string{`"subject"`}, value), nil
+	case "label":
+		return c.hasLabel(value), nil
+
+	case "is":
+		switch strings.ToLower(value) {
+		case "unread":
+			return "NOT (" + c.dialect.read + ")", nil
+		case "read":
+			return c.dialect.read, nil
+		case "starred":
+			return c.hasLabel("STARRED"), nil
+		case "important":
+			return c.hasLabel("IMPORTANT"), nil
+		}
+
+	case "in":
+		switch strings.ToLower(value) {
+		case "anywhere":
+			return c.dialect.true, nil
+		case "inbox", "archive", "trash", "spam":
+			state := map[string]EmailState{
+				"inbox":   StateInbox,
+				"archive": StateArchived,
+				"trash":   StateTrashed,
+				"spam":    StateSpam,
+			}[strings.ToLower(value)]
+			return `"state" = ` + c.arg(string(state)), nil
+		case "sent", "draft":
+			return c.hasLabel(value), nil
+		}
+
+	case "before", "after":
+		date, err := parseQueryDate(value, c.now.Location())
+		if err != nil {
+			return "", err
+		}
+		op := "<"
+		if t.key == "after" {
+			op = ">="
+		}
+		return fmt.Sprintf(`%s %s %s`, c.dialect.sentAt, op, c.arg(c.dialect.sentArg(date))), nil
+
+	case "older_than", "newer_than":
+		since, err := parseQueryAge(value, c.now)
+		if err != nil {
+			return "", err
+		}
+		op := "<"
+		if t.key == "newer_than" {
+			op = ">="
+		}
+		return fmt.Sprintf(`%s %s %s`, c.dialect.sentAt, op, c.arg(c.dialect.sentArg(since))), nil
+
+	case "has":
+		if strings.ToLower(value) == "attachment" {
+			return c.dialect.attachment, nil
+		}
+
+	case "larger", "smaller", "size":
+		size, err := parseQuerySize(value)
+		if err != nil {
+			return "", err
+		}
+		op := map[string]string{"larger": ">", "smaller": "<", "size": ">="}[t.key]
+		return fmt.Sprintf(`"size" %s %s`, op, c.arg(size)), nil
+
+	default:
+		return "", fmt.Errorf("unknown search operator %q", t.key+":")
+	}
+
+	return "", fmt.Errorf("unsupported value %q for %s:", value, t.key)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// parseQueryDate reads a before:/after: date as midnight in loc, the
+// user's time zone, as Gmail does.
+func parseQueryDate(value string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{"2006/01/02", "2006-01-02", "2006/1/2", "2006-1-2"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q: expected YYYY/MM/DD", value)
+}
+
+func parseQueryAge(value string, now time.Time) (time.Time, error) {
+	if len(value) < 2 {
+		return time.Time{}, fmt.Errorf("invalid age %q: expected e.g. 7d, 2m or 1y", value)
+	}
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("invalid age %q: expected e.g. 7d, 2m or 1y", value)
+	}
+	switch strings.ToLower(value[len(value)-1:]) {
+	case "d":
+		return now.AddDate(0, 0, -n), nil
+	case "m":
+		return now.AddDate(0, -n, 0), nil
+	case "y":
+		return now.AddDate(-n, 0, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid age %q: expected e.g. 7d, 2m or 1y", value)
+}
+
+// ParseSince reads a date as midnight in loc, or an age such as 30d, 6m or
+// 1y as that long before now, for flags that bound emails by sent date.
+func ParseSince(value string, now time.Time) (time.Time, error) {
+	if t, err := parseQueryDate(value, now.Location()); err == nil {
+		return t, nil
+	}
+	if t, err := parseQueryAge(value, now); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q: expected YYYY-MM-DD or an age such as 30d", value)
+}
+
+func parseQuerySize(value string) (int64, error) {
+	multiplier := int64(1)
+	number := strings.ToUpper(value)
+	switch {
+	case strings.HasSuffix(number, "K"):
+		multiplier, number = 1024, strings.TrimSuffix(number, "K")
+	case strings.HasSuffix(number, "M"):
+		multiplier, number = 1024*1024, strings.TrimSuffix(number, "M")
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: expected bytes or e.g. 5M", value)
+	}
+	return n * multiplier, nil
+}
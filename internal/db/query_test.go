@@ -0,0 +1,164 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		`(from:alice`,
+		`subject:"unterminated`,
+		`bogus:value`,
+		`is:sleepy`,
+		`before:yesterday`,
+		`larger:huge`,
+		`from:alice OR`,
+	} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("ParseQuery(%q): expected an error", query)
+		}
+	}
+}
+
+func TestQueryEmails(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		testQueryEmails(t, newTestSQLiteDB(t))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		testQueryEmails(t, newTestPostgresDB(t, testPostgresDSN(t)))
+	})
+}
+
+func testQueryEmails(t *testing.T, db EmailDB) {
+	recent := time.Now().Add(-2 * time.Hour).Format(time.RFC1123Z)
+	_, err := db.InsertEmails([]Email{
+		{Subject: "April invoice", From: "Billing <billing@example.com>", To: "me@example.com", Labels: "INBOX, UNREAD", SentDate: "Mon, 03 Apr 2023 18:15:16 +0000", HasAttachment: true, Size: 2 * 1024 * 1024},
+		{Subject: "Team lunch", From: "alice@example.com", To: "me@example.com", Cc: "bob@example.com", Labels: "INBOX, READ,IMPORTANT", Read: true, SentDate: "Tue, 04 Apr 2023 12:00:00 +0000", Size: 4096},
+		{Subject: "Weekly digest", From: "news@example.com", To: "me@example.com", Labels: "CATEGORY_UPDATES, STARRED, UNREAD", SentDate: recent, Size: 10000},
+	})
+	if err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+	_, err = db.InsertDeletedEmails([]Email{
+		{Subject: "Old invoice", From: "billing@example.com", Labels: "TRASH", Read: true, SentDate: "Sun, 02 Apr 2023 18:15:16 +0000", Deleted: true},
+	})
+	if err != nil {
+		t.Fatalf("InsertDeletedEmails failed: %v", err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{``, []string{"April invoice", "Old invoice", "Team lunch", "Weekly digest"}},
+		{`invoice`, []string{"April invoice", "Old invoice"}},
+		{`from:billing in:inbox`, []string{"April invoice"}},
+		{`in:trash`, []string{"Old invoice"}},
+		{`-in:trash invoice`, []string{"April invoice"}},
+		{`is:unread`, []string{"April invoice", "Weekly digest"}},
+		{`is:read in:inbox`, []string{"Team lunch"}},
+		{`is:starred OR is:important`, []string{"Team lunch", "Weekly digest"}},
+		{`to:bob`, []string{"Team lunch"}},
+		{`subject:"team lunch"`, []string{"Team lunch"}},
+		{`"weekly digest"`, []string{"Weekly digest"}},
+		{`label:category_updates`, []string{"Weekly digest"}},
+		{`has:attachment`, []string{"April invoice"}},
+		{`larger:1M`, []string{"April invoice"}},
+		{`smaller:5K`, []string{"Old invoice", "Team lunch"}},
+		{`after:2023/04/03 before:2023/04/05`, []string{"April invoice", "Team lunch"}},
+		{`newer_than:1d`, []string{"Weekly digest"}},
+		{`older_than:1y`, []string{"April invoice", "Old invoice", "Team lunch"}},
+		{`(from:alice OR from:news) -is:starred`, []string{"Team lunch"}},
+		{`NOT (invoice OR lunch) AND in:anywhere`, []string{"Weekly digest"}},
+	}
+
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) failed: %v", tt.query, err)
+			continue
+		}
+		page, err := db.ListEmails(EmailFilter{Query: q}, ListOptions{})
+		if err != nil {
+			t.Errorf("ListEmails(%q) failed: %v", tt.query, err)
+			continue
+		}
+
+		var got []string
+		for _, email := range page.Emails {
+			got = append(got, email.Subject)
+		}
+		sort.Strings(got)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("ListEmails(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// Postgres placeholders are numbered on from the filter's own arguments,
+// and none of SQLite's are left.
+func TestPostgresQueryPlaceholders(t *testing.T) {
+	q, err := ParseQuery(`(from:alice OR label:"Work Stuff") -is:unread after:2023/04/04 has:attachment larger:1K`)
+	if err != nil {
+		t.Fatalf("ParseQuery failed: %v", err)
+	}
+	query, args, err := postgresListQuery(EmailFilter{State: StateInbox, Query: q}, ListOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("postgresListQuery failed: %v", err)
+	}
+	if strings.Contains(query, "?") || strings.Contains(query, `"has_attachment" = 1`) || strings.Contains(query, `"read" = 1`) {
+		t.Errorf("Expected Postgres SQL, got %s", query)
+	}
+	for i := range args {
+		if !strings.Contains(query, fmt.Sprintf("$%d", i+1)) {
+			t.Errorf("Expected placeholder $%d in %s", i+1, query)
+		}
+	}
+	if strings.Contains(query, fmt.Sprintf("$%d", len(args)+1)) {
+		t.Errorf("Expected %d placeholders, got more in %s", len(args), query)
+	}
+	if args[0] != "inbox" || args[2] != "WORKSTUFF" || args[len(args)-1] != 10 {
+		t.Errorf("Unexpected arguments %v", args)
+	}
+	if _, ok := args[3].(time.Time); !ok {
+		t.Errorf("Expected after: to bind a time, got %T", args[3])
+	}
+}
+
+func TestQueryDatesUseLocalZone(t *testing.T) {
+	q, err := ParseQuery(`after:2023/04/04`)
+	if err != nil {
+		t.Fatalf("ParseQuery failed: %v", err)
+	}
+	tokyo := time.FixedZone("JST", 9*3600)
+	_, args, err := q.compile(time.Date(2023, 5, 1, 0, 0, 0, 0, tokyo))
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	// Midnight in Tokyo is 15:00 UTC the day before.
+	want := time.Date(2023, 4, 3, 15, 0, 0, 0, time.UTC).Unix()
+	if len(args) != 1 || args[0] != want {
+		t.Errorf("Expected after: to start at %d, got %v", want, args)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*3600)
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, tokyo)
+	for value, want := range map[string]time.Time{
+		"2023-04-04": time.Date(2023, 4, 4, 0, 0, 0, 0, tokyo),
+		"30d":        now.AddDate(0, 0, -30),
+		"1y":         now.AddDate(-1, 0, 0),
+	} {
+		if got, err := ParseSince(value, now); err != nil || !got.Equal(want) {
+			t.Errorf("ParseSince(%q) = %v, %v; expected %v", value, got, err, want)
+		}
+	}
+	if _, err := ParseSince("last week", now); err == nil {
+		t.Error(`ParseSince("last week"): expected an error`)
+	}
+}
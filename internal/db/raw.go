@@ -0,0 +1,275 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// RawMessage is the RFC 822 source of an email with the Gmail metadata
+// that is not part of it, enough to derive every column of the email again.
+type RawMessage struct {
+	EmailID      int64
+	GmailID      string
+	LabelIDs     []string
+	InternalDate int64
+	SizeEstimate int64
+	// Data is the message as Gmail returns it with format=raw. It is
+	// stored gzip-compressed.
+	Data []byte
+}
+
+const upsertRawQuery = `INSERT INTO raw_messages
+		(email_id, gmail_id, label_ids, internal_date, size_estimate, sha256, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (email_id) DO UPDATE SET
+		gmail_id = excluded.gmail_id,
+		label_ids = excluded.label_ids,
+		internal_date = excluded.internal_date,
+		size_estimate = excluded.size_estimate,
+		sha256 = excluded.sha256,
+		data = excluded.data,
+		fetched_at = CURRENT_TIMESTAMP`
+
+// rawWriter stores the raw source of emails that carry one.
+type rawWriter struct {
+	upsert *sql.Stmt
+}
+
+func newRawWriter(ctx context.Context, tx *sql.Tx) (*rawWriter, error) {
+	stmt, err := tx.PrepareContext(ctx, upsertRawQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &rawWriter{upsert: stmt}, nil
+}
+
+func (w *rawWriter) close() {
+	w.upsert.Close()
+}
+
+func (w *rawWriter) write(ctx context.Context, emailID int64, raw *RawMessage) error {
+	data, err := compressRaw(raw.Data)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(raw.Data)
+	_, err = w.upsert.ExecContext(ctx, emailID, raw.GmailID, strings.Join(raw.LabelIDs, ","),
+		raw.InternalDate, raw.SizeEstimate, hex.EncodeToString(sum[:]), data)
+	return err
+}
+
+func compressRaw(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressRaw(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// derivedWriter writes the rows kept alongside each stored email: its
+// addresses and, when it was fetched, its raw source.
+type derivedWriter struct {
+	addresses *addressWriter
+	raw       *rawWriter
+}
+
+func newDerivedWriter(ctx context.Context, tx *sql.Tx) (*derivedWriter, error) {
+	addresses, err := newAddressWriter(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := newRawWriter(ctx, tx)
+	if err != nil {
+		addresses.close()
+		return nil, err
+	}
+	return &derivedWriter{addresses: addresses, raw: raw}, nil
+}
+
+func (w *derivedWriter) close() {
+	w.addresses.close()
+	w.raw.close()
+}
+
+func (w *derivedWriter) write(ctx context.Context, emailID int64, email *Email) error {
+	if err := w.addresses.write(ctx, emailID, email); err != nil {
+		return err
+	}
+	if email.Raw != nil {
+		return w.raw.write(ctx, emailID, email.Raw)
+	}
+	return nil
+}
+
+// ReparseFailure is an email ReparseEmails left as it was.
+type ReparseFailure struct {
+	EmailID int64
+	Err     error
+}
+
+// ReparseResult reports the outcome of ReparseEmails.
+type ReparseResult struct {
+	Reparsed int
+	Failures []ReparseFailure
+}
+
+// ParseFunc derives an email from its raw source.
+type ParseFunc func(raw RawMessage) (Email, error)
+
+// reparsePage is how many raw messages reparseEmails holds in memory.
+const reparsePage = 100
+
+// reparseEmails rewrites every email that has a raw source from parse's
+// result, using update to set its columns. An email that fails to parse or
+// whose new key collides with another stored email is left unchanged and
+// reported; the rest are committed together.
+func reparseEmails(ctx context.Context, db *sql.DB, parse ParseFunc, update func(ctx context.Context, tx *sql.Tx, id int64, email *Email, sentDate time.Time) error) (ReparseResult, error) {
+	var result ReparseResult
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	addresses, err := newAddressWriter(ctx, tx)
+	if err != nil {
+		return result, err
+	}
+	defer addresses.close()
+
+	var after int64
+	for {
+		page, err := rawMessagePage(ctx, tx, after)
+		if err != nil {
+			return ReparseResult{}, err
+		}
+		if len(page) == 0 {
+			break
+		}
+		after = page[len(page)-1].EmailID
+
+		for _, raw := range page {
+			if err := reparseOne(ctx, tx, raw, parse, update, addresses); err != nil {
+				if ctx.Err() != nil {
+					return ReparseResult{}, ctx.Err()
+				}
+				result.Failures = append(result.Failures, ReparseFailure{EmailID: raw.EmailID, Err: err})
+				continue
+			}
+			result.Reparsed++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ReparseResult{}, err
+	}
+	return result, nil
+}
+
+// reparseOne rewrites one email inside a savepoint, so that a failure
+// leaves the transaction usable (PostgreSQL aborts it otherwise).
+func reparseOne(ctx context.Context, tx *sql.Tx, raw RawMessage, parse ParseFunc, update func(ctx context.Context, tx *sql.Tx, id int64, email *Email, sentDate time.Time) error, addresses *addressWriter) error {
+	email, err := parse(raw)
+	if err != nil {
+		return err
+	}
+	sentDate, err := email.sentTime()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT reparse`); err != nil {
+		return err
+	}
+	err = update(ctx, tx, raw.EmailID, &email, sentDate)
+	if err == nil {
+		err = addresses.write(ctx, raw.EmailID, &email)
+	}
+	if err != nil {
+		if _, rollbackErr := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT reparse`); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `RELEASE SAVEPOINT reparse`)
+	return err
+}
+
+// rawMessagePage reads the raw messages after the given email id. The rows
+// are read in full before returning, as PostgreSQL cannot run other
+// statements on the transaction while they are open.
+func rawMessagePage(ctx context.Context, tx *sql.Tx, after int64) ([]RawMessage, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT email_id, gmail_id, label_ids, internal_date, size_estimate, data
+		FROM raw_messages WHERE email_id > $1 ORDER BY email_id LIMIT $2`, after, reparsePage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var page []RawMessage
+	for rows.Next() {
+		var raw RawMessage
+		var labels string
+		var data []byte
+		if err := rows.Scan(&raw.EmailID, &raw.GmailID, &labels, &raw.InternalDate, &raw.SizeEstimate, &data); err != nil {
+			return nil, err
+		}
+		if labels != "" {
+			raw.LabelIDs = strings.Split(labels, ",")
+		}
+		if raw.Data, err = decompressRaw(data); err != nil {
+			return nil, fmt.Errorf("raw message of email %d: %v", raw.EmailID, err)
+		}
+		page = append(page, raw)
+	}
+	return page, rows.Err()
+}
+
+// rawMessage reads the raw source stored for an email.
+func rawMessage(ctx context.Context, db *sql.DB, emailID int64) (RawMessage, error) {
+	rows, err := db.QueryContext(ctx, `SELECT email_id, gmail_id, label_ids, internal_date, size_estimate, data
+		FROM raw_messages WHERE email_id = $1`, emailID)
+	if err != nil {
+		return RawMessage{}, err
+	}
+	defer rows.Close()
+
+	var raw RawMessage
+	var labels string
+	var data []byte
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return RawMessage{}, err
+		}
+		return RawMessage{}, fmt.Errorf("%w: raw message of email %d", ErrNotFound, emailID)
+	}
+	if err := rows.Scan(&raw.EmailID, &raw.GmailID, &labels, &raw.InternalDate, &raw.SizeEstimate, &data); err != nil {
+		return RawMessage{}, err
+	}
+	if labels != "" {
+		raw.LabelIDs = strings.Split(labels, ",")
+	}
+	raw.Data, err = decompressRaw(data)
+	return raw, err
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ErrSearchUnavailable is returned by Search when the sqlite3 driver was
+// compiled without FTS5.
+var ErrSearchUnavailable = errors.New("full-text search needs SQLite with FTS5: build with -tags sqlite_fts5")
+
+// searchTables are the tables mirrored into the email_search index.
+var searchTables = []string{"emails"}
+
+// SearchResult is one ranked match. Snippet is an excerpt of the best
+// matching column with the matched terms wrapped in the highlight markers.
+type SearchResult struct {
+	Email   Email
+	Rank    float64
+	Snippet string
+}
+
+// SearchOptions controls paging and highlighting of Search results.
+type SearchOptions struct {
+	Limit          int
+	Offset         int
+	HighlightStart string
+	HighlightEnd   string
+}
+
+// searchAvailable reports whether the linked SQLite has FTS5.
+func (s *SQLiteDB) searchAvailable() bool {
+	var used bool
+	err := s.DB.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&used)
+	return err == nil && used
+}
+
+// ensureSearchIndex creates the email_search FTS5 table and the triggers
+// that keep it in sync with the emails tables. If any trigger is missing, as
+// after a fresh install or a migration that rebuilt a table, the index is
+// rebuilt from scratch. The index is derived data that depends on how SQLite
+// was compiled, so it lives outside the numbered migrations and is simply
+// skipped without FTS5.
+func (s *SQLiteDB) ensureSearchIndex() error {
+	if !s.searchAvailable() {
+		return nil
+	}
+
+	var triggers int
+	err := s.DB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE '%\_search\_%' ESCAPE '\'`).Scan(&triggers)
+	if err != nil {
+		return err
+	}
+	if triggers == 3*len(searchTables) {
+		return nil
+	}
+
+	statements := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS email_search USING fts5(
+			"subject", "from", "to", "body",
+			source UNINDEXED, email_id UNINDEXED,
+			tokenize = 'unicode61 remove_diacritics 2'
+		)`,
+		`DELETE FROM email_search`,
+	}
+	for _, table := range searchTables {
+		insert := fmt.Sprintf(`INSERT INTO email_search ("subject", "from", "to", "body", source, email_id)
+			VALUES (new."subject", new."from", new."to", new."body", '%s', new.id);`, table)
+		remove := fmt.Sprintf(`DELETE FROM email_search WHERE source = '%s' AND email_id = old.id;`, table)
+
+		statements = append(statements,
+			fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_search_insert`, table),
+			fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_search_delete`, table),
+			fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_search_update`, table),
+			fmt.Sprintf(`CREATE TRIGGER %[1]s_search_insert AFTER INSERT ON %[1]s BEGIN %[2]s END`, table, insert),
+			fmt.Sprintf(`CREATE TRIGGER %[1]s_search_delete AFTER DELETE ON %[1]s BEGIN %[2]s END`, table, remove),
+			fmt.Sprintf(`CREATE TRIGGER %[1]s_search_update AFTER UPDATE ON %[1]s BEGIN %[2]s %[3]s END`, table, remove, insert),
+			fmt.Sprintf(`INSERT INTO email_search ("subject", "from", "to", "body", source, email_id)
+				SELECT "subject", "from", "to", "body", '%[1]s', id FROM %[1]s`, table),
+		)
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to build search index: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Println("Built full-text search index")
+	return nil
+}
+
+// Search runs an FTS5 query (terms, "phrases", OR, NOT, prefix*, and column
+// filters such as subject:invoice) over all stored emails. Results are
+// ranked by bm25 with subject and sender weighted above the body. It also
+// returns the total number of matches for pagination.
+func (s *SQLiteDB) Search(query string, opts SearchOptions) ([]SearchResult, int, error) {
+	if !s.searchAvailable() {
+		return nil, 0, ErrSearchUnavailable
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 20
+	}
+	if opts.HighlightStart == "" && opts.HighlightEnd == "" {
+		opts.HighlightStart, opts.HighlightEnd = "[", "]"
+	}
+
+	var total int
+	err := s.DB.QueryRow(`SELECT COUNT(*) FROM email_search WHERE email_search MATCH $1`, query).Scan(&total)
+	if err != nil {
+		return nil, 0, searchError(query, err)
+	}
+
+	rows, err := s.DB.Query(`SELECT email_id,
+			bm25(email_search, 10.0, 5.0, 2.0, 1.0) AS rank,
+			snippet(email_search, -1, ?, ?, '…', 16)
+		FROM email_search WHERE email_search MATCH ?
+		ORDER BY rank LIMIT ? OFFSET ?`,
+		opts.HighlightStart, opts.HighlightEnd, query, opts.Limit, opts.Offset)
+	if err != nil {
+		return nil, 0, searchError(query, err)
+	}
+
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		if err := rows.Scan(&result.Email.Id, &result.Rank, &result.Snippet); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		results = append(results, result)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, searchError(query, err)
+	}
+
+	for i := range results {
+		email, err := s.getEmailByID(results[i].Email.Id)
+		if err != nil {
+			return nil, 0, err
+		}
+		results[i].Email = email
+	}
+
+	return results, total, nil
+}
+
+func searchError(query string, err error) error {
+	if strings.Contains(err.Error(), "fts5") {
+		return fmt.Errorf("invalid search query %q: %v", query, err)
+	}
+	return err
+}
+
+func (s *SQLiteDB) getEmailByID(id int64) (Email, error) {
+	query := fmt.Sprintf(`SELECT %s, '' FROM emails WHERE id = ?`, sqliteEmailColumns)
+	rows, err := s.DB.Query(query, id)
+	if err != nil {
+		return Email{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return Email{}, err
+		}
+		return Email{}, ErrNotFound
+	}
+	email, _, err := scanSQLiteEmail(rows)
+	return email, err
+}
@@ -0,0 +1,72 @@
+//go:build sqlite_fts5 || fts5
+
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	db := newTestSQLiteDB(t)
+
+	_, err := db.InsertEmails([]Email{
+		{Subject: "Your invoice for April", From: "billing@example.com", Body: "Please find the invoice attached.", SentDate: "Mon, 03 Apr 2023 18:15:16 +0000"},
+		{Subject: "Team lunch", From: "alice@example.com", Body: "Pizza or tacos? The invoice goes to finance.", SentDate: "Mon, 03 Apr 2023 19:15:16 +0000"},
+		{Subject: "Weekly digest", From: "news@example.com", Body: "Nothing to see here.", SentDate: "Mon, 03 Apr 2023 20:15:16 +0000"},
+	})
+	if err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+	_, err = db.InsertDeletedEmails([]Email{
+		{Subject: "Old invoice", From: "billing@example.com", Body: "Archived.", SentDate: "Sun, 02 Apr 2023 18:15:16 +0000"},
+	})
+	if err != nil {
+		t.Fatalf("InsertDeletedEmails failed: %v", err)
+	}
+
+	results, total, err := db.Search("invoice", SearchOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if total != 3 {
+		t.Errorf("Expected 3 matches, got %d", total)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected a page of 2 results, got %d", len(results))
+	}
+	// Subject matches outrank body-only matches.
+	if !strings.Contains(results[0].Email.Subject, "invoice") {
+		t.Errorf("Expected a subject match first, got %+v", results[0].Email)
+	}
+	if !strings.Contains(results[0].Snippet, "[invoice]") {
+		t.Errorf("Expected highlighted snippet, got %q", results[0].Snippet)
+	}
+
+	page2, _, err := db.Search("invoice", SearchOptions{Limit: 2, Offset: 2})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(page2) != 1 {
+		t.Errorf("Expected 1 result on the second page, got %d", len(page2))
+	}
+
+	// Re-storing an email must not leave a stale copy in the index.
+	_, err = db.InsertEmails([]Email{
+		{Subject: "Team lunch", From: "alice@example.com", Body: "Sushi it is.", SentDate: "Mon, 03 Apr 2023 19:15:16 +0000"},
+	})
+	if err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+	_, total, err = db.Search("invoice", SearchOptions{})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if total != 2 {
+		t.Errorf("Expected 2 matches after update, got %d", total)
+	}
+
+	if _, _, err := db.Search(`"unbalanced`, SearchOptions{}); err == nil {
+		t.Errorf("Expected an error for an invalid query")
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !sqlite_fts5 && !fts5
+
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+// Without FTS5 the database still works; only Search is refused, and no
+// index is built.
+func TestSearchUnavailable(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	if _, err := db.InsertEmails([]Email{{Subject: "Your invoice", SentDate: "Mon, 03 Apr 2023 18:15:16 +0000"}}); err != nil {
+		t.Fatalf("InsertEmails failed: %v", err)
+	}
+
+	if _, _, err := db.Search("invoice", SearchOptions{}); !errors.Is(err, ErrSearchUnavailable) {
+		t.Errorf("Expected ErrSearchUnavailable, got %v", err)
+	}
+	var tables int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'email_search'`).Scan(&tables); err != nil || tables != 0 {
+		t.Errorf("Expected no search index, got %d tables, %v", tables, err)
+	}
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// similarCandidates bounds how many emails each candidate query of
+// SimilarEmails reads.
+const similarCandidates = 100
+
+// subjectStopWords are too common in subjects to make two emails similar.
+var subjectStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "you": true, "your": true, "with": true,
+	"from": true, "our": true, "this": true, "that": true, "are": true, "new": true,
+	"has": true, "have": true, "was": true, "not": true, "now": true, "fwd": true,
+}
+
+// SimilarEmails returns up to n stored emails most like email, for use as
+// examples of how such mail was handled: those from the same sender rank
+// first, then the same sender domain, then emails sharing subject words,
+// newest first among equals. email itself is left out, and so are the
+// candidates skip reports true for, e.g. those held out for evaluation;
+// nil skips none.
+func SimilarEmails(ctx context.Context, emailDB EmailDB, email Email, n int, skip func(Email) bool) ([]Email, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	address, domain := senderOf(email.From)
+	filters := []EmailFilter{{}}
+	if domain != "" {
+		filters = append(filters, EmailFilter{Sender: "@" + domain})
+	}
+	words := subjectWords(email.Subject)
+	for _, word := range longestWords(words, 3) {
+		filters = append(filters, EmailFilter{Text: word})
+	}
+
+	candidates := map[int64]Email{}
+	for _, filter := range filters {
+		page, err := emailDB.ListEmailsContext(ctx, filter, ListOptions{Limit: similarCandidates})
+		if err != nil {
+			return nil, err
+		}
+		for _, candidate := range page.Emails {
+			candidates[candidate.Id] = candidate
+		}
+	}
+
+	type scored struct {
+		email Email
+		score float64
+	}
+	var ranked []scored
+	for _, candidate := range candidates {
+		if candidate.Id == email.Id || sameEmail(candidate, email) || (skip != nil && skip(candidate)) {
+			continue
+		}
+		var score float64
+		candidateAddress, candidateDomain := senderOf(candidate.From)
+		switch {
+		case address != "" && candidateAddress == address:
+			score += 3
+		case domain != "" && candidateDomain == domain:
+			score += 1
+		}
+		score += 2 * jaccard(words, subjectWords(candidate.Subject))
+		ranked = append(ranked, scored{candidate, score})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		if !ranked[i].email.SentAt.Equal(ranked[j].email.SentAt) {
+			return ranked[i].email.SentAt.After(ranked[j].email.SentAt)
+		}
+		return ranked[i].email.Id > ranked[j].email.Id
+	})
+
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	similar := make([]Email, len(ranked))
+	for i, r := range ranked {
+		similar[i] = r.email
+	}
+	return similar, nil
+}
+
+// sameEmail reports whether a and b have the same key columns.
+func sameEmail(a, b Email) bool {
+	return a.Subject == b.Subject && a.From == b.From && a.To == b.To && a.SentDate == b.SentDate
+}
+
+// senderOf returns the lower-cased address and domain of a From header.
+func senderOf(from string) (address, domain string) {
+	list := ParseAddressList(from)
+	if len(list) == 0 {
+		return "", ""
+	}
+	address = strings.ToLower(list[0].Address)
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		domain = address[at+1:]
+	}
+	return address, domain
+}
+
+// subjectWords returns the distinct lower-cased words of a subject, less
+// stop words and words shorter than three letters.
+func subjectWords(subject string) map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(subject), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) >= 3 && !subjectStopWords[word] {
+			words[word] = true
+		}
+	}
+	return words
+}
+
+// longestWords returns up to n of words, longest first, as the likeliest
+// to be distinctive.
+func longestWords(words map[string]bool, n int) []string {
+	list := make([]string, 0, len(words))
+	for word := range words {
+		list = append(list, word)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if len(list[i]) != len(list[j]) {
+			return len(list[i]) > len(list[j])
+		}
+		return list[i] < list[j]
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for word := range a {
+		if b[word] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
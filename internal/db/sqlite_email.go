@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -11,313 +12,756 @@ import (
 )
 
 type SQLiteDB struct {
-	DB *sql.DB
+	DB   *sql.DB
+	path string
 }
 
-func NewSQLiteDB(filename string) *SQLiteDB {
+// NewSQLiteDB opens the database in filename for the commands that read and
+// write emails. A new database is given the latest schema; one at another
+// version is refused until it is migrated with MigrateTo, as `db migrate`
+// does, so that a migration, and the backup before it, is never implicit.
+func NewSQLiteDB(filename string) (*SQLiteDB, error) {
+	sqliteDB, err := OpenSQLiteDB(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := sqliteDB.checkSchema(); err != nil {
+		sqliteDB.DB.Close()
+		return nil, err
+	}
+
+	if err := sqliteDB.ensureSearchIndex(); err != nil {
+		sqliteDB.DB.Close()
+		return nil, err
+	}
+
+	return sqliteDB, nil
+}
+
+// OpenSQLiteDB opens the database in filename without looking at its
+// schema, for managing the schema with Migrate, MigrateTo and
+// MigrationStatus.
+func OpenSQLiteDB(filename string) (*SQLiteDB, error) {
 	db, err := sql.Open("sqlite3", filename)
 	if err != nil {
-		log.Fatal("Failed to open database:", err)
-	}
-
-	sqliteDB := &SQLiteDB{DB: db}
-	sqliteDB.createTable()
-
-	return sqliteDB
-}
-
-func (s *SQLiteDB) createTable() {
-	query := `CREATE TABLE IF NOT EXISTS emails (
-		id INTEGER PRIMARY KEY,
-		"subject" TEXT,
-		"body" TEXT,
-		"from" TEXT,
-		"to"	TEXT,
-		"Cc" TEXT,
-		"Bcc" TEXT,
-		"sentDate" TEXT,
-		"sender" TEXT,
-		"read" BOOLEAN DEFAULT 0,
-		"deleted" BOOLEAN DEFAULT 0,
-		"labels" TEXT,
-		created_at DATETIME,
-		UNIQUE(subject, "from", "to", "sentDate")
-	);`
-
-	_, err := s.DB.Exec(query)
+		return nil, err
+	}
+	return &SQLiteDB{DB: db, path: filename}, nil
+}
+
+// checkSchema migrates a database without data to the latest schema and
+// refuses any other database not at the latest version.
+func (s *SQLiteDB) checkSchema() error {
+	m, err := newMigrator(s.DB, "sqlite")
+	if err != nil {
+		return err
+	}
+	version, err := m.version()
 	if err != nil {
-		log.Fatal("Failed to create table:", err)
-	}
-
-	// create the deleted_emails table
-	deletedQuery := `CREATE TABLE IF NOT EXISTS deleted_emails (
-		id INTEGER PRIMARY KEY,
-		"subject" TEXT,
-		"body" TEXT,
-		"from" TEXT,
-		"to"	TEXT,
-		"Cc" TEXT,
-		"Bcc" TEXT,
-		"sentDate" TEXT,
-		"sender" TEXT,
-		"read" BOOLEAN DEFAULT 0,
-		"deleted" BOOLEAN DEFAULT 0,
-		"labels" TEXT,
-		created_at DATETIME,
-		UNIQUE(subject, "from", "to", "sentDate")
-	);`
-
-	_, err = s.DB.Exec(deletedQuery)
+		return err
+	}
+	switch hasData, err := s.hasData(); {
+	case err != nil:
+		return err
+	case !hasData:
+		_, err := s.migrate(m, m.latest())
+		return err
+	case version < m.latest():
+		return fmt.Errorf("database %s has schema version %d, this build needs %d: run `db migrate` to upgrade it (a backup is taken first)",
+			s.path, version, m.latest())
+	case version > m.latest():
+		return fmt.Errorf("database %s has schema version %d, newer than this build's %d: use a newer build, or `db migrate --to %d` to downgrade it",
+			s.path, version, m.latest(), m.latest())
+	}
+	return nil
+}
+
+// Migrate brings the schema up to the latest version. See MigrateTo.
+func (s *SQLiteDB) Migrate() (backup string, err error) {
+	m, err := newMigrator(s.DB, "sqlite")
 	if err != nil {
-		log.Fatal("Failed to create table:", err)
+		return "", err
 	}
+	return s.migrate(m, m.latest())
+}
 
+// MigrateTo runs the up or down migrations needed to reach version. If the
+// database already holds data it is first copied to a timestamped backup
+// next to the database file, whose path is returned.
+func (s *SQLiteDB) MigrateTo(version int) (backup string, err error) {
+	m, err := newMigrator(s.DB, "sqlite")
+	if err != nil {
+		return "", err
+	}
+	return s.migrate(m, version)
+}
+
+func (s *SQLiteDB) migrate(m *migrator, version int) (string, error) {
+	steps, down, err := m.plan(version)
+	if err != nil || len(steps) == 0 {
+		return "", err
+	}
+
+	var backup string
+	hasData, err := s.hasData()
+	if err != nil {
+		return "", err
+	}
+	if hasData {
+		current, err := m.version()
+		if err != nil {
+			return "", err
+		}
+		backup = fmt.Sprintf("%s.v%d-%s.bak", s.path, current, time.Now().Format("20060102T150405"))
+		if err := s.Backup(backup); err != nil {
+			return "", fmt.Errorf("pre-migration backup failed, not migrating: %v", err)
+		}
+		log.Printf("Backed up database to %s", backup)
+	}
+
+	return backup, m.run(steps, down)
+}
+
+// hasData reports whether the database holds any table besides the
+// migration bookkeeping, i.e. whether a migration could lose anything.
+func (s *SQLiteDB) hasData() (bool, error) {
+	var count int
+	err := s.DB.QueryRow(`SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence')`).Scan(&count)
+	return count > 0, err
+}
+
+// Backup writes a consistent copy of the database to path.
+func (s *SQLiteDB) Backup(path string) error {
+	_, err := s.DB.Exec(`VACUUM INTO $1`, path)
+	return err
+}
+
+// MigrationStatus lists every known migration and whether it is applied.
+func (s *SQLiteDB) MigrationStatus() ([]MigrationStatus, error) {
+	m, err := newMigrator(s.DB, "sqlite")
+	if err != nil {
+		return nil, err
+	}
+	return m.status()
 }
 
 func (s *SQLiteDB) InsertEmail(email *Email) (int64, error) {
-	query := `INSERT OR REPLACE INTO emails 
-				(subject, body, "from", "to", "Cc", "Bcc", "sentDate", "sender", "read", "deleted", "labels", created_at) 
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, datetime('now'))`
+	return s.InsertEmailContext(context.Background(), email)
+}
+
+func (s *SQLiteDB) InsertEmailContext(ctx context.Context, email *Email) (int64, error) {
+	query := sqliteInsertQuery()
 
-	convertedDate, err := parseSentDate(email.SentDate)
+	sentDate, err := email.sentTime()
 	if err != nil {
 		// Handle the error, e.g., skip the email or log the issue
 		return 0, err
 	}
 
-	result, err := s.DB.Exec(query, email.Subject, email.Body,
-		email.From, email.To, email.Cc,
-		email.Bcc, convertedDate, email.Sender, email.Read, email.Deleted, email.Labels)
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, err
 	}
+	defer tx.Rollback()
 
-	id, err := result.LastInsertId()
+	var id int64
+	err = tx.QueryRowContext(ctx, query, sqliteInsertArgs(email, sentDate)...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %s", ErrDuplicate, email.Subject)
+	}
 	if err != nil {
 		return 0, err
 	}
 
-	if id == 0 {
-		return 0, fmt.Errorf("duplicate email detected")
+	derived, err := newDerivedWriter(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	defer derived.close()
+	if err := derived.write(ctx, id, email); err != nil {
+		return 0, err
 	}
 
-	return id, nil
+	return id, tx.Commit()
 }
 
 // implementation of batch InsertEmails
 func (s *SQLiteDB) InsertEmails(emails []Email) (int64, error) {
-	baseQuery := `INSERT OR REPLACE INTO emails 
-                (subject, body, "from", "to", "Cc", "Bcc", "sentDate", "sender", "read", "deleted", "labels", created_at)
-                VALUES `
-	valueStrings := []string{}
-	valueArgs := []interface{}{}
-
-	for _, email := range emails {
-		convertedDate, err := parseSentDate(email.SentDate)
-		if err != nil {
-			log.Printf("Failed to parse sent date: %v", err)
+	return s.InsertEmailsContext(context.Background(), emails)
+}
+
+func (s *SQLiteDB) InsertEmailsContext(ctx context.Context, emails []Email) (int64, error) {
+	result, err := s.StoreEmails(ctx, emailChan(emails))
+	logSkipped(result)
+	return result.Stored(), err
+}
+
+func (s *SQLiteDB) InsertDeletedEmails(emails []Email) (int64, error) {
+	return s.InsertDeletedEmailsContext(context.Background(), emails)
+}
+
+func (s *SQLiteDB) InsertDeletedEmailsContext(ctx context.Context, emails []Email) (int64, error) {
+	result, err := s.StoreEmails(ctx, emailChan(trashedEmails(emails)))
+	logSkipped(result)
+	return result.Stored(), err
+}
+
+// sqliteMaxParams is SQLite's default limit on bound parameters per
+// statement in releases before 3.32, the lowest we may meet.
+const sqliteMaxParams = 999
+
+// sqliteInsertColumns are the columns bound per row by StoreEmails.
+// "sentDate" holds the sender's wall clock time, as it always has, so that
+// re-synced emails match their stored rows; sent_at is the instant.
+var sqliteInsertColumns = []string{`"subject"`, `"body"`, `"from"`, `"to"`, `"Cc"`, `"Bcc"`, `"sentDate"`,
+	`"sender"`, `"read"`, `"deleted"`, `"labels"`, `"size"`, `"has_attachment"`, `"state"`, `trashed_at`,
+	`sent_at`, `sent_offset`, `date_header`, `reply_to`}
+
+// sqliteChunkRows is the most rows one INSERT can carry within sqliteMaxParams.
+var sqliteChunkRows = sqliteMaxParams / len(sqliteInsertColumns)
+
+func sqliteInsertArgs(email *Email, sentDate time.Time) []interface{} {
+	state := email.state()
+	var trashedAt interface{}
+	if state == StateTrashed {
+		trashedAt = time.Now().UTC().Format(sentDateLayout)
+	}
+	return []interface{}{email.Subject, email.Body, email.From, email.To, email.Cc, email.Bcc,
+		sentDate.Format(sentDateLayout), email.Sender, email.Read, email.Deleted, email.Labels,
+		email.Size, email.HasAttachment, string(state), trashedAt,
+		sentDate.Unix(), sentOffset(sentDate), email.SentDate, email.ReplyTo}
+}
+
+// sqliteUpsertQuery inserts rows emails, updating in place any already
+// stored so that ids, created_at and first_seen_at survive a re-sync and
+// the email_events triggers see the change. trashed_at keeps the time an
+// email was first seen in the trash and is cleared when it leaves.
+func sqliteUpsertQuery(rows int) string {
+
+	var updates []string
+	for _, column := range sqliteInsertColumns {
+		switch column {
+		case `"subject"`, `"from"`, `"to"`, `"sentDate"`:
 			continue
+		case `trashed_at`:
+			updates = append(updates, `trashed_at = CASE WHEN excluded."state" = 'trashed' THEN COALESCE(emails.trashed_at, excluded.trashed_at) END`)
+		default:
+			updates = append(updates, fmt.Sprintf("%[1]s = excluded.%[1]s", column))
 		}
-
-		valueStrings = append(valueStrings, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, datetime('now'))")
-		valueArgs = append(valueArgs, email.Subject, email.Body, email.From, email.To, email.Cc, email.Bcc, convertedDate, email.Sender, email.Read, email.Deleted, email.Labels)
 	}
 
-	query := baseQuery + strings.Join(valueStrings, ",")
+	return fmt.Sprintf(`INSERT INTO emails (%s, created_at, first_seen_at) VALUES %s
+		ON CONFLICT ("subject", "from", "to", "sentDate") DO UPDATE SET %s`,
+		strings.Join(sqliteInsertColumns, ", "),
+		strings.TrimSuffix(strings.Repeat(sqliteInsertValues+", ", rows), ", "),
+		strings.Join(updates, ", "))
+}
+
+// sqliteInsertValues binds one row of sqliteInsertColumns.
+var sqliteInsertValues = "(" + strings.Repeat("?, ", len(sqliteInsertColumns)) + "datetime('now'), datetime('now'))"
+
+// sqliteInsertQuery inserts one email, returning no row if it is already
+// stored.
+func sqliteInsertQuery() string {
+	return fmt.Sprintf(`INSERT INTO emails (%s, created_at, first_seen_at) VALUES %s
+		ON CONFLICT ("subject", "from", "to", "sentDate") DO NOTHING RETURNING id`,
+		strings.Join(sqliteInsertColumns, ", "), sqliteInsertValues)
+}
+
+// sqliteUpdateQuery sets every column of sqliteInsertColumns of the email
+// with the id bound last, keeping trashed_at if it stays in the trash.
+func sqliteUpdateQuery() string {
+	var sets []string
+	for _, column := range sqliteInsertColumns {
+		if column == `trashed_at` {
+			sets = append(sets, `trashed_at = CASE WHEN ? IS NULL THEN NULL ELSE COALESCE(trashed_at, datetime('now')) END`)
+			continue
+		}
+		sets = append(sets, column+" = ?")
+	}
+	return `UPDATE emails SET ` + strings.Join(sets, ", ") + ` WHERE id = ?`
+}
 
-	// Start a transaction
-	tx, err := s.DB.Begin()
+// StoreEmails writes the emails received on the channel until it is closed,
+// updating stored copies in place, in chunks of multi-row prepared statements inside
+// a single transaction. Emails whose sent date does not parse are skipped.
+// If ctx is cancelled nothing is written; the sender should stop too.
+func (s *SQLiteDB) StoreEmails(ctx context.Context, emails <-chan Email) (BatchResult, error) {
+	var result BatchResult
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("Failed to start transaction: %v", err)
-		return 0, err
+		return result, err
 	}
 
-	result, err := tx.Exec(query, valueArgs...)
+	derived, err := newDerivedWriter(ctx, tx)
 	if err != nil {
-		log.Printf("Failed to execute query: %v", err)
 		tx.Rollback()
-		return 0, err
+		return result, err
 	}
+	defer derived.close()
 
-	rowsAffected, err := result.RowsAffected()
+	batch := &sqliteBatch{tx: tx, stmts: map[string]*sql.Stmt{}, derived: derived}
+	defer batch.close()
+
+	err = readBatches(ctx, emails, sqliteChunkRows, &result, func(rows []pendingRow) error {
+		return batch.write(ctx, rows, &result)
+	})
 	if err != nil {
-		log.Printf("Failed to get rows affected: %v", err)
 		tx.Rollback()
-		return 0, err
+		return BatchResult{}, err
 	}
 
 	if err := tx.Commit(); err != nil {
-		log.Printf("Failed to commit transaction: %v", err)
-		tx.Rollback()
-		return 0, err
+		return BatchResult{}, err
 	}
-
-	return rowsAffected, nil
+	return result, nil
 }
 
-func (s *SQLiteDB) InsertDeletedEmails(emails []Email) (int64, error) {
-	baseQuery := `INSERT OR REPLACE INTO deleted_emails 
-                (subject, body, "from", "to", "Cc", "Bcc", "sentDate", "sender", "read", "deleted", "labels", created_at)
-                VALUES `
-	valueStrings := []string{}
-	valueArgs := []interface{}{}
-
-	for _, email := range emails {
-		convertedDate, err := parseSentDate(email.SentDate)
-		if err != nil {
-			log.Printf("Failed to parse sent date: %v", err)
-			continue
-		}
+// sqliteBatch writes chunks within one transaction. Statements are
+// prepared once per chunk size, so a long sync prepares at most two of each.
+type sqliteBatch struct {
+	tx      *sql.Tx
+	stmts   map[string]*sql.Stmt
+	derived *derivedWriter
+}
 
-		valueStrings = append(valueStrings, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, datetime('now'))")
-		valueArgs = append(valueArgs, email.Subject, email.Body, email.From, email.To, email.Cc, email.Bcc, convertedDate, email.Sender, email.Read, email.Deleted, email.Labels)
+func (b *sqliteBatch) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	if stmt, ok := b.stmts[query]; ok {
+		return stmt, nil
 	}
+	stmt, err := b.tx.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	b.stmts[query] = stmt
+	return stmt, nil
+}
 
-	query := baseQuery + strings.Join(valueStrings, ",")
+func (b *sqliteBatch) close() {
+	for _, stmt := range b.stmts {
+		stmt.Close()
+	}
+}
 
-	// Start a transaction
-	tx, err := s.DB.Begin()
+func (b *sqliteBatch) write(ctx context.Context, rows []pendingRow, result *BatchResult) error {
+	existing, err := b.existing(ctx, rows)
 	if err != nil {
-		log.Printf("Failed to start transaction: %v", err)
-		return 0, err
+		return err
 	}
 
-	result, err := tx.Exec(query, valueArgs...)
+	stmt, err := b.stmt(ctx, sqliteUpsertQuery(len(rows))+` RETURNING id, "subject", "from", "to", "sentDate"`)
 	if err != nil {
-		log.Printf("Failed to execute query: %v", err)
-		tx.Rollback()
-		return 0, err
+		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	args := make([]interface{}, 0, len(rows)*len(sqliteInsertColumns))
+	for i := range rows {
+		args = append(args, sqliteInsertArgs(&rows[i].email, rows[i].sentDate)...)
+	}
+	stored, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
-		log.Printf("Failed to get rows affected: %v", err)
-		tx.Rollback()
-		return 0, err
+		return err
+	}
+	ids := map[emailKey]int64{}
+	for stored.Next() {
+		var id int64
+		var key emailKey
+		if err := stored.Scan(&id, &key.subject, &key.from, &key.to, &key.sentDate); err != nil {
+			stored.Close()
+			return err
+		}
+		ids[key] = id
+	}
+	stored.Close()
+	if err := stored.Err(); err != nil {
+		return err
 	}
 
-	if err := tx.Commit(); err != nil {
-		log.Printf("Failed to commit transaction: %v", err)
-		tx.Rollback()
-		return 0, err
+	for i, row := range rows {
+		if err := b.derived.write(ctx, ids[row.key()], &rows[i].email); err != nil {
+			return err
+		}
 	}
 
-	return rowsAffected, nil
+	for _, row := range rows {
+		status := RowInserted
+		if existing[row.key()] {
+			status = RowUpdated
+		}
+		// A later copy in the same chunk replaces this one.
+		existing[row.key()] = true
+		result.add(RowResult{Index: row.index, Subject: row.email.Subject, Status: status})
+	}
+	return nil
 }
 
-func (s *SQLiteDB) GetEmails(tableName string) ([]Email, error) {
-	query := fmt.Sprintf(`SELECT id, 
-	"subject", 
-	"body",
-	"from", 
-	"to", 
-	"Cc", 
-	"Bcc", 
-	"sentDate", 
-	"sender", 
-	"read",
-	"deleted",
-	"labels",
-	created_at			
-	FROM %s ORDER BY id DESC LIMIT 50`, tableName)
+// existing returns which of the rows' keys are already stored.
+func (b *sqliteBatch) existing(ctx context.Context, rows []pendingRow) (map[emailKey]bool, error) {
+	query := fmt.Sprintf(`SELECT "subject", "from", "to", "sentDate" FROM emails
+		WHERE ("subject", "from", "to", "sentDate") IN (VALUES %s)`,
+		strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?), ", len(rows)), ", "))
+	stmt, err := b.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
 
-	rows, err := s.DB.Query(query)
+	args := make([]interface{}, 0, len(rows)*4)
+	for _, row := range rows {
+		key := row.key()
+		args = append(args, key.subject, key.from, key.to, key.sentDate)
+	}
+	found, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	emails := []Email{}
-	for rows.Next() {
-		var email Email
-		err = rows.Scan(&email.Id,
-			&email.Subject,
-			&email.Body,
-			&email.From,
-			&email.To,
-			&email.Cc,
-			&email.Bcc,
-			&email.SentDate,
-			&email.Sender,
-			&email.Read,
-			&email.Deleted,
-			&email.Labels,
-			&email.CreatedAt)
-		if err != nil {
+	defer found.Close()
+
+	existing := map[emailKey]bool{}
+	for found.Next() {
+		var subject, from, to, sentDate string
+		if err := found.Scan(&subject, &from, &to, &sentDate); err != nil {
 			return nil, err
 		}
-
-		emails = append(emails, email)
+		existing[emailKey{subject, from, to, sentDate}] = true
 	}
+	return existing, found.Err()
+}
+
+// sqliteEmailColumns is the column list scanned by scanSQLiteEmail.
+const sqliteEmailColumns = `id, "subject", "body", "from", "to", "Cc", "Bcc", "sentDate",
+	"sender", "read", "deleted", "labels", "size", "has_attachment", created_at,
+	"state", trashed_at, first_seen_at, sent_at, sent_offset, date_header, reply_to`
 
-	return emails, nil
+func scanSQLiteEmail(rows *sql.Rows) (Email, cursor, error) {
+	var email Email
+	var c cursor
+	var trashedAt, firstSeenAt, dateHeader sql.NullString
+	var sentEpoch sql.NullInt64
+	var offset int
+	err := rows.Scan(&email.Id,
+		&email.Subject,
+		&email.Body,
+		&email.From,
+		&email.To,
+		&email.Cc,
+		&email.Bcc,
+		&email.SentDate,
+		&email.Sender,
+		&email.Read,
+		&email.Deleted,
+		&email.Labels,
+		&email.Size,
+		&email.HasAttachment,
+		&email.CreatedAt,
+		&email.State,
+		&trashedAt,
+		&firstSeenAt,
+		&sentEpoch,
+		&offset,
+		&dateHeader,
+		&email.ReplyTo,
+		&c.Key)
+	email.TrashedAt, email.FirstSeenAt = trashedAt.String, firstSeenAt.String
+	if dateHeader.String != "" {
+		email.SentDate = dateHeader.String
+	}
+	if sentEpoch.Valid {
+		email.SentAt = sentAt(sentEpoch.Int64, offset)
+	}
+	c.ID = email.Id
+	return email, c, err
 }
 
-func (s *SQLiteDB) UpdateEmailReadStatus(id int64, read bool) error {
-	query := `UPDATE emails SET read = $1 WHERE id = $2`
-	_, err := s.DB.Exec(query, read, id)
-	return err
+// ListEmails returns one page of emails. A zero Limit returns up to 50.
+func (s *SQLiteDB) ListEmails(filter EmailFilter, opts ListOptions) (EmailPage, error) {
+	return s.ListEmailsContext(context.Background(), filter, opts)
 }
 
-func (s *SQLiteDB) UpdateEmailLabels(id int64, labels string) error {
-	query := `UPDATE emails SET labels = $1 WHERE id = $2`
+func (s *SQLiteDB) ListEmailsContext(ctx context.Context, filter EmailFilter, opts ListOptions) (EmailPage, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 50
+	}
+	limit := opts.Limit
+	opts.Limit = pageLimit(limit)
 
-	_, err := s.DB.Exec(query, labels, id)
-	return err
+	it, err := s.IterateEmailsContext(ctx, filter, opts)
+	if err != nil {
+		return EmailPage{}, err
+	}
+	return listPage(it, limit)
 }
 
-func (s *SQLiteDB) GetEmail(tableName string, subject string, from string, to string, sentDate string) (Email, error) {
-	query := `SELECT id, 
-				"subject", 
-				"from", 
-				"to", 
-				"sentDate",
-				"labels"
-				FROM emails WHERE subject = $1 AND "from" = $2 AND "to" = $3 AND "sentDate" = $4 ORDER BY created_at DESC`
+// IterateEmails streams every email matching filter, up to opts.Limit if
+// set, without loading them all into memory.
+func (s *SQLiteDB) IterateEmails(filter EmailFilter, opts ListOptions) (*EmailIterator, error) {
+	return s.IterateEmailsContext(context.Background(), filter, opts)
+}
 
-	var email Email
-	err := s.DB.QueryRow(query, subject, from, to, sentDate).Scan(&email.Id,
-		&email.Subject,
-		&email.From,
-		&email.To,
-		&email.SentDate,
-		&email.Labels)
+func (s *SQLiteDB) IterateEmailsContext(ctx context.Context, filter EmailFilter, opts ListOptions) (*EmailIterator, error) {
+	query, args, err := sqliteListQuery(filter, opts)
 	if err != nil {
-		return email, err
+		return nil, err
 	}
 
-	return email, nil
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &EmailIterator{rows: rows, scan: scanSQLiteEmail}, nil
+}
 
+// FindEmail returns the most recently stored email matching filter, or
+// ErrNotFound.
+func (s *SQLiteDB) FindEmail(filter EmailFilter) (Email, error) {
+	return s.FindEmailContext(context.Background(), filter)
 }
 
-func parseSentDate(dateStr string) (string, error) {
-	formats := []string{
-		"Mon, 02 Jan 2006 15:04:05 -0700",
-		"Mon, 02 Jan 2006 15:04:05 -0700 (MST)",
-		"Sat, 1 Apr 2023 12:37:18 +0000",
-		"Mon, 03 Apr 2023 18:15:16 +0000 (UTC)",
-		"3 Apr 2023 01:14:34 -0500",
-		"Mon, 2 Jan 2006 15:04:05 -0700",
-		"Tue, 4 Apr 2023 00:17:49 +0000 (UTC)",
-		"Tue, 4 Apr 2023 00:17:49 +0000 (UTC)",
-		"Mon, 3 Apr 2023 12:17:07 -0400 (EDT)",
-		"Mon, 2 Jan 2006 15:04:05 -0700",       // Single-digit day without timezone abbreviation
-		"Mon, 2 Jan 2006 15:04:05 -0700 (MST)", // Single-digit day with timezone abbreviation
+func (s *SQLiteDB) FindEmailContext(ctx context.Context, filter EmailFilter) (Email, error) {
+	page, err := s.ListEmailsContext(ctx, filter, ListOptions{Sort: SortByCreatedAt, Limit: 1})
+	if err != nil {
+		return Email{}, err
+	}
+	if len(page.Emails) == 0 {
+		return Email{}, ErrNotFound
 	}
+	return page.Emails[0], nil
+}
 
-	var t time.Time
-	var err error
+func sqliteListQuery(filter EmailFilter, opts ListOptions) (string, []interface{}, error) {
+	sortField, err := opts.sortField()
+	if err != nil {
+		return "", nil, err
+	}
+	sortKey := map[SortField]string{
+		SortBySentDate:  `COALESCE(strftime('%Y-%m-%d %H:%M:%S', sent_at, 'unixepoch'), '')`,
+		SortByCreatedAt: `COALESCE(created_at, '')`,
+		SortByID:        `''`,
+	}[sortField]
+
+	c := &queryCompiler{now: time.Now(), dialect: sqliteQueryDialect}
+	where, err := sqliteFilterWhere(c, filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	query := fmt.Sprintf(`SELECT %s, %s AS sort_key FROM emails WHERE %s`, sqliteEmailColumns, sortKey, where)
+	args := c.args
+
+	cmp, dir := "<", "DESC"
+	if opts.Ascending {
+		cmp, dir = ">", "ASC"
+	}
+
+	after, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return "", nil, err
+	}
+	if after != nil {
+		query += fmt.Sprintf(` AND (%s, id) %s (?, ?)`, sortKey, cmp)
+		args = append(args, after.Key, after.ID)
+	}
+
+	query += fmt.Sprintf(` ORDER BY sort_key %[1]s, id %[1]s`, dir)
+	if opts.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, opts.Limit)
+	}
+
+	return query, args, nil
+}
 
-	for _, format := range formats {
-		t, err = time.Parse(format, dateStr)
-		if err == nil {
-			break
+func sqliteFilterWhere(c *queryCompiler, f EmailFilter) (string, error) {
+	conds := []string{"1"}
+
+	if len(f.IDs) > 0 {
+		ids := make([]string, len(f.IDs))
+		for i, id := range f.IDs {
+			ids[i] = c.arg(id)
+		}
+		conds = append(conds, `id IN (`+strings.Join(ids, ", ")+`)`)
+	}
+	if f.State != StateAll {
+		conds = append(conds, `"state" = `+c.arg(string(f.State)))
+	}
+	for _, label := range f.Labels {
+		conds = append(conds, c.hasLabel(label))
+	}
+	if f.Sender != "" {
+		conds = append(conds, c.like([]string{`"from"`}, f.Sender))
+	}
+	if !f.After.IsZero() {
+		conds = append(conds, `sent_at >= `+c.arg(f.After.Unix()))
+	}
+	if !f.Before.IsZero() {
+		conds = append(conds, `sent_at < `+c.arg(f.Before.Unix()))
+	}
+	if f.Read != nil {
+		conds = append(conds, `"read" = `+c.arg(*f.Read))
+	}
+	if f.Deleted != nil {
+		conds = append(conds, `"deleted" = `+c.arg(*f.Deleted))
+	}
+	if f.Text != "" {
+		conds = append(conds, c.like([]string{`"subject"`, `"body"`}, f.Text))
+	}
+	if f.Subject != "" {
+		conds = append(conds, `"subject" = `+c.arg(f.Subject))
+	}
+	if f.From != "" {
+		conds = append(conds, `"from" = `+c.arg(f.From))
+	}
+	if f.To != "" {
+		conds = append(conds, `"to" = `+c.arg(f.To))
+	}
+	if f.SentDate != "" {
+		// Dates are stored normalized; accept either form.
+		sentDate := f.SentDate
+		if converted, err := parseSentDate(sentDate); err == nil {
+			sentDate = converted
 		}
+		conds = append(conds, `"sentDate" = `+c.arg(sentDate))
 	}
+	if f.Query != nil {
+		where, err := c.node(f.Query.root)
+		if err != nil {
+			return "", err
+		}
+		conds = append(conds, where)
+	}
+
+	return strings.Join(conds, " AND "), nil
+}
+
+// EmailEvents returns the recorded changes to an email, oldest first.
+func (s *SQLiteDB) EmailEvents(emailID int64) ([]EmailEvent, error) {
+	return s.EmailEventsContext(context.Background(), emailID)
+}
 
+func (s *SQLiteDB) EmailEventsContext(ctx context.Context, emailID int64) ([]EmailEvent, error) {
+	rows, err := s.DB.QueryContext(ctx, emailEventsQuery, emailID)
 	if err != nil {
-		return "", fmt.Errorf("unable to parse date string: %s", dateStr)
+		return nil, err
 	}
+	return scanEmailEvents(rows)
+}
+
+func (s *SQLiteDB) UpdateEmailReadStatus(id int64, read bool) error {
+	return s.UpdateEmailReadStatusContext(context.Background(), id, read)
+}
+
+func (s *SQLiteDB) UpdateEmailReadStatusContext(ctx context.Context, id int64, read bool) error {
+	query := `UPDATE emails SET read = $1 WHERE id = $2`
+	result, err := s.DB.ExecContext(ctx, query, read, id)
+	return updateResult(result, err, id)
+}
+
+func (s *SQLiteDB) UpdateEmailLabels(id int64, labels string) error {
+	return s.UpdateEmailLabelsContext(context.Background(), id, labels)
+}
+
+func (s *SQLiteDB) UpdateEmailLabelsContext(ctx context.Context, id int64, labels string) error {
+	query := `UPDATE emails SET labels = $1 WHERE id = $2`
+
+	result, err := s.DB.ExecContext(ctx, query, labels, id)
+	return updateResult(result, err, id)
+}
+
+// ListContacts returns correspondents with their totals, see Contact.
+func (s *SQLiteDB) ListContacts(opts ContactOptions) ([]Contact, error) {
+	return s.ListContactsContext(context.Background(), opts)
+}
+
+func (s *SQLiteDB) ListContactsContext(ctx context.Context, opts ContactOptions) ([]Contact, error) {
+	return listContacts(ctx, s.DB, contactsDialect{
+		lastContact: `e.sent_at`,
+		like:        "LIKE",
+	}, opts)
+}
+
+// RebuildContacts re-parses the addresses of every stored email and
+// returns how many emails it indexed.
+func (s *SQLiteDB) RebuildContacts() (int, error) {
+	return s.RebuildContactsContext(context.Background())
+}
+
+func (s *SQLiteDB) RebuildContactsContext(ctx context.Context) (int, error) {
+	return rebuildAddresses(ctx, s.DB)
+}
+
+// RawMessage returns the stored raw source of an email, or ErrNotFound if
+// it has none.
+func (s *SQLiteDB) RawMessage(emailID int64) (RawMessage, error) {
+	return s.RawMessageContext(context.Background(), emailID)
+}
+
+func (s *SQLiteDB) RawMessageContext(ctx context.Context, emailID int64) (RawMessage, error) {
+	return rawMessage(ctx, s.DB, emailID)
+}
+
+// ReparseEmails rebuilds the columns and addresses of every email stored
+// with its raw source from parse's result, see ReparseResult.
+func (s *SQLiteDB) ReparseEmails(ctx context.Context, parse ParseFunc) (ReparseResult, error) {
+	query := sqliteUpdateQuery()
+	return reparseEmails(ctx, s.DB, parse, func(ctx context.Context, tx *sql.Tx, id int64, email *Email, sentDate time.Time) error {
+		result, err := tx.ExecContext(ctx, query, append(sqliteInsertArgs(email, sentDate), id)...)
+		return updateResult(result, err, id)
+	})
+}
+
+// StorePrediction records prediction and sets its ID, see Prediction.
+func (s *SQLiteDB) StorePrediction(prediction *Prediction) error {
+	return s.StorePredictionContext(context.Background(), prediction)
+}
+
+func (s *SQLiteDB) StorePredictionContext(ctx context.Context, prediction *Prediction) error {
+	return storePrediction(ctx, s.DB, prediction)
+}
+
+// PredictedEmails returns the ids of the emails with a prediction by model
+// and promptVersion.
+func (s *SQLiteDB) PredictedEmails(model, promptVersion string) (map[int64]bool, error) {
+	return s.PredictedEmailsContext(context.Background(), model, promptVersion)
+}
+
+func (s *SQLiteDB) PredictedEmailsContext(ctx context.Context, model, promptVersion string) (map[int64]bool, error) {
+	return predictedEmails(ctx, s.DB, model, promptVersion)
+}
+
+// RecordUsage adds u to the llm_usage ledger and sets its ID.
+func (s *SQLiteDB) RecordUsage(u *UsageRecord) error {
+	return s.RecordUsageContext(context.Background(), u)
+}
+
+func (s *SQLiteDB) RecordUsageContext(ctx context.Context, u *UsageRecord) error {
+	return recordUsage(ctx, s.DB, u)
+}
+
+// ListUsage returns the ledger entries recorded since since, oldest first.
+func (s *SQLiteDB) ListUsage(since time.Time) ([]UsageRecord, error) {
+	return s.ListUsageContext(context.Background(), since)
+}
+
+func (s *SQLiteDB) ListUsageContext(ctx context.Context, since time.Time) ([]UsageRecord, error) {
+	return listUsage(ctx, s.DB, since)
+}
+
+// SaveModel records a fine-tuning job in the models table, or updates the
+// one with the same job ID, and sets m's ID.
+func (s *SQLiteDB) SaveModel(m *ModelRecord) error {
+	return s.SaveModelContext(context.Background(), m)
+}
+
+func (s *SQLiteDB) SaveModelContext(ctx context.Context, m *ModelRecord) error {
+	return saveModel(ctx, s.DB, m)
+}
+
+// ListModels returns the models table, newest job first.
+func (s *SQLiteDB) ListModels() ([]ModelRecord, error) {
+	return s.ListModelsContext(context.Background())
+}
 
-	return t.Format("2006-01-02 15:04:05"), nil
+func (s *SQLiteDB) ListModelsContext(ctx context.Context) ([]ModelRecord, error) {
+	return listModels(ctx, s.DB)
 }
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// UsageRecord is one paid chat request in the llm_usage ledger.
+type UsageRecord struct {
+	ID        int64
+	Model     string
+	Operation string
+
+	PromptTokens     int
+	CompletionTokens int
+	// Cost is in USD, at the prices configured when it was recorded.
+	Cost float64
+	// CreatedAt defaults to now; it is kept to the second.
+	CreatedAt time.Time
+}
+
+func recordUsage(ctx context.Context, db *sql.DB, u *UsageRecord) error {
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now()
+	}
+	return db.QueryRowContext(ctx, `INSERT INTO llm_usage
+			(model, operation, prompt_tokens, completion_tokens, cost, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		u.Model, u.Operation, u.PromptTokens, u.CompletionTokens, u.Cost, u.CreatedAt.Unix(),
+	).Scan(&u.ID)
+}
+
+// listUsage returns the ledger entries recorded at or after since, oldest
+// first.
+func listUsage(ctx context.Context, db *sql.DB, since time.Time) ([]UsageRecord, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, model, operation, prompt_tokens, completion_tokens, cost, created_at
+		FROM llm_usage WHERE created_at >= $1 ORDER BY created_at, id`, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []UsageRecord
+	for rows.Next() {
+		var u UsageRecord
+		var createdAt int64
+		if err := rows.Scan(&u.ID, &u.Model, &u.Operation, &u.PromptTokens, &u.CompletionTokens, &u.Cost, &createdAt); err != nil {
+			return nil, err
+		}
+		u.CreatedAt = time.Unix(createdAt, 0)
+		records = append(records, u)
+	}
+	return records, rows.Err()
+}
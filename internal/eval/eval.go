@@ -0,0 +1,236 @@
+// Package eval measures a classifier against labelled emails: accuracy,
+// per-class precision, recall and F1, the confusion matrix, how well its
+// confidence is calibrated, and what it cost.
+package eval
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// Result is a classifier's answer for one labelled email. Failed answers,
+// such as ones that never validated, have Failed set and no Predicted.
+type Result struct {
+	EmailID    int64
+	Label      openai.Action
+	Predicted  openai.Action
+	Confidence float64
+	Failed     bool
+
+	Latency time.Duration
+	Usage   openai.Usage
+	Cost    float64
+	Cached  bool
+}
+
+// Report is what Evaluate finds. It marshals to JSON, so that runs can be
+// saved and compared.
+type Report struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt,omitempty"`
+	Split  string `json:"split"`
+	// CreatedAt is set by the caller, like Model, Prompt and Split.
+	CreatedAt time.Time `json:"created_at"`
+
+	Examples int `json:"examples"`
+	Answered int `json:"answered"`
+	Failed   int `json:"failed"`
+	// Accuracy and the class metrics count the answered examples.
+	Accuracy float64                       `json:"accuracy"`
+	MacroF1  float64                       `json:"macro_f1"`
+	Classes  map[openai.Action]ClassReport `json:"classes"`
+	// Confusion counts the answers per label, then per prediction.
+	Confusion   map[openai.Action]map[openai.Action]int `json:"confusion"`
+	Calibration Calibration                             `json:"calibration"`
+	Cost        Cost                                    `json:"cost"`
+	Latency     Latency                                 `json:"latency"`
+
+	Predictions []Prediction `json:"predictions"`
+}
+
+// ClassReport holds the metrics of one label.
+type ClassReport struct {
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+	// Support is how many answered examples have the label.
+	Support int `json:"support"`
+}
+
+// Calibration compares confidence with accuracy. ECE is the expected
+// calibration error, the gap between the two averaged over the bins and
+// weighted by their size; Brier is the mean squared difference between
+// the confidence and whether the answer was right.
+type Calibration struct {
+	ECE   float64 `json:"ece"`
+	Brier float64 `json:"brier"`
+	Bins  []Bin   `json:"bins"`
+}
+
+// Bin gathers the answers with confidence in [Lower, Upper), the last bin
+// including 1.
+type Bin struct {
+	Lower      float64 `json:"lower"`
+	Upper      float64 `json:"upper"`
+	Count      int     `json:"count"`
+	Confidence float64 `json:"confidence"`
+	Accuracy   float64 `json:"accuracy"`
+}
+
+// Cost totals the tokens and USD spent; answers from a cache spend none.
+type Cost struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	USD              float64 `json:"usd"`
+	Cached           int     `json:"cached"`
+}
+
+// Latency summarizes how long the answers took, in milliseconds.
+type Latency struct {
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P95  float64 `json:"p95_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+// Prediction is the outcome for one email, so that runs can be compared
+// email by email.
+type Prediction struct {
+	EmailID    int64         `json:"email_id"`
+	Label      openai.Action `json:"label"`
+	Predicted  openai.Action `json:"predicted,omitempty"`
+	Confidence float64       `json:"confidence"`
+	Failed     bool          `json:"failed,omitempty"`
+}
+
+// Evaluate computes a report from results, splitting confidence into bins
+// of equal width for calibration.
+func Evaluate(results []Result, bins int) Report {
+	if bins < 1 {
+		bins = 1
+	}
+	r := Report{
+		Examples:  len(results),
+		Classes:   map[openai.Action]ClassReport{},
+		Confusion: map[openai.Action]map[openai.Action]int{},
+	}
+	for _, actual := range openai.Actions {
+		r.Confusion[actual] = map[openai.Action]int{}
+		for _, predicted := range openai.Actions {
+			r.Confusion[actual][predicted] = 0
+		}
+	}
+	for i := 0; i < bins; i++ {
+		r.Calibration.Bins = append(r.Calibration.Bins, Bin{Lower: float64(i) / float64(bins), Upper: float64(i+1) / float64(bins)})
+	}
+	// confidences and hits are summed per bin before averaging.
+	confidences := make([]float64, bins)
+	hits := make([]int, bins)
+
+	var latencies []float64
+	correct := 0
+	for _, res := range results {
+		r.Predictions = append(r.Predictions, Prediction{res.EmailID, res.Label, res.Predicted, res.Confidence, res.Failed})
+		r.Cost.PromptTokens += res.Usage.PromptTokens
+		r.Cost.CompletionTokens += res.Usage.CompletionTokens
+		r.Cost.USD += res.Cost
+		if res.Cached {
+			r.Cost.Cached++
+		}
+		latencies = append(latencies, float64(res.Latency)/float64(time.Millisecond))
+		if res.Failed {
+			r.Failed++
+			continue
+		}
+		r.Answered++
+		r.Confusion[res.Label][res.Predicted]++
+
+		hit := 0.0
+		if res.Predicted == res.Label {
+			correct++
+			hit = 1
+		}
+		bin := int(res.Confidence * float64(bins))
+		if bin >= bins {
+			bin = bins - 1
+		}
+		if bin < 0 {
+			bin = 0
+		}
+		r.Calibration.Bins[bin].Count++
+		confidences[bin] += res.Confidence
+		hits[bin] += int(hit)
+		r.Calibration.Brier += (res.Confidence - hit) * (res.Confidence - hit)
+	}
+
+	if r.Answered > 0 {
+		r.Accuracy = float64(correct) / float64(r.Answered)
+		r.Calibration.Brier /= float64(r.Answered)
+	}
+	for i := range r.Calibration.Bins {
+		b := &r.Calibration.Bins[i]
+		if b.Count == 0 {
+			continue
+		}
+		b.Confidence = confidences[i] / float64(b.Count)
+		b.Accuracy = float64(hits[i]) / float64(b.Count)
+		r.Calibration.ECE += float64(b.Count) / float64(r.Answered) * math.Abs(b.Confidence-b.Accuracy)
+	}
+
+	// MacroF1 averages the labels that occur or are predicted.
+	seen := 0
+	for _, action := range openai.Actions {
+		var predicted, support int
+		for _, other := range openai.Actions {
+			predicted += r.Confusion[other][action]
+			support += r.Confusion[action][other]
+		}
+		truePositives := r.Confusion[action][action]
+		c := ClassReport{Support: support, Precision: ratio(truePositives, predicted), Recall: ratio(truePositives, support)}
+		if c.Precision+c.Recall > 0 {
+			c.F1 = 2 * c.Precision * c.Recall / (c.Precision + c.Recall)
+		}
+		r.Classes[action] = c
+		if predicted+support > 0 {
+			r.MacroF1 += c.F1
+			seen++
+		}
+	}
+	if seen > 0 {
+		r.MacroF1 /= float64(seen)
+	}
+
+	if len(latencies) > 0 {
+		sort.Float64s(latencies)
+		var sum float64
+		for _, l := range latencies {
+			sum += l
+		}
+		r.Latency = Latency{
+			Mean: sum / float64(len(latencies)),
+			P50:  percentile(latencies, 0.5),
+			P95:  percentile(latencies, 0.95),
+			Max:  latencies[len(latencies)-1],
+		}
+	}
+	return r
+}
+
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// percentile returns the nearest-rank percentile p of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
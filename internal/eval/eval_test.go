@@ -0,0 +1,98 @@
+package eval
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestEvaluate(t *testing.T) {
+	trash, read, star := openai.ActionTrash, openai.ActionRead, openai.ActionStar
+	results := []Result{
+		{EmailID: 1, Label: trash, Predicted: trash, Confidence: 0.95, Latency: 100 * time.Millisecond, Usage: openai.Usage{PromptTokens: 100, CompletionTokens: 10}, Cost: 0.002},
+		{EmailID: 2, Label: trash, Predicted: trash, Confidence: 0.9, Latency: 200 * time.Millisecond, Cached: true},
+		{EmailID: 3, Label: trash, Predicted: read, Confidence: 0.6, Latency: 300 * time.Millisecond},
+		{EmailID: 4, Label: read, Predicted: read, Confidence: 0.7, Latency: 400 * time.Millisecond},
+		{EmailID: 5, Label: read, Predicted: trash, Confidence: 0.55, Latency: 500 * time.Millisecond},
+		{EmailID: 6, Label: star, Failed: true, Latency: time.Second},
+	}
+	r := Evaluate(results, 10)
+
+	if r.Examples != 6 || r.Answered != 5 || r.Failed != 1 {
+		t.Errorf("Expected 6 examples, 5 answered and 1 failed, got %d, %d and %d", r.Examples, r.Answered, r.Failed)
+	}
+	if !near(r.Accuracy, 0.6) {
+		t.Errorf("Expected an accuracy of 0.6, got %v", r.Accuracy)
+	}
+	if c := r.Classes[trash]; !near(c.Precision, 2.0/3) || !near(c.Recall, 2.0/3) || !near(c.F1, 2.0/3) || c.Support != 3 {
+		t.Errorf("Unexpected trash metrics %+v", c)
+	}
+	if c := r.Classes[read]; !near(c.Precision, 0.5) || !near(c.Recall, 0.5) || c.Support != 2 {
+		t.Errorf("Unexpected read metrics %+v", c)
+	}
+	// The failed star neither occurs nor is predicted among the answers.
+	if !near(r.MacroF1, (2.0/3+0.5)/2) {
+		t.Errorf("Expected the macro F1 of trash and read, got %v", r.MacroF1)
+	}
+	if r.Confusion[trash][read] != 1 || r.Confusion[read][trash] != 1 || r.Confusion[trash][trash] != 2 || r.Confusion[star][star] != 0 {
+		t.Errorf("Unexpected confusion %v", r.Confusion)
+	}
+
+	if len(r.Calibration.Bins) != 10 {
+		t.Fatalf("Expected 10 bins, got %d", len(r.Calibration.Bins))
+	}
+	if b := r.Calibration.Bins[9]; b.Count != 2 || !near(b.Confidence, 0.925) || b.Accuracy != 1 {
+		t.Errorf("Unexpected top bin %+v", b)
+	}
+	if b := r.Calibration.Bins[6]; b.Count != 1 || !near(b.Confidence, 0.6) || b.Accuracy != 0 {
+		t.Errorf("Expected 0.6 in the bin it starts, got %+v", b)
+	}
+	// |0.925-1|*2 + |0.55-0| + |0.6-0| + |0.7-1|, over 5 answers.
+	if want := (0.075*2 + 0.55 + 0.6 + 0.3) / 5; !near(r.Calibration.ECE, want) {
+		t.Errorf("Expected an ECE of %v, got %v", want, r.Calibration.ECE)
+	}
+	if want := (0.05*0.05 + 0.1*0.1 + 0.6*0.6 + 0.3*0.3 + 0.55*0.55) / 5; !near(r.Calibration.Brier, want) {
+		t.Errorf("Expected a Brier score of %v, got %v", want, r.Calibration.Brier)
+	}
+
+	if r.Cost.PromptTokens != 100 || r.Cost.CompletionTokens != 10 || !near(r.Cost.USD, 0.002) || r.Cost.Cached != 1 {
+		t.Errorf("Unexpected cost %+v", r.Cost)
+	}
+	if r.Latency.Mean != 2500.0/6 || r.Latency.P50 != 300 || r.Latency.P95 != 1000 || r.Latency.Max != 1000 {
+		t.Errorf("Unexpected latency %+v", r.Latency)
+	}
+	if len(r.Predictions) != 6 || r.Predictions[5].EmailID != 6 || !r.Predictions[5].Failed {
+		t.Errorf("Expected a prediction per result in order, got %+v", r.Predictions)
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	r := Evaluate([]Result{{EmailID: 1, Label: openai.ActionTrash, Predicted: openai.ActionTrash, Confidence: 1}}, 4)
+	r.Model = "gpt-test"
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var back Report
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if back.Model != "gpt-test" || back.Accuracy != 1 || back.Confusion[openai.ActionTrash][openai.ActionTrash] != 1 ||
+		back.Classes[openai.ActionTrash].F1 != 1 || back.Calibration.Bins[3].Count != 1 {
+		t.Errorf("Expected the report to survive JSON, got %+v", back)
+	}
+}
+
+func TestEvaluateEmpty(t *testing.T) {
+	r := Evaluate(nil, 0)
+	if r.Accuracy != 0 || r.MacroF1 != 0 || len(r.Calibration.Bins) != 1 || r.Latency.Max != 0 {
+		t.Errorf("Expected an empty report, got %+v", r)
+	}
+}
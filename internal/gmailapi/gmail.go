@@ -3,15 +3,15 @@ package gmailapi
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"sort"
-	"strings"
 	"time"
 
+	"github.com/sunkay11/gmail-automation/internal/config"
 	"github.com/sunkay11/gmail-automation/internal/credentials"
 	"github.com/sunkay11/gmail-automation/internal/db"
 	"golang.org/x/oauth2"
@@ -22,37 +22,98 @@ import (
 type GmailClient struct {
 	emailDB          db.EmailDB
 	labelsThatMatter []string
+	account          config.Account
 }
 
 func NewGmailClient(emailDB db.EmailDB, labels []string) *GmailClient {
-	return &GmailClient{emailDB: emailDB, labelsThatMatter: labels}
+	account := config.Account{
+		Name:             "default",
+		Auth:             credentials.AuthOAuth,
+		ClientSecretPath: "./client_secret.json",
+		TokenPath:        "token.json",
+	}
+	return NewGmailClientForAccount(emailDB, labels, account)
+}
+
+// NewGmailClientForAccount returns a client that authenticates as account.
+func NewGmailClientForAccount(emailDB db.EmailDB, labels []string, account config.Account) *GmailClient {
+	return &GmailClient{emailDB: emailDB, labelsThatMatter: labels, account: account}
 }
 
 func (gc *GmailClient) GetInboxEmailsAndStore(numEmails int) error {
-	return getInboxEmailsAndStore(gc.emailDB, numEmails, gc.labelsThatMatter)
+	return gc.GetInboxEmailsAndStoreContext(context.Background(), numEmails)
+}
+
+// GetInboxEmailsAndStoreContext is GetInboxEmailsAndStore stopping when ctx
+// is cancelled. Emails fetched before the cancellation are not stored.
+func (gc *GmailClient) GetInboxEmailsAndStoreContext(ctx context.Context, numEmails int) error {
+	srv, err := newGmailService(ctx, gc.account)
+	if err != nil {
+		return err
+	}
+	return getInboxEmailsAndStore(ctx, srv, gc.emailDB, numEmails, gc.labelsThatMatter)
 }
 
 func (gc *GmailClient) GetDeletedEmailsAndStore(daysAgo int) error {
-	return getDeletedEmailsAndStore(gc.emailDB, daysAgo, gc.labelsThatMatter)
+	return gc.GetDeletedEmailsAndStoreContext(context.Background(), daysAgo)
 }
 
-// GetInboxEmailsAndStore retrieves all Inbox emails from the specified number of days ago.
-func getInboxEmailsAndStore(database db.EmailDB, numEmails int, labelsThatMatter []string) error {
-	oauth2Config, err := credentials.GetGmailCredentials()
+// GetDeletedEmailsAndStoreContext is GetDeletedEmailsAndStore stopping when
+// ctx is cancelled. Emails fetched before the cancellation are not stored.
+func (gc *GmailClient) GetDeletedEmailsAndStoreContext(ctx context.Context, daysAgo int) error {
+	srv, err := newGmailService(ctx, gc.account)
 	if err != nil {
 		return err
 	}
-	client := getClient(oauth2Config)
+	return getDeletedEmailsAndStore(ctx, srv, gc.emailDB, daysAgo, gc.labelsThatMatter)
+}
+
+// Reparse rebuilds the columns and addresses of every email stored with its
+// raw source, without contacting Gmail, after the parsing has changed.
+func (gc *GmailClient) Reparse(ctx context.Context) (db.ReparseResult, error) {
+	return gc.emailDB.ReparseEmails(ctx, func(raw db.RawMessage) (db.Email, error) {
+		return emailFromRaw(raw, gc.labelsThatMatter)
+	})
+}
+
+// newGmailService builds a Gmail service using the account's auth mode.
+func newGmailService(ctx context.Context, account config.Account) (*gmail.Service, error) {
+	var client *http.Client
+	var err error
+	switch account.Auth {
+	case credentials.AuthServiceAccount:
+		jwtConfig, err := credentials.GetServiceAccountCredentials(account.ServiceAccountPath, account.Subject)
+		if err != nil {
+			return nil, err
+		}
+		client = jwtConfig.Client(ctx)
+	case credentials.AuthOAuth, "":
+		oauth2Config, err := credentials.GetGmailCredentialsFromFile(account.ClientSecretPath)
+		if err != nil {
+			return nil, err
+		}
+		client, err = getClient(ctx, oauth2Config, account.TokenPath)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("account %s: unknown auth mode %q", account.Name, account.Auth)
+	}
 
-	srv, err := gmail.NewService(context.Background(), option.WithHTTPClient(client))
+	srv, err := gmail.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
-		log.Fatalf("Unable to retrieve Gmail client: %v", err)
+		return nil, fmt.Errorf("unable to retrieve Gmail client: %v", err)
 	}
+	return srv, nil
+}
+
+// GetInboxEmailsAndStore retrieves all Inbox emails from the specified number of days ago.
+func getInboxEmailsAndStore(ctx context.Context, srv *gmail.Service, database db.EmailDB, numEmails int, labelsThatMatter []string) error {
 
 	user := "me"
 	//query := "(in:inbox OR (in:trash )) is:unread OR is:read OR is:Deleted"
 	query := fmt.Sprintf("in:inbox OR (in:trash before:%s) is:unread OR is:read OR is:Deleted", time.Now().AddDate(0, 0, -1).Format("2006/01/02"))
-	messages, err := srv.Users.Messages.List(user).MaxResults(int64(numEmails)).Q(query).Do()
+	messages, err := srv.Users.Messages.List(user).MaxResults(int64(numEmails)).Q(query).Context(ctx).Do()
 	if err != nil {
 		return err
 	}
@@ -60,150 +121,140 @@ func getInboxEmailsAndStore(database db.EmailDB, numEmails int, labelsThatMatter
 	log.Println("Total Inbox messages  retrieved:", len(messages.Messages))
 	log.Println("Labels that matter:", labelsThatMatter)
 
-	inboxEmails := make([]db.Email, 0, len(messages.Messages))
-	for _, message := range messages.Messages {
-		msg, err := srv.Users.Messages.Get(user, message.Id).Fields("labelIds, payload/headers").Do()
-		if err != nil {
-			log.Printf("Failed to get message: %v", err)
-			continue
-		}
-
-		headers := make(map[string]string)
-		for _, header := range msg.Payload.Headers {
-			headers[header.Name] = header.Value
-		}
-
-		// Filter the labels based on labelsThatMatter
-		filteredLabelIds := filterLabels(msg.LabelIds, labelsThatMatter)
-
-		unread := isLabelPresent(msg.LabelIds, "UNREAD")
-		deleted := isLabelPresent(msg.LabelIds, "TRASH")
-
-		if !unread {
-			filteredLabelIds = append(filteredLabelIds, "READ")
-		}
-
-		// Get the labels for the message and sort them
-		sort.Strings(filteredLabelIds)
-		labels := strings.Join(filteredLabelIds, ", ")
-
-		log.Printf("Subject = %s, labelsOLD = %s, filteredLabels = %s", headers["Subject"], msg.LabelIds, labels)
+	result, err := storeWhileFetching(ctx, database, func(ctx context.Context, inboxEmails chan<- db.Email) error {
+		for _, message := range messages.Messages {
+			msg, err := srv.Users.Messages.Get(user, message.Id).Format("raw").Fields(rawMessageFields).Context(ctx).Do()
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf("Failed to get message: %v", err)
+				continue
+			}
 
-		// Add the "IMPORTANT" label if the message is important
-		if isMessageImportant(msg) && containsString(labelsThatMatter, "IMPORTANT") {
-			labels += ",IMPORTANT"
-		}
+			email, err := fetchedEmail(msg, labelsThatMatter)
+			if err != nil {
+				log.Printf("Failed to parse message: %v", err)
+				continue
+			}
+			log.Printf("Subject = %s, labelsOLD = %s, filteredLabels = %s", email.Subject, msg.LabelIds, email.Labels)
 
-		email := db.Email{
-			Subject:  headers["Subject"],
-			From:     headers["From"],
-			To:       headers["To"],
-			Cc:       headers["Cc"],
-			Bcc:      headers["Bcc"],
-			SentDate: headers["Date"],
-			Body:     msg.Snippet,
-			Sender:   headers["From"],
-			Read:     unread,
-			Deleted:  deleted,
-			Labels:   labels,
+			select {
+			case inboxEmails <- email:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
-
-		inboxEmails = append(inboxEmails, email)
-	}
-
-	rowsAffected, err := database.InsertEmails(inboxEmails)
+		return nil
+	})
 	if err != nil {
 		log.Printf("Error inserting inbox emails into the database: %v", err)
 		return err
 	}
 
-	log.Printf("Inserted %d inbox emails into the database", rowsAffected)
+	logStored("inbox", result)
 
 	return nil
 }
 
 // GetDeletedEmails retrieves all deleted emails from the specified number of days ago.
-func getDeletedEmailsAndStore(database db.EmailDB, daysAgo int, labelsThatMatter []string) error {
-	config, err := credentials.GetGmailCredentials()
-	if err != nil {
-		return err
-	}
-
-	//client := config.Client(context.Background(), token)
-	client := getClient(config)
-
-	srv, err := gmail.NewService(context.Background(), option.WithHTTPClient(client))
-	if err != nil {
-		log.Fatalf("Unable to retrieve Gmail client: %v", err)
-	}
-
+func getDeletedEmailsAndStore(ctx context.Context, srv *gmail.Service, database db.EmailDB, daysAgo int, labelsThatMatter []string) error {
 	user := "me"
 	query := fmt.Sprintf("in:trash before:%s", time.Now().AddDate(0, 0, -daysAgo).Format("2006/01/02"))
-	messages, err := srv.Users.Messages.List(user).MaxResults(50).Q(query).Do()
+	messages, err := srv.Users.Messages.List(user).MaxResults(50).Q(query).Context(ctx).Do()
 	if err != nil {
 		return err
 	}
 
 	log.Println("Total Deleted messages:", len(messages.Messages))
 
-	deletedEmails := make([]db.Email, 0, len(messages.Messages))
-	for _, message := range messages.Messages {
-		msg, err := srv.Users.Messages.Get(user, message.Id).Fields("labelIds, payload/headers").Do()
-		if err != nil {
-			log.Printf("Failed to get message: %v", err)
-			continue
-		}
-
-		headers := make(map[string]string)
-		for _, header := range msg.Payload.Headers {
-			headers[header.Name] = header.Value
-		}
-
-		// Filter the labels based on labelsThatMatter
-		filteredLabelIds := filterLabels(msg.LabelIds, labelsThatMatter)
-
-		unread := isLabelPresent(msg.LabelIds, "UNREAD")
+	result, err := storeWhileFetching(ctx, database, func(ctx context.Context, deletedEmails chan<- db.Email) error {
+		for _, message := range messages.Messages {
+			msg, err := srv.Users.Messages.Get(user, message.Id).Format("raw").Fields(rawMessageFields).Context(ctx).Do()
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf("Failed to get message: %v", err)
+				continue
+			}
 
-		if !unread {
-			filteredLabelIds = append(filteredLabelIds, "READ")
+			email, err := fetchedEmail(msg, labelsThatMatter)
+			if err != nil {
+				log.Printf("Failed to parse message: %v", err)
+				continue
+			}
+			log.Printf("Subject = %s, labelsOLD = %s, filteredLabels = %s", email.Subject, msg.LabelIds, email.Labels)
+			email.Deleted = true
+			email.State = db.StateTrashed
+
+			select {
+			case deletedEmails <- email:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error inserting deleted emails into the database: %v", err)
+		return err
+	}
 
-		// Get the labels for the message and sort them
-		sort.Strings(filteredLabelIds)
-		labels := strings.Join(filteredLabelIds, ", ")
-
-		log.Printf("Subject = %s, labelsOLD = %s, filteredLabels = %s", headers["Subject"], msg.LabelIds, labels)
+	logStored("deleted", result)
 
-		// Add the "IMPORTANT" label if the message is important
-		if isMessageImportant(msg) && containsString(labelsThatMatter, "IMPORTANT") {
-			labels += ",IMPORTANT"
-		}
+	return nil
+}
 
-		email := db.Email{
-			Subject:  headers["Subject"],
-			From:     headers["From"],
-			To:       headers["To"],
-			Cc:       headers["Cc"],
-			Bcc:      headers["Bcc"],
-			SentDate: headers["Date"],
-			Body:     msg.Snippet,
-			Sender:   headers["From"],
-			Deleted:  true,
-			Labels:   labels,
-		}
+// storeWhileFetching runs fetch, which sends emails as it retrieves them,
+// while StoreEmails writes them in the background. If either side fails
+// the other is cancelled and nothing is stored.
+func storeWhileFetching(ctx context.Context, database db.EmailDB, fetch func(ctx context.Context, emails chan<- db.Email) error) (db.BatchResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		deletedEmails = append(deletedEmails, email)
+	type stored struct {
+		result db.BatchResult
+		err    error
 	}
+	emails := make(chan db.Email)
+	done := make(chan stored, 1)
+	go func() {
+		result, err := database.StoreEmails(ctx, emails)
+		if err != nil {
+			cancel()
+		}
+		done <- stored{result, err}
+	}()
 
-	ids, err := database.InsertDeletedEmails(deletedEmails)
+	err := fetch(ctx, emails)
 	if err != nil {
-		log.Printf("Error inserting deleted emails into the database: %v", err)
-		return err
+		cancel()
 	}
+	close(emails)
+
+	// When the store fails first, fetch only sees the cancellation.
+	s := <-done
+	switch {
+	case err != nil && !errors.Is(err, context.Canceled):
+		return db.BatchResult{}, err
+	case s.err != nil:
+		return db.BatchResult{}, s.err
+	case err != nil:
+		return db.BatchResult{}, err
+	}
+	return s.result, nil
+}
 
-	log.Printf("Inserted %d deleted emails into the database", ids)
-
-	return nil
+// logStored logs the outcome of a sync, including why emails were skipped.
+func logStored(kind string, result db.BatchResult) {
+	for _, row := range result.Rows {
+		if row.Status == db.RowSkipped {
+			log.Printf("Skipped %q: %v", row.Subject, row.Err)
+		}
+	}
+	log.Printf("Stored %d %s emails in the database: %d new, %d updated, %d skipped",
+		result.Stored(), kind, result.Inserted, result.Updated, result.Skipped)
 }
 
 func getTokenFromFile(filename string) (*oauth2.Token, error) {
@@ -219,23 +270,24 @@ func getTokenFromFile(filename string) (*oauth2.Token, error) {
 }
 
 // Retrieve a token, saves the token, then returns the generated client.
-func getClient(config *oauth2.Config) *http.Client {
-	// The file token.json stores the user's access and refresh tokens, and is
+func getClient(ctx context.Context, config *oauth2.Config, tokFile string) (*http.Client, error) {
+	// The token file stores the user's access and refresh tokens, and is
 	// created automatically when the authorization flow completes for the first
 	// time.
-	tokFile := "token.json"
 	tok, err := getTokenFromFile(tokFile)
 	if err != nil {
-		tok, err = getTokenFromWeb(config)
-		saveToken(tokFile, tok)
+		tok, err = getTokenFromWeb(ctx, config)
 		if err != nil {
-			log.Fatalf("Unable to save token: %v", err)
+			return nil, fmt.Errorf("unable to get token: %w", err)
+		}
+		if err := saveToken(tokFile, tok); err != nil {
+			return nil, fmt.Errorf("unable to save token: %w", err)
 		}
 	}
-	return config.Client(context.Background(), tok)
+	return config.Client(ctx, tok), nil
 }
 
-func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
+func getTokenFromWeb(ctx context.Context, config *oauth2.Config) (*oauth2.Token, error) {
 	var token *oauth2.Token
 
 	// Create a channel to receive the authorization code.
@@ -271,10 +323,15 @@ func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
 	fmt.Println(authURL)
 
 	// Wait for the authorization code.
-	code := <-codeChan
+	var code string
+	select {
+	case code = <-codeChan:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 
 	// Exchange the authorization code for an access token.
-	token, err = config.Exchange(context.Background(), code)
+	token, err = config.Exchange(ctx, code)
 	if err != nil {
 		return nil, err
 	}
@@ -283,14 +340,14 @@ func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
 }
 
 // Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
+func saveToken(path string, token *oauth2.Token) error {
 	fmt.Printf("Saving credential file to: %s\n", path)
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		return err
 	}
 	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+	return json.NewEncoder(f).Encode(token)
 }
 
 // Helper function to check if a specific label is present in the labelIds list
@@ -303,16 +360,6 @@ func isLabelPresent(labelIds []string, label string) bool {
 	return false
 }
 
-func isMessageImportant(msg *gmail.Message) bool {
-	//check wether the message is important or not
-	for _, header := range msg.Payload.Headers {
-		if header.Name == "Importance" && header.Value == "high" {
-			return true
-		}
-	}
-	return false
-}
-
 func filterLabels(labels []string, labelsThatMatter []string) []string {
 	filteredLabels := make([]string, 0)
 	for _, label := range labels {
@@ -0,0 +1,194 @@
+package gmailapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"golang.org/x/text/encoding/htmlindex"
+	"google.golang.org/api/gmail/v1"
+)
+
+// rawMessageFields is the partial response requested for each message:
+// its RFC 822 source plus the Gmail metadata that is not part of it.
+const rawMessageFields = "id,labelIds,sizeEstimate,internalDate,raw"
+
+// maxBodyBytes caps how much of a text part is read into Email.Body.
+const maxBodyBytes = 1 << 20
+
+// maxMIMEDepth bounds the nesting of multipart bodies that are walked.
+const maxMIMEDepth = 8
+
+// fetchedEmail parses a message fetched with format=raw.
+func fetchedEmail(msg *gmail.Message, labelsThatMatter []string) (db.Email, error) {
+	data, err := base64.URLEncoding.DecodeString(msg.Raw)
+	if err != nil {
+		// Gmail pads, but be lenient in case it stops doing so.
+		if data, err = base64.RawURLEncoding.DecodeString(msg.Raw); err != nil {
+			return db.Email{}, fmt.Errorf("message %s: decoding raw source: %v", msg.Id, err)
+		}
+	}
+	return emailFromRaw(db.RawMessage{
+		GmailID:      msg.Id,
+		LabelIDs:     msg.LabelIds,
+		InternalDate: msg.InternalDate,
+		SizeEstimate: msg.SizeEstimate,
+		Data:         data,
+	}, labelsThatMatter)
+}
+
+// emailFromRaw derives every stored column of an email from its raw
+// source, so that a stored email can be parsed again by `reparse`.
+func emailFromRaw(raw db.RawMessage, labelsThatMatter []string) (db.Email, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw.Data))
+	if err != nil {
+		return db.Email{}, fmt.Errorf("message %s: %v", raw.GmailID, err)
+	}
+	header := func(name string) string {
+		return db.DecodeHeader(msg.Header.Get(name))
+	}
+
+	var content mimeContent
+	content.walk(textproto.MIMEHeader(msg.Header), msg.Body, 0)
+	body := content.text
+	if body == "" && content.html != "" {
+		body = htmlToText(content.html)
+	}
+
+	important := strings.EqualFold(msg.Header.Get("Importance"), "high")
+	return db.Email{
+		Subject:  header("Subject"),
+		From:     header("From"),
+		To:       header("To"),
+		Cc:       header("Cc"),
+		Bcc:      header("Bcc"),
+		ReplyTo:  header("Reply-To"),
+		SentDate: msg.Header.Get("Date"),
+		Body:     body,
+		Sender:   header("From"),
+		Read:     !isLabelPresent(raw.LabelIDs, "UNREAD"),
+		Deleted:  isLabelPresent(raw.LabelIDs, "TRASH"),
+		Labels:   storedLabels(raw.LabelIDs, labelsThatMatter, important),
+		State:    db.StateFromLabels(raw.LabelIDs),
+
+		Size:          raw.SizeEstimate,
+		HasAttachment: content.attachment,
+		// Used when the Date header is missing or malformed.
+		InternalDate: raw.InternalDate,
+		Raw:          &raw,
+	}, nil
+}
+
+// storedLabels is the label string stored for a message: the labels that
+// matter, plus READ when it is not UNREAD, sorted, and IMPORTANT when the
+// sender marked it so and Gmail did not.
+func storedLabels(labelIDs, labelsThatMatter []string, important bool) string {
+	// Filter the labels based on labelsThatMatter
+	filteredLabelIds := filterLabels(labelIDs, labelsThatMatter)
+	if !isLabelPresent(labelIDs, "UNREAD") {
+		filteredLabelIds = append(filteredLabelIds, "READ")
+	}
+
+	sort.Strings(filteredLabelIds)
+	labels := strings.Join(filteredLabelIds, ", ")
+
+	// Add the "IMPORTANT" label if the message is important
+	if important && containsString(labelsThatMatter, "IMPORTANT") && !containsString(filteredLabelIds, "IMPORTANT") {
+		labels += ",IMPORTANT"
+	}
+	return labels
+}
+
+// mimeContent collects what emailFromRaw needs from a MIME tree: the first
+// plain text and HTML parts that are not attachments, and whether there
+// is an attachment.
+type mimeContent struct {
+	text, html string
+	attachment bool
+}
+
+func (c *mimeContent) walk(header textproto.MIMEHeader, body io.Reader, depth int) {
+	// RFC 2045: a part without a usable Content-Type is plain US-ASCII text.
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if disposition == "attachment" || dispositionParams["filename"] != "" || params["name"] != "" {
+		c.attachment = true
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		if depth >= maxMIMEDepth || params["boundary"] == "" {
+			return
+		}
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := parts.NextRawPart()
+			if err != nil {
+				return
+			}
+			c.walk(part.Header, part, depth+1)
+		}
+	case mediaType == "text/plain" && c.text == "":
+		c.text = decodeText(header, params["charset"], body)
+	case mediaType == "text/html" && c.html == "":
+		c.html = decodeText(header, params["charset"], body)
+	}
+}
+
+// decodeText undoes a part's transfer encoding and converts it from its
+// charset to UTF-8. Whatever decodes before an error is kept.
+func decodeText(header textproto.MIMEHeader, charset string, body io.Reader) string {
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	switch charset = strings.ToLower(charset); charset {
+	case "", "utf-8", "us-ascii":
+	default:
+		if enc, err := htmlindex.Get(charset); err == nil {
+			body = enc.NewDecoder().Reader(body)
+		}
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(body, maxBodyBytes))
+	return strings.TrimSpace(strings.ReplaceAll(string(data), "\r\n", "\n"))
+}
+
+var (
+	htmlHidden = regexp.MustCompile(`(?is)<(head|script|style)\b.*?</(head|script|style)\s*>`)
+	htmlBreak  = regexp.MustCompile(`(?i)<(br|/p|/div|/tr|/li|/h[1-6])\b[^>]*>`)
+	htmlTag    = regexp.MustCompile(`<[^>]*>`)
+)
+
+// htmlToText reduces an HTML body to its visible text, one line per
+// block, for messages without a plain text part.
+func htmlToText(s string) string {
+	s = htmlHidden.ReplaceAllString(s, "")
+	s = htmlBreak.ReplaceAllString(s, "\n")
+	s = html.UnescapeString(htmlTag.ReplaceAllString(s, ""))
+
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,109 @@
+package gmailapi
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"google.golang.org/api/gmail/v1"
+)
+
+const multipartSource = "From: =?ISO-8859-1?Q?Ren=E9?= <rene@example.com>\r\n" +
+	"To: me@example.com\r\n" +
+	"Subject: =?UTF-8?B?Q2Fmw6k=?= order\r\n" +
+	"Date: Mon, 3 Apr 2023 22:30:00 -0400\r\n" +
+	"Importance: High\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Your caf=E9 order is =\r\n" +
+	"ready.\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>ignored</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename=receipt.pdf\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0=\r\n" +
+	"--outer--\r\n"
+
+func TestEmailFromRaw(t *testing.T) {
+	raw := db.RawMessage{
+		GmailID:      "m1",
+		LabelIDs:     []string{"INBOX", "CATEGORY_UPDATES"},
+		InternalDate: 1680575400000,
+		SizeEstimate: 1234,
+		Data:         []byte(multipartSource),
+	}
+	email, err := emailFromRaw(raw, []string{"INBOX", "IMPORTANT"})
+	if err != nil {
+		t.Fatalf("emailFromRaw failed: %v", err)
+	}
+
+	if email.Subject != "Café order" || email.From != "René <rene@example.com>" || email.Sender != email.From {
+		t.Errorf("Expected decoded headers, got subject %q from %q", email.Subject, email.From)
+	}
+	if email.SentDate != "Mon, 3 Apr 2023 22:30:00 -0400" {
+		t.Errorf("Expected the Date header as sent, got %q", email.SentDate)
+	}
+	if email.Body != "Your café order is ready." {
+		t.Errorf("Expected the decoded plain text part, got %q", email.Body)
+	}
+	if !email.HasAttachment {
+		t.Errorf("Expected the PDF to count as an attachment")
+	}
+	if !email.Read || email.Deleted || email.State != db.StateInbox {
+		t.Errorf("Expected a read inbox email, got read=%v deleted=%v state=%s", email.Read, email.Deleted, email.State)
+	}
+	if email.Labels != "INBOX, READ,IMPORTANT" {
+		t.Errorf("Expected IMPORTANT from the Importance header, got %q", email.Labels)
+	}
+	if labels := storedLabels([]string{"IMPORTANT", "INBOX"}, []string{"INBOX", "IMPORTANT"}, true); labels != "IMPORTANT, INBOX, READ" {
+		t.Errorf("Expected IMPORTANT once, got %q", labels)
+	}
+	if email.Size != 1234 || email.InternalDate != raw.InternalDate || email.Raw == nil || email.Raw.GmailID != "m1" {
+		t.Errorf("Expected the Gmail metadata and source to be kept, got %+v", email)
+	}
+}
+
+func TestEmailFromRawHTMLOnly(t *testing.T) {
+	source := "Subject: Newsletter\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString([]byte("<html><head><style>p{}</style></head><body><p>Hello&nbsp;<b>there</b></p><p>Fish &amp; chips</p></body></html>"))
+	email, err := emailFromRaw(db.RawMessage{LabelIDs: []string{"TRASH", "UNREAD"}, Data: []byte(source)}, nil)
+	if err != nil {
+		t.Fatalf("emailFromRaw failed: %v", err)
+	}
+	if email.Body != "Hello there\nFish & chips" {
+		t.Errorf("Expected the text of the HTML part, got %q", email.Body)
+	}
+	if email.Read || !email.Deleted || email.State != db.StateTrashed || email.HasAttachment {
+		t.Errorf("Expected an unread trashed email without attachments, got %+v", email)
+	}
+}
+
+func TestFetchedEmailDecodesRaw(t *testing.T) {
+	source := "Subject: Hi\r\n\r\nBody"
+	for _, encoding := range []*base64.Encoding{base64.URLEncoding, base64.RawURLEncoding} {
+		email, err := fetchedEmail(&gmail.Message{Id: "m1", Raw: encoding.EncodeToString([]byte(source))}, nil)
+		if err != nil || email.Subject != "Hi" || email.Body != "Body" || string(email.Raw.Data) != source {
+			t.Errorf("Expected the raw source decoded, got %+v, %v", email, err)
+		}
+	}
+	if _, err := fetchedEmail(&gmail.Message{Id: "m1", Raw: "not base64!"}, nil); err == nil || !strings.Contains(err.Error(), "m1") {
+		t.Errorf("Expected an error naming the message, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+// Package ml trains classifiers on the stored mailbox history that run
+// offline: multinomial naive Bayes and logistic regression over the words
+// and headers of emails, predicting what was done with them.
+package ml
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// maxBodyRunes and maxBodyWords bound how much of a body is learned from;
+// the start of an email says the most about it.
+const (
+	maxBodyRunes = 2000
+	maxBodyWords = 300
+)
+
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "you": true, "your": true, "with": true,
+	"from": true, "our": true, "this": true, "that": true, "are": true, "was": true,
+	"have": true, "has": true, "not": true, "but": true, "can": true, "will": true,
+	"all": true, "any": true, "its": true, "it's": true, "of": true, "to": true,
+	"in": true, "on": true, "at": true, "is": true, "be": true, "or": true, "an": true,
+}
+
+// Document renders what the models learn from an email as text that
+// Features reads: Subject, From and Labels lines, then the start of the
+// body. The labels that give away what was done with the email are left
+// out, as they are from few-shot examples.
+func Document(email db.Email) string {
+	example := openai.Examples([]db.Email{email})[0]
+	body := []rune(example.Body)
+	if len(body) > maxBodyRunes {
+		body = body[:maxBodyRunes]
+	}
+	return fmt.Sprintf("Subject: %s\nFrom: %s\nLabels: %s\n\n%s", example.Subject, example.From, example.Labels, string(body))
+}
+
+// Features returns the features of a text in Document's form: subject:,
+// body: and label: words, and the from: address and domain: of the
+// sender. A word is counted once per occurrence. Only the lines before
+// the first blank line are read as headers, so that a body quoting an
+// earlier email's From: line is still body.
+func Features(text string) []string {
+	var features []string
+	bodyWords := 0
+	inBody := false
+	for _, line := range strings.Split(text, "\n") {
+		if !inBody && strings.TrimSpace(line) == "" {
+			inBody = true
+			continue
+		}
+		field, value, found := strings.Cut(line, ":")
+		if found && !inBody {
+			switch strings.ToLower(strings.TrimSpace(field)) {
+			case "subject":
+				for _, word := range words(value) {
+					features = append(features, "subject:"+word)
+				}
+				continue
+			case "from":
+				if list := db.ParseAddressList(value); len(list) > 0 {
+					address := strings.ToLower(list[0].Address)
+					features = append(features, "from:"+address)
+					if at := strings.LastIndex(address, "@"); at >= 0 {
+						features = append(features, "domain:"+address[at+1:])
+					}
+				}
+				continue
+			case "labels":
+				for _, label := range strings.Split(value, ",") {
+					if label = strings.TrimSpace(label); label != "" {
+						features = append(features, "label:"+strings.ToUpper(label))
+					}
+				}
+				continue
+			}
+		}
+		for _, word := range words(line) {
+			if bodyWords == maxBodyWords {
+				break
+			}
+			features = append(features, "body:"+word)
+			bodyWords++
+		}
+	}
+	return features
+}
+
+// words returns the lower-cased words of s of two letters or more, less
+// stop words.
+func words(s string) []string {
+	var list []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}) {
+		word = strings.Trim(word, "'")
+		if len([]rune(word)) >= 2 && !stopWords[word] {
+			list = append(list, word)
+		}
+	}
+	return list
+}
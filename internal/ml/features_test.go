@@ -0,0 +1,52 @@
+package ml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+)
+
+func TestDocumentLeavesOutOutcomeLabels(t *testing.T) {
+	doc := Document(db.Email{
+		Subject: "Weekly deals",
+		From:    "Shop <deals@shop.example>",
+		Labels:  "INBOX, STARRED, CATEGORY_PROMOTIONS",
+		Body:    "Save 50% today",
+	})
+	want := "Subject: Weekly deals\nFrom: Shop <deals@shop.example>\nLabels: INBOX, CATEGORY_PROMOTIONS\n\nSave 50% today"
+	if doc != want {
+		t.Errorf("Expected %q, got %q", want, doc)
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	got := Features("Subject: The Weekly deals\nFrom: Shop <Deals@Shop.example>\nLabels: inbox, CATEGORY_PROMOTIONS\n\nSave 50% on your order: today's deals")
+	want := []string{
+		"subject:weekly", "subject:deals",
+		"from:deals@shop.example", "domain:shop.example",
+		"label:INBOX", "label:CATEGORY_PROMOTIONS",
+		"body:save", "body:50", "body:order", "body:today's", "body:deals",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// A reply quoting the email it answers keeps the quoted headers as body.
+	quoted := Features(Document(db.Email{Subject: "Re: Lunch", From: "bob@example.com",
+		Body: "Sounds good.\n\nFrom: Alice <alice@example.org>\nSubject: Lunch\nLabels: STARRED"}))
+	want = []string{
+		"subject:re", "subject:lunch", "from:bob@example.com", "domain:example.com",
+		"body:sounds", "body:good", "body:alice", "body:alice", "body:example", "body:org",
+		"body:subject", "body:lunch", "body:labels", "body:starred",
+	}
+	if !reflect.DeepEqual(quoted, want) {
+		t.Errorf("Expected the quoted headers to be body words %q, got %q", want, quoted)
+	}
+
+	long := "Subject: x\n\n" + strings.Repeat("word ", maxBodyWords+10)
+	if n := len(Features(long)); n != maxBodyWords {
+		t.Errorf("Expected the body to be cut at %d words, got %d features", maxBodyWords, n)
+	}
+}
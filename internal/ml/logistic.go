@@ -0,0 +1,61 @@
+package ml
+
+import "math/rand"
+
+// logistic is a multinomial logistic regression over the presence of
+// features.
+type logistic struct {
+	Weights [][]float64 `json:"weights"`
+	Bias    []float64   `json:"bias"`
+}
+
+// trainLogistic fits the weights by stochastic gradient descent on the
+// cross-entropy, with L2 regularisation and a learning rate that decays
+// with each epoch.
+func trainLogistic(docs [][]int, labels []int, classes, features int, opts TrainOptions, rng *rand.Rand) *logistic {
+	lr := &logistic{Weights: make([][]float64, classes), Bias: make([]float64, classes)}
+	for c := range lr.Weights {
+		lr.Weights[c] = make([]float64, features)
+	}
+	present := make([][]int, len(docs))
+	for i, doc := range docs {
+		for f := range set(doc) {
+			present[i] = append(present[i], f)
+		}
+	}
+
+	order := rng.Perm(len(docs))
+	for epoch := 0; epoch < opts.Epochs; epoch++ {
+		rate := opts.LearningRate / (1 + float64(epoch)/2)
+		rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		for _, i := range order {
+			probs := softmax(lr.scores(present[i]))
+			for c := range probs {
+				gradient := probs[c]
+				if c == labels[i] {
+					gradient--
+				}
+				lr.Bias[c] -= rate * gradient
+				for _, f := range present[i] {
+					lr.Weights[c][f] -= rate * (gradient + opts.L2*lr.Weights[c][f])
+				}
+			}
+		}
+	}
+	return lr
+}
+
+func (lr *logistic) scores(features []int) []float64 {
+	scores := make([]float64, len(lr.Bias))
+	for c := range scores {
+		scores[c] = lr.Bias[c]
+		for f := range set(features) {
+			scores[c] += lr.Weights[c][f]
+		}
+	}
+	return scores
+}
+
+func (lr *logistic) contribution(class, feature int) float64 {
+	return lr.Weights[class][feature]
+}
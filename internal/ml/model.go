@@ -0,0 +1,315 @@
+package ml
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// The algorithms Train knows.
+const (
+	NaiveBayes         = "naive_bayes"
+	LogisticRegression = "logistic_regression"
+)
+
+// ErrNoExamples is returned when there is nothing to train on.
+var ErrNoExamples = errors.New("no training examples")
+
+// Example is a text in Document's form and what was done with the email.
+type Example struct {
+	Text  string
+	Label openai.Action
+}
+
+// TrainOptions tune Train. Zero fields take the defaults.
+type TrainOptions struct {
+	// MinCount drops features seen in fewer examples (default 2).
+	MinCount int
+	// Epochs, LearningRate and L2 apply to logistic regression (defaults
+	// 20, 0.5 and 1e-4). Seed fixes the order examples are visited in.
+	Epochs       int
+	LearningRate float64
+	L2           float64
+	Seed         int64
+}
+
+func (o TrainOptions) withDefaults() TrainOptions {
+	if o.MinCount == 0 {
+		o.MinCount = 2
+	}
+	if o.Epochs == 0 {
+		o.Epochs = 20
+	}
+	if o.LearningRate == 0 {
+		o.LearningRate = 0.5
+	}
+	if o.L2 == 0 {
+		o.L2 = 1e-4
+	}
+	return o
+}
+
+// scorer is a trained algorithm. scores returns a score per class that
+// softmax turns into probabilities; contribution is what feature adds to
+// the score of class.
+type scorer interface {
+	scores(features []int) []float64
+	contribution(class, feature int) float64
+}
+
+// Model is a trained classifier. It implements openai.GPT for prompts in
+// Document's form, so it can stand in for a chat model.
+type Model struct {
+	Algorithm  string          `json:"algorithm"`
+	Classes    []openai.Action `json:"classes"`
+	Vocabulary []string        `json:"vocabulary"`
+	Examples   int             `json:"examples"`
+	TrainedAt  time.Time       `json:"trained_at"`
+
+	NaiveBayes *naiveBayes `json:"naive_bayes,omitempty"`
+	Logistic   *logistic   `json:"logistic,omitempty"`
+
+	index   map[string]int
+	version string
+}
+
+// Train fits a model of the given algorithm to examples.
+func Train(algorithm string, examples []Example, opts TrainOptions) (*Model, error) {
+	if len(examples) == 0 {
+		return nil, ErrNoExamples
+	}
+	opts = opts.withDefaults()
+
+	// Features seen in enough examples make the vocabulary, in order.
+	seen := map[string]int{}
+	for _, ex := range examples {
+		for feature := range set(Features(ex.Text)) {
+			seen[feature]++
+		}
+	}
+	m := &Model{Algorithm: algorithm, Classes: openai.Actions, Examples: len(examples), TrainedAt: time.Now().UTC()}
+	for feature, n := range seen {
+		if n >= opts.MinCount {
+			m.Vocabulary = append(m.Vocabulary, feature)
+		}
+	}
+	sort.Strings(m.Vocabulary)
+	m.buildIndex()
+
+	docs := make([][]int, len(examples))
+	labels := make([]int, len(examples))
+	for i, ex := range examples {
+		docs[i] = m.featureIDs(ex.Text)
+		labels[i] = m.classIndex(ex.Label)
+		if labels[i] < 0 {
+			return nil, fmt.Errorf("example %d: unknown label %q", i, ex.Label)
+		}
+	}
+
+	switch algorithm {
+	case NaiveBayes:
+		m.NaiveBayes = trainNaiveBayes(docs, labels, len(m.Classes), len(m.Vocabulary))
+	case LogisticRegression:
+		m.Logistic = trainLogistic(docs, labels, len(m.Classes), len(m.Vocabulary), opts, rand.New(rand.NewSource(opts.Seed)))
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q: expected %s or %s", algorithm, NaiveBayes, LogisticRegression)
+	}
+	return m, m.setVersion()
+}
+
+// Prediction is a model's verdict on one text.
+type Prediction struct {
+	Action     openai.Action
+	Confidence float64
+	// Probabilities holds the probability of every class.
+	Probabilities map[openai.Action]float64
+	// Evidence lists the features that most favoured Action, strongest
+	// first.
+	Evidence []string
+}
+
+// Predict classifies a text in Document's form.
+func (m *Model) Predict(text string) Prediction {
+	features := m.featureIDs(text)
+	probs := softmax(m.scorer().scores(features))
+
+	best := 0
+	for c := range probs {
+		if probs[c] > probs[best] {
+			best = c
+		}
+	}
+	p := Prediction{Action: m.Classes[best], Confidence: probs[best], Probabilities: map[openai.Action]float64{}}
+	for c, prob := range probs {
+		p.Probabilities[m.Classes[c]] = prob
+	}
+
+	// Evidence is what a feature adds to the best class beyond its average
+	// over the classes.
+	type weighed struct {
+		feature string
+		weight  float64
+	}
+	var evidence []weighed
+	for feature := range set(features) {
+		var mean float64
+		for c := range m.Classes {
+			mean += m.scorer().contribution(c, feature)
+		}
+		mean /= float64(len(m.Classes))
+		if w := m.scorer().contribution(best, feature) - mean; w > 0 {
+			evidence = append(evidence, weighed{m.Vocabulary[feature], w})
+		}
+	}
+	sort.Slice(evidence, func(i, j int) bool {
+		if evidence[i].weight != evidence[j].weight {
+			return evidence[i].weight > evidence[j].weight
+		}
+		return evidence[i].feature < evidence[j].feature
+	})
+	for i := 0; i < len(evidence) && i < 3; i++ {
+		p.Evidence = append(p.Evidence, evidence[i].feature)
+	}
+	return p
+}
+
+// Model names the model for predictions: the algorithm and a hash of the
+// trained parameters.
+func (m *Model) Model() string {
+	return m.Algorithm + "@" + m.version
+}
+
+func (m *Model) ClassifyEmail(prompt string) (openai.Classification, error) {
+	return m.ClassifyEmailContext(context.Background(), prompt)
+}
+
+// ClassifyEmailContext classifies a prompt in Document's form. Its answers
+// only make sense for text rendered by Document, as the model was trained
+// on: given a chat prompt template it reads the instructions and few-shot
+// examples as the email's body. The models do not learn categories, so
+// Category is always "other".
+func (m *Model) ClassifyEmailContext(ctx context.Context, prompt string) (openai.Classification, error) {
+	if err := ctx.Err(); err != nil {
+		return openai.Classification{}, err
+	}
+	p := m.Predict(prompt)
+	reason := fmt.Sprintf("Learned by %s from %d emails", strings.ReplaceAll(m.Algorithm, "_", " "), m.Examples)
+	if len(p.Evidence) > 0 {
+		reason += "; indicative: " + strings.Join(p.Evidence, ", ")
+	}
+	return openai.Classification{Action: p.Action, Category: "other", Confidence: p.Confidence, Reason: reason}, nil
+}
+
+// Save writes the model to path as JSON.
+func (m *Model) Save(path string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Load reads a model written by Save.
+func Load(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Model
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("model %s: %v", path, err)
+	}
+	if m.Algorithm == NaiveBayes && m.NaiveBayes == nil || m.Algorithm == LogisticRegression && m.Logistic == nil ||
+		m.Algorithm != NaiveBayes && m.Algorithm != LogisticRegression {
+		return nil, fmt.Errorf("model %s: no parameters for algorithm %q", path, m.Algorithm)
+	}
+	m.buildIndex()
+	return &m, m.setVersion()
+}
+
+func (m *Model) scorer() scorer {
+	if m.NaiveBayes != nil {
+		return m.NaiveBayes
+	}
+	return m.Logistic
+}
+
+func (m *Model) buildIndex() {
+	m.index = make(map[string]int, len(m.Vocabulary))
+	for i, feature := range m.Vocabulary {
+		m.index[feature] = i
+	}
+}
+
+// setVersion hashes the trained parameters.
+func (m *Model) setVersion() error {
+	params, err := json.Marshal(struct {
+		Classes    []openai.Action
+		Vocabulary []string
+		NaiveBayes *naiveBayes
+		Logistic   *logistic
+	}{m.Classes, m.Vocabulary, m.NaiveBayes, m.Logistic})
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(params)
+	m.version = hex.EncodeToString(sum[:])[:12]
+	return nil
+}
+
+// featureIDs returns the vocabulary indexes of the features of text,
+// leaving out those not in the vocabulary.
+func (m *Model) featureIDs(text string) []int {
+	var ids []int
+	for _, feature := range Features(text) {
+		if id, ok := m.index[feature]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (m *Model) classIndex(action openai.Action) int {
+	for i, c := range m.Classes {
+		if c == action {
+			return i
+		}
+	}
+	return -1
+}
+
+func set[T comparable](items []T) map[T]bool {
+	s := make(map[T]bool, len(items))
+	for _, item := range items {
+		s[item] = true
+	}
+	return s
+}
+
+func softmax(scores []float64) []float64 {
+	max := math.Inf(-1)
+	for _, s := range scores {
+		max = math.Max(max, s)
+	}
+	probs := make([]float64, len(scores))
+	var sum float64
+	for i, s := range scores {
+		probs[i] = math.Exp(s - max)
+		sum += probs[i]
+	}
+	for i := range probs {
+		probs[i] /= sum
+	}
+	return probs
+}
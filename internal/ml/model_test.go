@@ -0,0 +1,102 @@
+package ml
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+	"github.com/sunkay11/gmail-automation/internal/openai"
+)
+
+// trainingExamples are promotions that were trashed and emails from the
+// boss that were starred.
+func trainingExamples() []Example {
+	var examples []Example
+	for i := 0; i < 20; i++ {
+		examples = append(examples,
+			Example{Document(db.Email{
+				Subject: fmt.Sprintf("Sale %d: everything must go", i),
+				From:    "Shop <deals@shop.example>",
+				Labels:  "INBOX, CATEGORY_PROMOTIONS",
+				Body:    "Huge discount on shoes, unsubscribe here",
+			}), openai.ActionTrash},
+			Example{Document(db.Email{
+				Subject: fmt.Sprintf("Quarterly plan %d", i),
+				From:    "Boss <boss@work.example>",
+				Labels:  "INBOX, IMPORTANT",
+				Body:    "Please review the roadmap before our meeting",
+			}), openai.ActionStar},
+		)
+	}
+	return examples
+}
+
+func TestTrainAndPredict(t *testing.T) {
+	sale := Document(db.Email{Subject: "Sale ends tonight", From: "deals@shop.example", Labels: "CATEGORY_PROMOTIONS", Body: "Discount on shoes"})
+	plan := Document(db.Email{Subject: "Roadmap review", From: "boss@work.example", Labels: "IMPORTANT", Body: "Before the meeting"})
+
+	for _, algorithm := range []string{NaiveBayes, LogisticRegression} {
+		t.Run(algorithm, func(t *testing.T) {
+			m, err := Train(algorithm, trainingExamples(), TrainOptions{})
+			if err != nil {
+				t.Fatalf("Train failed: %v", err)
+			}
+			if p := m.Predict(sale); p.Action != openai.ActionTrash || p.Confidence < 0.5 {
+				t.Errorf("Expected the sale to be trashed, got %+v", p)
+			}
+			p := m.Predict(plan)
+			if p.Action != openai.ActionStar || p.Probabilities[openai.ActionStar] != p.Confidence {
+				t.Errorf("Expected the plan to be starred, got %+v", p)
+			}
+			if len(p.Evidence) == 0 {
+				t.Errorf("Expected evidence for the plan, got none")
+			}
+
+			var gpt openai.GPT = m
+			c, err := gpt.ClassifyEmailContext(context.Background(), plan)
+			if err != nil || c.Action != openai.ActionStar || c.Category != "other" || !strings.Contains(c.Reason, "indicative") {
+				t.Errorf("Expected a star classification, got %+v, %v", c, err)
+			}
+			if !strings.HasPrefix(gpt.Model(), algorithm+"@") {
+				t.Errorf("Expected the model name to start with %s@, got %s", algorithm, gpt.Model())
+			}
+		})
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	m, err := Train(LogisticRegression, trainingExamples(), TrainOptions{Seed: 1})
+	if err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "model.json")
+	if err := m.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.Model() != m.Model() {
+		t.Errorf("Expected the loaded model to be %s, got %s", m.Model(), loaded.Model())
+	}
+	text := trainingExamples()[0].Text
+	if got, want := loaded.Predict(text), m.Predict(text); got.Confidence != want.Confidence {
+		t.Errorf("Expected the loaded model to predict %+v, got %+v", want, got)
+	}
+}
+
+func TestTrainErrors(t *testing.T) {
+	if _, err := Train(NaiveBayes, nil, TrainOptions{}); err != ErrNoExamples {
+		t.Errorf("Expected ErrNoExamples, got %v", err)
+	}
+	if _, err := Train("forest", trainingExamples(), TrainOptions{}); err == nil {
+		t.Error("Expected an unknown algorithm to fail")
+	}
+	if _, err := Train(NaiveBayes, []Example{{Text: "Subject: x", Label: "archive"}}, TrainOptions{}); err == nil {
+		t.Error("Expected an unknown label to fail")
+	}
+}
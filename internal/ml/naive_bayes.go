@@ -0,0 +1,58 @@
+package ml
+
+import "math"
+
+// naiveBayes is a multinomial naive Bayes model with add-one smoothing.
+type naiveBayes struct {
+	// ClassCounts counts the examples of each class, FeatureCounts the
+	// occurrences of each feature in them and Totals all of their features.
+	ClassCounts   []float64   `json:"class_counts"`
+	FeatureCounts [][]float64 `json:"feature_counts"`
+	Totals        []float64   `json:"totals"`
+}
+
+func trainNaiveBayes(docs [][]int, labels []int, classes, features int) *naiveBayes {
+	nb := &naiveBayes{
+		ClassCounts:   make([]float64, classes),
+		FeatureCounts: make([][]float64, classes),
+		Totals:        make([]float64, classes),
+	}
+	for c := range nb.FeatureCounts {
+		nb.FeatureCounts[c] = make([]float64, features)
+	}
+	for i, doc := range docs {
+		c := labels[i]
+		nb.ClassCounts[c]++
+		for _, f := range doc {
+			nb.FeatureCounts[c][f]++
+			nb.Totals[c]++
+		}
+	}
+	return nb
+}
+
+// scores returns the log of the prior times the likelihood of the features
+// for each class. Classes without examples score -Inf.
+func (nb *naiveBayes) scores(features []int) []float64 {
+	var examples float64
+	for _, n := range nb.ClassCounts {
+		examples += n
+	}
+	scores := make([]float64, len(nb.ClassCounts))
+	for c, n := range nb.ClassCounts {
+		if n == 0 {
+			scores[c] = math.Inf(-1)
+			continue
+		}
+		scores[c] = math.Log(n / examples)
+		for _, f := range features {
+			scores[c] += nb.contribution(c, f)
+		}
+	}
+	return scores
+}
+
+func (nb *naiveBayes) contribution(class, feature int) float64 {
+	vocabulary := float64(len(nb.FeatureCounts[class]))
+	return math.Log((nb.FeatureCounts[class][feature] + 1) / (nb.Totals[class] + vocabulary))
+}
@@ -0,0 +1,193 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+)
+
+// ErrBudgetExceeded is returned instead of sending a request that could
+// take spending past a Budget.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// Price is what a model charges, in USD per 1000 tokens.
+type Price struct {
+	Prompt     float64
+	Completion float64
+}
+
+// Cost returns what u costs at p.
+func (p Price) Cost(u Usage) float64 {
+	return (float64(u.PromptTokens)*p.Prompt + float64(u.CompletionTokens)*p.Completion) / 1000
+}
+
+// Prices holds prices by model name.
+type Prices map[string]Price
+
+// Lookup returns the price of model, or of the longest model name it
+// starts with, so that gpt-4o-2024-05-13 costs what gpt-4o does. A
+// fine-tuned model, ft:<base>:<org>:<suffix>:<id>, that has no price of
+// its own costs what its base model does.
+func (p Prices) Lookup(model string) (Price, bool) {
+	if price, ok := p.lookup(model); ok {
+		return price, true
+	}
+	if base := strings.TrimPrefix(model, "ft:"); base != model {
+		if i := strings.IndexByte(base, ':'); i >= 0 {
+			base = base[:i]
+		}
+		return p.lookup(base)
+	}
+	return Price{}, false
+}
+
+func (p Prices) lookup(model string) (Price, bool) {
+	if price, ok := p[model]; ok {
+		return price, true
+	}
+	best := ""
+	for name := range p {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	price, ok := p[best]
+	return price, ok && best != ""
+}
+
+// Budget caps spending in USD per calendar day and month, in local time.
+// Zero leaves a period uncapped.
+type Budget struct {
+	Daily   float64
+	Monthly float64
+}
+
+// Ledger keeps the usage of paid requests; db.EmailDB is one.
+type Ledger interface {
+	RecordUsageContext(ctx context.Context, u *db.UsageRecord) error
+	ListUsageContext(ctx context.Context, since time.Time) ([]db.UsageRecord, error)
+}
+
+// MeteredGPT records the tokens and cost of every request its GPT makes to
+// a Ledger, and refuses requests whose estimated cost, added to what was
+// spent today or this month, would pass the Budget. The estimate is
+// EstimateUsage, one request; requests running concurrently count their
+// estimates until they finish, and then what they actually cost, retries
+// included, replaces the estimate.
+type MeteredGPT struct {
+	gpt       GPT
+	ledger    Ledger
+	price     Price
+	budget    Budget
+	operation string
+	now       func() time.Time
+
+	mu sync.Mutex
+	// day is the start of the day spentDay and spentMonth were summed on.
+	day                  time.Time
+	spentDay, spentMonth float64
+	reserved             float64
+}
+
+// NewMeteredGPT meters gpt, pricing its model from prices. A model
+// without a price costs nothing, which suits local servers. operation
+// names what the requests are for in the ledger, e.g. the command.
+func NewMeteredGPT(gpt GPT, ledger Ledger, prices Prices, budget Budget, operation string) *MeteredGPT {
+	price, _ := prices.Lookup(gpt.Model())
+	return &MeteredGPT{gpt: gpt, ledger: ledger, price: price, budget: budget, operation: operation, now: time.Now}
+}
+
+func (m *MeteredGPT) Model() string {
+	return m.gpt.Model()
+}
+
+// Params returns the Params of the metered GPT, if it has any, so that a
+// Cache in front of m keys answers as it would without m.
+func (m *MeteredGPT) Params() string {
+	if p, ok := m.gpt.(interface{ Params() string }); ok {
+		return p.Params()
+	}
+	return ""
+}
+
+func (m *MeteredGPT) ClassifyEmail(prompt string) (Classification, error) {
+	return m.ClassifyEmailContext(context.Background(), prompt)
+}
+
+// ClassifyEmailContext classifies prompt unless the budget forbids it, and
+// records the usage even when the classification fails.
+func (m *MeteredGPT) ClassifyEmailContext(ctx context.Context, prompt string) (Classification, error) {
+	estimate := m.price.Cost(EstimateUsage(m.Model(), prompt))
+	if err := m.reserve(ctx, estimate); err != nil {
+		return Classification{}, err
+	}
+
+	c, err := m.gpt.ClassifyEmailContext(ctx, prompt)
+
+	cost := m.price.Cost(c.Usage)
+	m.mu.Lock()
+	m.reserved -= estimate
+	m.spentDay += cost
+	m.spentMonth += cost
+	m.mu.Unlock()
+
+	if c.Usage != (Usage{}) {
+		record := db.UsageRecord{
+			Model:            m.Model(),
+			Operation:        m.operation,
+			PromptTokens:     c.Usage.PromptTokens,
+			CompletionTokens: c.Usage.CompletionTokens,
+			Cost:             cost,
+		}
+		// Not ctx: an interrupted request was still paid for.
+		if recordErr := m.ledger.RecordUsageContext(context.Background(), &record); recordErr != nil && err == nil {
+			err = recordErr
+		}
+	}
+	return c, err
+}
+
+// reserve sets estimate aside from the budget, or returns
+// ErrBudgetExceeded.
+func (m *MeteredGPT) reserve(ctx context.Context, estimate float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if !m.day.Equal(day) {
+		records, err := m.ledger.ListUsageContext(ctx, month)
+		if err != nil {
+			return err
+		}
+		m.day, m.spentDay, m.spentMonth = day, 0, 0
+		for _, r := range records {
+			m.spentMonth += r.Cost
+			if !r.CreatedAt.Before(day) {
+				m.spentDay += r.Cost
+			}
+		}
+	}
+
+	for _, limit := range []struct {
+		period string
+		budget float64
+		spent  float64
+	}{
+		{"daily", m.budget.Daily, m.spentDay},
+		{"monthly", m.budget.Monthly, m.spentMonth},
+	} {
+		if limit.budget > 0 && limit.spent+m.reserved+estimate > limit.budget {
+			return fmt.Errorf("%w: $%.4f spent or in flight and $%.4f estimated for this request would pass the %s budget of $%.4f",
+				ErrBudgetExceeded, limit.spent+m.reserved, estimate, limit.period, limit.budget)
+		}
+	}
+	m.reserved += estimate
+	return nil
+}
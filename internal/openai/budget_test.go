@@ -0,0 +1,193 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+)
+
+// memLedger is a Ledger in memory.
+type memLedger struct {
+	records []db.UsageRecord
+}
+
+func (l *memLedger) RecordUsageContext(ctx context.Context, u *db.UsageRecord) error {
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now()
+	}
+	l.records = append(l.records, *u)
+	return nil
+}
+
+func (l *memLedger) ListUsageContext(ctx context.Context, since time.Time) ([]db.UsageRecord, error) {
+	var records []db.UsageRecord
+	for _, r := range l.records {
+		if !r.CreatedAt.Before(since) {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func TestMeteredGPTRecordsAndEnforcesBudgets(t *testing.T) {
+	now := time.Date(2023, 5, 19, 12, 0, 0, 0, time.Local)
+	prices := Prices{"gpt-test": {Prompt: 1, Completion: 2}}
+	// Each answer of chatServer uses 100 prompt and 20 completion tokens.
+	const spent = (100*1 + 20*2) / 1000.0
+	estimate := prices["gpt-test"].Cost(EstimateUsage("gpt-test", "Subject: Hi"))
+
+	tests := []struct {
+		name   string
+		budget Budget
+	}{
+		// Earlier spending this month counts against the monthly budget
+		// only; last month's not at all.
+		{"daily", Budget{Daily: estimate + spent/2}},
+		{"monthly", Budget{Daily: 1000, Monthly: 5 + estimate + spent/2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			classifier, requests := chatServer(t, starAnswer, starAnswer)
+			ledger := &memLedger{records: []db.UsageRecord{
+				{Model: "gpt-test", Cost: 5, CreatedAt: now.AddDate(0, 0, -1)},
+				{Model: "gpt-test", Cost: 100, CreatedAt: now.AddDate(0, -1, 0)},
+			}}
+			metered := NewMeteredGPT(classifier, ledger, prices, tt.budget, "test")
+			metered.now = func() time.Time { return now }
+
+			if _, err := metered.ClassifyEmail("Subject: Hi"); err != nil {
+				t.Fatalf("ClassifyEmail failed: %v", err)
+			}
+			last := ledger.records[len(ledger.records)-1]
+			if last.Operation != "test" || last.PromptTokens != 100 || last.CompletionTokens != 20 || last.Cost != spent {
+				t.Errorf("Expected the usage and cost to be recorded, got %+v", last)
+			}
+
+			if _, err := metered.ClassifyEmail("Subject: Hi"); !errors.Is(err, ErrBudgetExceeded) {
+				t.Errorf("Expected ErrBudgetExceeded, got %v", err)
+			}
+			if len(*requests) != 1 {
+				t.Errorf("Expected the refused request not to be sent, got %d requests", len(*requests))
+			}
+		})
+	}
+}
+
+func TestMeteredGPTSettlesRetries(t *testing.T) {
+	prices := Prices{"gpt-test": {Prompt: 1, Completion: 2}}
+	estimate := prices["gpt-test"].Cost(EstimateUsage("gpt-test", "Subject: Hi"))
+
+	// The server fails every request the first time it is sent, and answers
+	// the second time with an invalid answer of the maximum length, so the
+	// classifier retries as much as it ever does. It holds the first request
+	// until released.
+	started, release := make(chan struct{}), make(chan struct{})
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			close(started)
+			<-release
+		}
+		if requests%2 == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"error": {"message": "The server had an error", "type": "server_error"}}`)
+			return
+		}
+		var req openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		prompt := 0
+		for _, m := range req.Messages {
+			prompt += CountTokens("gpt-test", m.Content) + messageOverhead
+		}
+		fmt.Fprintf(w, `{"choices": [{"message": {"role": "assistant", "content": "{}"}}],
+			"usage": {"prompt_tokens": %d, "completion_tokens": %d}}`, prompt+300, maxTokens)
+	}))
+	defer srv.Close()
+	classifier, err := NewClassifier(Provider{Name: "compatible", BaseURL: srv.URL + "/v1/", Model: "gpt-test"})
+	if err != nil {
+		t.Fatalf("NewClassifier failed: %v", err)
+	}
+	classifier.backoff = time.Millisecond
+
+	ledger := &memLedger{}
+	metered := NewMeteredGPT(classifier, ledger, prices, Budget{Daily: 1.5 * estimate}, "test")
+	done := make(chan error)
+	go func() {
+		_, err := metered.ClassifyEmail("Subject: Hi")
+		done <- err
+	}()
+
+	// While the first classification is in flight its estimate is set
+	// aside and a second does not fit.
+	<-started
+	if _, err := metered.ClassifyEmail("Subject: Hi"); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Expected ErrBudgetExceeded while the first request is reserved, got %v", err)
+	}
+	close(release)
+	if err := <-done; !errors.Is(err, ErrInvalidClassification) {
+		t.Fatalf("Expected ErrInvalidClassification, got %v", err)
+	}
+
+	if requests != 2*maxAttempts || len(ledger.records) != 1 {
+		t.Fatalf("Expected %d requests recorded once, got %d requests and %+v", 2*maxAttempts, requests, ledger.records)
+	}
+	// The retries cost more than the one request reserved, and are charged
+	// in full.
+	if spent := ledger.records[0]; spent.CompletionTokens != maxAttempts*maxTokens || spent.Cost <= 1.5*estimate {
+		t.Errorf("Expected the retries to cost more than the $%.4f budget, got %+v", 1.5*estimate, spent)
+	}
+	if _, err := metered.ClassifyEmail("Subject: Hi"); !errors.Is(err, ErrBudgetExceeded) || requests != 2*maxAttempts {
+		t.Errorf("Expected ErrBudgetExceeded once the retries were charged, got %v after %d requests", err, requests)
+	}
+}
+
+func TestPricesLookup(t *testing.T) {
+	prices := Prices{"gpt-4": {Prompt: 30}, "gpt-4o": {Prompt: 5}}
+	for model, want := range map[string]float64{"gpt-4": 30, "gpt-4-0613": 30, "gpt-4o-2024-05-13": 5} {
+		if price, ok := prices.Lookup(model); !ok || price.Prompt != want {
+			t.Errorf("Lookup(%q) = %+v, %v; expected a prompt price of %g", model, price, ok, want)
+		}
+	}
+	if _, ok := prices.Lookup("llama3"); ok {
+		t.Error("Expected no price for llama3")
+	}
+
+	// Fine-tuned models cost what their base model does, unless priced.
+	prices["ft:gpt-4o-mini"] = Price{Prompt: 0.3}
+	for model, want := range map[string]float64{
+		"ft:gpt-4-0613:acme::8xYz":              30,
+		"ft:gpt-4o-2024-08-06:acme:triage:9AbC": 5,
+		"ft:gpt-4o-mini-2024-07-18:acme::7dEf":  0.3,
+	} {
+		if price, ok := prices.Lookup(model); !ok || price.Prompt != want {
+			t.Errorf("Lookup(%q) = %+v, %v; expected a prompt price of %g", model, price, ok, want)
+		}
+	}
+	if _, ok := prices.Lookup("ft:llama3:acme::1"); ok {
+		t.Error("Expected no price for a fine-tuned llama3")
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	if n := CountTokens("gpt-3.5-turbo", "hello world"); n != 2 {
+		t.Errorf("Expected 2 tokens, got %d", n)
+	}
+	// Unknown models are counted with a common encoding.
+	if n := CountTokens("llama3", "hello world"); n != 2 {
+		t.Errorf("Expected 2 tokens for an unknown model, got %d", n)
+	}
+	estimate := EstimateUsage("gpt-3.5-turbo", "Subject: Hi")
+	if estimate.PromptTokens <= CountTokens("gpt-3.5-turbo", systemPrompt) || estimate.CompletionTokens != maxTokens {
+		t.Errorf("Expected the estimate to cover the system prompt, tool and a full answer of one request, got %+v", estimate)
+	}
+}
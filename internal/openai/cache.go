@@ -0,0 +1,168 @@
+package openai
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const createCacheTable = `CREATE TABLE IF NOT EXISTS llm_cache (
+	key TEXT PRIMARY KEY,
+	model TEXT NOT NULL,
+	answer TEXT NOT NULL,
+	prompt_tokens INTEGER NOT NULL DEFAULT 0,
+	completion_tokens INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL,
+	used_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS llm_cache_used_at ON llm_cache (used_at);`
+
+// Cache keeps the answers of a chat model in a SQLite file, so that asking
+// the same model the same prompt again costs nothing and gives the same
+// answer. Entries older than the TTL are not used; beyond MaxEntries the
+// least recently used are dropped. It is safe for concurrent use.
+type Cache struct {
+	db         *sql.DB
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+}
+
+// OpenCache opens or creates the cache at path. A zero ttl keeps answers
+// forever and a zero maxEntries does not bound the cache.
+func OpenCache(path string, ttl time.Duration, maxEntries int) (*Cache, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// One connection: the workers of a batch take turns instead of
+	// failing on SQLite's write lock.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(createCacheTable); err != nil {
+		db.Close()
+		return nil, err
+	}
+	c := &Cache{db: db, ttl: ttl, maxEntries: maxEntries, now: time.Now}
+	if ttl > 0 {
+		if _, err := db.Exec(`DELETE FROM llm_cache WHERE created_at < $1`, c.now().Add(-ttl).Unix()); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// Len returns the number of cached answers.
+func (c *Cache) Len() (int, error) {
+	var n int
+	err := c.db.QueryRow(`SELECT COUNT(*) FROM llm_cache`).Scan(&n)
+	return n, err
+}
+
+// cacheKey hashes everything that shapes an answer.
+func cacheKey(model, params, prompt string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + params + "\x00" + prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns the answer cached under key, if any and not expired.
+func (c *Cache) get(ctx context.Context, key string) (string, bool, error) {
+	var answer string
+	var createdAt int64
+	err := c.db.QueryRowContext(ctx, `SELECT answer, created_at FROM llm_cache WHERE key = $1`, key).
+		Scan(&answer, &createdAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", false, nil
+	case err != nil:
+		return "", false, err
+	case c.ttl > 0 && c.now().Sub(time.Unix(createdAt, 0)) > c.ttl:
+		return "", false, nil
+	}
+	_, err = c.db.ExecContext(ctx, `UPDATE llm_cache SET used_at = $1 WHERE key = $2`, c.now().Unix(), key)
+	return answer, true, err
+}
+
+// put caches answer under key, then drops the least recently used entries
+// beyond the size limit.
+func (c *Cache) put(ctx context.Context, key, model, answer string, usage Usage) error {
+	now := c.now().Unix()
+	_, err := c.db.ExecContext(ctx, `INSERT INTO llm_cache
+			(key, model, answer, prompt_tokens, completion_tokens, created_at, used_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $6)
+		ON CONFLICT (key) DO UPDATE SET
+			answer = excluded.answer,
+			prompt_tokens = excluded.prompt_tokens,
+			completion_tokens = excluded.completion_tokens,
+			created_at = excluded.created_at,
+			used_at = excluded.used_at`,
+		key, model, answer, usage.PromptTokens, usage.CompletionTokens, now)
+	if err != nil || c.maxEntries <= 0 {
+		return err
+	}
+	_, err = c.db.ExecContext(ctx, `DELETE FROM llm_cache WHERE key IN
+		(SELECT key FROM llm_cache ORDER BY used_at DESC, created_at DESC LIMIT -1 OFFSET $1)`, c.maxEntries)
+	return err
+}
+
+// CachedGPT answers from a Cache what its GPT was already asked.
+type CachedGPT struct {
+	gpt    GPT
+	cache  *Cache
+	params string
+	// Refresh asks the model even when an answer is cached, and caches the
+	// new answer.
+	Refresh bool
+}
+
+// Wrap puts the cache in front of gpt. Answers are keyed by gpt's model,
+// the prompt and, when gpt has a Params method, what it returns.
+func (c *Cache) Wrap(gpt GPT) *CachedGPT {
+	cached := &CachedGPT{gpt: gpt, cache: c}
+	if p, ok := gpt.(interface{ Params() string }); ok {
+		cached.params = p.Params()
+	}
+	return cached
+}
+
+func (g *CachedGPT) Model() string {
+	return g.gpt.Model()
+}
+
+func (g *CachedGPT) ClassifyEmail(prompt string) (Classification, error) {
+	return g.ClassifyEmailContext(context.Background(), prompt)
+}
+
+// ClassifyEmailContext returns the cached classification of prompt, with
+// Cached set and no Usage, or asks the model and caches its answer. A
+// cached answer that no longer validates is asked for again.
+func (g *CachedGPT) ClassifyEmailContext(ctx context.Context, prompt string) (Classification, error) {
+	key := cacheKey(g.gpt.Model(), g.params, prompt)
+	if !g.Refresh {
+		answer, ok, err := g.cache.get(ctx, key)
+		if err != nil {
+			return Classification{}, err
+		}
+		if ok {
+			if c, err := parseClassification(answer); err == nil {
+				c.Raw = answer
+				c.Cached = true
+				return c, nil
+			}
+		}
+	}
+
+	c, err := g.gpt.ClassifyEmailContext(ctx, prompt)
+	if err != nil {
+		return c, err
+	}
+	return c, g.cache.put(ctx, key, g.gpt.Model(), c.Raw, c.Usage)
+}
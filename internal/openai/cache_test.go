@@ -0,0 +1,79 @@
+package openai
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const starAnswer = `{"action": "star", "category": "personal", "confidence": 0.8, "reason": "From a friend."}`
+
+func openTestCache(t *testing.T, ttl time.Duration, maxEntries int) *Cache {
+	cache, err := OpenCache(filepath.Join(t.TempDir(), "cache.sqlite"), ttl, maxEntries)
+	if err != nil {
+		t.Fatalf("OpenCache failed: %v", err)
+	}
+	t.Cleanup(func() { cache.Close() })
+	return cache
+}
+
+func TestCachedGPT(t *testing.T) {
+	classifier, requests := chatServer(t, starAnswer, starAnswer, starAnswer)
+	cached := openTestCache(t, 0, 0).Wrap(classifier)
+
+	first, err := cached.ClassifyEmail("Subject: Dinner?")
+	if err != nil || first.Cached || first.Usage.PromptTokens != 100 {
+		t.Fatalf("Expected the model to be asked first, got %+v, %v", first, err)
+	}
+	second, err := cached.ClassifyEmail("Subject: Dinner?")
+	if err != nil || !second.Cached || second.Usage != (Usage{}) || second.Action != ActionStar || second.Raw != starAnswer {
+		t.Errorf("Expected the cached answer without usage, got %+v, %v", second, err)
+	}
+	if len(*requests) != 1 {
+		t.Errorf("Expected 1 request, got %d", len(*requests))
+	}
+
+	if _, err := cached.ClassifyEmail("Subject: Lunch?"); err != nil || len(*requests) != 2 {
+		t.Errorf("Expected another prompt to be asked, got %d requests, %v", len(*requests), err)
+	}
+	cached.Refresh = true
+	if c, err := cached.ClassifyEmail("Subject: Dinner?"); err != nil || c.Cached || len(*requests) != 3 {
+		t.Errorf("Expected Refresh to ask again, got %+v after %d requests, %v", c, len(*requests), err)
+	}
+}
+
+func TestCacheTTLAndSize(t *testing.T) {
+	classifier, requests := chatServer(t, starAnswer, starAnswer, starAnswer, starAnswer)
+	cache := openTestCache(t, time.Hour, 2)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	cached := cache.Wrap(classifier)
+
+	for _, prompt := range []string{"a", "b", "c"} {
+		if _, err := cached.ClassifyEmail(prompt); err != nil {
+			t.Fatalf("ClassifyEmail failed: %v", err)
+		}
+	}
+	if n, err := cache.Len(); err != nil || n != 2 {
+		t.Errorf("Expected the cache to keep 2 entries, got %d, %v", n, err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if c, err := cached.ClassifyEmail("c"); err != nil || c.Cached || len(*requests) != 4 {
+		t.Errorf("Expected an expired answer to be asked again, got %+v after %d requests, %v", c, len(*requests), err)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	classifier, _ := chatServer(t)
+	key := cacheKey("gpt-test", classifier.Params(), "prompt")
+	for _, other := range []string{
+		cacheKey("gpt-other", classifier.Params(), "prompt"),
+		cacheKey("gpt-test", "", "prompt"),
+		cacheKey("gpt-test", classifier.Params(), "prompt "),
+	} {
+		if other == key {
+			t.Errorf("Expected model, params and prompt to change the key %s", key)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package openai
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Action is what the classifier suggests doing with an email. The actions
+// match what can be observed in the mailbox afterwards, so that
+// suggestions can be checked against history.
+type Action string
+
+const (
+	ActionTrash  Action = "trash"
+	ActionStar   Action = "star"
+	ActionRead   Action = "read"
+	ActionIgnore Action = "ignore"
+)
+
+// Actions lists every valid Action.
+var Actions = []Action{ActionTrash, ActionStar, ActionRead, ActionIgnore}
+
+// Categories lists the valid values of Classification.Category.
+var Categories = []string{"personal", "work", "finance", "updates", "promotions", "social", "forums", "spam", "other"}
+
+// ErrInvalidClassification is returned when the model's answer still does
+// not validate after the retries.
+var ErrInvalidClassification = errors.New("invalid classification")
+
+// Classification is the model's verdict on an email.
+type Classification struct {
+	Action   Action `json:"action"`
+	Category string `json:"category"`
+	// Confidence is between 0 and 1.
+	Confidence float64 `json:"confidence"`
+	Reason     string  `json:"reason"`
+
+	// Raw is the answer the classification was parsed from and Usage the
+	// tokens spent on it, counting retries. Cached is set when the answer
+	// came from a Cache, which spends none.
+	Raw    string `json:"-"`
+	Usage  Usage  `json:"-"`
+	Cached bool   `json:"-"`
+}
+
+// Usage counts the tokens of chat completions.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Validate checks that every field holds an allowed value.
+func (c Classification) Validate() error {
+	var problems []string
+	if !containsAction(Actions, c.Action) {
+		problems = append(problems, fmt.Sprintf("action %q is not one of %s", c.Action, strings.Join(actionNames(), ", ")))
+	}
+	if !containsString(Categories, c.Category) {
+		problems = append(problems, fmt.Sprintf("category %q is not one of %s", c.Category, strings.Join(Categories, ", ")))
+	}
+	if c.Confidence < 0 || c.Confidence > 1 {
+		problems = append(problems, fmt.Sprintf("confidence %g is not between 0 and 1", c.Confidence))
+	}
+	if strings.TrimSpace(c.Reason) == "" {
+		problems = append(problems, "reason is empty")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidClassification, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (c Classification) String() string {
+	return fmt.Sprintf("%s (%s, %.0f%%): %s", c.Action, c.Category, c.Confidence*100, c.Reason)
+}
+
+func containsAction(actions []Action, action Action) bool {
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func actionNames() []string {
+	names := make([]string, len(Actions))
+	for i, a := range Actions {
+		names[i] = string(a)
+	}
+	return names
+}
+
+func containsString(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,263 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// outcomeReasons explain the actions of fine-tuning examples, which are
+// what was done with the emails rather than anyone's judgement.
+var outcomeReasons = map[Action]string{
+	ActionTrash:  "Emails like this one get trashed.",
+	ActionStar:   "Emails like this one get starred.",
+	ActionRead:   "Emails like this one get read.",
+	ActionIgnore: "Emails like this one are left unread.",
+}
+
+// fineTuneMessage is a chat message of a fine-tuning example; unlike
+// openai.ChatCompletionMessage it leaves out the content of tool calls.
+type fineTuneMessage struct {
+	Role      string            `json:"role"`
+	Content   string            `json:"content,omitempty"`
+	ToolCalls []openai.ToolCall `json:"tool_calls,omitempty"`
+}
+
+// FineTuneExample renders a line of a chat fine-tuning dataset that
+// teaches a model to answer prompt with outcome the way GPT3Classifier
+// asks: the same system prompt, and a classify_email call in reply.
+// confidence is what the model learns to say it is; for its answers to be
+// calibrated it should be how often emails like this one had outcome,
+// not a certainty the outcome alone does not give.
+func FineTuneExample(prompt string, outcome Action, confidence float64) ([]byte, error) {
+	args, err := json.Marshal(Classification{
+		Action:     outcome,
+		Category:   "other",
+		Confidence: confidence,
+		Reason:     outcomeReasons[outcome],
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Messages []fineTuneMessage `json:"messages"`
+		Tools    []openai.Tool     `json:"tools"`
+	}{
+		Messages: []fineTuneMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: systemPrompt},
+			{Role: openai.ChatMessageRoleUser, Content: prompt},
+			{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{{
+				ID:       "call_1",
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: classifyFunction, Arguments: string(args)},
+			}}},
+		},
+		Tools: []openai.Tool{classifyTool},
+	})
+}
+
+// The statuses a fine-tuning job ends in.
+const (
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is a fine-tuning job. FineTunedModel is set once it succeeds.
+type Job struct {
+	ID             string
+	BaseModel      string
+	FineTunedModel string
+	Status         string
+	TrainingFile   string
+	ValidationFile string
+	TrainedTokens  int
+	CreatedAt      time.Time
+	// FinishedAt is zero while the job runs.
+	FinishedAt time.Time
+}
+
+// Done reports whether the job has stopped, for better or worse.
+func (j Job) Done() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCancelled
+}
+
+// JobEvent is a message a fine-tuning job logged.
+type JobEvent struct {
+	CreatedAt time.Time
+	Level     string
+	Message   string
+}
+
+// JobRequest starts a fine-tuning job of Model on the uploaded
+// TrainingFile. Epochs of 0 leaves the number to the server.
+type JobRequest struct {
+	Model          string
+	TrainingFile   string
+	ValidationFile string
+	Suffix         string
+	Epochs         int
+}
+
+// FineTuner uploads datasets and manages fine-tuning jobs through the
+// files and fine-tuning endpoints of a Provider. Create one with
+// NewFineTuner.
+type FineTuner struct {
+	client *openai.Client
+	// Poll is how often Watch asks for news of a job.
+	Poll time.Duration
+}
+
+// NewFineTuner returns a FineTuner talking to p.
+func NewFineTuner(p Provider) (*FineTuner, error) {
+	client, err := newClient(p)
+	if err != nil {
+		return nil, err
+	}
+	return &FineTuner{client: client, Poll: 30 * time.Second}, nil
+}
+
+// Upload checks that the file at path holds chat fine-tuning examples, one
+// JSON object with messages per line, and uploads it for fine-tuning. It
+// returns the ID of the uploaded file.
+func (f *FineTuner) Upload(ctx context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	lines := 0
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var example struct {
+			Messages []json.RawMessage `json:"messages"`
+		}
+		if err := json.Unmarshal(line, &example); err != nil || len(example.Messages) == 0 {
+			return "", fmt.Errorf("%s:%d: not a chat fine-tuning example", path, i+1)
+		}
+		lines++
+	}
+	if lines == 0 {
+		return "", fmt.Errorf("%s: no examples", path)
+	}
+	file, err := f.client.CreateFileBytes(ctx, openai.FileBytesRequest{
+		Name:    filepath.Base(path),
+		Bytes:   data,
+		Purpose: openai.PurposeFineTune,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error uploading %s: %w", path, err)
+	}
+	return file.ID, nil
+}
+
+// Create starts a fine-tuning job.
+func (f *FineTuner) Create(ctx context.Context, req JobRequest) (Job, error) {
+	r := openai.FineTuningJobRequest{
+		Model:          req.Model,
+		TrainingFile:   req.TrainingFile,
+		ValidationFile: req.ValidationFile,
+		Suffix:         req.Suffix,
+	}
+	if req.Epochs > 0 {
+		r.Hyperparameters = &openai.Hyperparameters{Epochs: req.Epochs}
+	}
+	job, err := f.client.CreateFineTuningJob(ctx, r)
+	if err != nil {
+		return Job{}, fmt.Errorf("error creating fine-tuning job: %w", err)
+	}
+	return jobOf(job), nil
+}
+
+// Job returns the current state of a job.
+func (f *FineTuner) Job(ctx context.Context, id string) (Job, error) {
+	job, err := f.client.RetrieveFineTuningJob(ctx, id)
+	if err != nil {
+		return Job{}, fmt.Errorf("error retrieving fine-tuning job %s: %w", id, err)
+	}
+	return jobOf(job), nil
+}
+
+// Cancel stops a job.
+func (f *FineTuner) Cancel(ctx context.Context, id string) (Job, error) {
+	job, err := f.client.CancelFineTuningJob(ctx, id)
+	if err != nil {
+		return Job{}, fmt.Errorf("error cancelling fine-tuning job %s: %w", id, err)
+	}
+	return jobOf(job), nil
+}
+
+// Events returns the latest events of a job, oldest first.
+func (f *FineTuner) Events(ctx context.Context, id string) ([]JobEvent, error) {
+	list, err := f.client.ListFineTuningJobEvents(ctx, id, openai.ListFineTuningJobEventsWithLimit(100))
+	if err != nil {
+		return nil, fmt.Errorf("error listing events of fine-tuning job %s: %w", id, err)
+	}
+	events := make([]JobEvent, len(list.Data))
+	for i, e := range list.Data {
+		// The server lists the newest first.
+		events[len(events)-1-i] = JobEvent{CreatedAt: time.Unix(e.CreatedAt, 0), Level: e.Level, Message: e.Message}
+	}
+	return events, nil
+}
+
+// Watch polls a job every Poll until it is done, passing each event it
+// has not passed before to onEvent and each state of the job to onJob, and
+// returns the final state.
+func (f *FineTuner) Watch(ctx context.Context, id string, onEvent func(JobEvent), onJob func(Job)) (Job, error) {
+	seen := map[string]bool{}
+	for {
+		// The events are read after the job, so that those logged as it
+		// finished are passed on before it is returned.
+		job, err := f.Job(ctx, id)
+		if err != nil {
+			return Job{}, err
+		}
+		events, err := f.Events(ctx, id)
+		if err != nil {
+			return Job{}, err
+		}
+		for _, e := range events {
+			key := fmt.Sprint(e.CreatedAt.Unix(), e.Level, e.Message)
+			if !seen[key] {
+				seen[key] = true
+				onEvent(e)
+			}
+		}
+		onJob(job)
+		if job.Done() {
+			return job, nil
+		}
+		select {
+		case <-time.After(f.Poll):
+		case <-ctx.Done():
+			return job, ctx.Err()
+		}
+	}
+}
+
+func jobOf(job openai.FineTuningJob) Job {
+	j := Job{
+		ID:             job.ID,
+		BaseModel:      job.Model,
+		FineTunedModel: job.FineTunedModel,
+		Status:         job.Status,
+		TrainingFile:   job.TrainingFile,
+		ValidationFile: job.ValidationFile,
+		TrainedTokens:  job.TrainedTokens,
+	}
+	if job.CreatedAt != 0 {
+		j.CreatedAt = time.Unix(job.CreatedAt, 0)
+	}
+	if job.FinishedAt != 0 {
+		j.FinishedAt = time.Unix(job.FinishedAt, 0)
+	}
+	return j
+}
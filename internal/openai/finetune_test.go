@@ -0,0 +1,167 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// fakeFineTuning serves the files and fine-tuning endpoints. A job moves
+// on to the next of its statuses each time it is retrieved, logging an
+// event for each.
+type fakeFineTuning struct {
+	mu       sync.Mutex
+	uploads  map[string]string
+	requests []openai.FineTuningJobRequest
+	statuses []string
+	job      openai.FineTuningJob
+	events   []openai.FineTuneEvent
+}
+
+func newFakeFineTuning(t *testing.T, statuses ...string) (*FineTuner, *fakeFineTuning) {
+	fake := &fakeFineTuning{uploads: map[string]string{}, statuses: statuses}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	tuner, err := NewFineTuner(Provider{Name: "openai", BaseURL: srv.URL + "/v1", APIKey: "sk-test"})
+	if err != nil {
+		t.Fatalf("NewFineTuner failed: %v", err)
+	}
+	tuner.Poll = time.Millisecond
+	return tuner, fake
+}
+
+func (f *fakeFineTuning) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v1")
+	switch {
+	case r.Method == http.MethodPost && path == "/files":
+		file, header, err := r.FormFile("file")
+		if err != nil || r.FormValue("purpose") != "fine-tune" {
+			http.Error(w, "bad upload", http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		id := fmt.Sprintf("file-%d", len(f.uploads)+1)
+		f.uploads[id] = string(data)
+		json.NewEncoder(w).Encode(openai.File{ID: id, FileName: header.Filename, Purpose: "fine-tune"})
+	case r.Method == http.MethodPost && path == "/fine_tuning/jobs":
+		var req openai.FineTuningJobRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.requests = append(f.requests, req)
+		f.job = openai.FineTuningJob{ID: "ftjob-1", Model: req.Model, Status: "validating_files",
+			TrainingFile: req.TrainingFile, ValidationFile: req.ValidationFile, CreatedAt: 1700000000}
+		f.log("Created fine-tuning job")
+		json.NewEncoder(w).Encode(f.job)
+	case r.Method == http.MethodGet && path == "/fine_tuning/jobs/ftjob-1":
+		if len(f.statuses) > 0 && !f.done() {
+			f.job.Status, f.statuses = f.statuses[0], f.statuses[1:]
+			f.log("Job is " + f.job.Status)
+			if f.job.Status == JobSucceeded {
+				f.job.FineTunedModel = "ft:gpt-test:triage:1"
+				f.job.TrainedTokens = 4200
+				f.job.FinishedAt = 1700003600
+			}
+		}
+		json.NewEncoder(w).Encode(f.job)
+	case r.Method == http.MethodGet && path == "/fine_tuning/jobs/ftjob-1/events":
+		list := openai.FineTuningJobEventList{Object: "list"}
+		for i := len(f.events) - 1; i >= 0; i-- {
+			list.Data = append(list.Data, f.events[i])
+		}
+		json.NewEncoder(w).Encode(list)
+	case r.Method == http.MethodPost && path == "/fine_tuning/jobs/ftjob-1/cancel":
+		f.job.Status = JobCancelled
+		f.log("Job cancelled")
+		json.NewEncoder(w).Encode(f.job)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error": {"message": "no such job", "type": "invalid_request_error"}}`)
+	}
+}
+
+func (f *fakeFineTuning) done() bool {
+	return Job{Status: f.job.Status}.Done()
+}
+
+func (f *fakeFineTuning) log(message string) {
+	f.events = append(f.events, openai.FineTuneEvent{Object: "fine_tuning.job.event", CreatedAt: 1700000000 + int64(len(f.events)), Level: "info", Message: message})
+}
+
+func TestFineTuner(t *testing.T) {
+	tuner, fake := newFakeFineTuning(t, "queued", "running", JobSucceeded)
+	ctx := context.Background()
+
+	dataset := filepath.Join(t.TempDir(), "train.jsonl")
+	line, err := FineTuneExample("Subject: Sale", ActionTrash, 0.8)
+	if err != nil {
+		t.Fatalf("FineTuneExample failed: %v", err)
+	}
+	os.WriteFile(dataset, append(line, '\n'), 0644)
+	fileID, err := tuner.Upload(ctx, dataset)
+	if err != nil || fileID != "file-1" || fake.uploads[fileID] != string(line)+"\n" {
+		t.Fatalf("Expected the dataset to be uploaded as file-1, got %q, %v", fileID, err)
+	}
+
+	job, err := tuner.Create(ctx, JobRequest{Model: "gpt-test", TrainingFile: fileID, Suffix: "triage", Epochs: 3})
+	if err != nil || job.ID != "ftjob-1" || job.Done() {
+		t.Fatalf("Expected a running job, got %+v, %v", job, err)
+	}
+	if req := fake.requests[0]; req.Suffix != "triage" || req.Hyperparameters == nil || req.Hyperparameters.Epochs != float64(3) {
+		t.Errorf("Unexpected job request %+v", req)
+	}
+
+	var messages, statuses []string
+	job, err = tuner.Watch(ctx, job.ID, func(e JobEvent) {
+		messages = append(messages, e.Message)
+	}, func(j Job) {
+		statuses = append(statuses, j.Status)
+	})
+	if err != nil || job.Status != JobSucceeded || job.FineTunedModel != "ft:gpt-test:triage:1" || job.FinishedAt.Unix() != 1700003600 {
+		t.Fatalf("Expected the job to succeed, got %+v, %v", job, err)
+	}
+	if got := strings.Join(statuses, ","); got != "queued,running,succeeded" {
+		t.Errorf("Expected each status once, got %s", got)
+	}
+	if got := strings.Join(messages, ","); got != "Created fine-tuning job,Job is queued,Job is running,Job is succeeded" {
+		t.Errorf("Expected each event once and in order, got %s", got)
+	}
+}
+
+func TestFineTunerErrors(t *testing.T) {
+	tuner, fake := newFakeFineTuning(t)
+	ctx := context.Background()
+
+	bad := filepath.Join(t.TempDir(), "train.csv")
+	os.WriteFile(bad, []byte("id,subject\n1,Sale\n"), 0644)
+	if _, err := tuner.Upload(ctx, bad); err == nil || !strings.Contains(err.Error(), "train.csv:1") {
+		t.Errorf("Expected a CSV to be refused, got %v", err)
+	}
+	if len(fake.uploads) != 0 {
+		t.Errorf("Expected nothing uploaded, got %d files", len(fake.uploads))
+	}
+
+	if _, err := tuner.Job(ctx, "ftjob-404"); err == nil || !strings.Contains(err.Error(), "no such job") {
+		t.Errorf("Expected the API error, got %v", err)
+	}
+
+	if _, err := tuner.Create(ctx, JobRequest{Model: "gpt-test", TrainingFile: "file-1"}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	job, err := tuner.Cancel(ctx, "ftjob-1")
+	if err != nil || job.Status != JobCancelled || !job.Done() {
+		t.Errorf("Expected the job to be cancelled, got %+v, %v", job, err)
+	}
+}
@@ -1,5 +1,11 @@
 package openai
 
+import "context"
+
 type GPT interface {
-	ClassifyEmail(prompt string) (string, error)
+	ClassifyEmail(prompt string) (Classification, error)
+	ClassifyEmailContext(ctx context.Context, prompt string) (Classification, error)
+	// Model names the model behind the classifier, to record with its
+	// predictions.
+	Model() string
 }
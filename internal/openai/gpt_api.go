@@ -2,65 +2,203 @@ package openai
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
 	openai "github.com/sashabaranov/go-openai"
-	"github.com/sunkay11/gmail-automation/internal/db"
+	"github.com/sashabaranov/go-openai/jsonschema"
 )
 
+// classifyFunction is the tool the model is made to call with its verdict,
+// which constrains its answer to the Classification schema.
+const classifyFunction = "classify_email"
+
+// maxAttempts is how many times an answer that does not validate is asked
+// for before giving up with ErrInvalidClassification.
+const maxAttempts = 3
+
+// maxTokens bounds the length of an answer.
+const maxTokens = 200
+
+// maxRequests bounds how many times one request is sent when the server
+// answers that it is rate limited or failing; the wait between requests
+// doubles from the classifier's backoff.
+const maxRequests = 5
+
+const systemPrompt = `You triage a Gmail inbox. For each email decide what its owner should do with it: ` +
+	`trash it, star it, read it, or ignore it (leave it unread). Answer by calling ` + classifyFunction + `.`
+
+// retryPrompt asks again for an answer that did not validate, given the
+// answer and what was wrong with it.
+const retryPrompt = `Your previous answer %s was invalid: %v. Call ` + classifyFunction + ` again with valid arguments.`
+
+var classifyTool = openai.Tool{
+	Type: openai.ToolTypeFunction,
+	Function: &openai.FunctionDefinition{
+		Name:        classifyFunction,
+		Description: "Record the suggested action and category of the email.",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"action":     {Type: jsonschema.String, Enum: actionNames()},
+				"category":   {Type: jsonschema.String, Enum: Categories},
+				"confidence": {Type: jsonschema.Number, Description: "How sure you are, from 0 to 1."},
+				"reason":     {Type: jsonschema.String, Description: "One sentence explaining the action."},
+			},
+			Required: []string{"action", "category", "confidence", "reason"},
+		},
+	},
+}
+
+// GPT3Classifier classifies emails with the chat completions API of a
+// Provider. Create one with NewClassifier.
 type GPT3Classifier struct {
 	client *openai.Client
 	model  string
+	// backoff is the first wait before resending a rate-limited request.
+	backoff time.Duration
 }
 
-func NewGPT3Classifier(apiKey string, model string) *GPT3Classifier {
-	client := openai.NewClient(apiKey)
-	return &GPT3Classifier{client, model}
+// Model returns the name of the model classifying.
+func (g *GPT3Classifier) Model() string {
+	return g.model
 }
 
-func (g *GPT3Classifier) ClassifyEmail(prompt string) (string, error) {
-	ctx := context.Background()
+// Params describes the request settings besides the model and prompt that
+// shape the answers, so that a Cache can tell them apart.
+func (g *GPT3Classifier) Params() string {
+	params, _ := json.Marshal(struct {
+		System    string
+		Tool      openai.Tool
+		MaxTokens int
+	}{systemPrompt, classifyTool, maxTokens})
+	return string(params)
+}
 
-	completionReq := openai.CompletionRequest{
-		Model:     g.model,
-		Prompt:    prompt,
-		MaxTokens: 100,
-		N:         1,
-		Stop:      []string{"\n"},
-	}
+func (g *GPT3Classifier) ClassifyEmail(prompt string) (Classification, error) {
+	return g.ClassifyEmailContext(context.Background(), prompt)
+}
 
-	completion, err := g.client.CreateCompletion(ctx, completionReq)
-	if err != nil {
-		return "", fmt.Errorf("error creating completion: %v", err)
+// ClassifyEmailContext asks the model to classify the email described by
+// prompt. Answers that are not valid JSON or fail Validate are asked for
+// again, telling the model what was wrong. On error the classification
+// still carries the Usage of the requests that were answered.
+func (g *GPT3Classifier) ClassifyEmailContext(ctx context.Context, prompt string) (Classification, error) {
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: systemPrompt},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
 	}
 
-	if len(completion.Choices) > 0 {
-		answer := completion.Choices[0].Text
-		return answer, nil
-	}
+	var usage Usage
+	var lastAnswer string
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		resp, err := g.createChatCompletion(ctx, openai.ChatCompletionRequest{
+			Model:     g.model,
+			Messages:  messages,
+			MaxTokens: maxTokens,
+			Tools:     []openai.Tool{classifyTool},
+			ToolChoice: openai.ToolChoice{
+				Type:     openai.ToolTypeFunction,
+				Function: openai.ToolFunction{Name: classifyFunction},
+			},
+		})
+		if err != nil {
+			return Classification{Usage: usage}, fmt.Errorf("error creating chat completion: %w", err)
+		}
+		usage.PromptTokens += resp.Usage.PromptTokens
+		usage.CompletionTokens += resp.Usage.CompletionTokens
 
-	return "", fmt.Errorf("no answer found")
+		answer, err := answerOf(resp)
+		if err != nil {
+			return Classification{Usage: usage}, err
+		}
+		classification, err := parseClassification(answer)
+		if err == nil {
+			classification.Raw = answer
+			classification.Usage = usage
+			return classification, nil
+		}
+		lastAnswer, lastErr = answer, err
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: fmt.Sprintf(retryPrompt, answer, err),
+		})
+	}
+	return Classification{Raw: lastAnswer, Usage: usage}, lastErr
 }
 
-func (g *GPT3Classifier) GenerateContextualPrompt(email db.Email) string {
-	prompt := `I have a list of emails, and I need to determine if they should be placed in the trash folder. Emails can have the following labels: UNREAD, CATEGORY_UPDATES, CATEGORY_PROMOTIONS, CATEGORY_PERSONAL, CATEGORY_SOCIAL, CATEGORY_FORUMS, and others. The trash folder typically contains emails that are not important, spam, or promotional in nature.
+// createChatCompletion sends req, waiting and sending it again while the
+// server answers 429 Too Many Requests or a 5xx error.
+func (g *GPT3Classifier) createChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	wait := g.backoff
+	for request := 1; ; request++ {
+		resp, err := g.client.CreateChatCompletion(ctx, req)
+		if err == nil || request == maxRequests || !retryable(err) {
+			return resp, err
+		}
+		select {
+		case <-ctx.Done():
+			return resp, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
 
-Please analyze the following email based on its subject, recipients, sender, and labels, and determine if it should be placed in the trash folder.
+// retryable reports whether err is a rate limit or server error, which
+// may go away when the request is sent again.
+func retryable(err error) bool {
+	status := 0
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		status = reqErr.HTTPStatusCode
+	}
+	return status == http.StatusTooManyRequests || status >= 500
+}
 
-Email Details:
-Subject: %s
-To: %s
-From: %s
-Labels: %s
+// answerOf returns the arguments of the model's classify_email call, or its
+// text when it answered without calling it, as some compatible servers do.
+func answerOf(resp openai.ChatCompletionResponse) (string, error) {
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("no answer found")
+	}
+	message := resp.Choices[0].Message
+	for _, call := range message.ToolCalls {
+		if call.Function.Name == classifyFunction {
+			return call.Function.Arguments, nil
+		}
+	}
+	if message.FunctionCall != nil && message.FunctionCall.Name == classifyFunction {
+		return message.FunctionCall.Arguments, nil
+	}
+	return message.Content, nil
+}
 
-Based on the email details, should this email be placed in the trash folder? Provide only the label that you think is most appropriate.
-`
+// parseClassification decodes and validates an answer. Markdown code
+// fences around the JSON are tolerated.
+func parseClassification(answer string) (Classification, error) {
+	answer = strings.TrimSpace(answer)
+	answer = strings.TrimPrefix(answer, "```json")
+	answer = strings.TrimPrefix(answer, "```")
+	answer = strings.TrimSuffix(answer, "```")
 
-	return fmt.Sprintf(
-		prompt,
-		email.Subject,
-		email.To,
-		email.From,
-		email.Labels,
-	)
+	var c Classification
+	if err := json.Unmarshal([]byte(answer), &c); err != nil {
+		return Classification{}, fmt.Errorf("%w: %v", ErrInvalidClassification, err)
+	}
+	c.Action = Action(strings.ToLower(string(c.Action)))
+	c.Category = strings.ToLower(c.Category)
+	if err := c.Validate(); err != nil {
+		return Classification{}, err
+	}
+	return c, nil
 }
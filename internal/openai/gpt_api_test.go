@@ -0,0 +1,121 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// chatServer answers chat completions with a classify_email call carrying
+// each of answers in turn, recording the requests.
+func chatServer(t *testing.T, answers ...string) (*GPT3Classifier, *[]openai.ChatCompletionRequest) {
+	var requests []openai.ChatCompletionRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Bad request body: %v", err)
+		}
+		requests = append(requests, req)
+		answer := answers[len(requests)-1]
+		fmt.Fprintf(w, `{"choices": [{"message": {"role": "assistant", "tool_calls": [
+			{"id": "call_1", "type": "function", "function": {"name": "classify_email", "arguments": %q}}]}}],
+			"usage": {"prompt_tokens": 100, "completion_tokens": 20, "total_tokens": 120}}`, answer)
+	}))
+	t.Cleanup(srv.Close)
+
+	classifier, err := NewClassifier(Provider{Name: "compatible", BaseURL: srv.URL + "/v1/", Model: "gpt-test"})
+	if err != nil {
+		t.Fatalf("NewClassifier failed: %v", err)
+	}
+	return classifier, &requests
+}
+
+func TestClassifyEmailRetriesInvalidAnswers(t *testing.T) {
+	classifier, requests := chatServer(t,
+		`{"action": "delete"`,
+		`{"action": "delete", "category": "promotions", "confidence": 0.9, "reason": "An ad."}`,
+		`{"action": "Trash", "category": "promotions", "confidence": 0.9, "reason": "An ad."}`,
+	)
+
+	c, err := classifier.ClassifyEmail("Subject: 50% off")
+	if err != nil {
+		t.Fatalf("ClassifyEmail failed: %v", err)
+	}
+	want := Classification{Action: ActionTrash, Category: "promotions", Confidence: 0.9, Reason: "An ad.",
+		Raw:   `{"action": "Trash", "category": "promotions", "confidence": 0.9, "reason": "An ad."}`,
+		Usage: Usage{PromptTokens: 300, CompletionTokens: 60}}
+	if c != want {
+		t.Errorf("Unexpected classification %+v", c)
+	}
+
+	if len(*requests) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(*requests))
+	}
+	last := (*requests)[2]
+	if last.Model != "gpt-test" || len(last.Tools) != 1 || last.Tools[0].Function.Name != classifyFunction {
+		t.Errorf("Expected the classify_email tool to be offered, got %+v", last)
+	}
+	if feedback := last.Messages[len(last.Messages)-1].Content; !strings.Contains(feedback, `action "delete" is not one of`) {
+		t.Errorf("Expected the validation error to be fed back, got %q", feedback)
+	}
+}
+
+func TestClassifyEmailGivesUp(t *testing.T) {
+	classifier, _ := chatServer(t, "{}", "{}", "{}")
+	if _, err := classifier.ClassifyEmail("Subject: ?"); !errors.Is(err, ErrInvalidClassification) {
+		t.Errorf("Expected ErrInvalidClassification, got %v", err)
+	}
+}
+
+func TestClassifyEmailWaitsOutRateLimits(t *testing.T) {
+	// The server is rate limited for the first limited requests.
+	requests, limited := 0, 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= limited {
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error": {"message": "Rate limit reached", "type": "requests"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"choices": [{"message": {"role": "assistant", "content":
+			"{\"action\": \"read\", \"category\": \"work\", \"confidence\": 0.7, \"reason\": \"A colleague.\"}"}}]}`)
+	}))
+	defer srv.Close()
+
+	classifier, err := NewClassifier(Provider{Name: "compatible", BaseURL: srv.URL + "/v1/", Model: "gpt-test"})
+	if err != nil {
+		t.Fatalf("NewClassifier failed: %v", err)
+	}
+	classifier.backoff = time.Millisecond
+
+	c, err := classifier.ClassifyEmail("Subject: Standup notes")
+	if err != nil || c.Action != ActionRead || requests != 3 {
+		t.Errorf("Expected the third request to succeed, got %+v, %v after %d requests", c, err, requests)
+	}
+
+	requests, limited = 0, 100
+	if _, err := classifier.ClassifyEmail("Subject: Standup notes"); !retryable(err) || requests != maxRequests {
+		t.Errorf("Expected to give up after %d requests, got %v after %d", maxRequests, err, requests)
+	}
+}
+
+func TestParseClassification(t *testing.T) {
+	c, err := parseClassification("```json\n{\"action\": \"star\", \"category\": \"personal\", \"confidence\": 1, \"reason\": \"From family.\"}\n```")
+	if err != nil || c.Action != ActionStar {
+		t.Errorf("Expected a fenced answer to parse, got %+v, %v", c, err)
+	}
+
+	_, err = parseClassification(`{"action": "read", "category": "news", "confidence": 1.5, "reason": " "}`)
+	for _, want := range []string{"category", "confidence", "reason"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the %s problem to be reported, got %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package openai
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+)
+
+// DefaultPromptName is the built-in prompt, used unless another is chosen.
+const DefaultPromptName = "triage"
+
+const defaultPrompt = `{{- if .Examples}}Here is how similar emails were handled before:
+{{range .Examples}}
+Subject: {{.Subject}}
+From: {{.From}}
+Labels: {{.Labels}}
+Action: {{.Outcome}}
+{{end}}
+{{end -}}
+Emails can have the following labels: UNREAD, CATEGORY_UPDATES, CATEGORY_PROMOTIONS, CATEGORY_PERSONAL, CATEGORY_SOCIAL, CATEGORY_FORUMS, and others. The trash folder typically contains emails that are not important, spam, or promotional in nature.
+
+Please analyze the following email based on its subject, recipients, sender, and labels.
+
+Email Details:
+Subject: {{.Subject}}
+To: {{.To}}
+From: {{.From}}
+Labels: {{.Labels}}
+`
+
+// outcomeLabels give away what was done with an email, so they are
+// removed from the labels of examples; Outcome says it instead.
+var outcomeLabels = map[string]bool{"TRASH": true, "READ": true, "UNREAD": true, "STARRED": true}
+
+// Example is a past email and what was done with it.
+type Example struct {
+	db.Email
+	Outcome Action
+}
+
+// PromptData is what a prompt template is executed with: every field of
+// the email to classify (.Subject, .From, .Body, ...) and the examples.
+type PromptData struct {
+	db.Email
+	Examples []Example
+}
+
+// promptFuncs are available in templates besides the text/template ones.
+var promptFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"join":  strings.Join,
+	// truncate shortens s to at most n runes, e.g. {{truncate 500 .Body}}.
+	"truncate": func(n int, s string) string {
+		if r := []rune(s); len(r) > n {
+			return string(r[:n]) + "…"
+		}
+		return s
+	},
+}
+
+// Prompt is a named text/template producing the prompt for one email.
+type Prompt struct {
+	Name string
+	// Version identifies the template text, so that results can be traced
+	// back to the prompt that produced them.
+	Version string
+	tmpl    *template.Template
+}
+
+// ParsePrompt parses a prompt template, see PromptData.
+func ParsePrompt(name, text string) (*Prompt, error) {
+	tmpl, err := template.New(name).Funcs(promptFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("prompt %s: %v", name, err)
+	}
+	sum := sha256.Sum256([]byte(text))
+	return &Prompt{Name: name, Version: hex.EncodeToString(sum[:])[:12], tmpl: tmpl}, nil
+}
+
+// Render executes the prompt for email with the given examples.
+func (p *Prompt) Render(email db.Email, examples []Example) (string, error) {
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, PromptData{Email: email, Examples: examples}); err != nil {
+		return "", fmt.Errorf("prompt %s: %v", p.Name, err)
+	}
+	return buf.String(), nil
+}
+
+// Prompts holds prompts by name.
+type Prompts map[string]*Prompt
+
+// LoadPrompts returns the built-in prompt plus every *.tmpl file in dir,
+// named after the file, and the given templates by name. Later sources
+// replace earlier ones of the same name. dir may be empty.
+func LoadPrompts(dir string, templates map[string]string) (Prompts, error) {
+	prompts := Prompts{}
+	builtin, err := ParsePrompt(DefaultPromptName, defaultPrompt)
+	if err != nil {
+		return nil, err
+	}
+	prompts[DefaultPromptName] = builtin
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			text, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			name := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+			if prompts[name], err = ParsePrompt(name, string(text)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for name, text := range templates {
+		prompt, err := ParsePrompt(name, text)
+		if err != nil {
+			return nil, err
+		}
+		prompts[name] = prompt
+	}
+	return prompts, nil
+}
+
+// Get returns the named prompt, or the built-in one for an empty name.
+func (p Prompts) Get(name string) (*Prompt, error) {
+	if name == "" {
+		name = DefaultPromptName
+	}
+	prompt, ok := p[name]
+	if !ok {
+		names := make([]string, 0, len(p))
+		for n := range p {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown prompt %q (have %s)", name, strings.Join(names, ", "))
+	}
+	return prompt, nil
+}
+
+// OutcomeOf is what was done with a stored email, the action a perfect
+// classifier would have suggested.
+func OutcomeOf(email db.Email) Action {
+	labels := map[string]bool{}
+	for _, label := range strings.Split(email.Labels, ",") {
+		labels[strings.ToUpper(strings.TrimSpace(label))] = true
+	}
+	switch {
+	case email.Deleted || email.State == db.StateTrashed || labels["TRASH"]:
+		return ActionTrash
+	case labels["STARRED"]:
+		return ActionStar
+	case email.Read, labels["READ"]:
+		return ActionRead
+	}
+	return ActionIgnore
+}
+
+// Examples turns past emails into few-shot examples, with the labels that
+// give the outcome away removed.
+func Examples(emails []db.Email) []Example {
+	examples := make([]Example, len(emails))
+	for i, email := range emails {
+		examples[i] = Example{Email: email, Outcome: OutcomeOf(email)}
+
+		var kept []string
+		for _, label := range strings.Split(email.Labels, ",") {
+			if label = strings.TrimSpace(label); label != "" && !outcomeLabels[strings.ToUpper(label)] {
+				kept = append(kept, label)
+			}
+		}
+		examples[i].Labels = strings.Join(kept, ", ")
+	}
+	return examples
+}
@@ -0,0 +1,114 @@
+package openai
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sunkay11/gmail-automation/internal/db"
+)
+
+func TestDefaultPromptWithExamples(t *testing.T) {
+	prompts, err := LoadPrompts("", nil)
+	if err != nil {
+		t.Fatalf("LoadPrompts failed: %v", err)
+	}
+	prompt, err := prompts.Get("")
+	if err != nil || prompt.Name != DefaultPromptName || len(prompt.Version) != 12 {
+		t.Fatalf("Expected the built-in prompt, got %+v, %v", prompt, err)
+	}
+
+	email := db.Email{Subject: "Weekly digest", From: "news@example.com", To: "me@example.com", Labels: "INBOX, UNREAD"}
+	text, err := prompt.Render(email, nil)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if strings.Contains(text, "handled before") || !strings.Contains(text, "Subject: Weekly digest\nTo: me@example.com") {
+		t.Errorf("Unexpected prompt without examples:\n%s", text)
+	}
+
+	examples := Examples([]db.Email{
+		{Subject: "Last week's digest", From: "news@example.com", Labels: "CATEGORY_UPDATES, TRASH, READ", State: db.StateTrashed},
+	})
+	text, err = prompt.Render(email, examples)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	want := "Subject: Last week's digest\nFrom: news@example.com\nLabels: CATEGORY_UPDATES\nAction: trash\n"
+	if !strings.HasPrefix(text, "Here is how similar emails were handled before:") || !strings.Contains(text, want) {
+		t.Errorf("Expected the example without outcome labels, got:\n%s", text)
+	}
+}
+
+func TestLoadPrompts(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"short.tmpl":  "{{.Subject}} from {{lower .From}}: {{truncate 5 .Body}}",
+		"triage.tmpl": "overridden {{.Subject}}",
+		"notes.txt":   "ignored",
+	}
+	for name, text := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prompts, err := LoadPrompts(dir, map[string]string{"inline": "{{len .Examples}} examples"})
+	if err != nil {
+		t.Fatalf("LoadPrompts failed: %v", err)
+	}
+	if len(prompts) != 3 {
+		t.Errorf("Expected triage, short and inline, got %v", prompts)
+	}
+
+	email := db.Email{Subject: "Hi", From: "Bob@Example.com", Body: "Hello there"}
+	for name, want := range map[string]string{
+		"short":  "Hi from bob@example.com: Hello…",
+		"triage": "overridden Hi",
+		"inline": "0 examples",
+	} {
+		prompt, err := prompts.Get(name)
+		if err != nil {
+			t.Fatalf("Get(%s) failed: %v", name, err)
+		}
+		if text, err := prompt.Render(email, nil); err != nil || text != want {
+			t.Errorf("Expected %s to render %q, got %q, %v", name, want, text, err)
+		}
+	}
+
+	if _, err := prompts.Get("missing"); err == nil || !strings.Contains(err.Error(), "inline, short, triage") {
+		t.Errorf("Expected an error listing the prompts, got %v", err)
+	}
+	if _, err := LoadPrompts("", map[string]string{"bad": "{{.Subject"}); err == nil {
+		t.Errorf("Expected a parse error")
+	}
+	typo, err := ParsePrompt("typo", "{{.Subjct}}")
+	if err != nil {
+		t.Fatalf("ParsePrompt failed: %v", err)
+	}
+	if _, err := typo.Render(email, nil); err == nil || !strings.Contains(err.Error(), "typo") {
+		t.Errorf("Expected an unknown field to fail rendering, got %v", err)
+	}
+}
+
+func TestOutcomeOf(t *testing.T) {
+	tests := []struct {
+		email db.Email
+		want  Action
+	}{
+		{db.Email{Labels: "INBOX, READ", Deleted: true}, ActionTrash},
+		{db.Email{Labels: "INBOX", State: db.StateTrashed}, ActionTrash},
+		{db.Email{Labels: "INBOX, READ, STARRED"}, ActionStar},
+		{db.Email{Labels: "INBOX, READ"}, ActionRead},
+		{db.Email{Labels: "INBOX, UNREAD"}, ActionIgnore},
+		{db.Email{Labels: "INBOX", Read: true}, ActionRead},
+		{db.Email{Read: true}, ActionRead},
+		{db.Email{}, ActionIgnore},
+	}
+	for _, tc := range tests {
+		if got := OutcomeOf(tc.email); got != tc.want {
+			t.Errorf("OutcomeOf(%+v) = %s, want %s", tc.email, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package openai
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Provider names the server a classifier talks to and how to reach it.
+type Provider struct {
+	// Name is openai, azure, or compatible for any server that speaks the
+	// OpenAI chat completions API, such as Ollama, llama.cpp's server or
+	// vLLM.
+	Name    string
+	BaseURL string
+	Model   string
+	APIKey  string
+
+	// APIVersion and Deployment apply to azure only. Deployment defaults to
+	// the model name without dots, as Azure names gpt-3.5-turbo
+	// gpt-35-turbo.
+	APIVersion string
+	Deployment string
+}
+
+// NewClassifier returns a classifier using p's model.
+func NewClassifier(p Provider) (*GPT3Classifier, error) {
+	client, err := newClient(p)
+	if err != nil {
+		return nil, err
+	}
+	return &GPT3Classifier{client: client, model: p.Model, backoff: time.Second}, nil
+}
+
+func newClient(p Provider) (*openai.Client, error) {
+	var config openai.ClientConfig
+	switch p.Name {
+	case "openai", "":
+		config = openai.DefaultConfig(p.APIKey)
+		if p.BaseURL != "" {
+			config.BaseURL = strings.TrimRight(p.BaseURL, "/")
+		}
+	case "azure":
+		if p.BaseURL == "" {
+			return nil, fmt.Errorf("provider azure needs a base URL")
+		}
+		config = openai.DefaultAzureConfig(p.APIKey, p.BaseURL)
+		if p.APIVersion != "" {
+			config.APIVersion = p.APIVersion
+		}
+		if p.Deployment != "" {
+			config.AzureModelMapperFunc = func(string) string { return p.Deployment }
+		}
+	case "compatible":
+		if p.BaseURL == "" {
+			return nil, fmt.Errorf("provider compatible needs a base URL")
+		}
+		// Local servers usually ignore the key; an empty one is fine.
+		config = openai.DefaultConfig(p.APIKey)
+		config.BaseURL = strings.TrimRight(p.BaseURL, "/")
+	default:
+		return nil, fmt.Errorf("unknown provider %q: expected openai, azure or compatible", p.Name)
+	}
+	return openai.NewClientWithConfig(config), nil
+}
@@ -0,0 +1,86 @@
+package openai
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const validAnswer = `{"choices": [{"message": {"role": "assistant", "content": "{\"action\": \"read\", \"category\": \"work\", \"confidence\": 0.7, \"reason\": \"From a colleague.\"}"}}]}`
+
+func TestProviderRequests(t *testing.T) {
+	tests := []struct {
+		provider Provider
+		path     string
+		query    string
+		header   string
+		value    string
+	}{
+		{
+			// No key, no Authorization header.
+			provider: Provider{Name: "compatible", Model: "llama3"},
+			path:     "/v1/chat/completions",
+			header:   "Authorization", value: "",
+		},
+		{
+			provider: Provider{Name: "openai", Model: "gpt-4", APIKey: "sk-test"},
+			path:     "/v1/chat/completions",
+			header:   "Authorization", value: "Bearer sk-test",
+		},
+		{
+			provider: Provider{Name: "azure", Model: "gpt-3.5-turbo", APIKey: "az-key"},
+			path:     "/openai/deployments/gpt-35-turbo/chat/completions",
+			query:    "api-version=2023-05-15",
+			header:   "api-key", value: "az-key",
+		},
+		{
+			provider: Provider{Name: "azure", Model: "gpt-4", APIKey: "az-key", APIVersion: "2024-02-01", Deployment: "triage"},
+			path:     "/openai/deployments/triage/chat/completions",
+			query:    "api-version=2024-02-01",
+			header:   "api-key", value: "az-key",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.provider.Name+"/"+tc.provider.Model, func(t *testing.T) {
+			var got *http.Request
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+				w.Write([]byte(validAnswer))
+			}))
+			defer srv.Close()
+
+			tc.provider.BaseURL = srv.URL
+			if tc.provider.Name != "azure" {
+				tc.provider.BaseURL += "/v1"
+			}
+			classifier, err := NewClassifier(tc.provider)
+			if err != nil {
+				t.Fatalf("NewClassifier failed: %v", err)
+			}
+			c, err := classifier.ClassifyEmail("Subject: Standup notes")
+			if err != nil || c.Action != ActionRead {
+				t.Fatalf("Expected a plain JSON answer to be accepted, got %+v, %v", c, err)
+			}
+
+			if got.URL.Path != tc.path || got.URL.RawQuery != tc.query {
+				t.Errorf("Expected %s?%s, got %s", tc.path, tc.query, got.URL)
+			}
+			if value := got.Header.Get(tc.header); value != tc.value {
+				t.Errorf("Expected %s %q, got %q", tc.header, tc.value, value)
+			}
+		})
+	}
+}
+
+func TestNewClassifierErrors(t *testing.T) {
+	for _, p := range []Provider{
+		{Name: "bedrock", Model: "claude"},
+		{Name: "azure", Model: "gpt-4"},
+		{Name: "compatible", Model: "llama3"},
+	} {
+		if _, err := NewClassifier(p); err == nil {
+			t.Errorf("Expected an error for %+v", p)
+		}
+	}
+}
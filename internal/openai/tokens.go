@@ -0,0 +1,62 @@
+package openai
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// The encodings are compiled into the binary rather than downloaded on
+// first use.
+func init() {
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// fallbackEncoding counts the tokens of models tiktoken does not know,
+// such as those of local servers, approximately.
+const fallbackEncoding = "cl100k_base"
+
+// messageOverhead is the tokens the chat format adds around each message
+// and to prime the reply.
+const messageOverhead = 4
+
+var encodings sync.Map // model name -> *tiktoken.Tiktoken
+
+// CountTokens returns how many tokens text is for model.
+func CountTokens(model, text string) int {
+	enc, err := encodingFor(model)
+	if err != nil {
+		// Roughly four characters make a token in English.
+		return (len(text) + 3) / 4
+	}
+	return len(enc.Encode(text, nil, nil))
+}
+
+func encodingFor(model string) (*tiktoken.Tiktoken, error) {
+	if enc, ok := encodings.Load(model); ok {
+		return enc.(*tiktoken.Tiktoken), nil
+	}
+	enc, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		if enc, err = tiktoken.GetEncoding(fallbackEncoding); err != nil {
+			return nil, err
+		}
+	}
+	encodings.Store(model, enc)
+	return enc, nil
+}
+
+// EstimateUsage is what a classification of prompt by model usually
+// spends: one request carrying the system prompt, the tool definition and
+// prompt, answered at the maximum length. Retries of an invalid answer and
+// resends after a server error cost more; MeteredGPT settles those from
+// the usage the API reports.
+func EstimateUsage(model, prompt string) Usage {
+	tool, _ := json.Marshal(classifyTool.Function)
+	return Usage{
+		PromptTokens:     CountTokens(model, systemPrompt) + CountTokens(model, string(tool)) + CountTokens(model, prompt) + 3*messageOverhead,
+		CompletionTokens: maxTokens,
+	}
+}